@@ -0,0 +1,124 @@
+package main
+
+// draws a speed or altitude profile of a trip as a PNG or SVG, against time
+//  or distance, with stops shaded and waypoints marked
+//
+//	chart -in trips/trip-20220520-120000.csv -kind altitude -x distance -out alt.png
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/chart"
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/pipeline"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+)
+
+func main() {
+	var inPath, outPath, wpPath, kind, xAxis, units, format, tz string
+	opts := chart.DefaultOptions()
+	flag.StringVar(&inPath, "in", "", "Trip log or any track file trackconv reads, gzipped or not")
+	flag.StringVar(&outPath, "out", "", "File to write, standard output if empty")
+	flag.StringVar(&format, "format", "", "png or svg, from -out's extension if empty")
+	flag.StringVar(&kind, "kind", "speed", "speed or altitude")
+	flag.StringVar(&xAxis, "x", "time", "Chart against time or distance")
+	flag.StringVar(&units, "units", "imperial", "imperial or metric")
+	flag.StringVar(&wpPath, "waypoints", "", "Waypoint file from cmd/waypoint to mark")
+	flag.StringVar(&tz, "tz", "", "Time zone for the time axis, like America/Chicago, local if empty")
+	flag.IntVar(&opts.Width, "width", opts.Width, "Width in pixels")
+	flag.IntVar(&opts.Height, "height", opts.Height, "Height in pixels")
+	flag.Parse()
+
+	if inPath == "" {
+		log.Fatal("-in is required")
+	}
+	var err error
+	if opts.X, err = chart.ParseAxis(xAxis); err != nil {
+		log.Fatalf("Bad -x: %s", err.Error())
+	}
+	switch units {
+	case "imperial":
+	case "metric":
+		opts.Speed = chart.Unit{Name: "km/h", Per: 3.6}
+		opts.Distance = chart.Unit{Name: "km", Per: 0.001}
+		opts.Altitude = chart.Unit{Name: "m", Per: 1}
+	default:
+		log.Fatalf("Bad -units '%s', want imperial or metric", units)
+	}
+	if tz != "" {
+		if opts.Location, err = time.LoadLocation(tz); err != nil {
+			log.Fatalf("Bad -tz: %s", err.Error())
+		}
+	}
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(outPath)), ".")
+	}
+	if format != "png" && format != "svg" {
+		log.Fatal("-format has to be png or svg, or -out end in one")
+	}
+	build := chart.SpeedChart
+	switch kind {
+	case "speed":
+	case "altitude":
+		build = chart.AltitudeChart
+	default:
+		log.Fatalf("Bad -kind '%s', want speed or altitude", kind)
+	}
+
+	src, err := pipeline.Open(inPath, "", func(error) error { return nil })
+	if err != nil {
+		log.Fatalf("Couldn't read %s: %s", inPath, err.Error())
+	}
+	var records []gps.GPSRecord
+	for {
+		gr, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Couldn't read %s: %s", inPath, err.Error())
+		}
+		if pipeline.HasFix(gr) {
+			records = append(records, gr)
+		}
+	}
+	src.Close()
+	if len(records) == 0 {
+		log.Fatal("No usable records in the log")
+	}
+	if wpPath != "" {
+		if opts.Waypoints, err = waypoint.ReadFile(wpPath); err != nil {
+			log.Fatalf("Couldn't read waypoints: %s", err.Error())
+		}
+	}
+
+	out := os.Stdout
+	if outPath != "" {
+		if out, err = os.Create(outPath); err != nil {
+			log.Fatalf("Couldn't create output: %s", err.Error())
+		}
+	}
+	bw := bufio.NewWriter(out)
+	c := build(records, opts)
+	if format == "png" {
+		err = c.WritePNG(bw)
+	} else {
+		err = c.WriteSVG(bw)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		log.Fatalf("Couldn't write the chart: %s", err.Error())
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("Couldn't close output: %s", err.Error())
+	}
+}
@@ -1,93 +1,198 @@
 package main
 
 // a simple command line tool to convert a given csv file to kml
-//  and filtering out possibly bad data (zeros, impossible. etc)
-//	in the process
+//  (or any of trackio's formats with -format) and filtering out possibly bad
+//	data (zeros, impossible. etc) in the process. Records stream through a
+//	pipeline so month-long and gzipped logs don't need to fit in memory
 
 import (
-	"encoding/csv"
+	"bufio"
 	"flag"
-	"fmt"
 	"log"
 	"os"
-	"strconv"
+	"strings"
+	"time"
 
-	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/activity"
+	"github.com/samiam2013/raspigogps/common/clip"
+	"github.com/samiam2013/raspigogps/common/geojson"
+	"github.com/samiam2013/raspigogps/common/gpx"
+	"github.com/samiam2013/raspigogps/common/kml"
+	"github.com/samiam2013/raspigogps/common/pipeline"
+	"github.com/samiam2013/raspigogps/common/report"
+	"github.com/samiam2013/raspigogps/common/trackio"
+	"github.com/samiam2013/raspigogps/common/waypoint"
 )
 
 func main() {
 	// get the file argument
-	var filepath string
-	flag.StringVar(&filepath, "file", "gps.log", "Path to the file to be converted from CSV to KML")
+	var filepath, outPath, format, color, altMode, wpPath, colorBy, gradient, sportName, units string
+	var simplify uint64
+	var strict bool
+	var clipFrom, clipTo, clipBox, clipPoly string
+	var progress time.Duration
+	var lapOpts activity.Options
+	opts := kml.DefaultOptions()
+	coloring := kml.DefaultColoring()
+	gpxOpts := gpx.DefaultOptions()
+	jsonOpts := geojson.DefaultOptions()
+	flag.StringVar(&filepath, "file", "gps.log", "Path to the CSV log (gzipped or not) or track store (.trk) to be converted to KML")
+	flag.StringVar(&outPath, "out", "", "File to write, standard output if empty")
+	flag.StringVar(&format, "format", "kml", "Output format, one of "+strings.Join(trackio.Writable, ", "))
+	flag.StringVar(&colorBy, "color-by", "speed", "KMZ only: color the track by speed, altitude or sats")
+	flag.StringVar(&gradient, "gradient", "rainbow",
+		"KMZ only: rainbow, traffic, heat or colors low to high like #0000ff,#ff0000")
+	flag.StringVar(&units, "units", "imperial", "KMZ only: color speed and altitude in imperial or metric units")
+	flag.IntVar(&coloring.Steps, "steps", coloring.Steps, "KMZ only: how many colors the range is split into")
+	flag.Float64Var(&coloring.Min, "color-min", 0, "KMZ only: value at the low end of the gradient")
+	flag.Float64Var(&coloring.Max, "color-max", 0, "KMZ only: value at the high end, the track's own range if it equals -color-min")
+	flag.DurationVar(&gpxOpts.MaxGap, "gap", gpxOpts.MaxGap, "GPX only: start a new track segment after a gap this long")
+	flag.StringVar(&sportName, "sport", "cycling", "TCX and FIT only: cycling, running, driving or other")
+	flag.Float64Var(&lapOpts.Distance, "lap-distance", 0, "TCX and FIT only: start a new lap every this many meters")
+	flag.DurationVar(&lapOpts.Duration, "lap-time", 0, "TCX and FIT only: start a new lap after this long")
+	flag.StringVar(&wpPath, "waypoints", "", "Waypoint file from cmd/waypoint to add as numbered points, GPX, KML, KMZ and GeoJSON")
+	flag.IntVar(&jsonOpts.Precision, "precision", jsonOpts.Precision,
+		"GeoJSON only: decimals kept in positions, 6 is about 10cm, 0 keeps them all")
+	flag.StringVar(&opts.Name, "name", opts.Name, "Name of the document and track")
+	flag.StringVar(&color, "color", "#ff0000", "Track color, #rrggbb or KML's aabbggrr")
+	flag.Float64Var(&opts.LineWidth, "width", opts.LineWidth, "Track line width in pixels")
+	flag.StringVar(&altMode, "altitude", "clamp", "Altitude mode: clamp, relative or absolute (uses the logged altitude)")
+	flag.BoolVar(&opts.LineString, "line", opts.LineString, "Write the track as a LineString")
+	flag.BoolVar(&opts.Track, "track", opts.Track, "Write the track as a gx:Track with timestamps")
+	flag.BoolVar(&opts.Endpoints, "endpoints", opts.Endpoints, "Add start and end placemarks")
+	flag.StringVar(&opts.StartIcon, "start-icon", opts.StartIcon, "Icon for the start placemark")
+	flag.StringVar(&opts.EndIcon, "end-icon", opts.EndIcon, "Icon for the end placemark")
+	flag.StringVar(&clipFrom, "start", "", "Drop records before this time, RFC 3339 or local 2006-01-02 15:04")
+	flag.StringVar(&clipTo, "end", "", "Drop records from this time on, RFC 3339 or local 2006-01-02 15:04")
+	flag.StringVar(&clipBox, "bbox", "", "Keep records inside west,south,east,north")
+	flag.StringVar(&clipPoly, "polygon", "", "Keep records inside the polygons in this GeoJSON or KML file")
+	flag.Uint64Var(&simplify, "simplify", 10, "Keep a point every this many seconds and at turns, 0 keeps every point")
+	flag.BoolVar(&strict, "strict", false, "Stop at the first bad row instead of skipping it")
+	flag.DurationVar(&progress, "progress", 10*time.Second, "Log progress this often, 0 for never")
 	flag.Parse()
-	// open file
-	f, err := os.Open(filepath)
+
+	clipper, err := clip.New(clipFrom, clipTo, clipBox, clipPoly)
 	if err != nil {
-		log.Fatalf("Couldn't open file: %s", err.Error())
+		log.Fatalf("Bad clipping: %s", err.Error())
+	}
+	if opts.LineColor, err = kml.ParseColor(color); err != nil {
+		log.Fatalf("Bad -color: %s", err.Error())
+	}
+	if opts.AltitudeMode, err = kml.ParseAltitudeMode(altMode); err != nil {
+		log.Fatalf("Bad -altitude: %s", err.Error())
 	}
-	defer f.Close()
 
-	csvR := csv.NewReader(f)
-	data, err := csvR.ReadAll()
+	if coloring.Metric, err = kml.ParseMetric(colorBy); err != nil {
+		log.Fatalf("Bad -color-by: %s", err.Error())
+	}
+	u, err := report.ParseUnits(units)
 	if err != nil {
-		log.Fatalf("Couldn't read in data from gps log file: %s", err.Error())
+		log.Fatalf("Bad -units: %s", err.Error())
+	}
+	coloring.Speed, coloring.Altitude = u.SpeedUnit(), u.AltitudeUnit()
+	if coloring.Gradient, err = kml.ParseGradient(gradient); err != nil {
+		log.Fatalf("Bad -gradient: %s", err.Error())
+	}
+	if coloring.Steps < 1 {
+		log.Fatal("-steps must be at least 1")
 	}
-	// peel off the header
-	data = data[1:]
 
-	gpsDatum := make([]gps.GPSRecord, 0)
-	for _, row := range data {
-		unixMicroTime, err := strconv.ParseInt(row[0], 10, 64)
-		if err != nil {
-			log.Fatalf("Could not parse time: %s", err.Error())
+	sport, err := activity.ParseSport(sportName)
+	if err != nil {
+		log.Fatalf("Bad -sport: %s", err.Error())
+	}
+
+	writable := false
+	for _, f := range trackio.Writable {
+		writable = writable || f == format
+	}
+	if !writable {
+		log.Fatalf("Unknown -format %s, want one of %s", format, strings.Join(trackio.Writable, ", "))
+	}
+	var waypoints []waypoint.Waypoint
+	if wpPath != "" {
+		if waypoints, err = waypoint.ReadFile(wpPath); err != nil {
+			log.Fatalf("Couldn't read waypoints: %s", err.Error())
 		}
-		lat, err := strconv.ParseFloat(row[1], 64)
-		if err != nil {
-			log.Fatalf("Could not parse lattitude: %s", err.Error())
+	}
+	if len(waypoints) > 0 {
+		var unplaced int
+		if waypoints, unplaced, err = pipeline.LocateWaypoints(filepath, "", waypoints, waypoint.DefaultMaxGap); err != nil {
+			log.Fatalf("Couldn't place waypoints on the track: %s", err.Error())
 		}
-		long, err := strconv.ParseFloat(row[2], 64)
-		if err != nil {
-			log.Fatalf("Could not parse longitude: %s", err.Error())
+		if unplaced > 0 {
+			log.Printf("Couldn't place %d waypoints marked without a fix, the track has no fix around them", unplaced)
 		}
-		if lat == 0.0 || lat == long {
-			//      log.Print("Zeroes spotted in the data, skipping")
-			continue
+		kept := waypoints[:0]
+		for _, w := range waypoints {
+			if clipper.Contains(w.Record()) {
+				kept = append(kept, w)
+			}
 		}
-		gpsDatum = append(gpsDatum, gps.GPSRecord{
-			UnixMicro: uint64(unixMicroTime),
-			Lat:       lat,
-			Long:      long,
-		})
+		waypoints = kept
 	}
 
-	gpsDatum = captureWaypoints(gpsDatum, 10)
-
-	for i, gpsWaypoint := range gpsDatum {
-		if i%100 == 0 {
-			fmt.Printf("%v\n", gpsWaypoint)
+	skipped := 0
+	bad := func(err error) error {
+		if strict {
+			return err
+		}
+		if skipped++; skipped <= 10 {
+			log.Printf("Skipping %s", err.Error())
 		}
+		return nil
 	}
+	src, err := pipeline.Open(filepath, "", bad)
+	if err != nil {
+		log.Fatalf("Couldn't read in data from gps log file: %s", err.Error())
+	}
+	defer src.Close()
 
-}
-
-func captureWaypoints(data []gps.GPSRecord, secondsInterval uint64) []gps.GPSRecord {
-	start := data[0].UnixMicro
-	lastWaypointTime := start
-	lastWaypointIdx := 0
-	previousWPIdx := lastWaypointIdx
-	filtered := make([]gps.GPSRecord, 1)
-	for i, coord := range data {
-		if coord.UnixMicro-(secondsInterval*1_000_000) > lastWaypointTime {
-			filtered = append(filtered, coord)
-			previousWPIdx = lastWaypointIdx
-			lastWaypointTime = coord.UnixMicro
-			lastWaypointIdx = i
-		} else if data[previousWPIdx].Turned(data[lastWaypointIdx], coord) {
-			filtered = append(filtered, coord)
-			previousWPIdx = lastWaypointIdx
-			lastWaypointTime = coord.UnixMicro
-			lastWaypointIdx = i
+	out := os.Stdout
+	if outPath != "" {
+		if out, err = os.Create(outPath); err != nil {
+			log.Fatalf("Couldn't create output: %s", err.Error())
 		}
 	}
-	return filtered
+	bw := bufio.NewWriter(out)
+	gpxOpts.Name, jsonOpts.Name = opts.Name, opts.Name
+	sink, err := trackio.NewWriter(bw, format, trackio.Options{
+		KML:       opts,
+		Coloring:  coloring,
+		GPX:       gpxOpts,
+		GeoJSON:   jsonOpts,
+		Waypoints: waypoints,
+		Laps:      lapOpts,
+		Sport:     sport,
+	})
+	if err != nil {
+		log.Fatalf("Couldn't start the output: %s", err.Error())
+	}
+	p := &pipeline.Pipeline{
+		Source:        src,
+		Filters:       []pipeline.Filter{pipeline.HasFix, clipper.Contains},
+		Sink:          sink,
+		Progress:      func(s pipeline.Stats) { log.Print(s) },
+		ProgressEvery: progress,
+	}
+	if simplify > 0 {
+		p.Simplifier = pipeline.NewSimplifier(time.Duration(simplify) * time.Second)
+	}
+	stats, err := p.Run()
+	if err != nil {
+		log.Fatalf("Couldn't convert %s to %s: %s", filepath, format, err.Error())
+	}
+	if err := bw.Flush(); err != nil {
+		log.Fatalf("Couldn't write %s: %s", format, err.Error())
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("Couldn't close output: %s", err.Error())
+	}
+	if skipped > 10 {
+		log.Printf("Skipped %d bad rows in all", skipped)
+	}
+	if stats.Written == 0 {
+		log.Fatal("No usable records in the log")
+	}
+	log.Printf("Wrote %d of %d points", stats.Written, stats.Read)
 }
@@ -0,0 +1,47 @@
+package main
+
+// resamples a geoid grid such as NGA's EGM96 WW15MGH.GRD to a coarser
+//  spacing and writes it gzipped, for the grid built into common/geoid
+
+import (
+	"compress/gzip"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/samiam2013/raspigogps/common/geoid"
+)
+
+func main() {
+	var inPath, outPath string
+	var step float64
+	flag.StringVar(&inPath, "in", "WW15MGH.GRD", "Grid to resample, may be gzipped")
+	flag.Float64Var(&step, "step", 1, "Spacing of the new grid in degrees")
+	flag.StringVar(&outPath, "out", "egm96-1.grd.gz", "Path to write the gzipped grid to")
+	flag.Parse()
+
+	if step <= 0 {
+		log.Fatal("-step has to be positive")
+	}
+	g, err := geoid.Open(inPath)
+	if err != nil {
+		log.Fatalf("Couldn't read grid: %s", err.Error())
+	}
+	out, err := os.Create(outPath)
+	if err != nil {
+		log.Fatalf("Couldn't create output: %s", err.Error())
+	}
+	zw, err := gzip.NewWriterLevel(out, gzip.BestCompression)
+	if err != nil {
+		log.Fatalf("Couldn't compress: %s", err.Error())
+	}
+	if err := g.Resample(step).WriteGRD(zw); err != nil {
+		log.Fatalf("Couldn't write grid: %s", err.Error())
+	}
+	if err := zw.Close(); err != nil {
+		log.Fatalf("Couldn't write grid: %s", err.Error())
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("Couldn't close output: %s", err.Error())
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+// builds the compact reverse geocoding index from a GeoNames dump so the Pi
+//  doesn't have to parse the (much larger) text files on every boot
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/samiam2013/raspigogps/common/geocode"
+)
+
+func main() {
+	var citiesPath, admin1Path, outPath string
+	var minPop int64
+	flag.StringVar(&citiesPath, "cities", "cities1000.txt", "Path to a GeoNames cities file")
+	flag.StringVar(&admin1Path, "admin1", "", "Path to GeoNames admin1CodesASCII.txt for state/province names (optional)")
+	flag.Int64Var(&minPop, "min-pop", 0, "Leave out places with a smaller population")
+	flag.StringVar(&outPath, "out", "places.idx", "Path to write the index to")
+	flag.Parse()
+
+	cities, err := os.Open(citiesPath)
+	if err != nil {
+		log.Fatalf("Couldn't open cities file: %s", err.Error())
+	}
+	defer cities.Close()
+
+	var ix *geocode.Index
+	if admin1Path != "" {
+		admin1, err := os.Open(admin1Path)
+		if err != nil {
+			log.Fatalf("Couldn't open admin1 file: %s", err.Error())
+		}
+		defer admin1.Close()
+		ix, err = geocode.LoadGeoNames(cities, admin1, minPop)
+		if err != nil {
+			log.Fatalf("Couldn't load GeoNames data: %s", err.Error())
+		}
+	} else {
+		ix, err = geocode.LoadGeoNames(cities, nil, minPop)
+		if err != nil {
+			log.Fatalf("Couldn't load GeoNames data: %s", err.Error())
+		}
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		log.Fatalf("Couldn't create index file: %s", err.Error())
+	}
+	n, err := ix.WriteTo(out)
+	if err != nil {
+		log.Fatalf("Couldn't write index: %s", err.Error())
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("Couldn't close index file: %s", err.Error())
+	}
+	log.Printf("Wrote %d places (%d bytes) to %s", ix.Len(), n, outPath)
+}
@@ -0,0 +1,128 @@
+package main
+
+// draws a heatmap of everywhere the logs have driven as a Web Mercator PNG
+//  with a world file, plus a KML GroundOverlay of the same for Google Earth
+//
+//	heatmap -zoom 13 -out heat.png logs/*.csv logs/*.trk
+//
+//	writes heat.png and heat.pgw, and heat.kml showing heat-kml.png
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/clip"
+	"github.com/samiam2013/raspigogps/common/heatmap"
+	"github.com/samiam2013/raspigogps/common/kml"
+	"github.com/samiam2013/raspigogps/common/pipeline"
+)
+
+func main() {
+	var outPath, bbox, gradient string
+	var zoom int
+	opts := heatmap.DefaultOptions()
+	flag.StringVar(&outPath, "out", "heat.png", "PNG to write, the world file and KML go beside it")
+	flag.IntVar(&zoom, "zoom", 13, "Web Mercator zoom, each step doubles the detail")
+	flag.IntVar(&opts.Radius, "radius", opts.Radius, "Widen roads this many pixels each side")
+	flag.IntVar(&opts.MaxSize, "max-size", opts.MaxSize, "Largest width or height in pixels")
+	flag.StringVar(&gradient, "gradient", "", "Named gradient or colors low to high, like #0000ff,#ff0000")
+	flag.StringVar(&bbox, "bbox", "", "Only draw inside west,south,east,north")
+	flag.Parse()
+	paths := flag.Args()
+	if len(paths) == 0 {
+		log.Fatal("Give the logs to draw as arguments")
+	}
+	sort.Strings(paths)
+
+	grid, err := heatmap.NewGrid(zoom)
+	if err != nil {
+		log.Fatalf("Bad -zoom: %s", err.Error())
+	}
+	if gradient != "" {
+		if opts.Gradient, err = kml.ParseGradient(gradient); err != nil {
+			log.Fatalf("Bad -gradient: %s", err.Error())
+		}
+	}
+	filters := []pipeline.Filter{pipeline.HasFix}
+	if bbox != "" {
+		var c clip.Clip
+		if c, err = clip.New("", "", bbox, ""); err != nil {
+			log.Fatalf("Bad -bbox: %s", err.Error())
+		}
+		filters = append(filters, c.Contains)
+	}
+
+	start := time.Now()
+	total := 0
+	for _, p := range paths {
+		src, err := pipeline.Open(p, "", func(error) error { return nil })
+		if err != nil {
+			log.Printf("Skipping %s: %s", p, err.Error())
+			continue
+		}
+		pl := &pipeline.Pipeline{Source: src, Filters: filters, Sink: grid}
+		stats, err := pl.Run()
+		src.Close()
+		if err != nil {
+			// keep what was read, a bad row is usually the torn last line
+			log.Printf("%s: %s", p, err.Error())
+		}
+		total += stats.Written
+	}
+	log.Printf("Drew %d records from %d logs over %d pixels in %s",
+		total, len(paths), grid.Pixels(), time.Since(start).Round(time.Millisecond))
+
+	img, bounds, err := grid.Render(opts)
+	if err != nil {
+		log.Fatalf("Couldn't draw the heatmap: %s", err.Error())
+	}
+	base := strings.TrimSuffix(outPath, filepath.Ext(outPath))
+	if err := writePNG(outPath, img); err != nil {
+		log.Fatal(err.Error())
+	}
+	if err := os.WriteFile(base+".pgw", []byte(heatmap.WorldFile(bounds, zoom)), 0644); err != nil {
+		log.Fatalf("Couldn't write the world file: %s", err.Error())
+	}
+	overlayPath := base + "-kml.png"
+	if err := writePNG(overlayPath, heatmap.Equirectangular(img, bounds, zoom)); err != nil {
+		log.Fatal(err.Error())
+	}
+	f, err := os.Create(base + ".kml")
+	if err != nil {
+		log.Fatalf("Couldn't create the KML: %s", err.Error())
+	}
+	doc := heatmap.Overlay("Heatmap", filepath.Base(overlayPath), bounds, zoom)
+	if err := doc.Encode(f); err != nil {
+		log.Fatalf("Couldn't write the KML: %s", err.Error())
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Couldn't write the KML: %s", err.Error())
+	}
+	fmt.Printf("Wrote %s (%dx%d), %s.pgw and %s.kml\n", outPath, bounds.Dx(), bounds.Dy(), base, base)
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("couldn't create %s: %s", path, err.Error())
+	}
+	bw := bufio.NewWriter(f)
+	if err := png.Encode(bw, img); err != nil {
+		f.Close()
+		return fmt.Errorf("couldn't write %s: %s", path, err.Error())
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("couldn't write %s: %s", path, err.Error())
+	}
+	return f.Close()
+}
@@ -1,18 +1,181 @@
 package main
 
+// logs every record from the gps to CSV files or binary track stores named
+//  by GPS date, in the formats csvtokml reads
+
 import (
-	"fmt"
+	"flag"
+	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/samiam2013/raspigogps/common/coord"
+	"github.com/samiam2013/raspigogps/common/geocode"
+	"github.com/samiam2013/raspigogps/common/geoid"
 	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/tracklog"
+	"github.com/samiam2013/raspigogps/common/trackstore"
+	"github.com/samiam2013/raspigogps/common/trip"
+	"github.com/samiam2013/raspigogps/common/wmm"
 )
 
+// trackWriter is what the CSV and binary writers have in common
+type trackWriter interface {
+	Write(gps.GPSRecord) error
+	Close() error
+	Path() string
+}
+
 func main() {
-	recordChan := gps.StartSerial("/dev/ttyACM0", 9600)
+	var devPath, dir, geoidPath, wmmPath, captureDir, replayPath, format, tripsDir, placesPath, coordName string
+	var baud int
+	var maxMB int64
+	var syncEvery time.Duration
+	flag.StringVar(&devPath, "dev", "/dev/ttyACM0", "Serial port of the gps receiver")
+	flag.IntVar(&baud, "baud", 9600, "Baud rate of the gps receiver")
+	flag.StringVar(&dir, "dir", "logs", "Directory to write track logs to")
+	flag.StringVar(&format, "format", "csv", "Log format, csv or trk (the binary track store)")
+	flag.Int64Var(&maxMB, "max-mb", 64, "Start a new file once the current one reaches this size")
+	flag.DurationVar(&syncEvery, "sync", 5*time.Second, "How often to fsync, at most this much is lost on power loss")
+	flag.StringVar(&geoidPath, "geoid", "", "Finer geoid grid than the built in 1 degree EGM96 (WW15MGH.GRD, may be gzipped) for MSL altitude")
+	flag.StringVar(&wmmPath, "wmm", "", "Newer WMM.COF from NOAA than the built in magnetic model, for declination and magnetic heading")
+	flag.StringVar(&captureDir, "capture", "", "Directory to also save the raw NMEA from the receiver to, for debugging")
+	flag.StringVar(&replayPath, "replay", "", "Log a raw NMEA capture instead of reading the receiver")
+	flag.StringVar(&tripsDir, "trips", "", "Directory to also split the log into trips in, with a trip index")
+	flag.StringVar(&placesPath, "places", "", "Reverse geocoding index (from geoindex) to name where trips start and end")
+	flag.StringVar(&coordName, "coords", "dd", "Extra coordinate column for CSV logs and trip files, one of "+coord.FormatNames()+", dd for none")
+	flag.Parse()
+	coordFmt, err := coord.ParseFormat(coordName)
+	if err != nil {
+		log.Fatalf("Bad coordinate format: %s", err.Error())
+	}
+
+	if geoidPath != "" {
+		g, err := geoid.Open(geoidPath)
+		if err != nil {
+			log.Fatalf("Couldn't load geoid grid: %s", err.Error())
+		}
+		geoid.SetDefault(g)
+	}
+	if wmmPath != "" {
+		m, err := wmm.Open(wmmPath)
+		if err != nil {
+			log.Fatalf("Couldn't load magnetic model: %s", err.Error())
+		}
+		wmm.SetDefault(m)
+	}
+
+	var w trackWriter
+	switch format {
+	case "csv":
+		cw := tracklog.NewWriter(dir)
+		cw.MaxBytes = maxMB << 20
+		cw.SyncEvery = syncEvery
+		cw.Coords = coordFmt
+		w = cw
+	case "trk":
+		tw := trackstore.NewWriter(dir)
+		tw.SyncEvery = syncEvery
+		w = tw
+	default:
+		log.Fatalf("Unknown format %s, want csv or trk", format)
+	}
+
+	var seg *trip.Segmenter
+	var sink *trip.FileSink
+	if tripsDir != "" {
+		var places *geocode.Index
+		if placesPath != "" {
+			var err error
+			places, err = geocode.Open(placesPath)
+			if err != nil {
+				log.Fatalf("Couldn't load places index: %s", err.Error())
+			}
+		}
+		var err error
+		sink, err = trip.OpenFileSink(tripsDir, places)
+		if err != nil {
+			log.Fatalf("Couldn't open trips directory: %s", err.Error())
+		}
+		sink.SyncEvery = syncEvery
+		sink.Coords = coordFmt
+		seg = trip.NewSegmenter(sink)
+	}
+
+	var recordChan chan gps.GPSRecord
+	var capture *gps.CaptureWriter
+	if replayPath != "" {
+		var err error
+		recordChan, err = gps.StartReplay(replayPath, false)
+		if err != nil {
+			log.Fatalf("Couldn't replay capture: %s", err.Error())
+		}
+	} else {
+		if captureDir != "" {
+			var err error
+			capture, err = gps.CreateCapture(captureDir)
+			if err != nil {
+				log.Fatalf("Couldn't start capture: %s", err.Error())
+			}
+			log.Printf("Capturing raw NMEA to %s", capture.Name())
+		}
+		recordChan = gps.StartSerialCapture(devPath, baud, capture)
+		if recordChan == nil {
+			log.Fatalf("Couldn't start reading from %s", devPath)
+		}
+	}
+
+	// a clean shutdown gets the last few seconds onto the card too
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	shutdown := func() {
+		if capture != nil {
+			capture.Close()
+		}
+		if seg != nil {
+			if err := seg.Close(); err != nil {
+				log.Printf("Couldn't finish trip: %s", err.Error())
+			}
+			if err := sink.Close(); err != nil {
+				log.Printf("Couldn't close trip: %s", err.Error())
+			}
+		}
+		if err := w.Close(); err != nil {
+			log.Fatalf("Couldn't close log: %s", err.Error())
+		}
+	}
 
-	// start a goroutine to read the gps data or exit if there's an error
 	for {
-		gr := <-recordChan
-		fmt.Printf("%+v\n", gr)
+		select {
+		case gr, ok := <-recordChan:
+			if !ok {
+				// the replay is finished
+				shutdown()
+				return
+			}
+			path := w.Path()
+			if err := w.Write(gr); err != nil {
+				log.Printf("Couldn't write record: %s", err.Error())
+				continue
+			}
+			if w.Path() != path {
+				log.Printf("Logging to %s", w.Path())
+			}
+			if seg != nil {
+				inTrip := seg.InTrip()
+				if err := seg.Add(gr); err != nil {
+					log.Printf("Couldn't write trip: %s", err.Error())
+				}
+				if !inTrip && seg.InTrip() {
+					log.Printf("Trip started at %s", gr.Time().Format(time.RFC3339))
+				}
+			}
+		case sig := <-sigs:
+			log.Printf("Got %s, closing %s", sig.String(), w.Path())
+			shutdown()
+			return
+		}
 	}
 }
@@ -0,0 +1,140 @@
+package main
+
+// snaps a trace to the roads it drove using a local OpenStreetMap extract,
+//  writing where each fix matched with the way's ID and road name as CSV, the
+//	snapped track in any format trackconv writes or an HTML report, the tracks
+//	and report with a waypoint naming each road where the trace turns onto it
+//
+//	mapmatch -osm stlouis.osm.pbf -in trips/trip-20220520-120000.csv -out matched.csv
+//	mapmatch -osm stlouis.osm.pbf -in trips/trip-20220520-120000.csv -out matched.gpx
+//	mapmatch -osm stlouis.osm.pbf -in trips/trip-20220520-120000.csv -out matched.html
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/mapmatch"
+	"github.com/samiam2013/raspigogps/common/pipeline"
+	"github.com/samiam2013/raspigogps/common/report"
+	"github.com/samiam2013/raspigogps/common/trackio"
+)
+
+// -to for the CSV of matches and for the HTML report
+const (
+	matchesFormat = "matches"
+	reportFormat  = "report"
+)
+
+func main() {
+	var inPath, osmPath, outPath, to string
+	opts := mapmatch.DefaultOptions()
+	flag.StringVar(&inPath, "in", "", "Trace to match, any format trackconv reads, gzipped or not")
+	flag.StringVar(&osmPath, "osm", "", "OpenStreetMap extract, .osm, .osm.gz or .osm.pbf")
+	flag.StringVar(&outPath, "out", "", "File to write, standard output if empty")
+	flag.StringVar(&to, "to", "", "matches for a CSV of matches, report for an HTML report, or a track format to write the snapped track in ("+
+		strings.Join(trackio.Writable, ", ")+"), from -out's extension if empty")
+	flag.Float64Var(&opts.Sigma, "sigma", opts.Sigma, "GPS error in meters")
+	flag.Float64Var(&opts.Radius, "radius", opts.Radius, "Consider roads within this many meters of a fix")
+	flag.Parse()
+
+	if inPath == "" || osmPath == "" {
+		log.Fatal("-in and -osm are required")
+	}
+	if to == "" {
+		// a CSV out is the matches, the snapped track as CSV needs -to csv
+		switch strings.ToLower(filepath.Ext(outPath)) {
+		case ".html", ".htm":
+			to = reportFormat
+		default:
+			if to = trackio.FromExt(outPath); to == "" || to == trackio.CSV {
+				to = matchesFormat
+			}
+		}
+	}
+	writable := to == matchesFormat || to == reportFormat
+	for _, f := range trackio.Writable {
+		writable = writable || f == to
+	}
+	if !writable {
+		log.Fatalf("Can't write %s, want %s, %s or one of %s", to, matchesFormat, reportFormat, strings.Join(trackio.Writable, ", "))
+	}
+
+	start := time.Now()
+	data, err := mapmatch.ReadFile(osmPath)
+	if err != nil {
+		log.Fatalf("Couldn't read the extract: %s", err.Error())
+	}
+	g := mapmatch.NewGraph(data)
+	log.Printf("Loaded %d roads, %d road edges in %s", len(data.Ways), len(g.Edges), time.Since(start).Round(time.Millisecond))
+
+	src, err := pipeline.Open(inPath, "", func(error) error { return nil })
+	if err != nil {
+		log.Fatalf("Couldn't read %s: %s", inPath, err.Error())
+	}
+	var records []gps.GPSRecord
+	for {
+		gr, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Couldn't read %s: %s", inPath, err.Error())
+		}
+		if pipeline.HasFix(gr) {
+			records = append(records, gr)
+		}
+	}
+	src.Close()
+	if len(records) == 0 {
+		log.Fatal("No usable records in the trace")
+	}
+
+	matches := mapmatch.NewMatcher(g, opts).Match(records)
+	matched := 0
+	ways := map[int64]bool{}
+	for _, m := range matches {
+		if m.Matched {
+			matched++
+			ways[m.WayID] = true
+		}
+	}
+
+	out := os.Stdout
+	if outPath != "" {
+		if out, err = os.Create(outPath); err != nil {
+			log.Fatalf("Couldn't create output: %s", err.Error())
+		}
+	}
+	bw := bufio.NewWriter(out)
+	roads := mapmatch.Roads(records, matches)
+	switch to {
+	case matchesFormat:
+		err = mapmatch.WriteCSV(bw, records, matches)
+	case reportFormat:
+		ro := report.DefaultOptions()
+		ro.Title = "Matched " + strings.TrimSuffix(filepath.Base(inPath), filepath.Ext(inPath))
+		err = report.Write(bw, mapmatch.Snapped(records, matches), roads, ro)
+	default:
+		tro := trackio.DefaultOptions("Matched track")
+		tro.Waypoints = roads
+		err = trackio.Write(bw, to, mapmatch.Snapped(records, matches), tro)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		log.Fatalf("Couldn't write %s: %s", to, err.Error())
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("Couldn't close output: %s", err.Error())
+	}
+	fmt.Fprintf(os.Stderr, "Matched %d of %d records onto %d roads\n", matched, len(records), len(ways))
+}
@@ -0,0 +1,61 @@
+package main
+
+// shows the trip computer's odometer and trip meters from the state the
+//  display saves, or asks it to reset a trip meter
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/tripcomputer"
+)
+
+func main() {
+	var statePath, reset string
+	var asJSON bool
+	flag.StringVar(&statePath, "state", "odometer.json", "Trip computer state saved by ssh1106")
+	flag.StringVar(&reset, "reset", "", "Reset trip meter a or b")
+	flag.BoolVar(&asJSON, "json", false, "Print the state as JSON")
+	flag.Parse()
+
+	if reset != "" {
+		if err := tripcomputer.RequestReset(statePath, reset); err != nil {
+			log.Fatalf("Couldn't reset: %s", err.Error())
+		}
+		fmt.Printf("Trip %s resets at the display's next save\n", reset)
+		return
+	}
+
+	s, err := tripcomputer.Load(statePath)
+	if err != nil {
+		log.Fatalf("Couldn't load state: %s", err.Error())
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(s); err != nil {
+			log.Fatalf("Couldn't encode state: %s", err.Error())
+		}
+		return
+	}
+	fmt.Printf("odometer  %10.1f mi\n", s.Odometer/tripcomputer.MetersPerMile)
+	for _, m := range []struct {
+		name  string
+		meter tripcomputer.Meter
+	}{{"A", s.A}, {"B", s.B}} {
+		since := "never reset"
+		if !m.meter.Since.IsZero() {
+			since = "since " + m.meter.Since.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("trip %s    %10.1f mi  (%s)\n", m.name, m.meter.Distance/tripcomputer.MetersPerMile, since)
+		fmt.Printf("  avg %.1f mph, max %.1f mph\n", tripcomputer.MPH(m.meter.AvgSpeed()), tripcomputer.MPH(m.meter.MaxSpeed))
+		fmt.Printf("  moving %s, stopped %s\n", m.meter.Moving.Round(time.Second), m.meter.Stopped.Round(time.Second))
+	}
+	if !s.Updated.IsZero() {
+		fmt.Printf("updated %s\n", s.Updated.Local().Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+// writes a self-contained HTML report for a trip: a map of the track,
+//  its stats and speed and altitude profiles, nothing loaded from the
+//	network so it works offline and on a phone
+//
+//	report -in trips/trip-20220520-120000.csv -waypoints wp.txt -out trip.html
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/pipeline"
+	"github.com/samiam2013/raspigogps/common/report"
+	"github.com/samiam2013/raspigogps/common/tripstats"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+)
+
+func main() {
+	var inPath, outPath, wpPath, units, tz string
+	opts := report.DefaultOptions()
+	flag.StringVar(&inPath, "in", "", "Trip log or any track file trackconv reads, gzipped or not")
+	flag.StringVar(&outPath, "out", "", "HTML file to write, standard output if empty")
+	flag.StringVar(&wpPath, "waypoints", "", "Waypoint file from cmd/waypoint to mark on the map")
+	flag.StringVar(&opts.Title, "title", "", "Report title, the input's name if empty")
+	flag.StringVar(&units, "units", "imperial", "imperial or metric")
+	flag.StringVar(&tz, "tz", "", "Time zone for times, like America/Chicago, local if empty")
+	flag.Parse()
+
+	if inPath == "" {
+		log.Fatal("-in is required")
+	}
+	var err error
+	if opts.Units, err = report.ParseUnits(units); err != nil {
+		log.Fatalf("Bad -units: %s", err.Error())
+	}
+	if tz != "" {
+		if opts.Location, err = time.LoadLocation(tz); err != nil {
+			log.Fatalf("Bad -tz: %s", err.Error())
+		}
+	}
+	if opts.Title == "" {
+		opts.Title = strings.TrimSuffix(filepath.Base(inPath), filepath.Ext(inPath))
+	}
+
+	skipped := 0
+	src, err := pipeline.Open(inPath, "", func(error) error {
+		skipped++
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Couldn't read %s: %s", inPath, err.Error())
+	}
+	var records []gps.GPSRecord
+	for {
+		gr, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Couldn't read %s: %s", inPath, err.Error())
+		}
+		if pipeline.HasFix(gr) {
+			records = append(records, gr)
+		}
+	}
+	src.Close()
+	if skipped > 0 {
+		log.Printf("Skipped %d bad rows", skipped)
+	}
+	if len(records) == 0 {
+		log.Fatal("No usable records in the log")
+	}
+
+	var waypoints []waypoint.Waypoint
+	if wpPath != "" {
+		if waypoints, err = waypoint.ReadFile(wpPath); err != nil {
+			log.Fatalf("Couldn't read waypoints: %s", err.Error())
+		}
+		l := waypoint.NewLocator(waypoints, waypoint.DefaultMaxGap)
+		for _, gr := range records {
+			l.Write(gr)
+		}
+		var unplaced int
+		if waypoints, unplaced = l.Waypoints(); unplaced > 0 {
+			log.Printf("Couldn't place %d waypoints marked without a fix", unplaced)
+		}
+	}
+
+	out := os.Stdout
+	if outPath != "" {
+		if out, err = os.Create(outPath); err != nil {
+			log.Fatalf("Couldn't create output: %s", err.Error())
+		}
+	}
+	bw := bufio.NewWriter(out)
+	if err := report.Write(bw, records, waypoints, opts); err != nil {
+		log.Fatalf("Couldn't write the report: %s", err.Error())
+	}
+	if err := bw.Flush(); err != nil {
+		log.Fatalf("Couldn't write the report: %s", err.Error())
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("Couldn't close output: %s", err.Error())
+	}
+	fmt.Fprintf(os.Stderr, "%s: %s over %d records\n", inPath, opts.Units.FormatDistance(tripstats.Compute(records, nil, time.UTC).Distance), len(records))
+}
@@ -1,45 +1,173 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
 	"time"
 
+	"github.com/samiam2013/raspigogps/common/coord"
+	"github.com/samiam2013/raspigogps/common/geocode"
+	"github.com/samiam2013/raspigogps/common/geoid"
 	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/solar"
+	"github.com/samiam2013/raspigogps/common/tripcomputer"
+	"github.com/samiam2013/raspigogps/common/wmm"
 	"github.com/samiam2013/raspigogps/cwrapper"
 )
 
+// palettes are the display's look for each phase of the sun: full contrast
+// by day, dimmer through twilight, and inverted at its dimmest by night
+var palettes = map[solar.Phase]cwrapper.Palette{
+	solar.Day:      {Contrast: 0xFF, Inverted: false},
+	solar.Twilight: {Contrast: 0x60, Inverted: false},
+	solar.Night:    {Contrast: 0x08, Inverted: true},
+}
+
 func main() {
-	recordChan := gps.StartSerial("/dev/ttyACM1", 9600)
+	var coordName, placesPath, geoidPath, wmmPath, captureDir, replayPath, odoPath string
+	var autoDim bool
+	var placesKm float64
+	flag.StringVar(&coordName, "coords", "dd", "Coordinate format for the display, one of "+coord.FormatNames())
+	flag.StringVar(&placesPath, "places", "", "Reverse geocoding index (from geoindex) to show the nearest town")
+	flag.Float64Var(&placesKm, "places-km", geocode.DefaultMaxKm, "Show a town only within this many km")
+	flag.StringVar(&geoidPath, "geoid", "", "Finer geoid grid than the built in 1 degree EGM96 (WW15MGH.GRD, may be gzipped) for MSL altitude")
+	flag.StringVar(&wmmPath, "wmm", "", "Newer WMM.COF from NOAA than the built in magnetic model, for declination and magnetic heading")
+	flag.BoolVar(&autoDim, "auto-dim", true, "Dim the display from sunrise/sunset at the current position")
+	flag.StringVar(&captureDir, "capture", "", "Directory to save a raw NMEA capture of the receiver to")
+	flag.StringVar(&replayPath, "replay", "", "Show a raw NMEA capture at its original pace instead of the receiver")
+	flag.StringVar(&odoPath, "odometer", "odometer.json", "Trip computer state file, empty to turn the trip computer off")
+	flag.Parse()
+	coordFmt, err := coord.ParseFormat(coordName)
+	if err != nil {
+		log.Fatalf("Bad coordinate format: %s", err.Error())
+	}
+	if geoidPath != "" {
+		g, err := geoid.Open(geoidPath)
+		if err != nil {
+			log.Fatalf("Couldn't load geoid grid: %s", err.Error())
+		}
+		geoid.SetDefault(g)
+	}
+	if wmmPath != "" {
+		m, err := wmm.Open(wmmPath)
+		if err != nil {
+			log.Fatalf("Couldn't load magnetic model: %s", err.Error())
+		}
+		wmm.SetDefault(m)
+	}
+	var places *geocode.Index
+	if placesPath != "" {
+		places, err = geocode.Open(placesPath)
+		if err != nil {
+			log.Fatalf("Couldn't load places index: %s", err.Error())
+		}
+	}
+
+	var comp *tripcomputer.Computer
+	if odoPath != "" {
+		comp, err = tripcomputer.Open(odoPath)
+		if err != nil {
+			log.Fatalf("Couldn't load trip computer: %s", err.Error())
+		}
+	}
+
+	var recordChan chan gps.GPSRecord
+	if replayPath != "" {
+		recordChan, err = gps.StartReplay(replayPath, true)
+		if err != nil {
+			log.Fatalf("Couldn't replay capture: %s", err.Error())
+		}
+	} else {
+		var capture *gps.CaptureWriter
+		if captureDir != "" {
+			capture, err = gps.CreateCapture(captureDir)
+			if err != nil {
+				log.Fatalf("Couldn't start capture: %s", err.Error())
+			}
+			defer capture.Close()
+		}
+		recordChan = gps.StartSerialCapture("/dev/ttyACM1", 9600, capture)
+	}
 
 	lcd := cwrapper.NewLCD("/dev/i2c-1", 0x3c)
 	lcd.LCDInit()
 	lcd.Clear()
+	defer lcd.Close()
 
 	// start a goroutine to read the gps data or exit if there's an error
 	latestUpdate := time.Now()
+	phase := solar.Phase(-1) // unknown until the first fix
 	for {
-		gr := <-recordChan
+		gr, ok := <-recordChan
+		if !ok {
+			// end of the replay
+			if comp != nil {
+				if err := comp.Save(); err != nil {
+					log.Printf("Could not save trip computer: %s", err.Error())
+				}
+			}
+			return
+		}
 		fmt.Printf("%+v\n", gr)
+		if comp != nil {
+			if err := comp.Update(gr); err != nil {
+				log.Printf("Could not save trip computer: %s", err.Error())
+			}
+		}
+		if autoDim {
+			if p := solar.PhaseAt(gr.Lat, gr.Long, gr.Time()); p != phase {
+				if err := lcd.Dim(palettes[p]); err != nil {
+					log.Printf("Could not dim display for %s: %s", p.String(), err.Error())
+				} else {
+					phase = p
+				}
+			}
+		}
 		if time.Since(latestUpdate) > time.Second {
 			lcd.Clear()
 			latestUpdate = time.Now()
-			lat := fmt.Sprintf(" %3.6f", gr.Lat)
-			for i := 1; i < len(lat)+1; i++ {
-				lcd.PrintAtRowCol(rune(lat[i-1]), 1, i)
+			// the position takes the first one or two rows depending on format
+			for row, part := range coordFmt.Parts(gr.Lat, gr.Long) {
+				part = " " + part
+				for i := 1; i < len(part)+1; i++ {
+					lcd.PrintAtRowCol(rune(part[i-1]), row+1, i)
+				}
 			}
-			long := fmt.Sprintf(" %3.6f", gr.Long)
-			for i := 1; i < len(long)+1; i++ {
-				lcd.PrintAtRowCol(rune(long[i-1]), 2, i)
+			if places != nil {
+				if desc := places.Describe(gr, placesKm); desc != "" {
+					near := " " + desc
+					if len(near) > 15 {
+						near = near[:15]
+					}
+					for i := 1; i < len(near)+1; i++ {
+						lcd.PrintAtRowCol(rune(near[i-1]), 3, i)
+					}
+				}
 			}
 			spd := fmt.Sprintf("  speed %3.1f", gr.Speed)
 			for i := 0; i < len(spd); i++ {
 				lcd.PrintAtRowCol(rune(spd[i]), 4, i)
 			}
+			if comp != nil {
+				trip := " " + tripLine(comp.State(), time.Now())
+				if len(trip) > 15 {
+					trip = trip[:15]
+				}
+				for i := 0; i < len(trip); i++ {
+					lcd.PrintAtRowCol(rune(trip[i]), 5, i)
+				}
+			}
 			alt := fmt.Sprintf("  alt %.1f", gr.Alt)
 			for i := 0; i < len(alt); i++ {
 				lcd.PrintAtRowCol(rune(alt[i]), 6, i)
 			}
-			hdg := fmt.Sprintf("  hdg %.1f", gr.Heading)
+			// true and magnetic heading side by side for the paper maps, marked
+			//  when it's estimated from positions (~), held (=) or missing (?)
+			marker := map[gps.HeadingSource]rune{
+				gps.HeadingTrack: ' ', gps.HeadingDerived: '~', gps.HeadingHeld: '=', gps.HeadingNone: '?',
+			}[gr.HeadingSource]
+			hdg := fmt.Sprintf(" %chdg %3.0fT %3.0fM", marker, gr.Heading, gr.MagHeading)
 			for i := 0; i < len(hdg); i++ {
 				lcd.PrintAtRowCol(rune(hdg[i]), 7, i)
 			}
@@ -50,5 +178,21 @@ func main() {
 
 		}
 	}
-	lcd.Close()
+}
+
+// tripLine is the trip computer's row, which cycles through the odometer and
+// trip meters every few seconds since they won't fit on one, in miles and mph
+func tripLine(s tripcomputer.State, now time.Time) string {
+	mi := func(m float64) float64 { return m / tripcomputer.MetersPerMile }
+	switch (now.Unix() / 3) % 4 {
+	case 0:
+		return fmt.Sprintf("odo %.1f", mi(s.Odometer))
+	case 1:
+		return fmt.Sprintf("A %.1f av %.0f", mi(s.A.Distance), tripcomputer.MPH(s.A.AvgSpeed()))
+	case 2:
+		mv := s.A.Moving.Round(time.Minute)
+		return fmt.Sprintf("mx %.0f mv %d:%02d", tripcomputer.MPH(s.A.MaxSpeed), int(mv.Hours()), int(mv.Minutes())%60)
+	default:
+		return fmt.Sprintf("B %.1f av %.0f", mi(s.B.Distance), tripcomputer.MPH(s.B.AvgSpeed()))
+	}
 }
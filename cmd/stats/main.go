@@ -0,0 +1,294 @@
+package main
+
+// sums up one or many logs: distance, time, speeds and the time spent in
+//  speed bands, climbing, the longest stop, fix quality and satellites, and
+//	driving by hour of the day, as a table or JSON
+//
+//	stats -units metric -bands 50,80,110 logs/track-2022-05-*.csv
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/pipeline"
+	"github.com/samiam2013/raspigogps/common/report"
+	"github.com/samiam2013/raspigogps/common/tripstats"
+)
+
+func main() {
+	var unitsName, bandsFlag, tz string
+	var asJSON, strict bool
+	flag.StringVar(&unitsName, "units", "imperial", "imperial or metric")
+	flag.StringVar(&bandsFlag, "bands", "", "Speed band edges in the -units speed, like 25,45,65 (the default) or 40,70,100 for metric")
+	flag.StringVar(&tz, "tz", "", "Time zone for times and hours of the day, like America/Chicago, local if empty")
+	flag.BoolVar(&asJSON, "json", false, "Write JSON instead of a table")
+	flag.BoolVar(&strict, "strict", false, "Stop at the first bad row instead of skipping it")
+	flag.Parse()
+	paths := flag.Args()
+	if len(paths) == 0 {
+		log.Fatal("Give the logs to sum up as arguments")
+	}
+	sort.Strings(paths)
+
+	units, err := report.ParseUnits(unitsName)
+	if err != nil {
+		log.Fatalf("Bad -units: %s", err.Error())
+	}
+	if bandsFlag == "" {
+		bandsFlag = "25,45,65"
+		if units.Name == "metric" {
+			bandsFlag = "40,70,100"
+		}
+	}
+	bands, err := parseBands(bandsFlag, units)
+	if err != nil {
+		log.Fatalf("Bad -bands: %s", err.Error())
+	}
+	loc := time.Local
+	if tz != "" {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			log.Fatalf("Bad -tz: %s", err.Error())
+		}
+	}
+
+	acc := tripstats.NewAccumulator(bands, loc)
+	skipped := 0
+	var bad func(error) error
+	if !strict {
+		bad = func(error) error {
+			skipped++
+			return nil
+		}
+	}
+	for _, p := range paths {
+		src, err := pipeline.Open(p, "", bad)
+		if err != nil {
+			log.Fatalf("Couldn't open %s: %s", p, err.Error())
+		}
+		pl := &pipeline.Pipeline{Source: src, Filters: []pipeline.Filter{pipeline.HasFix}, Sink: acc}
+		_, err = pl.Run()
+		src.Close()
+		if err != nil {
+			log.Fatalf("Couldn't read %s: %s", p, err.Error())
+		}
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d bad rows", skipped)
+	}
+	s := acc.Stats()
+	if s.Records == 0 {
+		log.Fatal("No usable records in the logs")
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(toJSON(s, units, loc)); err != nil {
+			log.Fatalf("Couldn't write JSON: %s", err.Error())
+		}
+		return
+	}
+	writeTable(os.Stdout, s, units, loc)
+}
+
+// parseBands reads band edges in u's speed unit and returns them in m/s
+func parseBands(s string, u report.Units) ([]float64, error) {
+	var bands []float64
+	for _, f := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("'%s' isn't a speed", f)
+		}
+		if len(bands) > 0 && v/u.PerMPS <= bands[len(bands)-1] {
+			return nil, fmt.Errorf("edges have to go up")
+		}
+		bands = append(bands, v/u.PerMPS)
+	}
+	return bands, nil
+}
+
+// bandName is a band's range in u's speed unit
+func bandName(b tripstats.Band, u report.Units) string {
+	if math.IsInf(b.To, 1) {
+		return fmt.Sprintf("over %g %s", math.Round(b.From*u.PerMPS), u.Speed)
+	}
+	return fmt.Sprintf("%g-%g %s", math.Round(b.From*u.PerMPS), math.Round(b.To*u.PerMPS), u.Speed)
+}
+
+func percent(part, whole float64) string {
+	if whole <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*part/whole)
+}
+
+func writeTable(w io.Writer, s tripstats.Stats, u report.Units, loc *time.Location) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	when := func(t time.Time) string { return t.In(loc).Format("2006-01-02 15:04:05 MST") }
+	fmt.Fprintf(tw, "Logs\t%d\n", s.Logs)
+	fmt.Fprintf(tw, "Records\t%d\n", s.Records)
+	fmt.Fprintf(tw, "From\t%s\n", when(s.Start))
+	fmt.Fprintf(tw, "To\t%s\n", when(s.End))
+	fmt.Fprintf(tw, "Logged time\t%s\n", report.FormatDuration(s.Logged))
+	fmt.Fprintf(tw, "Moving time\t%s\n", report.FormatDuration(s.Moving))
+	fmt.Fprintf(tw, "Distance\t%s\n", u.FormatDistance(s.Distance))
+	fmt.Fprintf(tw, "Max speed\t%s\n", u.FormatSpeed(s.MaxSpeed))
+	fmt.Fprintf(tw, "Average moving speed\t%s\n", u.FormatSpeed(s.AvgSpeed()))
+	if s.HasAltitude {
+		fmt.Fprintf(tw, "Elevation gain\t%s\n", u.FormatAltitude(s.Climb))
+		fmt.Fprintf(tw, "Elevation loss\t%s\n", u.FormatAltitude(s.Descent))
+		fmt.Fprintf(tw, "Lowest / highest\t%s / %s\n", u.FormatAltitude(s.MinAlt), u.FormatAltitude(s.MaxAlt))
+	}
+	if st := s.LongestStop; st.Duration > 0 {
+		fmt.Fprintf(tw, "Longest stop\t%s from %s at %.5f, %.5f\n", report.FormatDuration(st.Duration), when(st.Start), st.Lat, st.Long)
+	}
+
+	fmt.Fprintf(tw, "\nSpeed\tTime\t\tDistance\n")
+	for _, b := range s.Bands {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", bandName(b, u), report.FormatDuration(b.Time),
+			percent(b.Time.Seconds(), s.Logged.Seconds()), u.FormatDistance(b.Distance))
+	}
+
+	fmt.Fprintf(tw, "\nFix quality\tRecords\t\n")
+	for _, f := range gps.FixQualities {
+		if n := s.Fix[f]; n > 0 {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", f, n, percent(float64(n), float64(s.Records)))
+		}
+	}
+
+	fmt.Fprintf(tw, "\nSatellites\tRecords\t\n")
+	for _, n := range sortedSats(s.Sats) {
+		fmt.Fprintf(tw, "%d\t%d\t%s\n", n, s.Sats[n], percent(float64(s.Sats[n]), float64(s.Records)))
+	}
+
+	if s.Moving > 0 {
+		fmt.Fprintf(tw, "\nHour\tMoving\t\tDistance\n")
+	}
+	for h, hour := range s.Hours {
+		if hour.Moving > 0 {
+			fmt.Fprintf(tw, "%02d:00\t%s\t%s\t%s\n", h, report.FormatDuration(hour.Moving),
+				percent(hour.Moving.Seconds(), s.Moving.Seconds()), u.FormatDistance(hour.Distance))
+		}
+	}
+	tw.Flush()
+}
+
+func sortedSats(sats map[int64]int) []int64 {
+	var ns []int64
+	for n := range sats {
+		ns = append(ns, n)
+	}
+	sort.Slice(ns, func(i, j int) bool { return ns[i] < ns[j] })
+	return ns
+}
+
+// the JSON output, distances, speeds and altitudes in the chosen units and
+// times in seconds
+type jsonStats struct {
+	Units struct {
+		Distance string `json:"distance"`
+		Speed    string `json:"speed"`
+		Altitude string `json:"altitude"`
+	} `json:"units"`
+	Logs        int            `json:"logs"`
+	Records     int            `json:"records"`
+	Start       time.Time      `json:"start"`
+	End         time.Time      `json:"end"`
+	Logged      float64        `json:"logged_s"`
+	Moving      float64        `json:"moving_s"`
+	Distance    float64        `json:"distance"`
+	MaxSpeed    float64        `json:"max_speed"`
+	AvgSpeed    float64        `json:"avg_moving_speed"`
+	Climb       *float64       `json:"climb,omitempty"`
+	Descent     *float64       `json:"descent,omitempty"`
+	MinAlt      *float64       `json:"min_alt,omitempty"`
+	MaxAlt      *float64       `json:"max_alt,omitempty"`
+	LongestStop *jsonStop      `json:"longest_stop,omitempty"`
+	Bands       []jsonBand     `json:"speed_bands"`
+	Fix         map[string]int `json:"fix_quality"`
+	Sats        map[int64]int  `json:"satellites"`
+	Hours       []jsonHour     `json:"hours"`
+}
+
+type jsonStop struct {
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration_s"`
+	Lat      float64   `json:"lat"`
+	Long     float64   `json:"long"`
+}
+
+type jsonBand struct {
+	From     float64  `json:"from"`
+	To       *float64 `json:"to"` // null for the top band
+	Time     float64  `json:"time_s"`
+	Distance float64  `json:"distance"`
+}
+
+type jsonHour struct {
+	Hour     int     `json:"hour"`
+	Moving   float64 `json:"moving_s"`
+	Distance float64 `json:"distance"`
+}
+
+// round keeps JSON numbers to a sensible precision
+func round(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
+
+func toJSON(s tripstats.Stats, u report.Units, loc *time.Location) jsonStats {
+	j := jsonStats{
+		Logs: s.Logs, Records: s.Records,
+		Start: s.Start.In(loc), End: s.End.In(loc),
+		Logged: s.Logged.Seconds(), Moving: s.Moving.Seconds(),
+		Distance: round(s.Distance*u.PerMeter, 3),
+		MaxSpeed: round(s.MaxSpeed*u.PerMPS, 2),
+		AvgSpeed: round(s.AvgSpeed()*u.PerMPS, 2),
+		Fix:      map[string]int{},
+		Sats:     s.Sats,
+		Bands:    []jsonBand{},
+		Hours:    []jsonHour{},
+	}
+	j.Units.Distance, j.Units.Speed, j.Units.Altitude = u.Distance, u.Speed, u.Altitude
+	if s.HasAltitude {
+		alt := func(m float64) *float64 {
+			v := round(m*u.AltPerMeter, 1)
+			return &v
+		}
+		j.Climb, j.Descent, j.MinAlt, j.MaxAlt = alt(s.Climb), alt(s.Descent), alt(s.MinAlt), alt(s.MaxAlt)
+	}
+	if st := s.LongestStop; st.Duration > 0 {
+		j.LongestStop = &jsonStop{Start: st.Start.In(loc), Duration: st.Duration.Seconds(), Lat: st.Lat, Long: st.Long}
+	}
+	for _, b := range s.Bands {
+		jb := jsonBand{
+			From: round(b.From*u.PerMPS, 2), Time: b.Time.Seconds(),
+			Distance: round(b.Distance*u.PerMeter, 3),
+		}
+		if !math.IsInf(b.To, 1) {
+			to := round(b.To*u.PerMPS, 2)
+			jb.To = &to
+		}
+		j.Bands = append(j.Bands, jb)
+	}
+	for f, n := range s.Fix {
+		j.Fix[f.String()] = n
+	}
+	for h, hour := range s.Hours {
+		if hour.Moving > 0 {
+			j.Hours = append(j.Hours, jsonHour{h, hour.Moving.Seconds(), round(hour.Distance*u.PerMeter, 3)})
+		}
+	}
+	return j
+}
@@ -0,0 +1,141 @@
+package main
+
+// converts a track between any of the formats trackio reads and writes:
+//  the logger's CSV and track store, GPX, KML/KMZ, GeoJSON and raw NMEA in,
+//	and CSV, GPX, KML/KMZ, GeoJSON, TCX and FIT out. Any of them can be
+//	gzipped. Bad rows are skipped and summed up unless -strict, and the track
+//	can be clipped to a time range, box or polygon on the way
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/samiam2013/raspigogps/common/activity"
+	"github.com/samiam2013/raspigogps/common/clip"
+	"github.com/samiam2013/raspigogps/common/coord"
+	"github.com/samiam2013/raspigogps/common/pipeline"
+	"github.com/samiam2013/raspigogps/common/trackio"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+)
+
+func main() {
+	var inPath, outPath, from, to, name, wpPath, sportName, coordName string
+	var strict bool
+	var clipFrom, clipTo, clipBox, clipPoly string
+	opts := trackio.DefaultOptions("Track")
+	flag.StringVar(&inPath, "in", "", "Track to read, gzipped or not")
+	flag.StringVar(&from, "from", "", "Input format, one of "+strings.Join(trackio.Readable, ", ")+
+		", guessed from the file if empty")
+	flag.StringVar(&outPath, "out", "", "File to write, standard output if empty")
+	flag.StringVar(&to, "to", "", "Output format, one of "+strings.Join(trackio.Writable, ", ")+
+		", from -out's extension if empty")
+	flag.BoolVar(&strict, "strict", false, "Stop at the first bad row or point instead of skipping it")
+	flag.StringVar(&name, "name", "Track", "Name of the track in formats that have one")
+	flag.StringVar(&wpPath, "waypoints", "", "Waypoint file from cmd/waypoint to add as numbered points, for GPX, KML, KMZ and GeoJSON")
+	flag.StringVar(&sportName, "sport", "cycling", "Sport for TCX and FIT: cycling, running, driving or other")
+	flag.Float64Var(&opts.Laps.Distance, "lap-distance", 0, "TCX and FIT: start a new lap every this many meters")
+	flag.DurationVar(&opts.Laps.Duration, "lap-time", 0, "TCX and FIT: start a new lap after this long")
+	flag.StringVar(&coordName, "coords", "dd", "CSV: extra coordinate column, one of "+coord.FormatNames()+", dd for none")
+	flag.StringVar(&clipFrom, "start", "", "Drop records before this time, RFC 3339 or local 2006-01-02 15:04")
+	flag.StringVar(&clipTo, "end", "", "Drop records from this time on, RFC 3339 or local 2006-01-02 15:04")
+	flag.StringVar(&clipBox, "bbox", "", "Keep records inside west,south,east,north")
+	flag.StringVar(&clipPoly, "polygon", "", "Keep records inside the polygons in this GeoJSON or KML file")
+	flag.Parse()
+
+	if inPath == "" {
+		log.Fatal("-in is required")
+	}
+	if to == "" {
+		if to = trackio.FromExt(outPath); to == "" {
+			log.Fatal("-to is required when -out doesn't have a known extension")
+		}
+	}
+	writable := false
+	for _, f := range trackio.Writable {
+		writable = writable || f == to
+	}
+	if !writable {
+		log.Fatalf("Can't write %s, want one of %s", to, strings.Join(trackio.Writable, ", "))
+	}
+	clipper, err := clip.New(clipFrom, clipTo, clipBox, clipPoly)
+	if err != nil {
+		log.Fatalf("Bad clipping: %s", err.Error())
+	}
+	if opts.Sport, err = activity.ParseSport(sportName); err != nil {
+		log.Fatalf("Bad -sport: %s", err.Error())
+	}
+	opts.KML.Name, opts.GPX.Name, opts.GeoJSON.Name = name, name, name
+	if opts.Coords, err = coord.ParseFormat(coordName); err != nil {
+		log.Fatalf("Bad -coords: %s", err.Error())
+	}
+	if wpPath != "" {
+		if opts.Waypoints, err = waypoint.ReadFile(wpPath); err != nil {
+			log.Fatalf("Couldn't read waypoints: %s", err.Error())
+		}
+	}
+	if len(opts.Waypoints) > 0 {
+		var unplaced int
+		if opts.Waypoints, unplaced, err = pipeline.LocateWaypoints(inPath, from, opts.Waypoints, waypoint.DefaultMaxGap); err != nil {
+			log.Fatalf("Couldn't place waypoints on the track: %s", err.Error())
+		}
+		if unplaced > 0 {
+			log.Printf("Couldn't place %d waypoints marked without a fix, the track has no fix around them", unplaced)
+		}
+		kept := opts.Waypoints[:0]
+		for _, w := range opts.Waypoints {
+			if clipper.Contains(w.Record()) {
+				kept = append(kept, w)
+			}
+		}
+		opts.Waypoints = kept
+	}
+
+	sum := &trackio.Summary{}
+	bad := sum.Skip
+	if strict {
+		bad = nil
+	}
+	src, err := pipeline.Open(inPath, from, bad)
+	if err != nil {
+		log.Fatalf("Couldn't read %s: %s", inPath, err.Error())
+	}
+	defer src.Close()
+
+	out := os.Stdout
+	if outPath != "" {
+		if out, err = os.Create(outPath); err != nil {
+			log.Fatalf("Couldn't create output: %s", err.Error())
+		}
+	}
+	bw := bufio.NewWriter(out)
+	sink, err := trackio.NewWriter(bw, to, opts)
+	if err != nil {
+		log.Fatalf("Couldn't start the output: %s", err.Error())
+	}
+	p := &pipeline.Pipeline{Source: src, Sink: sink}
+	if !clipper.IsZero() {
+		p.Filters = append(p.Filters, clipper.Contains)
+	}
+	stats, err := p.Run()
+	sum.Format, sum.Records = src.Format, stats.Read
+	fmt.Fprintf(os.Stderr, "%s: %s\n", inPath, sum)
+	if !clipper.IsZero() {
+		fmt.Fprintf(os.Stderr, "%s: kept %d inside the clip\n", inPath, stats.Written)
+	}
+	if err != nil {
+		log.Fatalf("Couldn't convert %s to %s: %s", inPath, to, err.Error())
+	}
+	if stats.Written == 0 {
+		log.Fatal("No records to write")
+	}
+	if err := bw.Flush(); err != nil {
+		log.Fatalf("Couldn't write %s: %s", to, err.Error())
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("Couldn't close output: %s", err.Error())
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+// converts a binary track store (.trk) from the logger to a CSV track log,
+//  optionally only the records between two times
+
+import (
+	"bufio"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/tracklog"
+	"github.com/samiam2013/raspigogps/common/trackstore"
+)
+
+func main() {
+	var inPath, outPath, fromStr, toStr string
+	flag.StringVar(&inPath, "file", "", "Track store to convert")
+	flag.StringVar(&outPath, "out", "", "CSV file to write, standard output if empty")
+	flag.StringVar(&fromStr, "from", "", "Only records at or after this time (RFC3339)")
+	flag.StringVar(&toStr, "to", "", "Only records before this time (RFC3339)")
+	flag.Parse()
+	if inPath == "" {
+		log.Fatal("-file is required")
+	}
+	var from, to time.Time
+	var err error
+	if fromStr != "" {
+		if from, err = time.Parse(time.RFC3339, fromStr); err != nil {
+			log.Fatalf("Bad -from time: %s", err.Error())
+		}
+	}
+	if toStr != "" {
+		if to, err = time.Parse(time.RFC3339, toStr); err != nil {
+			log.Fatalf("Bad -to time: %s", err.Error())
+		}
+	}
+
+	s, err := trackstore.Open(inPath)
+	if err != nil {
+		log.Fatalf("Couldn't open track store: %s", err.Error())
+	}
+	defer s.Close()
+	records, err := s.Range(from, to)
+	if err != nil {
+		log.Fatalf("Couldn't read track store: %s", err.Error())
+	}
+	if s.Skipped > 0 || s.Torn > 0 {
+		log.Printf("Skipped %d corrupt bytes and %d bytes of torn writes", s.Skipped, s.Torn)
+	}
+
+	out := os.Stdout
+	if outPath != "" {
+		if out, err = os.Create(outPath); err != nil {
+			log.Fatalf("Couldn't create output: %s", err.Error())
+		}
+	}
+	bw := bufio.NewWriter(out)
+	if err := tracklog.WriteAll(bw, records); err != nil {
+		log.Fatalf("Couldn't write CSV: %s", err.Error())
+	}
+	if err := bw.Flush(); err != nil {
+		log.Fatalf("Couldn't write CSV: %s", err.Error())
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("Couldn't close output: %s", err.Error())
+	}
+	log.Printf("Wrote %d records", len(records))
+}
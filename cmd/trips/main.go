@@ -0,0 +1,77 @@
+package main
+
+// splits existing logs (CSV or track stores) into trips the same way the
+//  logger does live, writing a file per trip and the trip index
+//
+//	trips -out trips logs/track-2022-05-*.csv
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/geocode"
+	"github.com/samiam2013/raspigogps/common/trackstore"
+	"github.com/samiam2013/raspigogps/common/trip"
+)
+
+func main() {
+	var outDir, placesPath string
+	var stopFor, maxGap time.Duration
+	var minDist float64
+	flag.StringVar(&outDir, "out", "trips", "Directory to write trips and the trip index to")
+	flag.StringVar(&placesPath, "places", "", "Reverse geocoding index (from geoindex) to name where trips start and end")
+	flag.DurationVar(&stopFor, "stop", 3*time.Minute, "Stopped this long ends a trip")
+	flag.DurationVar(&maxGap, "gap", 5*time.Minute, "No fix for this long ends a trip")
+	flag.Float64Var(&minDist, "min-dist", 200, "Leave out trips shorter than this many meters")
+	flag.Parse()
+	paths := flag.Args()
+	if len(paths) == 0 {
+		log.Fatal("Give the logs to split as arguments")
+	}
+	// the logger names files by date so this is oldest first
+	sort.Strings(paths)
+
+	var places *geocode.Index
+	if placesPath != "" {
+		var err error
+		places, err = geocode.Open(placesPath)
+		if err != nil {
+			log.Fatalf("Couldn't load places index: %s", err.Error())
+		}
+	}
+	sink, err := trip.OpenFileSink(outDir, places)
+	if err != nil {
+		log.Fatalf("Couldn't open trips directory: %s", err.Error())
+	}
+	seg := trip.NewSegmenter(sink)
+	seg.StopDuration = stopFor
+	seg.MaxGap = maxGap
+	seg.MinDistance = minDist
+
+	for _, p := range paths {
+		records, err := trackstore.ReadFile(p)
+		if err != nil {
+			// keep what was read, a bad row is usually the torn last line
+			log.Printf("%s: %s", p, err.Error())
+		}
+		for _, gr := range records {
+			if err := seg.Add(gr); err != nil {
+				log.Fatalf("Couldn't write trip: %s", err.Error())
+			}
+		}
+	}
+	if err := seg.Close(); err != nil {
+		log.Fatalf("Couldn't write trip: %s", err.Error())
+	}
+	if err := sink.Close(); err != nil {
+		log.Fatalf("Couldn't close trip: %s", err.Error())
+	}
+
+	for _, t := range sink.Trips() {
+		fmt.Printf("%s  %s  %6.1f km  %-24s -> %s\n", t.ID, t.Duration().Round(time.Second),
+			t.Distance/1000, t.StartPlace, t.EndPlace)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"math"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/adrianmo/go-nmea"
+	"github.com/samiam2013/raspigogps/common/coord"
 	"github.com/sirupsen/logrus"
 	"github.com/tarm/serial" // TODO can this be replace by periphio?
 	"periph.io/x/conn/v3/gpio"
@@ -19,6 +21,15 @@ import (
 )
 
 func main() {
+	var coordName string
+	flag.StringVar(&coordName, "coords", "dd",
+		"Extra coordinate column appended to each waypoint line, one of "+coord.FormatNames())
+	flag.Parse()
+	coordFmt, err := coord.ParseFormat(coordName)
+	if err != nil {
+		logrus.WithError(err).Fatal("Bad coordinate format")
+	}
+
 	logrus.SetLevel(logrus.ErrorLevel)
 	if getProcessOwner() != "root" {
 		logrus.Fatalf("Must be run as root. user given '%s'", getProcessOwner())
@@ -88,6 +99,7 @@ func main() {
 	}(&engage)
 
 	gps := NewGPS()
+	defer gps.Close()
 	timeout := time.Second * 10
 	waypointCount := 0
 	lastWPTime := time.Now().Add(-1 * timeout)
@@ -120,11 +132,19 @@ func main() {
 		}
 		w, err := gps.GetWaypoint()
 		if err != nil {
-			logrus.WithError(err).Error("Couldn't get waypoint.")
+			// log the press at 0,0 anyway, the converter places it on the track
+			logrus.WithError(err).Error("Couldn't get waypoint, logging it without a fix.")
+			w = Waypoint{UnixMicroTime: time.Now().UnixMicro()}
 		}
 		lastWPTime = time.Now()
 		actualCount := ((waypointCount + 1) / 2)
-		fmt.Printf("%d,%f,%f,%d\n", w.UnixMicroTime, w.Latitude, w.Longitude, actualCount)
+		if coordFmt == coord.DD || (w.Latitude == 0 && w.Longitude == 0) {
+			fmt.Printf("%d,%f,%f,%d\n", w.UnixMicroTime, w.Latitude, w.Longitude, actualCount)
+		} else {
+			// keep the decimal columns so the lines stay readable by csvtokml
+			fmt.Printf("%d,%f,%f,%d,%s\n", w.UnixMicroTime, w.Latitude, w.Longitude, actualCount,
+				coordFmt.Format(w.Latitude, w.Longitude))
+		}
 		// fmt.Printf("Waypoint count: %d\n", actualCount)
 		inverse, err := time.ParseDuration(fmt.Sprintf("%fs", 1/math.Log10(float64(actualCount)*33)))
 		if err != nil {
@@ -139,7 +159,6 @@ func main() {
 		}
 		logrus.Info("Finishing count by blink")
 	}
-	gps.Close()
 }
 
 type GPS struct {
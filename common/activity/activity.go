@@ -0,0 +1,206 @@
+package activity
+
+// an activity is a track the way training platforms see it: a sport and
+//  laps, each lap split off after so much distance or time, with the totals
+//	the TCX and FIT encoders write for it
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// Sport is what the activity was
+type Sport int
+
+const (
+	Other Sport = iota
+	Cycling
+	Running
+	Driving
+)
+
+var sportNames = map[Sport]string{
+	Other:   "other",
+	Cycling: "cycling",
+	Running: "running",
+	Driving: "driving",
+}
+
+// Sports lists every sport, in the order used for help text
+var Sports = []Sport{Other, Cycling, Running, Driving}
+
+func (s Sport) String() string {
+	if name, ok := sportNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Sport(%d)", int(s))
+}
+
+// ParseSport looks up a sport by the name used in command line flags
+func ParseSport(name string) (Sport, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for s, n := range sportNames {
+		if n == name {
+			return s, nil
+		}
+	}
+	switch name {
+	case "biking", "bike", "cycle":
+		return Cycling, nil
+	case "run":
+		return Running, nil
+	case "drive", "car":
+		return Driving, nil
+	}
+	names := make([]string, len(Sports))
+	for i, s := range Sports {
+		names[i] = s.String()
+	}
+	return Other, fmt.Errorf("unknown sport '%s' (want one of %s)", name, strings.Join(names, ", "))
+}
+
+// Trigger is what ended a lap
+type Trigger int
+
+const (
+	TriggerEnd      Trigger = iota // the last lap, the activity ended
+	TriggerDistance                // Options.Distance was reached
+	TriggerTime                    // Options.Duration was reached
+)
+
+// Lap is a run of records with its totals
+type Lap struct {
+	// Records and Distances are only filled in by Split, a Splitter keeps
+	// just the totals. Distances is the distance from the start of the
+	// activity at each record
+	Records   []gps.GPSRecord
+	Distances []float64     // meters
+	First     gps.GPSRecord // the lap's first and last records
+	Last      gps.GPSRecord
+	Start     time.Time // the end of the lap before, or the first record
+	Distance  float64   // meters
+	MaxSpeed  float64   // meters per second
+	Trigger   Trigger
+}
+
+// End is the last record's time
+func (l Lap) End() time.Time {
+	return l.Last.Time()
+}
+
+// Duration is from the start to the last record
+func (l Lap) Duration() time.Duration {
+	return l.End().Sub(l.Start)
+}
+
+// AvgSpeed is the lap's distance over its duration in meters per second
+func (l Lap) AvgSpeed() float64 {
+	if s := l.Duration().Seconds(); s > 0 {
+		return l.Distance / s
+	}
+	return 0
+}
+
+// Options is where laps are split, each when it's non-zero. With both zero
+// the activity is one lap
+type Options struct {
+	Distance float64 // meters
+	Duration time.Duration
+}
+
+// Splitter splits records into laps as they arrive, for writers that can't
+// hold the whole activity. A lap ends on the record that reaches the
+// distance or duration, the step to the next record counts toward the next
+// lap, and the last record never ends a lap early since the activity ends
+// there anyway
+type Splitter struct {
+	opts    Options
+	lap     Lap
+	last    gps.GPSRecord
+	total   float64
+	started bool
+	ended   bool // the last record reached the distance or duration
+}
+
+// NewSplitter returns a splitter for laps of opts
+func NewSplitter(opts Options) *Splitter {
+	return &Splitter{opts: opts}
+}
+
+// Add takes the next record, returning its distance from the start and, when
+// it's the first record after a lap ended, that lap
+func (s *Splitter) Add(gr gps.GPSRecord) (distance float64, done *Lap) {
+	switch {
+	case !s.started:
+		s.started = true
+		s.lap = Lap{First: gr, Start: gr.Time()}
+	case s.ended:
+		prev := s.lap
+		done = &prev
+		s.lap = Lap{First: gr, Start: prev.End()}
+		s.ended = false
+		fallthrough
+	default:
+		d := gps.Distance(s.last, gr)
+		s.total += d
+		s.lap.Distance += d
+	}
+	s.last, s.lap.Last = gr, gr
+	s.lap.MaxSpeed = math.Max(s.lap.MaxSpeed, gr.SpeedMetersPerSecond())
+	switch {
+	case s.opts.Distance > 0 && s.lap.Distance >= s.opts.Distance:
+		s.lap.Trigger, s.ended = TriggerDistance, true
+	case s.opts.Duration > 0 && gr.Time().Sub(s.lap.Start) >= s.opts.Duration:
+		s.lap.Trigger, s.ended = TriggerTime, true
+	}
+	return s.total, done
+}
+
+// End returns the last lap, false if there weren't any records
+func (s *Splitter) End() (Lap, bool) {
+	if !s.started {
+		return Lap{}, false
+	}
+	s.lap.Trigger = TriggerEnd
+	return s.lap, true
+}
+
+// Split breaks the records into laps like a Splitter, with their records
+func Split(records []gps.GPSRecord, opts Options) []Lap {
+	s := NewSplitter(opts)
+	var laps []Lap
+	var lapRecords []gps.GPSRecord
+	var distances []float64
+	for _, gr := range records {
+		d, done := s.Add(gr)
+		if done != nil {
+			done.Records, done.Distances = lapRecords, distances
+			laps = append(laps, *done)
+			lapRecords, distances = nil, nil
+		}
+		lapRecords = append(lapRecords, gr)
+		distances = append(distances, d)
+	}
+	if last, ok := s.End(); ok {
+		last.Records, last.Distances = lapRecords, distances
+		laps = append(laps, last)
+	}
+	return laps
+}
+
+// Totals is the whole activity: its start, end, distance and top speed
+func Totals(laps []Lap) (start, end time.Time, distance, maxSpeed float64) {
+	if len(laps) == 0 {
+		return
+	}
+	start, end = laps[0].Start, laps[len(laps)-1].End()
+	for _, l := range laps {
+		distance += l.Distance
+		maxSpeed = math.Max(maxSpeed, l.MaxSpeed)
+	}
+	return start, end, distance, maxSpeed
+}
@@ -0,0 +1,85 @@
+package activity
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gpstest"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		lengths  []int
+		triggers []Trigger
+	}{
+		{"one lap", Options{}, []int{10}, []Trigger{TriggerEnd}},
+		{"by distance", Options{Distance: 40}, []int{5, 4, 1}, []Trigger{TriggerDistance, TriggerDistance, TriggerEnd}},
+		{"by time", Options{Duration: 3 * time.Second}, []int{4, 3, 3}, []Trigger{TriggerTime, TriggerTime, TriggerEnd}},
+		{"ends on a split", Options{Duration: 9 * time.Second}, []int{10}, []Trigger{TriggerEnd}},
+	}
+	// speeding up a knot a second
+	records := gpstest.Records(10, 0)
+	for i := range records {
+		records[i].Speed = float64(i)
+	}
+	_, _, whole, _ := Totals(Split(records, Options{}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			laps := Split(records, tt.opts)
+			if len(laps) != len(tt.lengths) {
+				t.Fatalf("got %d laps, want %d", len(laps), len(tt.lengths))
+			}
+			for i, l := range laps {
+				if len(l.Records) != tt.lengths[i] || l.Trigger != tt.triggers[i] {
+					t.Errorf("lap %d has %d records ended by %d, want %d by %d",
+						i, len(l.Records), l.Trigger, tt.lengths[i], tt.triggers[i])
+				}
+				if l.First != l.Records[0] || l.Last != l.Records[len(l.Records)-1] {
+					t.Errorf("lap %d runs from %v to %v, not its first and last records", i, l.First.Time(), l.Last.Time())
+				}
+				if i > 0 && !l.Start.Equal(laps[i-1].End()) {
+					t.Errorf("lap %d starts %v, not at the end of the last", i, l.Start)
+				}
+			}
+			// no distance is lost between laps
+			begin, end, distance, maxSpeed := Totals(laps)
+			if math.Abs(distance-whole) > 1e-6 || !begin.Equal(gpstest.Start) || end.Sub(begin) != 9*time.Second {
+				t.Errorf("Totals() = %v to %v, %vm, want 9s and %vm", begin, end, distance, whole)
+			}
+			last := laps[len(laps)-1]
+			if math.Abs(last.Distances[len(last.Distances)-1]-whole) > 1e-6 {
+				t.Errorf("distance at the last record = %v, want %v", last.Distances[len(last.Distances)-1], whole)
+			}
+			if maxSpeed != records[9].SpeedMetersPerSecond() {
+				t.Errorf("max speed = %v, want %v", maxSpeed, records[9].SpeedMetersPerSecond())
+			}
+		})
+	}
+	if laps := Split(nil, Options{}); laps != nil {
+		t.Errorf("Split(nil) = %v", laps)
+	}
+}
+
+func TestParseSport(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Sport
+		wantErr bool
+	}{
+		{"cycling", Cycling, false},
+		{"Bike", Cycling, false},
+		{"run", Running, false},
+		{"driving", Driving, false},
+		{"other", Other, false},
+		{"curling", Other, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSport(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSport(%s) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
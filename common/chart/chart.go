@@ -0,0 +1,224 @@
+package chart
+
+// speed and altitude profiles of a track against time or distance, with
+//  stops shaded and waypoints marked. Charts are drawn once and rendered
+//	either to SVG written by hand or to PNG with the standard library's image
+//	packages and common/font's bitmap font
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+)
+
+// Axis is what a chart's x axis measures
+type Axis int
+
+const (
+	ByTime     Axis = iota // seconds since the first record
+	ByDistance             // distance along the track
+)
+
+func (a Axis) String() string {
+	if a == ByDistance {
+		return "distance"
+	}
+	return "time"
+}
+
+// ParseAxis takes time or distance
+func ParseAxis(s string) (Axis, error) {
+	switch s {
+	case "time":
+		return ByTime, nil
+	case "distance":
+		return ByDistance, nil
+	}
+	return ByTime, fmt.Errorf("unknown axis '%s', want time or distance", s)
+}
+
+// Unit is a unit's name and how many of it there are in a meter, or a meter
+// per second for speeds
+type Unit struct {
+	Name string
+	Per  float64
+}
+
+// the units DefaultOptions labels charts in
+var (
+	MPH   = Unit{"mph", 3600 / 1609.344}
+	Miles = Unit{"mi", 1 / 1609.344}
+	Feet  = Unit{"ft", 3.28084}
+)
+
+// the thresholds for stops and gaps, chosen for a car
+const (
+	// StopSpeed in m/s, slower counts as stopped
+	StopSpeed = 0.6
+	// MinStop is how long the car has to stay stopped to be marked
+	MinStop = time.Minute
+	// MaxGap between records, longer breaks the line
+	MaxGap = 30 * time.Second
+)
+
+// Options says what to chart and how
+type Options struct {
+	X                         Axis
+	Speed, Distance, Altitude Unit
+	// Location times on the axis are shown in
+	Location *time.Location
+	// Waypoints to mark, ones outside the track are left off
+	Waypoints     []waypoint.Waypoint
+	Width, Height int
+}
+
+// DefaultOptions charts against time in imperial units and local time
+func DefaultOptions() Options {
+	return Options{
+		X: ByTime, Speed: MPH, Distance: Miles, Altitude: Feet,
+		Location: time.Local, Width: 800, Height: 220,
+	}
+}
+
+// Point is a value at a position along the x axis
+type Point struct {
+	X, Y float64
+	// Break starts a new line, the point comes after a gap
+	Break bool
+}
+
+// Span is a range of the x axis
+type Span struct {
+	From, To float64
+}
+
+// Mark is a labelled position on the x axis
+type Mark struct {
+	X     float64
+	Label string
+}
+
+// Chart is a profile ready to render
+type Chart struct {
+	Title  string // what's charted
+	YLabel string // the y axis unit
+	XLabel string
+	X      Axis
+	// Start is the time at x = 0 when X is ByTime, shown in Location
+	Start    time.Time
+	Location *time.Location
+	Points   []Point
+	Stops    []Span
+	Marks    []Mark
+	Width    int
+	Height   int
+}
+
+// Stop is the records from From to To inclusive, where the car stayed
+// stopped at least MinStop
+type Stop struct {
+	From, To int
+}
+
+// Stops finds where the car stopped, records in time order. A gap in the log
+// ends a stop
+func Stops(records []gps.GPSRecord) []Stop {
+	var stops []Stop
+	from := -1
+	end := func(to int) {
+		if from >= 0 && records[to].Time().Sub(records[from].Time()) >= MinStop {
+			stops = append(stops, Stop{from, to})
+		}
+		from = -1
+	}
+	for i, gr := range records {
+		if i > 0 && gr.Time().Sub(records[i-1].Time()) > MaxGap {
+			end(i - 1)
+		}
+		if gr.SpeedMetersPerSecond() >= StopSpeed {
+			if from >= 0 {
+				end(i - 1)
+			}
+			continue
+		}
+		if from < 0 {
+			from = i
+		}
+	}
+	if from >= 0 {
+		end(len(records) - 1)
+	}
+	return stops
+}
+
+// SpeedChart charts the records' speed
+func SpeedChart(records []gps.GPSRecord, opts Options) *Chart {
+	return newChart("Speed", opts.Speed, records, opts, gps.GPSRecord.SpeedMetersPerSecond)
+}
+
+// AltitudeChart charts the records' altitude above sea level
+func AltitudeChart(records []gps.GPSRecord, opts Options) *Chart {
+	return newChart("Altitude", opts.Altitude, records, opts, func(gr gps.GPSRecord) float64 {
+		return gr.Alt / 3.28084
+	})
+}
+
+// newChart charts value, in meters or m/s, scaled to unit
+func newChart(title string, unit Unit, records []gps.GPSRecord, opts Options, value func(gps.GPSRecord) float64) *Chart {
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	c := &Chart{
+		Title: title, YLabel: unit.Name, X: opts.X, Location: opts.Location,
+		Width: opts.Width, Height: opts.Height,
+	}
+	if c.Width <= 0 || c.Height <= 0 {
+		c.Width, c.Height = 800, 220
+	}
+	c.XLabel = "time"
+	if opts.X == ByDistance {
+		c.XLabel = "distance (" + opts.Distance.Name + ")"
+	}
+	if len(records) == 0 {
+		return c
+	}
+
+	c.Start = records[0].Time()
+	xs := make([]float64, len(records))
+	dist := 0.0
+	for i, gr := range records {
+		if i > 0 {
+			dist += gps.Distance(records[i-1], gr)
+		}
+		if opts.X == ByDistance {
+			xs[i] = dist * opts.Distance.Per
+		} else {
+			xs[i] = gr.Time().Sub(c.Start).Seconds()
+		}
+		c.Points = append(c.Points, Point{
+			X: xs[i], Y: value(gr) * unit.Per,
+			Break: i > 0 && gr.Time().Sub(records[i-1].Time()) > MaxGap,
+		})
+	}
+	for _, s := range Stops(records) {
+		c.Stops = append(c.Stops, Span{xs[s.From], xs[s.To]})
+	}
+
+	last := records[len(records)-1].Time()
+	for _, w := range opts.Waypoints {
+		if w.Time.Before(c.Start) || w.Time.After(last) {
+			continue
+		}
+		i := sort.Search(len(records), func(i int) bool { return !records[i].Time().Before(w.Time) })
+		x := xs[i]
+		if opts.X == ByTime {
+			x = w.Time.Sub(c.Start).Seconds()
+		}
+		c.Marks = append(c.Marks, Mark{x, strconv.Itoa(w.Number)})
+	}
+	return c
+}
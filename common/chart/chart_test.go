@@ -0,0 +1,170 @@
+package chart
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+)
+
+var start = time.Date(2022, 5, 20, 12, 0, 0, 0, time.UTC)
+
+// trip drives north at 20 m/s for 5 minutes, stops for 2, drives 3 more,
+// then after a 10 minute gap in the log drives another 2. It climbs a
+// meter every 10 seconds of driving
+func trip() []gps.GPSRecord {
+	var records []gps.GPSRecord
+	lat, alt := 38.6, 100.0
+	add := func(at time.Duration, speed float64) {
+		lat += speed / 110996
+		if speed > 0 {
+			alt += 0.1
+		}
+		records = append(records, gps.GPSRecord{
+			GPSTime: start.Add(at), Lat: lat, Long: -90.2,
+			Alt: alt * 3.28084, Speed: speed * 3.6 / 1.852,
+		})
+	}
+	for s := 0; s < 600; s++ {
+		speed := 20.0
+		if s >= 300 && s < 420 {
+			speed = 0
+		}
+		add(time.Duration(s)*time.Second, speed)
+	}
+	for s := 1200; s < 1320; s++ {
+		add(time.Duration(s)*time.Second, 20)
+	}
+	return records
+}
+
+func TestStops(t *testing.T) {
+	records := trip()
+	got := Stops(records)
+	if len(got) != 1 || got[0] != (Stop{300, 419}) {
+		t.Errorf("Stops = %v, want [{300 419}]", got)
+	}
+	// too short to count
+	if got := Stops(records[290:350]); len(got) != 0 {
+		t.Errorf("Stops of a 50s stop = %v, want none", got)
+	}
+}
+
+func TestCharts(t *testing.T) {
+	records := trip()
+	opts := DefaultOptions()
+	opts.Location = time.UTC
+	opts.Waypoints = []waypoint.Waypoint{
+		{Time: start.Add(100 * time.Second), Number: 1},
+		{Time: start.Add(time.Hour), Number: 2}, // after the track
+	}
+
+	c := SpeedChart(records, opts)
+	if len(c.Points) != len(records) || c.XLabel != "time" || c.YLabel != "mph" {
+		t.Fatalf("%d points labelled %s, %s", len(c.Points), c.XLabel, c.YLabel)
+	}
+	if p := c.Points[10]; p.X != 10 || math.Abs(p.Y-44.74) > 0.01 {
+		t.Errorf("Points[10] = %+v, want 10s at 44.74 mph", p)
+	}
+	if !c.Points[600].Break || c.Points[601].Break {
+		t.Error("the line should break after the gap only")
+	}
+	if len(c.Stops) != 1 || c.Stops[0] != (Span{300, 419}) {
+		t.Errorf("Stops = %v", c.Stops)
+	}
+	if len(c.Marks) != 1 || c.Marks[0] != (Mark{100, "1"}) {
+		t.Errorf("Marks = %v, want only waypoint 1", c.Marks)
+	}
+
+	opts.X = ByDistance
+	opts.Distance, opts.Altitude = Unit{"km", 0.001}, Unit{"m", 1}
+	c = AltitudeChart(records, opts)
+	if c.XLabel != "distance (km)" {
+		t.Errorf("XLabel = %s", c.XLabel)
+	}
+	// 8 minutes driven before the gap, 2 after at 20 m/s
+	last := c.Points[len(c.Points)-1]
+	if math.Abs(last.X-12) > 0.02 || math.Abs(last.Y-160) > 0.1 {
+		t.Errorf("last point %+v, want 12km at 160m", last)
+	}
+	if s := c.Stops[0]; math.Abs(s.From-5.98) > 0.01 || s.To != s.From {
+		t.Errorf("stop at %v, want a point 5.98km in", s)
+	}
+	if math.Abs(c.Marks[0].X-2) > 0.01 {
+		t.Errorf("waypoint at %.3fkm, want 2", c.Marks[0].X)
+	}
+}
+
+func TestRender(t *testing.T) {
+	records := trip()
+	for _, x := range []Axis{ByTime, ByDistance} {
+		opts := DefaultOptions()
+		opts.X = x
+		opts.Waypoints = []waypoint.Waypoint{{Time: start.Add(100 * time.Second), Number: 1}}
+		for _, c := range []*Chart{SpeedChart(records, opts), AltitudeChart(records, opts), SpeedChart(nil, opts)} {
+			s := c.SVG()
+			d := xml.NewDecoder(strings.NewReader(s))
+			for {
+				if _, err := d.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("%s by %s: bad SVG: %s", c.Title, x, err.Error())
+				}
+			}
+
+			var b bytes.Buffer
+			if err := c.WritePNG(&b); err != nil {
+				t.Fatalf("WritePNG: %s", err.Error())
+			}
+			img, err := png.Decode(&b)
+			if err != nil {
+				t.Fatalf("%s by %s: bad PNG: %s", c.Title, x, err.Error())
+			}
+			if got := img.Bounds().Size(); got.X != 800 || got.Y != 220 {
+				t.Errorf("PNG is %v, want 800x220", got)
+			}
+			lined := 0
+			for y := 0; y < 220; y++ {
+				for x := 0; x < 800; x++ {
+					if img.At(x, y) == lineColor {
+						lined++
+					}
+				}
+			}
+			if (len(c.Points) > 0) != (lined > 500) {
+				t.Errorf("%s by %s with %d points has %d pixels of line", c.Title, x, len(c.Points), lined)
+			}
+		}
+	}
+}
+
+func TestParseAxis(t *testing.T) {
+	for _, a := range []Axis{ByTime, ByDistance} {
+		if got, err := ParseAxis(a.String()); err != nil || got != a {
+			t.Errorf("ParseAxis(%s) = %v, %v", a, got, err)
+		}
+	}
+	if _, err := ParseAxis("altitude"); err == nil {
+		t.Error("ParseAxis(altitude) didn't fail")
+	}
+}
+
+func TestNice(t *testing.T) {
+	tests := []struct {
+		v, want float64
+	}{
+		{0, 0}, {-3, 0}, {1, 1}, {1.9, 1}, {2, 2}, {4.99, 2}, {7, 5}, {120, 100}, {0.37, 0.2},
+	}
+	for _, tt := range tests {
+		if got := Nice(tt.v); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("Nice(%v) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
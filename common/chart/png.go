@@ -0,0 +1,76 @@
+package chart
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+
+	"github.com/samiam2013/raspigogps/common/font"
+)
+
+type pngCanvas struct {
+	img *image.RGBA
+}
+
+func (p *pngCanvas) rect(x, y, w, h float64, fill color.RGBA) {
+	r := image.Rect(int(math.Round(x)), int(math.Round(y)), int(math.Round(x+w)), int(math.Round(y+h)))
+	r = r.Intersect(p.img.Bounds())
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		for px := r.Min.X; px < r.Max.X; px++ {
+			p.img.SetRGBA(px, py, fill)
+		}
+	}
+}
+
+// dot fills a square width pixels across centered on x, y
+func (p *pngCanvas) dot(x, y, width float64, c color.RGBA) {
+	half := math.Max(width, 1) / 2
+	p.rect(x-half, y-half, 2*half, 2*half, c)
+}
+
+// polyline steps along each segment half a pixel at a time, which is plenty
+// for lines a couple of pixels wide
+func (p *pngCanvas) polyline(points []pt, stroke color.RGBA, width float64) {
+	for i, a := range points {
+		if i == 0 {
+			p.dot(a.x, a.y, width, stroke)
+			continue
+		}
+		b := points[i-1]
+		n := int(math.Ceil(math.Hypot(a.x-b.x, a.y-b.y) * 2))
+		for k := 1; k <= n; k++ {
+			f := float64(k) / float64(n)
+			p.dot(b.x+(a.x-b.x)*f, b.y+(a.y-b.y)*f, width, stroke)
+		}
+	}
+}
+
+// text draws with the bitmap font, titles at twice the size
+func (p *pngCanvas) text(x, y float64, s string, a anchor, c color.RGBA, title bool) {
+	scale := 1
+	if title {
+		scale = 2
+	}
+	w := float64(font.TextWidth(s) * scale)
+	switch a {
+	case anchorMiddle:
+		x -= w / 2
+	case anchorEnd:
+		x -= w
+	}
+	font.Draw(p.img, int(math.Round(x)), int(math.Round(y))-font.Height*scale, s, c, scale)
+}
+
+// Image draws the chart
+func (c *Chart) Image() *image.RGBA {
+	p := &pngCanvas{img: image.NewRGBA(image.Rect(0, 0, c.Width, c.Height))}
+	c.draw(p)
+	return p.img
+}
+
+// WritePNG writes the chart as a PNG
+func (c *Chart) WritePNG(w io.Writer) error {
+	return png.Encode(w, c.Image())
+}
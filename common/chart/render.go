@@ -0,0 +1,217 @@
+package chart
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"time"
+)
+
+// the margins around the plot
+const (
+	marginLeft   = 52
+	marginRight  = 14
+	marginTop    = 28
+	marginBottom = 34
+)
+
+// the colors, the line matches the track on the report's map
+var (
+	white     = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	textColor = color.RGBA{0x33, 0x33, 0x33, 0xff}
+	tickColor = color.RGBA{0x55, 0x55, 0x55, 0xff}
+	gridColor = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+	axisColor = color.RGBA{0x99, 0x99, 0x99, 0xff}
+	stopColor = color.RGBA{0xfb, 0xe3, 0xc0, 0xff}
+	lineColor = color.RGBA{0xdd, 0x22, 0x22, 0xff}
+	markColor = color.RGBA{0x22, 0x66, 0xcc, 0xff}
+)
+
+// anchor is where text sits on its x
+type anchor int
+
+const (
+	anchorStart anchor = iota
+	anchorMiddle
+	anchorEnd
+)
+
+type pt struct {
+	x, y float64
+}
+
+// canvas is what a chart is drawn on, SVG or an image. Text y is the
+// baseline
+type canvas interface {
+	rect(x, y, w, h float64, fill color.RGBA)
+	polyline(points []pt, stroke color.RGBA, width float64)
+	text(x, y float64, s string, a anchor, c color.RGBA, title bool)
+}
+
+// timeSteps are the spacings tried for time axis ticks
+var timeSteps = []time.Duration{
+	time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+}
+
+// draw lays the chart out on cv
+func (c *Chart) draw(cv canvas) {
+	w, h := float64(c.Width), float64(c.Height)
+	cv.rect(0, 0, w, h, white)
+	cv.text(marginLeft, 17, fmt.Sprintf("%s (%s)", c.Title, c.YLabel), anchorStart, textColor, true)
+	cv.text(w-marginRight, h-4, c.XLabel, anchorEnd, tickColor, false)
+	plotW, plotH := w-marginLeft-marginRight, h-marginTop-marginBottom
+	if len(c.Points) == 0 {
+		cv.text(marginLeft+plotW/2, marginTop+plotH/2, "no data", anchorMiddle, tickColor, false)
+		return
+	}
+
+	x0, x1 := c.Points[0].X, c.Points[0].X
+	lo, hi := c.Points[0].Y, c.Points[0].Y
+	for _, p := range c.Points {
+		x0, x1 = math.Min(x0, p.X), math.Max(x1, p.X)
+		lo, hi = math.Min(lo, p.Y), math.Max(hi, p.Y)
+	}
+	if x1 <= x0 {
+		x1 = x0 + 1
+	}
+	step := Nice((hi - lo) / 4)
+	if step == 0 {
+		step = 1
+	}
+	lo, hi = math.Floor(lo/step)*step, math.Ceil(hi/step)*step
+	if hi == lo {
+		hi = lo + step
+	}
+	px := func(x float64) float64 { return marginLeft + (x-x0)/(x1-x0)*plotW }
+	py := func(y float64) float64 { return marginTop + (hi-y)/(hi-lo)*plotH }
+
+	// stops go under everything, at least a few pixels wide so they show
+	// on a distance axis where the car didn't move
+	for _, s := range c.Stops {
+		l, r := px(s.From), px(s.To)
+		if r-l < 3 {
+			l, r = (l+r)/2-1.5, (l+r)/2+1.5
+		}
+		cv.rect(l, marginTop, r-l, plotH, stopColor)
+	}
+
+	for y := lo; y <= hi+step/2; y += step {
+		cv.polyline([]pt{{marginLeft, py(y)}, {marginLeft + plotW, py(y)}}, gridColor, 1)
+		cv.text(marginLeft-6, py(y)+4, fmt.Sprintf("%g", y), anchorEnd, tickColor, false)
+	}
+	for _, t := range c.xTicks(x0, x1) {
+		x := px(t.x)
+		cv.polyline([]pt{{x, marginTop + plotH}, {x, marginTop + plotH + 4}}, axisColor, 1)
+		cv.text(x, h-18, t.label, anchorMiddle, tickColor, false)
+	}
+	cv.polyline([]pt{{marginLeft, marginTop}, {marginLeft, marginTop + plotH}, {marginLeft + plotW, marginTop + plotH}}, axisColor, 1)
+
+	for _, l := range lines(c.Points, px, py) {
+		cv.polyline(l, lineColor, 1.5)
+	}
+
+	for _, m := range c.Marks {
+		x := px(m.X)
+		cv.polyline([]pt{{x, marginTop}, {x, marginTop + plotH}}, markColor, 1)
+		bw := float64(6*len(m.Label) + 6)
+		cv.rect(x-bw/2, marginTop, bw, 12, markColor)
+		cv.text(x, marginTop+10, m.Label, anchorMiddle, white, false)
+	}
+}
+
+type tick struct {
+	x     float64
+	label string
+}
+
+// xTicks are round times or distances across the axis
+func (c *Chart) xTicks(x0, x1 float64) []tick {
+	var ticks []tick
+	if c.X == ByDistance {
+		step := Nice((x1 - x0) / 5)
+		for x := math.Ceil(x0/step) * step; x <= x1; x += step {
+			ticks = append(ticks, tick{x, fmt.Sprintf("%g", math.Round(x/step)*step)})
+		}
+		return ticks
+	}
+	t0 := c.Start.Add(time.Duration(x0 * float64(time.Second)))
+	t1 := c.Start.Add(time.Duration(x1 * float64(time.Second)))
+	step := timeSteps[len(timeSteps)-1]
+	for _, s := range timeSteps {
+		if t1.Sub(t0)/s <= 6 {
+			step = s
+			break
+		}
+	}
+	for t := t0.Truncate(step); !t.After(t1); t = t.Add(step) {
+		if t.Before(t0) {
+			continue
+		}
+		ticks = append(ticks, tick{t.Sub(c.Start).Seconds(), t.In(c.Location).Format("15:04")})
+	}
+	return ticks
+}
+
+// lines thins points to the lowest and highest of each pixel column, in
+// order, so peaks survive however long the track is. A new line starts at
+// each break
+func lines(points []Point, px, py func(float64) float64) [][]pt {
+	var out [][]pt
+	var cur []pt
+	col, begin := math.MinInt, 0
+	flush := func(end int) {
+		if col == math.MinInt {
+			return
+		}
+		lowI, highI := begin, begin
+		for i := begin; i < end; i++ {
+			if points[i].Y < points[lowI].Y {
+				lowI = i
+			}
+			if points[i].Y > points[highI].Y {
+				highI = i
+			}
+		}
+		idx := []int{lowI, highI}
+		sort.Ints(idx)
+		for k, i := range idx {
+			if k == 1 && i == idx[0] {
+				break
+			}
+			cur = append(cur, pt{px(points[i].X), py(points[i].Y)})
+		}
+	}
+	for i, p := range points {
+		c := int(px(p.X))
+		if c != col || p.Break {
+			flush(i)
+			if p.Break && len(cur) > 0 {
+				out = append(out, cur)
+				cur = nil
+			}
+			col, begin = c, i
+		}
+	}
+	flush(len(points))
+	if len(cur) > 0 {
+		out = append(out, cur)
+	}
+	return out
+}
+
+// Nice is the largest 1, 2 or 5 times a power of ten at most v, a round
+// step for an axis or scale bar
+func Nice(v float64) float64 {
+	if v <= 0 {
+		return 0
+	}
+	p := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{5, 2, 1} {
+		if m*p <= v {
+			return m * p
+		}
+	}
+	return p
+}
@@ -0,0 +1,63 @@
+package chart
+
+import (
+	"fmt"
+	"html"
+	"image/color"
+	"io"
+	"strings"
+)
+
+type svgCanvas struct {
+	b strings.Builder
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func (s *svgCanvas) rect(x, y, w, h float64, fill color.RGBA) {
+	fmt.Fprintf(&s.b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`, x, y, w, h, hex(fill))
+}
+
+func (s *svgCanvas) polyline(points []pt, stroke color.RGBA, width float64) {
+	s.b.WriteString(`<path d="`)
+	for i, p := range points {
+		cmd := "L"
+		if i == 0 {
+			cmd = "M"
+		}
+		fmt.Fprintf(&s.b, "%s%.1f,%.1f", cmd, p.x, p.y)
+	}
+	fmt.Fprintf(&s.b, `" fill="none" stroke="%s" stroke-width="%g" stroke-linejoin="round"/>`, hex(stroke), width)
+}
+
+func (s *svgCanvas) text(x, y float64, str string, a anchor, c color.RGBA, title bool) {
+	attrs := `font-size="11"`
+	if title {
+		attrs = `font-size="13" font-weight="bold"`
+	}
+	switch a {
+	case anchorMiddle:
+		attrs += ` text-anchor="middle"`
+	case anchorEnd:
+		attrs += ` text-anchor="end"`
+	}
+	fmt.Fprintf(&s.b, `<text x="%.1f" y="%.1f" %s fill="%s">%s</text>`, x, y, attrs, hex(c), html.EscapeString(str))
+}
+
+// SVG is the chart as an svg element, to stand alone or go inline in HTML
+func (c *Chart) SVG() string {
+	s := &svgCanvas{}
+	fmt.Fprintf(&s.b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" class="chart" role="img" aria-label="%s" font-family="sans-serif">`,
+		c.Width, c.Height, html.EscapeString(c.Title))
+	c.draw(s)
+	s.b.WriteString(`</svg>`)
+	return s.b.String()
+}
+
+// WriteSVG writes the chart as an SVG file
+func (c *Chart) WriteSVG(w io.Writer) error {
+	_, err := io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+c.SVG()+"\n")
+	return err
+}
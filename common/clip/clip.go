@@ -0,0 +1,159 @@
+package clip
+
+// clipping a track to a time range, a bounding box or the inside of
+//  polygons, for exporting just Tuesday afternoon or just the laps of a
+//	track day venue. A Clip is a filter on single records so it can run in
+//	the conversion pipeline before simplifying
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// localLayouts are the times ParseTime takes besides RFC 3339, in the local
+// time zone
+var localLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTime reads an RFC 3339 time, or a date with an optional time of day
+// in loc
+func ParseTime(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't read time '%s', want RFC 3339 or a local 2006-01-02 15:04:05", s)
+}
+
+// BBox is a box of longitudes and latitudes, edges included
+type BBox struct {
+	West, South, East, North float64
+}
+
+// ParseBBox reads west,south,east,north in degrees, GeoJSON's bbox order
+func ParseBBox(s string) (BBox, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 4 {
+		return BBox{}, fmt.Errorf("bounding box '%s' needs west,south,east,north", s)
+	}
+	var v [4]float64
+	for i, f := range fields {
+		var err error
+		if v[i], err = strconv.ParseFloat(strings.TrimSpace(f), 64); err != nil {
+			return BBox{}, fmt.Errorf("bad bounding box edge '%s'", f)
+		}
+	}
+	b := BBox{West: v[0], South: v[1], East: v[2], North: v[3]}
+	if b.South > b.North || b.South < -90 || b.North > 90 || b.West < -180 || b.East > 180 {
+		return BBox{}, fmt.Errorf("bounding box '%s' is out of range or upside down", s)
+	}
+	return b, nil
+}
+
+// Contains reports whether a position is in the box. A box whose West is
+// east of its East crosses the antimeridian
+func (b BBox) Contains(long, lat float64) bool {
+	if lat < b.South || lat > b.North {
+		return false
+	}
+	if b.West <= b.East {
+		return long >= b.West && long <= b.East
+	}
+	return long >= b.West || long <= b.East
+}
+
+// Clip keeps the records inside every limit that's set
+type Clip struct {
+	// From and To are a half open time range, a zero one is open
+	From, To time.Time
+	Box      *BBox
+	// Polygons keep records inside any of them
+	Polygons []Polygon
+}
+
+// New makes a clip from command line style arguments, each empty one isn't a
+// limit. Local times are in time.Local and polygonPath is a GeoJSON or KML
+// file
+func New(from, to, bbox, polygonPath string) (Clip, error) {
+	var c Clip
+	var err error
+	if from != "" {
+		if c.From, err = ParseTime(from, time.Local); err != nil {
+			return c, err
+		}
+	}
+	if to != "" {
+		if c.To, err = ParseTime(to, time.Local); err != nil {
+			return c, err
+		}
+	}
+	if !c.From.IsZero() && !c.To.IsZero() && !c.From.Before(c.To) {
+		return c, fmt.Errorf("start %s isn't before end %s", c.From, c.To)
+	}
+	if bbox != "" {
+		b, err := ParseBBox(bbox)
+		if err != nil {
+			return c, err
+		}
+		c.Box = &b
+	}
+	if polygonPath != "" {
+		if c.Polygons, err = ReadPolygonFile(polygonPath); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// IsZero reports whether the clip keeps everything
+func (c Clip) IsZero() bool {
+	return c.From.IsZero() && c.To.IsZero() && c.Box == nil && len(c.Polygons) == 0
+}
+
+// Contains reports whether a record is kept
+func (c Clip) Contains(gr gps.GPSRecord) bool {
+	t := gr.Time()
+	if !c.From.IsZero() && t.Before(c.From) {
+		return false
+	}
+	if !c.To.IsZero() && !t.Before(c.To) {
+		return false
+	}
+	if c.Box != nil && !c.Box.Contains(gr.Long, gr.Lat) {
+		return false
+	}
+	if len(c.Polygons) == 0 {
+		return true
+	}
+	for _, p := range c.Polygons {
+		if p.Contains(gr.Long, gr.Lat) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns the records the clip keeps, in order
+func (c Clip) Apply(records []gps.GPSRecord) []gps.GPSRecord {
+	kept := make([]gps.GPSRecord, 0, len(records))
+	for _, gr := range records {
+		if c.Contains(gr) {
+			kept = append(kept, gr)
+		}
+	}
+	return kept
+}
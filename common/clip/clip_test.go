@@ -0,0 +1,165 @@
+package clip
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+var start = time.Date(2022, time.May, 24, 12, 0, 0, 0, time.UTC)
+
+func TestParseTime(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skipf("no zoneinfo: %v", err)
+	}
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2022-05-24T12:00:00Z", start, false},
+		{"2022-05-24T07:00:00-05:00", start, false},
+		{"2022-05-24 07:00", start, false},
+		{"2022-05-24T07:00:00", start, false},
+		{"2022-05-24", start.Add(-7 * time.Hour), false},
+		{"tuesday", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.in, chicago)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%s) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseBBox(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantErr bool
+		inside  [2]float64 // long, lat
+	}{
+		{"-90.3,38.5,-90.1,38.7", false, [2]float64{-90.2, 38.6}},
+		{"170,-10,-170,10", false, [2]float64{179.5, 0}}, // over the antimeridian
+		{"-90.3,38.7,-90.1,38.5", true, [2]float64{}},
+		{"-90.3,38.5,-90.1", true, [2]float64{}},
+		{"west,38.5,-90.1,38.7", true, [2]float64{}},
+	}
+	for _, tt := range tests {
+		b, err := ParseBBox(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseBBox(%s) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (!b.Contains(tt.inside[0], tt.inside[1]) || b.Contains(0, 50)) {
+			t.Errorf("%s Contains() is wrong", tt.in)
+		}
+	}
+}
+
+// venue is a 2 by 2 square with a 1 by 1 hole in the middle
+var venue = Polygon{
+	Outer: []Point{{0, 0}, {2, 0}, {2, 2}, {0, 2}},
+	Holes: [][]Point{{{0.5, 0.5}, {1.5, 0.5}, {1.5, 1.5}, {0.5, 1.5}, {0.5, 0.5}}},
+}
+
+func TestPolygonContains(t *testing.T) {
+	tests := []struct {
+		long, lat float64
+		want      bool
+	}{
+		{0.25, 0.25, true},
+		{1.75, 1, true},
+		{1, 1, false}, // in the hole
+		{3, 1, false},
+		{-0.1, 1, false},
+	}
+	for _, tt := range tests {
+		if got := venue.Contains(tt.long, tt.lat); got != tt.want {
+			t.Errorf("Contains(%v, %v) = %v, want %v", tt.long, tt.lat, got, tt.want)
+		}
+	}
+}
+
+func TestReadPolygonFile(t *testing.T) {
+	tests := []struct {
+		name, doc string
+		polygons  int
+		wantErr   bool
+	}{
+		{"geojson", `{"type":"FeatureCollection","features":[
+			{"type":"Feature","geometry":{"type":"Polygon","coordinates":
+				[[[0,0],[2,0],[2,2],[0,2],[0,0]],[[0.5,0.5],[1.5,0.5],[1.5,1.5],[0.5,1.5],[0.5,0.5]]]}},
+			{"type":"Feature","geometry":{"type":"MultiPolygon","coordinates":
+				[[[[5,5],[6,5],[6,6],[5,5]]],[[[7,7],[8,7],[8,8],[7,7]]]]}},
+			{"type":"Feature","geometry":{"type":"Point","coordinates":[1,1]}}]}`, 3, false},
+		{"kml", `<?xml version="1.0"?><kml xmlns="http://www.opengis.net/kml/2.2"><Document><Placemark>
+			<Polygon><outerBoundaryIs><LinearRing><coordinates>0,0,0 2,0,0 2,2,0 0,2,0 0,0,0</coordinates></LinearRing></outerBoundaryIs>
+			<innerBoundaryIs><LinearRing><coordinates>0.5,0.5 1.5,0.5 1.5,1.5 0.5,1.5</coordinates></LinearRing></innerBoundaryIs>
+			</Polygon></Placemark></Document></kml>`, 1, false},
+		{"no polygons", `{"type":"Point","coordinates":[1,1]}`, 0, true},
+		{"short ring", `{"type":"Polygon","coordinates":[[[0,0],[1,1]]]}`, 0, true},
+		{"csv", "unixmicro,lat,long\n", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "venue")
+			if err := os.WriteFile(path, []byte(tt.doc), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			polys, err := ReadPolygonFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadPolygonFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(polys) != tt.polygons {
+				t.Fatalf("ReadPolygonFile() = %d polygons, want %d", len(polys), tt.polygons)
+			}
+			if len(polys) > 0 && (!polys[0].Contains(0.25, 0.25) || polys[0].Contains(1, 1)) {
+				t.Errorf("first polygon = %+v, want the venue with its hole", polys[0])
+			}
+		})
+	}
+}
+
+func TestClip(t *testing.T) {
+	// a record a minute for an hour, heading east across the venue
+	var records []gps.GPSRecord
+	for i := 0; i < 60; i++ {
+		tm := start.Add(time.Duration(i) * time.Minute)
+		records = append(records, gps.GPSRecord{
+			UnixMicro: uint64(tm.UnixMicro()),
+			Lat:       0.25,
+			Long:      -0.975 + float64(i)*0.05,
+			GPSTime:   tm,
+		})
+	}
+	tests := []struct {
+		name string
+		clip Clip
+		want int
+	}{
+		{"nothing", Clip{}, 60},
+		{"from", Clip{From: start.Add(50 * time.Minute)}, 10},
+		{"range", Clip{From: start.Add(10 * time.Minute), To: start.Add(20 * time.Minute)}, 10},
+		{"box", Clip{Box: &BBox{West: 0, South: 0, East: 1, North: 1}}, 20},
+		{"polygon", Clip{Polygons: []Polygon{venue}}, 40},
+		{"polygon and time", Clip{Polygons: []Polygon{venue}, To: start.Add(30 * time.Minute)}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := len(tt.clip.Apply(records)); got != tt.want {
+				t.Errorf("Apply() kept %d, want %d", got, tt.want)
+			}
+		})
+	}
+	if !(Clip{}).IsZero() || (Clip{Polygons: []Polygon{venue}}).IsZero() {
+		t.Error("IsZero() is wrong")
+	}
+	if _, err := New("2022-05-24 13:00", "2022-05-24 12:00", "", ""); err == nil || !strings.Contains(err.Error(), "isn't before") {
+		t.Errorf("New() with end before start error = %v", err)
+	}
+}
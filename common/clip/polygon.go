@@ -0,0 +1,231 @@
+package clip
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Point is a polygon vertex in degrees
+type Point struct {
+	Long, Lat float64
+}
+
+// Polygon is an outer ring with optional holes, each ring's last point
+// joins back to its first whether or not it repeats it
+type Polygon struct {
+	Outer []Point
+	Holes [][]Point
+}
+
+// inRing is the even-odd test, treating degrees as flat which is fine for
+// anything smaller than a country
+func inRing(ring []Point, long, lat float64) bool {
+	in := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > lat) != (b.Lat > lat) &&
+			long < (b.Long-a.Long)*(lat-a.Lat)/(b.Lat-a.Lat)+a.Long {
+			in = !in
+		}
+	}
+	return in
+}
+
+// Contains reports whether a position is inside the outer ring and not in a
+// hole
+func (p Polygon) Contains(long, lat float64) bool {
+	if !inRing(p.Outer, long, lat) {
+		return false
+	}
+	for _, h := range p.Holes {
+		if inRing(h, long, lat) {
+			return false
+		}
+	}
+	return true
+}
+
+func toRing(positions [][]float64) ([]Point, error) {
+	if len(positions) < 3 {
+		return nil, fmt.Errorf("a ring needs at least 3 positions, got %d", len(positions))
+	}
+	ring := make([]Point, 0, len(positions))
+	for _, pos := range positions {
+		if len(pos) < 2 {
+			return nil, fmt.Errorf("position %v needs long and lat", pos)
+		}
+		ring = append(ring, Point{Long: pos[0], Lat: pos[1]})
+	}
+	return ring, nil
+}
+
+func toPolygon(rings [][][]float64) (Polygon, error) {
+	var p Polygon
+	if len(rings) == 0 {
+		return p, fmt.Errorf("polygon has no rings")
+	}
+	var err error
+	if p.Outer, err = toRing(rings[0]); err != nil {
+		return p, err
+	}
+	for _, r := range rings[1:] {
+		hole, err := toRing(r)
+		if err != nil {
+			return p, err
+		}
+		p.Holes = append(p.Holes, hole)
+	}
+	return p, nil
+}
+
+// geoJSON is any GeoJSON object, read for its polygons
+type geoJSON struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSON        `json:"geometry"`
+	Geometries  []geoJSON       `json:"geometries"`
+	Features    []geoJSON       `json:"features"`
+}
+
+func (g geoJSON) polygons() ([]Polygon, error) {
+	var polys []Polygon
+	switch g.Type {
+	case "Polygon":
+		var rings [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &rings); err != nil {
+			return nil, fmt.Errorf("bad Polygon coordinates: %w", err)
+		}
+		p, err := toPolygon(rings)
+		if err != nil {
+			return nil, err
+		}
+		polys = append(polys, p)
+	case "MultiPolygon":
+		var multi [][][][]float64
+		if err := json.Unmarshal(g.Coordinates, &multi); err != nil {
+			return nil, fmt.Errorf("bad MultiPolygon coordinates: %w", err)
+		}
+		for _, rings := range multi {
+			p, err := toPolygon(rings)
+			if err != nil {
+				return nil, err
+			}
+			polys = append(polys, p)
+		}
+	case "Feature":
+		if g.Geometry != nil {
+			return g.Geometry.polygons()
+		}
+	case "FeatureCollection", "GeometryCollection":
+		for _, sub := range append(g.Features, g.Geometries...) {
+			p, err := sub.polygons()
+			if err != nil {
+				return nil, err
+			}
+			polys = append(polys, p...)
+		}
+	}
+	return polys, nil
+}
+
+// ReadGeoJSON reads every Polygon and MultiPolygon in a GeoJSON document
+func ReadGeoJSON(r io.Reader) ([]Polygon, error) {
+	var g geoJSON
+	if err := json.NewDecoder(r).Decode(&g); err != nil {
+		return nil, fmt.Errorf("not a GeoJSON file: %w", err)
+	}
+	return g.polygons()
+}
+
+// kmlPolygon is the parts of a KML Polygon with positions
+type kmlPolygon struct {
+	Outer string   `xml:"outerBoundaryIs>LinearRing>coordinates"`
+	Inner []string `xml:"innerBoundaryIs>LinearRing>coordinates"`
+}
+
+// kmlRing reads a coordinates element's long,lat[,alt] tuples
+func kmlRing(text string) ([][]float64, error) {
+	var positions [][]float64
+	for _, tuple := range strings.Fields(text) {
+		fields := strings.Split(tuple, ",")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("bad coordinate '%s'", tuple)
+		}
+		pos := make([]float64, 2)
+		for i := range pos {
+			var err error
+			if pos[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+				return nil, fmt.Errorf("bad coordinate '%s'", tuple)
+			}
+		}
+		positions = append(positions, pos)
+	}
+	return positions, nil
+}
+
+// ReadKML reads every Polygon in a KML document, wherever it is
+func ReadKML(r io.Reader) ([]Polygon, error) {
+	dec := xml.NewDecoder(r)
+	var polys []Polygon
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return polys, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("not a KML file: %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "Polygon" {
+			continue
+		}
+		var kp kmlPolygon
+		if err := dec.DecodeElement(&kp, &start); err != nil {
+			return nil, fmt.Errorf("not a KML file: %w", err)
+		}
+		var rings [][][]float64
+		for _, text := range append([]string{kp.Outer}, kp.Inner...) {
+			ring, err := kmlRing(text)
+			if err != nil {
+				return nil, err
+			}
+			rings = append(rings, ring)
+		}
+		p, err := toPolygon(rings)
+		if err != nil {
+			return nil, err
+		}
+		polys = append(polys, p)
+	}
+}
+
+// ReadPolygonFile reads the polygons from a GeoJSON or KML file, telling
+// which by its first character
+func ReadPolygonFile(path string) ([]Polygon, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var polys []Polygon
+	switch trimmed := bytes.TrimLeft(data, "\xef\xbb\xbf \t\r\n"); {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		polys, err = ReadGeoJSON(bytes.NewReader(data))
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		polys, err = ReadKML(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("%s isn't GeoJSON or KML", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(polys) == 0 {
+		return nil, fmt.Errorf("%s has no polygons", path)
+	}
+	return polys, nil
+}
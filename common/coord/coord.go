@@ -0,0 +1,275 @@
+package coord
+
+// formatting and parsing of coordinates in the notations the display and the
+//  command line tools can be switched between. Every format is plain ASCII
+//	(no degree signs) so it can be drawn with the 8x8 font on the OLED and
+//	dropped in a CSV column without quoting
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Format is a coordinate notation
+type Format int
+
+const (
+	DD         Format = iota // decimal degrees
+	DDM                      // degrees and decimal minutes
+	DMS                      // degrees, minutes and seconds
+	UTM                      // universal transverse mercator
+	MGRS                     // military grid reference system
+	Maidenhead               // amateur radio grid locator
+	Geohash
+)
+
+var formatNames = map[Format]string{
+	DD:         "dd",
+	DDM:        "ddm",
+	DMS:        "dms",
+	UTM:        "utm",
+	MGRS:       "mgrs",
+	Maidenhead: "maidenhead",
+	Geohash:    "geohash",
+}
+
+// Formats lists every supported format, in the order used for help text
+var Formats = []Format{DD, DDM, DMS, UTM, MGRS, Maidenhead, Geohash}
+
+func (f Format) String() string {
+	if name, ok := formatNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// ParseFormat looks up a format by the name used in command line flags
+func ParseFormat(name string) (Format, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for f, n := range formatNames {
+		if n == name {
+			return f, nil
+		}
+	}
+	// a couple of common aliases
+	switch name {
+	case "grid", "qth", "locator":
+		return Maidenhead, nil
+	case "decimal":
+		return DD, nil
+	}
+	return DD, fmt.Errorf("unknown coordinate format '%s' (want one of %s)", name, FormatNames())
+}
+
+// FormatNames returns the flag names of all formats, comma separated
+func FormatNames() string {
+	names := make([]string, 0, len(Formats))
+	for _, f := range Formats {
+		names = append(names, f.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// Parts formats a position as one or more short strings, each at most 15
+// characters so that they fit on a line of the display
+func (f Format) Parts(lat, long float64) []string {
+	switch f {
+	case DDM:
+		return []string{formatDDM(lat, "N", "S", 2), formatDDM(long, "E", "W", 3)}
+	case DMS:
+		return []string{formatDMS(lat, "N", "S", 2), formatDMS(long, "E", "W", 3)}
+	case UTM:
+		u, err := ToUTM(lat, long)
+		if err != nil {
+			return []string{"out of range"}
+		}
+		return []string{
+			fmt.Sprintf("%d%c %06.0fE", u.Zone, u.Band, math.Floor(u.Easting)),
+			fmt.Sprintf("%07.0fN", math.Floor(u.Northing)),
+		}
+	case MGRS:
+		m, err := ToMGRS(lat, long, 5)
+		if err != nil {
+			return []string{"out of range"}
+		}
+		// zone, band and square on one line, the digits on the next
+		return []string{m[:5], m[6:]}
+	case Maidenhead:
+		return []string{ToMaidenhead(lat, long, 3)}
+	case Geohash:
+		return []string{GeohashEncode(lat, long, 9)}
+	default:
+		return []string{fmt.Sprintf("%.6f", lat), fmt.Sprintf("%.6f", long)}
+	}
+}
+
+// Format returns the position as a single string
+func (f Format) Format(lat, long float64) string {
+	return strings.Join(f.Parts(lat, long), " ")
+}
+
+// Parse reads a position written in the format. DD, DDM and DMS are read
+// leniently and also accept degree, minute and second symbols
+func (f Format) Parse(s string) (lat, long float64, err error) {
+	switch f {
+	case DD, DDM, DMS:
+		return parseAngles(s)
+	case UTM:
+		u, err := ParseUTM(s)
+		if err != nil {
+			return 0, 0, err
+		}
+		return u.LatLong()
+	case MGRS:
+		return ParseMGRS(s)
+	case Maidenhead:
+		return ParseMaidenhead(s)
+	case Geohash:
+		return GeohashDecode(s)
+	}
+	return 0, 0, fmt.Errorf("unknown format %s", f.String())
+}
+
+func hemisphere(v float64, pos, neg string) (string, float64) {
+	if v < 0 {
+		return neg, -v
+	}
+	return pos, v
+}
+
+func formatDDM(v float64, pos, neg string, degWidth int) string {
+	h, v := hemisphere(v, pos, neg)
+	deg := math.Floor(v)
+	min := (v - deg) * 60
+	// don't let rounding print 60 minutes
+	if min >= 59.99995 {
+		deg++
+		min = 0
+	}
+	return fmt.Sprintf("%s%0*.0f %07.4f", h, degWidth, deg, min)
+}
+
+func formatDMS(v float64, pos, neg string, degWidth int) string {
+	h, v := hemisphere(v, pos, neg)
+	// work in hundredths of a second so the carry is exact
+	total := math.Round(v * 360000)
+	deg := math.Floor(total / 360000)
+	min := math.Floor((total - deg*360000) / 6000)
+	sec := (total - deg*360000 - min*6000) / 100
+	return fmt.Sprintf("%s%0*.0f %02.0f %05.2f", h, degWidth, deg, min, sec)
+}
+
+type angleGroup struct {
+	nums []float64
+	hemi byte
+	neg  bool
+}
+
+func (g angleGroup) value() (float64, error) {
+	if len(g.nums) == 0 || len(g.nums) > 3 {
+		return 0, fmt.Errorf("expected 1 to 3 numbers per coordinate, got %d", len(g.nums))
+	}
+	v := 0.0
+	for i, n := range g.nums {
+		if n < 0 {
+			return 0, fmt.Errorf("only the degrees may be negative")
+		}
+		if i > 0 && n >= 60 {
+			return 0, fmt.Errorf("minutes and seconds must be under 60, got %v", n)
+		}
+		v += n / math.Pow(60, float64(i))
+	}
+	if g.neg || g.hemi == 'S' || g.hemi == 'W' {
+		v = -v
+	}
+	return v, nil
+}
+
+// parseAngles reads a latitude and longitude pair written as decimal degrees,
+// degrees decimal minutes or degrees minutes seconds, with hemisphere letters
+// either before or after each coordinate (or signs instead of letters)
+func parseAngles(s string) (float64, float64, error) {
+	norm := strings.ToUpper(s)
+	for _, sym := range []string{"°", "º", "'", "′", "\"", "″", ",", ";", ":"} {
+		norm = strings.ReplaceAll(norm, sym, " ")
+	}
+	for _, h := range []string{"N", "S", "E", "W"} {
+		norm = strings.ReplaceAll(norm, h, " "+h+" ")
+	}
+	tokens := strings.Fields(norm)
+	if len(tokens) == 0 {
+		return 0, 0, fmt.Errorf("empty coordinate")
+	}
+	isHemi := func(t string) bool {
+		return len(t) == 1 && strings.ContainsAny(t, "NSEW")
+	}
+
+	groups := make([]angleGroup, 0, 2)
+	prefix := isHemi(tokens[0])
+	var cur angleGroup
+	for _, t := range tokens {
+		if isHemi(t) {
+			if prefix {
+				if len(cur.nums) > 0 {
+					groups = append(groups, cur)
+				}
+				cur = angleGroup{hemi: t[0]}
+			} else {
+				cur.hemi = t[0]
+				groups = append(groups, cur)
+				cur = angleGroup{}
+			}
+			continue
+		}
+		n, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("could not parse '%s' in '%s'", t, s)
+		}
+		if math.Signbit(n) {
+			cur.neg = true
+			n = -n
+		}
+		cur.nums = append(cur.nums, n)
+	}
+	if len(cur.nums) > 0 {
+		groups = append(groups, cur)
+	}
+
+	// without hemisphere letters split the numbers evenly
+	if len(groups) == 1 && groups[0].hemi == 0 && len(groups[0].nums)%2 == 0 {
+		nums := groups[0].nums
+		half := len(nums) / 2
+		groups = []angleGroup{{nums: nums[:half]}, {nums: nums[half:]}}
+		// the sign was recorded on the group, find which half it belonged to
+		for i, t := range tokens {
+			if strings.HasPrefix(t, "-") {
+				if i < half {
+					groups[0].neg = true
+				} else {
+					groups[1].neg = true
+				}
+			}
+		}
+	}
+	if len(groups) != 2 {
+		return 0, 0, fmt.Errorf("expected a latitude and a longitude in '%s'", s)
+	}
+	// allow the longitude to be written first if the letters say so
+	if groups[0].hemi == 'E' || groups[0].hemi == 'W' {
+		groups[0], groups[1] = groups[1], groups[0]
+	}
+	lat, err := groups[0].value()
+	if err != nil {
+		return 0, 0, err
+	}
+	long, err := groups[1].value()
+	if err != nil {
+		return 0, 0, err
+	}
+	if math.Abs(lat) > 90 || math.Abs(long) > 180 {
+		return 0, 0, fmt.Errorf("coordinate out of range in '%s'", s)
+	}
+	return lat, long, nil
+}
@@ -0,0 +1,85 @@
+package coord
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	// the Gateway Arch in St. Louis
+	lat, long := 38.624691, -90.184776
+	tests := []struct {
+		name   string
+		format Format
+		want   string
+	}{
+		{name: "decimal degrees", format: DD, want: "38.624691 -90.184776"},
+		{name: "degrees decimal minutes", format: DDM, want: "N38 37.4815 W090 11.0866"},
+		{name: "degrees minutes seconds", format: DMS, want: "N38 37 28.89 W090 11 05.19"},
+		{name: "utm", format: UTM, want: "15S 745079E 4278889N"},
+		{name: "mgrs", format: MGRS, want: "15SYC 45079 78889"},
+		{name: "maidenhead", format: Maidenhead, want: "EM48vo"},
+		{name: "geohash", format: Geohash, want: "9yzgez3mn"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.format.Format(lat, long); got != tt.want {
+				t.Errorf("Format() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   Format
+		input    string
+		wantLat  float64
+		wantLong float64
+		// how close the round trip has to be, in degrees
+		tolerance float64
+		wantErr   bool
+	}{
+		{name: "signed decimal", format: DD, input: "38.624691, -90.184776",
+			wantLat: 38.624691, wantLong: -90.184776, tolerance: 1e-9},
+		{name: "decimal with suffixes", format: DD, input: "38.624691N 90.184776W",
+			wantLat: 38.624691, wantLong: -90.184776, tolerance: 1e-9},
+		{name: "ddm with prefixes", format: DDM, input: "N38 37.4815 W090 11.0866",
+			wantLat: 38.624691, wantLong: -90.184776, tolerance: 1e-5},
+		{name: "dms with symbols", format: DMS, input: `38°37'28.89"N 90°11'05.19"W`,
+			wantLat: 38.624691, wantLong: -90.184776, tolerance: 1e-5},
+		{name: "dms longitude first", format: DMS, input: "W090 11 05.19 N38 37 28.89",
+			wantLat: 38.624691, wantLong: -90.184776, tolerance: 1e-5},
+		{name: "dms bad minutes", format: DMS, input: "N38 61 28.89 W090 11 05.19", wantErr: true},
+		{name: "utm", format: UTM, input: "15S 745079E 4278889N",
+			wantLat: 38.624691, wantLong: -90.184776, tolerance: 1e-4},
+		{name: "utm southern hemisphere", format: UTM, input: "56H 334900 6252290",
+			wantLat: -33.856784, wantLong: 151.215297, tolerance: 1e-4},
+		{name: "mgrs", format: MGRS, input: "15SYC4507978889",
+			wantLat: 38.624691, wantLong: -90.184776, tolerance: 1e-4},
+		{name: "mgrs southern hemisphere", format: MGRS, input: "56H LH 34900 52290",
+			wantLat: -33.856784, wantLong: 151.215297, tolerance: 1e-4},
+		{name: "mgrs bad square", format: MGRS, input: "15SAI 44880 78700", wantErr: true},
+		{name: "maidenhead", format: Maidenhead, input: "EM48vo",
+			wantLat: 38.6041667, wantLong: -90.208333, tolerance: 1e-6},
+		{name: "maidenhead odd length", format: Maidenhead, input: "EM48w", wantErr: true},
+		{name: "geohash", format: Geohash, input: "ezs42",
+			wantLat: 42.605, wantLong: -5.603, tolerance: 1e-3},
+		{name: "geohash bad character", format: Geohash, input: "ezs4a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lat, long, err := tt.format.Parse(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if math.Abs(lat-tt.wantLat) > tt.tolerance || math.Abs(long-tt.wantLong) > tt.tolerance {
+				t.Errorf("Parse() = %v, %v, want %v, %v", lat, long, tt.wantLat, tt.wantLong)
+			}
+		})
+	}
+}
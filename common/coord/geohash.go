@@ -0,0 +1,76 @@
+package coord
+
+import (
+	"fmt"
+	"strings"
+)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// GeohashEncode returns a geohash of the given length (9 characters is
+// roughly 5m)
+func GeohashEncode(lat, long float64, length int) string {
+	if length < 1 {
+		length = 1
+	} else if length > 12 {
+		length = 12
+	}
+	latRange := [2]float64{-90, 90}
+	longRange := [2]float64{-180, 180}
+	var b strings.Builder
+	even := true
+	bit, ch := 0, 0
+	for b.Len() < length {
+		// bits alternate between longitude and latitude, starting with longitude
+		r, v := &latRange, lat
+		if even {
+			r, v = &longRange, long
+		}
+		mid := (r[0] + r[1]) / 2
+		if v >= mid {
+			ch = ch<<1 | 1
+			r[0] = mid
+		} else {
+			ch <<= 1
+			r[1] = mid
+		}
+		even = !even
+		bit++
+		if bit == 5 {
+			b.WriteByte(geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return b.String()
+}
+
+// GeohashDecode returns the center of the geohash cell
+func GeohashDecode(hash string) (float64, float64, error) {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	if len(hash) == 0 {
+		return 0, 0, fmt.Errorf("empty geohash")
+	}
+	latRange := [2]float64{-90, 90}
+	longRange := [2]float64{-180, 180}
+	even := true
+	for i := 0; i < len(hash); i++ {
+		idx := strings.IndexByte(geohashAlphabet, hash[i])
+		if idx < 0 {
+			return 0, 0, fmt.Errorf("invalid geohash character '%c'", hash[i])
+		}
+		for mask := 16; mask > 0; mask >>= 1 {
+			r := &latRange
+			if even {
+				r = &longRange
+			}
+			mid := (r[0] + r[1]) / 2
+			if idx&mask != 0 {
+				r[0] = mid
+			} else {
+				r[1] = mid
+			}
+			even = !even
+		}
+	}
+	return (latRange[0] + latRange[1]) / 2, (longRange[0] + longRange[1]) / 2, nil
+}
@@ -0,0 +1,90 @@
+package coord
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ToMaidenhead returns the locator with the given number of pairs, 1 (field,
+// "EM") through 4 (extended square, "EM58ab12"). 3 pairs is the usual
+// six character locator
+func ToMaidenhead(lat, long float64, pairs int) string {
+	if pairs < 1 {
+		pairs = 1
+	} else if pairs > 4 {
+		pairs = 4
+	}
+	// shift to positive and keep the poles/antimeridian inside the grid
+	lon := long + 180
+	la := lat + 90
+	if lon >= 360 {
+		lon = 359.999999
+	}
+	if la >= 180 {
+		la = 179.999999
+	}
+
+	var b strings.Builder
+	lonSize, latSize := 20.0, 10.0
+	for i := 0; i < pairs; i++ {
+		lonIdx := int(lon / lonSize)
+		latIdx := int(la / latSize)
+		switch i {
+		case 0:
+			b.WriteByte(byte('A' + lonIdx))
+			b.WriteByte(byte('A' + latIdx))
+		case 2:
+			b.WriteByte(byte('a' + lonIdx))
+			b.WriteByte(byte('a' + latIdx))
+		default:
+			b.WriteByte(byte('0' + lonIdx))
+			b.WriteByte(byte('0' + latIdx))
+		}
+		lon -= float64(lonIdx) * lonSize
+		la -= float64(latIdx) * latSize
+		// fields split into 10 squares, squares into 24 subsquares, and so on
+		div := 10.0
+		if i == 1 {
+			div = 24.0
+		}
+		lonSize /= div
+		latSize /= div
+	}
+	return b.String()
+}
+
+// ParseMaidenhead returns the center of the locator's square
+func ParseMaidenhead(s string) (float64, float64, error) {
+	loc := strings.ToUpper(strings.TrimSpace(s))
+	if len(loc) == 0 || len(loc)%2 != 0 || len(loc) > 8 {
+		return 0, 0, fmt.Errorf("locator '%s' must have 2, 4, 6 or 8 characters", s)
+	}
+	lon, lat := -180.0, -90.0
+	lonSize, latSize := 20.0, 10.0
+	for i := 0; i < len(loc)/2; i++ {
+		lc, ac := loc[2*i], loc[2*i+1]
+		var lonIdx, latIdx, limit int
+		switch i {
+		case 0:
+			lonIdx, latIdx, limit = int(lc)-'A', int(ac)-'A', 18
+		case 2:
+			lonIdx, latIdx, limit = int(lc)-'A', int(ac)-'A', 24
+		default:
+			lonIdx, latIdx, limit = int(lc)-'0', int(ac)-'0', 10
+		}
+		if lonIdx < 0 || lonIdx >= limit || latIdx < 0 || latIdx >= limit {
+			return 0, 0, fmt.Errorf("invalid character pair '%c%c' in locator '%s'", lc, ac, s)
+		}
+		lon += float64(lonIdx) * lonSize
+		lat += float64(latIdx) * latSize
+		if i < len(loc)/2-1 {
+			div := 10.0
+			if i == 1 {
+				div = 24.0
+			}
+			lonSize /= div
+			latSize /= div
+		}
+	}
+	return lat + latSize/2, lon + lonSize/2, nil
+}
@@ -0,0 +1,281 @@
+package coord
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// WGS84 ellipsoid and UTM projection constants
+const (
+	wgs84A     = 6378137.0
+	wgs84F     = 1 / 298.257223563
+	utmK0      = 0.9996
+	falseEast  = 500000.0
+	falseNorth = 10000000.0
+)
+
+const bandLetters = "CDEFGHJKLMNPQRSTUVWX"
+
+// UTMCoord is a position in universal transverse mercator
+type UTMCoord struct {
+	Zone     int
+	Band     byte // latitude band letter, C through X
+	Easting  float64
+	Northing float64
+}
+
+// North reports whether the coordinate is in the northern hemisphere
+func (u UTMCoord) North() bool {
+	return u.Band >= 'N'
+}
+
+func (u UTMCoord) String() string {
+	return fmt.Sprintf("%d%c %.0f %.0f", u.Zone, u.Band, u.Easting, u.Northing)
+}
+
+func utmZone(lat, long float64) int {
+	zone := int(math.Floor((long+180)/6)) + 1
+	if zone > 60 {
+		zone = 1
+	}
+	// the exceptions around southern Norway and Svalbard
+	if lat >= 56 && lat < 64 && long >= 3 && long < 12 {
+		return 32
+	}
+	if lat >= 72 && lat < 84 && long >= 0 && long < 42 {
+		switch {
+		case long < 9:
+			return 31
+		case long < 21:
+			return 33
+		case long < 33:
+			return 35
+		default:
+			return 37
+		}
+	}
+	return zone
+}
+
+func latBand(lat float64) byte {
+	i := int(math.Floor((lat + 80) / 8))
+	if i > len(bandLetters)-1 {
+		// band X is 12 degrees tall
+		i = len(bandLetters) - 1
+	}
+	return bandLetters[i]
+}
+
+// ToUTM projects a WGS84 position into its UTM zone. Polar regions (covered
+// by UPS) return an error
+func ToUTM(lat, long float64) (UTMCoord, error) {
+	if lat < -80 || lat > 84 {
+		return UTMCoord{}, fmt.Errorf("latitude %f is outside of UTM coverage", lat)
+	}
+	zone := utmZone(lat, long)
+	e, n := project(lat, long, zone)
+	return UTMCoord{Zone: zone, Band: latBand(lat), Easting: e, Northing: n}, nil
+}
+
+// project does the forward transverse mercator projection into the given zone
+func project(lat, long float64, zone int) (float64, float64) {
+	e2 := wgs84F * (2 - wgs84F)
+	ep2 := e2 / (1 - e2)
+	phi := lat * math.Pi / 180
+	lambda0 := float64((zone-1)*6-180+3) * math.Pi / 180
+	lambda := long * math.Pi / 180
+
+	sinPhi, cosPhi := math.Sin(phi), math.Cos(phi)
+	n := wgs84A / math.Sqrt(1-e2*sinPhi*sinPhi)
+	t := math.Tan(phi) * math.Tan(phi)
+	c := ep2 * cosPhi * cosPhi
+	a := cosPhi * (lambda - lambda0)
+	m := meridianArc(phi)
+
+	easting := utmK0*n*(a+(1-t+c)*math.Pow(a, 3)/6+
+		(5-18*t+t*t+72*c-58*ep2)*math.Pow(a, 5)/120) + falseEast
+	northing := utmK0 * (m + n*math.Tan(phi)*(a*a/2+
+		(5-t+9*c+4*c*c)*math.Pow(a, 4)/24+
+		(61-58*t+t*t+600*c-330*ep2)*math.Pow(a, 6)/720))
+	if lat < 0 {
+		northing += falseNorth
+	}
+	return easting, northing
+}
+
+func meridianArc(phi float64) float64 {
+	e2 := wgs84F * (2 - wgs84F)
+	e4, e6 := e2*e2, e2*e2*e2
+	return wgs84A * ((1-e2/4-3*e4/64-5*e6/256)*phi -
+		(3*e2/8+3*e4/32+45*e6/1024)*math.Sin(2*phi) +
+		(15*e4/256+45*e6/1024)*math.Sin(4*phi) -
+		(35*e6/3072)*math.Sin(6*phi))
+}
+
+// LatLong converts back to WGS84 degrees
+func (u UTMCoord) LatLong() (float64, float64, error) {
+	if u.Zone < 1 || u.Zone > 60 {
+		return 0, 0, fmt.Errorf("zone %d out of range", u.Zone)
+	}
+	e2 := wgs84F * (2 - wgs84F)
+	e4, e6 := e2*e2, e2*e2*e2
+	ep2 := e2 / (1 - e2)
+	x := u.Easting - falseEast
+	y := u.Northing
+	if !u.North() {
+		y -= falseNorth
+	}
+	lambda0 := float64((u.Zone-1)*6-180+3) * math.Pi / 180
+
+	mu := y / utmK0 / (wgs84A * (1 - e2/4 - 3*e4/64 - 5*e6/256))
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+	phi1 := mu + (3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+		(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+		(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+		(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+
+	sin1, cos1, tan1 := math.Sin(phi1), math.Cos(phi1), math.Tan(phi1)
+	c1 := ep2 * cos1 * cos1
+	t1 := tan1 * tan1
+	n1 := wgs84A / math.Sqrt(1-e2*sin1*sin1)
+	r1 := wgs84A * (1 - e2) / math.Pow(1-e2*sin1*sin1, 1.5)
+	d := x / (n1 * utmK0)
+
+	phi := phi1 - (n1*tan1/r1)*(d*d/2-
+		(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+		(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+	lambda := lambda0 + (d-(1+2*t1+c1)*math.Pow(d, 3)/6+
+		(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120)/cos1
+	return phi * 180 / math.Pi, lambda * 180 / math.Pi, nil
+}
+
+// ParseUTM reads "16S 123456 4123456", also accepting E/N suffixes on the
+// numbers and a space between the zone and band
+func ParseUTM(s string) (UTMCoord, error) {
+	fields := strings.Fields(strings.ToUpper(s))
+	if len(fields) == 4 {
+		// "16 S 123456 4123456"
+		fields = []string{fields[0] + fields[1], fields[2], fields[3]}
+	}
+	if len(fields) != 3 {
+		return UTMCoord{}, fmt.Errorf("expected zone, easting and northing in '%s'", s)
+	}
+	zb := fields[0]
+	if len(zb) < 2 {
+		return UTMCoord{}, fmt.Errorf("missing latitude band in '%s'", s)
+	}
+	zone, err := strconv.Atoi(zb[:len(zb)-1])
+	if err != nil || zone < 1 || zone > 60 {
+		return UTMCoord{}, fmt.Errorf("invalid zone in '%s'", s)
+	}
+	band := zb[len(zb)-1]
+	if !strings.ContainsRune(bandLetters, rune(band)) {
+		return UTMCoord{}, fmt.Errorf("invalid latitude band '%c'", band)
+	}
+	easting, err := strconv.ParseFloat(strings.TrimSuffix(fields[1], "E"), 64)
+	if err != nil {
+		return UTMCoord{}, fmt.Errorf("invalid easting: %w", err)
+	}
+	northing, err := strconv.ParseFloat(strings.TrimSuffix(fields[2], "N"), 64)
+	if err != nil {
+		return UTMCoord{}, fmt.Errorf("invalid northing: %w", err)
+	}
+	return UTMCoord{Zone: zone, Band: band, Easting: easting, Northing: northing}, nil
+}
+
+// MGRS 100km square letters, columns cycle every three zones and rows are
+// offset by five letters in even zones
+var mgrsColumns = [3]string{"ABCDEFGH", "JKLMNPQR", "STUVWXYZ"}
+
+const mgrsRows = "ABCDEFGHJKLMNPQRSTUV"
+
+// ToMGRS returns the grid reference with precision digits per axis
+// (5 is 1m, 1 is 10km)
+func ToMGRS(lat, long float64, precision int) (string, error) {
+	if precision < 0 || precision > 5 {
+		return "", fmt.Errorf("precision must be between 0 and 5")
+	}
+	u, err := ToUTM(lat, long)
+	if err != nil {
+		return "", err
+	}
+	col := int(math.Floor(u.Easting/100000)) - 1
+	row := int(math.Floor(u.Northing/100000)) % 20
+	if u.Zone%2 == 0 {
+		row = (row + 5) % 20
+	}
+	if col < 0 || col > 7 {
+		return "", fmt.Errorf("easting %f is outside of the zone", u.Easting)
+	}
+	colLetter := mgrsColumns[(u.Zone-1)%3][col]
+	rowLetter := mgrsRows[row]
+
+	div := math.Pow(10, float64(5-precision))
+	e := math.Floor(math.Mod(u.Easting, 100000) / div)
+	n := math.Floor(math.Mod(u.Northing, 100000) / div)
+	ref := fmt.Sprintf("%02d%c%c%c", u.Zone, u.Band, colLetter, rowLetter)
+	if precision == 0 {
+		return ref, nil
+	}
+	return fmt.Sprintf("%s %0*.0f %0*.0f", ref, precision, e, precision, n), nil
+}
+
+// ParseMGRS reads a grid reference with or without spaces and returns the
+// south west corner of the referenced square
+func ParseMGRS(s string) (float64, float64, error) {
+	ref := strings.ToUpper(strings.Join(strings.Fields(s), ""))
+	i := 0
+	for i < len(ref) && i < 2 && ref[i] >= '0' && ref[i] <= '9' {
+		i++
+	}
+	if i == 0 || len(ref) < i+3 {
+		return 0, 0, fmt.Errorf("malformed grid reference '%s'", s)
+	}
+	zone, _ := strconv.Atoi(ref[:i])
+	if zone < 1 || zone > 60 {
+		return 0, 0, fmt.Errorf("invalid zone in '%s'", s)
+	}
+	band := ref[i]
+	bandIdx := strings.IndexByte(bandLetters, band)
+	if bandIdx < 0 {
+		return 0, 0, fmt.Errorf("invalid latitude band '%c'", band)
+	}
+	col := strings.IndexByte(mgrsColumns[(zone-1)%3], ref[i+1])
+	row := strings.IndexByte(mgrsRows, ref[i+2])
+	if col < 0 || row < 0 {
+		return 0, 0, fmt.Errorf("invalid 100km square '%s'", ref[i+1:i+3])
+	}
+	if zone%2 == 0 {
+		row = (row + 15) % 20
+	}
+	digits := ref[i+3:]
+	if len(digits)%2 != 0 || len(digits) > 10 {
+		return 0, 0, fmt.Errorf("uneven grid digits '%s'", digits)
+	}
+	var e, n float64
+	if len(digits) > 0 {
+		half := len(digits) / 2
+		scale := math.Pow(10, float64(5-half))
+		ev, err := strconv.Atoi(digits[:half])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid easting digits: %w", err)
+		}
+		nv, err := strconv.Atoi(digits[half:])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid northing digits: %w", err)
+		}
+		e, n = float64(ev)*scale, float64(nv)*scale
+	}
+	easting := float64(col+1)*100000 + e
+	northing := float64(row)*100000 + n
+
+	// the row letters repeat every 2000km, pick the cycle that falls in the band
+	bandLat := -80 + float64(bandIdx)*8
+	_, bandNorthing := project(bandLat, float64((zone-1)*6-180+3), zone)
+	for northing < bandNorthing-500000 {
+		northing += 2000000
+	}
+	return UTMCoord{Zone: zone, Band: band, Easting: easting, Northing: northing}.LatLong()
+}
@@ -0,0 +1,382 @@
+package fit
+
+// Garmin FIT activity files: a 14 byte header, definition and data messages
+//  for file_id, a timer start event, a record per fix, a lap per
+//	activity.Lap, then the session and activity, and a CRC over the lot.
+//	Only the fields we have values for are defined, everything little endian
+
+import (
+	"errors"
+	"io"
+	"math"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/activity"
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/spool"
+)
+
+// the header's protocol 2.0 and profile 21.32 versions
+const (
+	protocolVersion = 0x20
+	profileVersion  = 2132
+	headerSize      = 14
+)
+
+// epoch is when FIT's date_time counts seconds from
+var epoch = time.Date(1989, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// global message numbers
+const (
+	mesgFileID   = 0
+	mesgSession  = 18
+	mesgLap      = 19
+	mesgRecord   = 20
+	mesgEvent    = 21
+	mesgActivity = 34
+)
+
+// base types
+const (
+	typeEnum    = 0x00
+	typeSint32  = 0x85
+	typeUint16  = 0x84
+	typeUint32  = 0x86
+	typeUint32z = 0x8c
+)
+
+// enum values used here
+const (
+	fileActivity         = 4
+	manufacturerDev      = 255
+	eventTimer           = 0
+	eventLap             = 9
+	eventSession         = 8
+	eventActivity        = 26
+	eventTypeStart       = 0
+	eventTypeStop        = 1
+	eventTypeStopAll     = 4
+	lapTriggerTime       = 1
+	lapTriggerDistance   = 2
+	lapTriggerSessionEnd = 7
+	activityManual       = 0
+)
+
+// the timestamp field every message but file_id has
+const fieldTimestamp = 253
+
+// crcTable is the nibble table of FIT's CRC-16
+var crcTable = [16]uint16{
+	0x0000, 0xCC01, 0xD801, 0x1400, 0xF001, 0x3C00, 0x2800, 0xE401,
+	0xA001, 0x6C00, 0x7800, 0xB401, 0x5000, 0x9C01, 0x8801, 0x4400,
+}
+
+// CRC is FIT's CRC-16 of data continuing from crc
+func CRC(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		tmp := crcTable[crc&0xf]
+		crc = (crc >> 4) & 0x0fff
+		crc = crc ^ tmp ^ crcTable[b&0xf]
+		tmp = crcTable[crc&0xf]
+		crc = (crc >> 4) & 0x0fff
+		crc = crc ^ tmp ^ crcTable[(b>>4)&0xf]
+	}
+	return crc
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+type field struct {
+	num, size, base byte
+	value           uint64
+}
+
+// encoder writes messages to w, each global message gets the next local
+// number and is defined the first time it's written. It counts what it wrote
+// for the header
+type encoder struct {
+	w      io.Writer
+	buf    []byte
+	locals map[uint16]byte
+	size   int64
+}
+
+func (e *encoder) message(global uint16, fields ...field) error {
+	e.buf = e.buf[:0]
+	local, ok := e.locals[global]
+	if !ok {
+		if len(e.locals) == 16 {
+			return errors.New("fit: more than 16 message types")
+		}
+		local = byte(len(e.locals))
+		e.locals[global] = local
+		e.buf = append(e.buf, 0x40|local, 0, 0) // definition, reserved, little endian
+		e.buf = appendUint16(e.buf, global)
+		e.buf = append(e.buf, byte(len(fields)))
+		for _, f := range fields {
+			e.buf = append(e.buf, f.num, f.size, f.base)
+		}
+	}
+	e.buf = append(e.buf, local)
+	for _, f := range fields {
+		for i := byte(0); i < f.size; i++ {
+			e.buf = append(e.buf, byte(f.value>>(8*i)))
+		}
+	}
+	e.size += int64(len(e.buf))
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+func enum(num byte, v uint8) field {
+	return field{num, 1, typeEnum, uint64(v)}
+}
+
+func uint16Field(num byte, v uint16) field {
+	return field{num, 2, typeUint16, uint64(v)}
+}
+
+func uint32Field(num byte, v uint32) field {
+	return field{num, 4, typeUint32, uint64(v)}
+}
+
+func sint32Field(num byte, v int32) field {
+	return field{num, 4, typeSint32, uint64(uint32(v))}
+}
+
+// Timestamp is a time as FIT's date_time, seconds since 1989-12-31
+func Timestamp(t time.Time) uint32 {
+	return uint32(t.Sub(epoch) / time.Second)
+}
+
+func timestamp(num byte, t time.Time) field {
+	return uint32Field(num, Timestamp(t))
+}
+
+// Semicircles is degrees as FIT stores positions, 2^31 to 180 degrees
+func Semicircles(deg float64) int32 {
+	// 180 east is 180 west, one semicircle past what an int32 holds
+	return int32(math.Min(math.Round(deg*(1<<31)/180), math.MaxInt32))
+}
+
+// scaled rounds v*scale into a uint32, clamped at zero
+func scaled(v, scale float64) uint32 {
+	return uint32(math.Max(0, math.Round(v*scale)))
+}
+
+// speed is meters per second scaled by 1000, clamped below uint16's invalid
+func speed(num byte, v float64) field {
+	return uint16Field(num, uint16(math.Min(float64(scaled(v, 1000)), 0xfffe)))
+}
+
+// altitude is meters scaled by 5 and offset by 500, clamped to uint16
+func altitude(num byte, meters float64) field {
+	return uint16Field(num, uint16(math.Min(float64(scaled(meters+500, 5)), 0xfffe)))
+}
+
+var lapTriggers = map[activity.Trigger]uint8{
+	activity.TriggerEnd:      lapTriggerSessionEnd,
+	activity.TriggerDistance: lapTriggerDistance,
+	activity.TriggerTime:     lapTriggerTime,
+}
+
+// sport is the FIT sport enum
+func sport(s activity.Sport) uint8 {
+	switch s {
+	case activity.Running:
+		return 1
+	case activity.Cycling:
+		return 2
+	case activity.Driving:
+		return 24
+	}
+	return 0
+}
+
+// Writer writes an activity file a record at a time, splitting laps as it
+// goes. The header holds the size of the messages after it, so they wait in
+// a spool file until Close
+type Writer struct {
+	w        io.Writer
+	sport    activity.Sport
+	split    *activity.Splitter
+	data     spool.File
+	e        *encoder
+	first    gps.GPSRecord
+	laps     int
+	distance float64 // meters, the laps' so far
+	maxSpeed float64 // meters per second
+	started  bool
+}
+
+// NewWriter returns a writer for one activity split into laps by laps
+func NewWriter(w io.Writer, laps activity.Options, sport activity.Sport) *Writer {
+	fw := &Writer{w: w, sport: sport, split: activity.NewSplitter(laps)}
+	fw.e = &encoder{w: &fw.data, locals: map[uint16]byte{}}
+	return fw
+}
+
+func (fw *Writer) start(first gps.GPSRecord) error {
+	fw.started, fw.first = true, first
+	err := fw.e.message(mesgFileID,
+		enum(0, fileActivity),
+		uint16Field(1, manufacturerDev),
+		uint16Field(2, 0),
+		field{3, 4, typeUint32z, uint64(Timestamp(first.Time()))}, // serial number
+		timestamp(4, first.Time()),
+	)
+	if err != nil {
+		return err
+	}
+	return fw.e.message(mesgEvent, timestamp(fieldTimestamp, first.Time()), enum(0, eventTimer), enum(1, eventTypeStart))
+}
+
+func (fw *Writer) lap(l activity.Lap) error {
+	fw.distance += l.Distance
+	fw.maxSpeed = math.Max(fw.maxSpeed, l.MaxSpeed)
+	err := fw.e.message(mesgLap,
+		timestamp(fieldTimestamp, l.End()),
+		enum(0, eventLap),
+		enum(1, eventTypeStop),
+		timestamp(2, l.Start),
+		sint32Field(3, Semicircles(l.First.Lat)),
+		sint32Field(4, Semicircles(l.First.Long)),
+		sint32Field(5, Semicircles(l.Last.Lat)),
+		sint32Field(6, Semicircles(l.Last.Long)),
+		uint32Field(7, scaled(l.Duration().Seconds(), 1000)),
+		uint32Field(8, scaled(l.Duration().Seconds(), 1000)),
+		uint32Field(9, scaled(l.Distance, 100)),
+		speed(13, l.AvgSpeed()),
+		speed(14, l.MaxSpeed),
+		enum(24, lapTriggers[l.Trigger]),
+		enum(25, sport(fw.sport)),
+		uint16Field(254, uint16(fw.laps)),
+	)
+	fw.laps++
+	return err
+}
+
+// Write adds a record, writing the lap before it if it starts a new one
+func (fw *Writer) Write(gr gps.GPSRecord) error {
+	distance, done := fw.split.Add(gr)
+	if !fw.started {
+		if err := fw.start(gr); err != nil {
+			return err
+		}
+	}
+	if done != nil {
+		if err := fw.lap(*done); err != nil {
+			return err
+		}
+	}
+	return fw.e.message(mesgRecord,
+		timestamp(fieldTimestamp, gr.Time()),
+		sint32Field(0, Semicircles(gr.Lat)),
+		sint32Field(1, Semicircles(gr.Long)),
+		altitude(2, gr.Alt/3.28084),
+		uint32Field(5, scaled(distance, 100)),
+		speed(6, gr.SpeedMetersPerSecond()),
+	)
+}
+
+// Close writes the last lap, the session and activity, then the file, it
+// doesn't close the underlying writer
+func (fw *Writer) Close() error {
+	defer fw.data.Close()
+	last, ok := fw.split.End()
+	if !ok {
+		return errors.New("fit: no records to write")
+	}
+	if err := fw.lap(last); err != nil {
+		return err
+	}
+	e := fw.e
+	start, end := fw.first.Time(), last.End()
+	elapsed := end.Sub(start).Seconds()
+	err := e.message(mesgEvent, timestamp(fieldTimestamp, end), enum(0, eventTimer), enum(1, eventTypeStopAll))
+	if err != nil {
+		return err
+	}
+	avg := 0.0
+	if elapsed > 0 {
+		avg = fw.distance / elapsed
+	}
+	err = e.message(mesgSession,
+		timestamp(fieldTimestamp, end),
+		enum(0, eventSession),
+		enum(1, eventTypeStop),
+		timestamp(2, start),
+		sint32Field(3, Semicircles(fw.first.Lat)),
+		sint32Field(4, Semicircles(fw.first.Long)),
+		enum(5, sport(fw.sport)),
+		enum(6, 0), // generic sub sport
+		uint32Field(7, scaled(elapsed, 1000)),
+		uint32Field(8, scaled(elapsed, 1000)),
+		uint32Field(9, scaled(fw.distance, 100)),
+		speed(14, avg),
+		speed(15, fw.maxSpeed),
+		uint16Field(25, 0),
+		uint16Field(26, uint16(fw.laps)),
+		uint16Field(254, 0),
+	)
+	if err != nil {
+		return err
+	}
+	_, offset := end.In(time.Local).Zone()
+	err = e.message(mesgActivity,
+		timestamp(fieldTimestamp, end),
+		uint32Field(0, scaled(elapsed, 1000)),
+		uint16Field(1, 1),
+		enum(2, activityManual),
+		enum(3, eventActivity),
+		enum(4, eventTypeStop),
+		timestamp(5, end.Add(time.Duration(offset)*time.Second)),
+	)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, headerSize, protocolVersion)
+	header = appendUint16(header, profileVersion)
+	header = appendUint32(header, uint32(e.size))
+	header = append(header, ".FIT"...)
+	header = appendUint16(header, CRC(0, header))
+	cw := &crcWriter{w: fw.w}
+	if _, err := cw.Write(header); err != nil {
+		return err
+	}
+	if _, err := fw.data.WriteTo(cw); err != nil {
+		return err
+	}
+	_, err = fw.w.Write(appendUint16(nil, cw.crc))
+	return err
+}
+
+// crcWriter keeps the CRC of what's written through it
+type crcWriter struct {
+	w   io.Writer
+	crc uint16
+}
+
+func (c *crcWriter) Write(p []byte) (int, error) {
+	c.crc = CRC(c.crc, p)
+	return c.w.Write(p)
+}
+
+// Write writes the records as an activity file split into laps by laps
+func Write(w io.Writer, records []gps.GPSRecord, laps activity.Options, sport activity.Sport) error {
+	fw := NewWriter(w, laps, sport)
+	for _, gr := range records {
+		if err := fw.Write(gr); err != nil {
+			return err
+		}
+	}
+	return fw.Close()
+}
@@ -0,0 +1,161 @@
+package fit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/activity"
+	"github.com/samiam2013/raspigogps/common/gpstest"
+)
+
+func TestCRC(t *testing.T) {
+	// FIT's CRC is CRC-16/ARC, this is its check value
+	if got := CRC(0, []byte("123456789")); got != 0xbb3d {
+		t.Errorf("CRC() = %#x, want 0xbb3d", got)
+	}
+}
+
+// message is a decoded data message, fields by number
+type message struct {
+	global uint16
+	fields map[byte]uint64
+}
+
+// decode checks the header and CRCs and reads back the data messages
+func decode(data []byte) ([]message, error) {
+	if len(data) < headerSize+2 || data[0] != headerSize || string(data[8:12]) != ".FIT" {
+		return nil, fmt.Errorf("bad header % x", data[:headerSize])
+	}
+	if CRC(0, data[:12]) != binary.LittleEndian.Uint16(data[12:14]) {
+		return nil, fmt.Errorf("bad header CRC")
+	}
+	size := int(binary.LittleEndian.Uint32(data[4:8]))
+	if headerSize+size+2 != len(data) {
+		return nil, fmt.Errorf("data size %d in a %d byte file", size, len(data))
+	}
+	if CRC(0, data) != 0 {
+		return nil, fmt.Errorf("bad file CRC")
+	}
+
+	type def struct {
+		global uint16
+		fields [][3]byte
+	}
+	defs := map[byte]def{}
+	var msgs []message
+	b := data[headerSize : headerSize+size]
+	for len(b) > 0 {
+		h := b[0]
+		local := h & 0x0f
+		if h&0x80 != 0 {
+			return nil, fmt.Errorf("compressed timestamp header %#x", h)
+		}
+		if h&0x40 != 0 {
+			if b[2] != 0 {
+				return nil, fmt.Errorf("big endian definition")
+			}
+			d := def{global: binary.LittleEndian.Uint16(b[3:5])}
+			n := int(b[5])
+			for i := 0; i < n; i++ {
+				f := b[6+3*i : 9+3*i]
+				d.fields = append(d.fields, [3]byte{f[0], f[1], f[2]})
+			}
+			defs[local] = d
+			b = b[6+3*n:]
+			continue
+		}
+		d, ok := defs[local]
+		if !ok {
+			return nil, fmt.Errorf("data for undefined local message %d", local)
+		}
+		b = b[1:]
+		m := message{global: d.global, fields: map[byte]uint64{}}
+		for _, f := range d.fields {
+			var v uint64
+			for i := 0; i < int(f[1]); i++ {
+				v |= uint64(b[i]) << (8 * i)
+			}
+			m.fields[f[0]] = v
+			b = b[f[1]:]
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
+
+func TestWrite(t *testing.T) {
+	records := gpstest.Records(10, 10.8) // 20 km/h
+	laps := activity.Split(records, activity.Options{Distance: 40})
+	var buf bytes.Buffer
+	if err := Write(&buf, records, activity.Options{Distance: 40}, activity.Cycling); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	msgs, err := decode(buf.Bytes())
+	if err != nil {
+		t.Fatalf("decode() error = %v", err)
+	}
+
+	var order []uint16
+	for _, m := range msgs {
+		if len(order) == 0 || order[len(order)-1] != m.global {
+			order = append(order, m.global)
+		}
+	}
+	want := []uint16{mesgFileID, mesgEvent, mesgRecord, mesgLap, mesgRecord, mesgLap, mesgRecord, mesgLap,
+		mesgEvent, mesgSession, mesgActivity}
+	if fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Fatalf("messages in order %v, want %v", order, want)
+	}
+
+	rec := msgs[2].fields
+	if got := time.Unix(int64(rec[fieldTimestamp])+epoch.Unix(), 0).UTC(); !got.Equal(records[0].Time()) {
+		t.Errorf("first record at %v, want %v", got, records[0].Time())
+	}
+	if lat := float64(int32(rec[0])) * 180 / (1 << 31); lat < 38.5999999 || lat > 38.6000001 {
+		t.Errorf("first record latitude %v, want 38.6", lat)
+	}
+	if alt := float64(rec[2])/5 - 500; alt < 152.3 || alt > 152.5 {
+		t.Errorf("first record altitude %vm, want 152.4", alt)
+	}
+	if spd := rec[6]; spd != 5556 {
+		t.Errorf("first record speed %d mm/s, want 5556", spd)
+	}
+
+	for _, m := range msgs {
+		switch m.global {
+		case mesgSession:
+			_, _, distance, _ := activity.Totals(laps)
+			if m.fields[26] != 3 || m.fields[9] != uint64(scaled(distance, 100)) || m.fields[5] != 2 {
+				t.Errorf("session = %v, want 3 cycling laps over %vm", m.fields, distance)
+			}
+			if m.fields[7] != 9000 {
+				t.Errorf("session elapsed time = %dms, want 9000", m.fields[7])
+			}
+		case mesgLap:
+			if m.fields[24] != lapTriggerDistance && m.fields[24] != lapTriggerSessionEnd {
+				t.Errorf("lap trigger = %d", m.fields[24])
+			}
+		}
+	}
+
+	if err := Write(&buf, nil, activity.Options{}, activity.Cycling); err == nil {
+		t.Error("Write() with no records didn't fail")
+	}
+}
+
+func TestSemicircles(t *testing.T) {
+	tests := []struct {
+		deg  float64
+		want int32
+	}{
+		{0, 0}, {90, 1 << 30}, {-90, -(1 << 30)}, {-180, -(1 << 31)}, {180, 1<<31 - 1},
+	}
+	for _, tt := range tests {
+		if got := Semicircles(tt.deg); got != tt.want {
+			t.Errorf("Semicircles(%v) = %d, want %d", tt.deg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package font
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestTextWidth(t *testing.T) {
+	for s, want := range map[string]int{"": 0, "1": Width, "10 km": 5*Advance - 1, "°": Width} {
+		if got := TextWidth(s); got != want {
+			t.Errorf("TextWidth(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+func TestDraw(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2*Advance*2, Height*2))
+	Draw(img, 0, 0, "1a", color.White, 2)
+	// lower case is drawn in capitals, and every lit pixel of a glyph is a
+	// scale square
+	lit := func(x, y int) bool { return img.NRGBAAt(x, y).A != 0 }
+	for row, bits := range Glyph('A') {
+		for col := 0; col < Width; col++ {
+			want := bits&(1<<(Width-1-col)) != 0
+			x, y := Advance*2+col*2, row*2
+			if lit(x, y) != want || lit(x+1, y+1) != want {
+				t.Fatalf("pixel %d,%d of a is %v, want %v", col, row, lit(x, y), want)
+			}
+		}
+	}
+	if Glyph('~') != missing {
+		t.Error("a rune the font doesn't have isn't drawn as a box")
+	}
+	// clipped to the image
+	Draw(img, -3, -3, "8888", color.White, 3)
+}
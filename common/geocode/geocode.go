@@ -0,0 +1,255 @@
+package geocode
+
+// offline reverse geocoding: nearest populated place to a position from a
+//  GeoNames dump (https://download.geonames.org/export/dump/, the cities*.txt
+//	and admin1CodesASCII.txt files), held in a static k-d tree so a lookup on
+//	the Pi is a few dozen distance checks instead of a scan of every town
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+const earthRadiusKm = 6371.0088
+
+// DefaultMaxKm is how far away a place can be and still name a position
+const DefaultMaxKm = 25.0
+
+// Place is a populated place from the GeoNames dump
+type Place struct {
+	Name       string
+	Admin1     string // state/province abbreviation or name
+	Country    string // ISO 3166 two letter code
+	Lat        float64
+	Long       float64
+	Population int64
+}
+
+// Label is the short form used on the display and in reports, e.g.
+// "Springfield, IL"
+func (p Place) Label() string {
+	if p.Admin1 != "" {
+		return p.Name + ", " + p.Admin1
+	}
+	if p.Country != "" {
+		return p.Name + ", " + p.Country
+	}
+	return p.Name
+}
+
+// Index answers nearest place queries
+type Index struct {
+	// places are stored in k-d tree order: the median of each sub slice is the
+	// splitting node, split on x, y, z of the unit vector by depth
+	places []Place
+	points [][3]float64
+}
+
+// Len is the number of places in the index
+func (ix *Index) Len() int {
+	return len(ix.places)
+}
+
+func unitVector(lat, long float64) [3]float64 {
+	phi := lat * math.Pi / 180
+	lambda := long * math.Pi / 180
+	return [3]float64{math.Cos(phi) * math.Cos(lambda), math.Cos(phi) * math.Sin(lambda), math.Sin(phi)}
+}
+
+func chord2(a, b [3]float64) float64 {
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dx*dx + dy*dy + dz*dz
+}
+
+// chordToKm converts a squared chord length on the unit sphere to a great
+// circle distance
+func chordToKm(c2 float64) float64 {
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(c2)/2))
+}
+
+// NewIndex builds the k-d tree over the places, the slice is reordered
+func NewIndex(places []Place) *Index {
+	ix := &Index{places: places, points: make([][3]float64, len(places))}
+	for i, p := range places {
+		ix.points[i] = unitVector(p.Lat, p.Long)
+	}
+	ix.build(0, len(places), 0)
+	return ix
+}
+
+// build puts the median (on the depth's axis) in the middle of [lo, hi) and
+// recurses on each side
+func (ix *Index) build(lo, hi, depth int) {
+	if hi-lo <= 1 {
+		return
+	}
+	axis := depth % 3
+	sub := kdSlice{ix: ix, lo: lo, n: hi - lo, axis: axis}
+	sort.Sort(sub)
+	mid := lo + (hi-lo)/2
+	ix.build(lo, mid, depth+1)
+	ix.build(mid+1, hi, depth+1)
+}
+
+type kdSlice struct {
+	ix   *Index
+	lo   int
+	n    int
+	axis int
+}
+
+func (s kdSlice) Len() int { return s.n }
+func (s kdSlice) Less(i, j int) bool {
+	return s.ix.points[s.lo+i][s.axis] < s.ix.points[s.lo+j][s.axis]
+}
+func (s kdSlice) Swap(i, j int) {
+	i, j = s.lo+i, s.lo+j
+	s.ix.points[i], s.ix.points[j] = s.ix.points[j], s.ix.points[i]
+	s.ix.places[i], s.ix.places[j] = s.ix.places[j], s.ix.places[i]
+}
+
+// Nearest returns the closest place and its distance in km, false if the
+// index is empty
+func (ix *Index) Nearest(lat, long float64) (Place, float64, bool) {
+	if len(ix.places) == 0 {
+		return Place{}, 0, false
+	}
+	target := unitVector(lat, long)
+	best, bestD := -1, math.Inf(1)
+	ix.search(0, len(ix.places), 0, target, &best, &bestD)
+	return ix.places[best], chordToKm(bestD), true
+}
+
+func (ix *Index) search(lo, hi, depth int, target [3]float64, best *int, bestD *float64) {
+	if lo >= hi {
+		return
+	}
+	mid := lo + (hi-lo)/2
+	if d := chord2(ix.points[mid], target); d < *bestD {
+		*best, *bestD = mid, d
+	}
+	axis := depth % 3
+	diff := target[axis] - ix.points[mid][axis]
+	near, far := [2]int{lo, mid}, [2]int{mid + 1, hi}
+	if diff > 0 {
+		near, far = far, near
+	}
+	ix.search(near[0], near[1], depth+1, target, best, bestD)
+	// only cross the splitting plane if it is closer than the best so far
+	if diff*diff < *bestD {
+		ix.search(far[0], far[1], depth+1, target, best, bestD)
+	}
+}
+
+// NearestLabel returns the label of the place nearest lat, long, or an
+// empty string if there is nothing within maxKm
+func (ix *Index) NearestLabel(lat, long, maxKm float64) string {
+	p, d, ok := ix.Nearest(lat, long)
+	if !ok || d > maxKm {
+		return ""
+	}
+	return p.Label()
+}
+
+// Describe returns "near <place>" for the record's position, or an empty
+// string if there is nothing within maxKm
+func (ix *Index) Describe(gr gps.GPSRecord, maxKm float64) string {
+	if label := ix.NearestLabel(gr.Lat, gr.Long, maxKm); label != "" {
+		return "near " + label
+	}
+	return ""
+}
+
+// LoadGeoNames reads a GeoNames cities file and (optionally, may be nil) the
+// admin1 codes file, keeping populated places of at least minPopulation
+func LoadGeoNames(cities io.Reader, admin1 io.Reader, minPopulation int64) (*Index, error) {
+	admin1Names := make(map[string]string)
+	if admin1 != nil {
+		s := bufio.NewScanner(admin1)
+		for s.Scan() {
+			// US.IL	Illinois	Illinois	4896861
+			fields := strings.Split(s.Text(), "\t")
+			if len(fields) < 3 {
+				continue
+			}
+			admin1Names[fields[0]] = fields[2]
+		}
+		if err := s.Err(); err != nil {
+			return nil, fmt.Errorf("could not read admin1 codes: %w", err)
+		}
+	}
+
+	places := make([]Place, 0, 1024)
+	s := bufio.NewScanner(cities)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024) // alternate names can be long
+	line := 0
+	for s.Scan() {
+		line++
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) < 15 {
+			return nil, fmt.Errorf("line %d: expected at least 15 columns, got %d", line, len(fields))
+		}
+		if fields[6] != "P" {
+			continue
+		}
+		pop, _ := strconv.ParseInt(fields[14], 10, 64)
+		if pop < minPopulation {
+			continue
+		}
+		lat, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad latitude: %w", line, err)
+		}
+		long, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad longitude: %w", line, err)
+		}
+		country, code := fields[8], fields[10]
+		adm := ""
+		if country == "US" {
+			// US states are already their postal abbreviations
+			adm = code
+		} else if name, ok := admin1Names[country+"."+code]; ok {
+			adm = name
+		}
+		places = append(places, Place{
+			Name:       fields[2], // ascii name, the 8x8 font has no accents
+			Admin1:     adm,
+			Country:    country,
+			Lat:        lat,
+			Long:       long,
+			Population: pop,
+		})
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("could not read cities: %w", err)
+	}
+	return NewIndex(places), nil
+}
+
+// Open loads either a compact index written by WriteTo or a raw GeoNames
+// cities file (without admin names)
+func Open(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(2)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	if magic[0] == 0x1f && magic[1] == 0x8b {
+		return ReadIndex(br)
+	}
+	return LoadGeoNames(br, nil, 0)
+}
@@ -0,0 +1,123 @@
+package geocode
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// a few lines in the GeoNames cities format, with alternate names cut down
+const testCities = "4250542\tSpringfield\tSpringfield\t\t39.80172\t-89.64371\tP\tPPLA\tUS\t\tIL\t167\t\t\t114394\t\t179\tAmerica/Chicago\t2019-08-08\n" +
+	"4407066\tSaint Louis\tSaint Louis\t\t38.62727\t-90.19789\tP\tPPLA2\tUS\t\tMO\t510\t\t\t315685\t\t149\tAmerica/Chicago\t2019-08-08\n" +
+	"4887398\tChicago\tChicago\t\t41.85003\t-87.65005\tP\tPPLA2\tUS\t\tIL\t031\t\t\t2720546\t\t180\tAmerica/Chicago\t2019-08-08\n" +
+	"6167865\tToronto\tToronto\t\t43.70011\t-79.4163\tP\tPPLA\tCA\t\t08\t3520\t\t\t2600000\t\t175\tAmerica/Toronto\t2019-08-08\n" +
+	"4250543\tSangamon River\tSangamon River\t\t39.9\t-89.5\tH\tSTM\tUS\t\tIL\t\t\t\t0\t\t170\tAmerica/Chicago\t2019-08-08\n" +
+	"4237579\tTiny Town\tTiny Town\t\t39.0\t-89.0\tP\tPPL\tUS\t\tIL\t\t\t\t12\t\t170\tAmerica/Chicago\t2019-08-08\n"
+
+const testAdmin1 = "US.IL\tIllinois\tIllinois\t4896861\nCA.08\tOntario\tOntario\t6093943\n"
+
+func TestNearest(t *testing.T) {
+	ix, err := LoadGeoNames(strings.NewReader(testCities), strings.NewReader(testAdmin1), 100)
+	if err != nil {
+		t.Fatalf("LoadGeoNames() error = %v", err)
+	}
+	if ix.Len() != 4 {
+		t.Fatalf("Len() = %d, want 4 (streams and tiny places dropped)", ix.Len())
+	}
+	// round trip through the compact format, every case should match after
+	var buf bytes.Buffer
+	if _, err := ix.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	reloaded, err := ReadIndex(&buf)
+	if err != nil {
+		t.Fatalf("ReadIndex() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		lat, long float64
+		wantLabel string
+		wantKm    float64
+	}{
+		{name: "downtown springfield", lat: 39.80172, long: -89.64371, wantLabel: "Springfield, IL", wantKm: 0},
+		{name: "east of st louis", lat: 38.63, long: -90.0, wantLabel: "Saint Louis, MO", wantKm: 17.2},
+		{name: "ontario uses admin name", lat: 43.6, long: -79.5, wantLabel: "Toronto, Ontario", wantKm: 13.0},
+		{name: "hudson bay is closer to toronto", lat: 60, long: -87.65005, wantLabel: "Toronto, Ontario", wantKm: 1895},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, idx := range []*Index{ix, reloaded} {
+				p, km, ok := idx.Nearest(tt.lat, tt.long)
+				if !ok {
+					t.Fatalf("Nearest() found nothing")
+				}
+				if p.Label() != tt.wantLabel {
+					t.Errorf("Nearest() = %v, want %v", p.Label(), tt.wantLabel)
+				}
+				// loose, the point is which place not the exact distance
+				if math.Abs(km-tt.wantKm) > math.Max(1, tt.wantKm*0.2) {
+					t.Errorf("Nearest() distance = %v km, want about %v", km, tt.wantKm)
+				}
+			}
+		})
+	}
+
+	// the tree has to agree with checking every place
+	rng := rand.New(rand.NewSource(1))
+	random := make([]Place, 500)
+	for i := range random {
+		random[i] = Place{Lat: rng.Float64()*180 - 90, Long: rng.Float64()*360 - 180}
+	}
+	randomIx := NewIndex(random)
+	for i := 0; i < 1000; i++ {
+		lat, long := rng.Float64()*180-90, rng.Float64()*360-180
+		got, _, _ := randomIx.Nearest(lat, long)
+		target := unitVector(lat, long)
+		want, wantD := Place{}, math.Inf(1)
+		for j, p := range randomIx.places {
+			if d := chord2(randomIx.points[j], target); d < wantD {
+				want, wantD = p, d
+			}
+		}
+		if got != want {
+			t.Fatalf("Nearest(%v, %v) = %+v, brute force found %+v", lat, long, got, want)
+		}
+	}
+
+	if got := ix.Describe(gps.GPSRecord{Lat: 39.8, Long: -89.6}, 50); got != "near Springfield, IL" {
+		t.Errorf("Describe() = %v, want near Springfield, IL", got)
+	}
+	if got := ix.Describe(gps.GPSRecord{Lat: 0, Long: 0}, 50); got != "" {
+		t.Errorf("Describe() = %v, want nothing in the gulf of guinea", got)
+	}
+	// hudson bay is nearest toronto but much too far to be named for it
+	if got := ix.NearestLabel(60, -87.65005, DefaultMaxKm); got != "" {
+		t.Errorf("NearestLabel() = %v, want nothing in hudson bay", got)
+	}
+}
+
+func TestReadIndexBadCount(t *testing.T) {
+	header := func(count uint64) *bytes.Buffer {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(indexMagic))
+		b := make([]byte, binary.MaxVarintLen64)
+		zw.Write(b[:binary.PutUvarint(b, count)])
+		zw.Close()
+		return &buf
+	}
+	// a count past the limit fails before anything is allocated, one under
+	// it fails on the missing places
+	for _, count := range []uint64{math.MaxUint64, MaxIndexPlaces} {
+		if _, err := ReadIndex(header(count)); err == nil {
+			t.Errorf("ReadIndex() of %d places in an empty file succeeded", count)
+		}
+	}
+}
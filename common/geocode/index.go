@@ -0,0 +1,150 @@
+package geocode
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// compact index file: gzip of a magic string, a place count and then every
+// place in k-d tree order so loading doesn't need to rebuild the tree.
+// Coordinates are stored as 1e-5 degree integers (about a meter)
+const indexMagic = "RGEOIDX1"
+
+// MaxIndexPlaces is the most places ReadIndex takes a count for, well over
+// every place in GeoNames' allCountries dump
+const MaxIndexPlaces = 1 << 25
+
+// at most this many places are allocated for before they're read, so a
+// corrupt count in a short file fails on the data not on memory
+const indexPrealloc = 1 << 16
+
+// WriteTo writes the compact index
+func (ix *Index) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	zw := gzip.NewWriter(cw)
+	bw := bufio.NewWriter(zw)
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(v uint64) error {
+		n := binary.PutUvarint(buf, v)
+		_, err := bw.Write(buf[:n])
+		return err
+	}
+	putString := func(s string) error {
+		if err := putUvarint(uint64(len(s))); err != nil {
+			return err
+		}
+		_, err := bw.WriteString(s)
+		return err
+	}
+
+	if _, err := bw.WriteString(indexMagic); err != nil {
+		return cw.n, err
+	}
+	if err := putUvarint(uint64(len(ix.places))); err != nil {
+		return cw.n, err
+	}
+	for _, p := range ix.places {
+		for _, s := range []string{p.Name, p.Admin1, p.Country} {
+			if err := putString(s); err != nil {
+				return cw.n, err
+			}
+		}
+		var coords [8]byte
+		binary.LittleEndian.PutUint32(coords[0:], uint32(int32(math.Round(p.Lat*1e5))))
+		binary.LittleEndian.PutUint32(coords[4:], uint32(int32(math.Round(p.Long*1e5))))
+		if _, err := bw.Write(coords[:]); err != nil {
+			return cw.n, err
+		}
+		if err := putUvarint(uint64(p.Population)); err != nil {
+			return cw.n, err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return cw.n, err
+	}
+	if err := zw.Close(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, nil
+}
+
+// ReadIndex loads an index written by WriteTo
+func ReadIndex(r io.Reader) (*Index, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a compact index: %w", err)
+	}
+	defer zr.Close()
+	br := bufio.NewReader(zr)
+
+	magic := make([]byte, len(indexMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != indexMagic {
+		return nil, fmt.Errorf("bad index header")
+	}
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("could not read place count: %w", err)
+	}
+	if count > MaxIndexPlaces {
+		return nil, fmt.Errorf("place count %d over the limit of %d", count, MaxIndexPlaces)
+	}
+	readString := func() (string, error) {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return "", err
+		}
+		if n > 1024 {
+			return "", fmt.Errorf("string length %d too long", n)
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(br, b)
+		return string(b), err
+	}
+
+	prealloc := count
+	if prealloc > indexPrealloc {
+		prealloc = indexPrealloc
+	}
+	ix := &Index{
+		places: make([]Place, 0, prealloc),
+		points: make([][3]float64, 0, prealloc),
+	}
+	for i := uint64(0); i < count; i++ {
+		var p Place
+		for _, dst := range []*string{&p.Name, &p.Admin1, &p.Country} {
+			if *dst, err = readString(); err != nil {
+				return nil, fmt.Errorf("place %d: %w", i, err)
+			}
+		}
+		var coords [8]byte
+		if _, err := io.ReadFull(br, coords[:]); err != nil {
+			return nil, fmt.Errorf("place %d: %w", i, err)
+		}
+		p.Lat = float64(int32(binary.LittleEndian.Uint32(coords[0:]))) / 1e5
+		p.Long = float64(int32(binary.LittleEndian.Uint32(coords[4:]))) / 1e5
+		pop, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("place %d: %w", i, err)
+		}
+		p.Population = int64(pop)
+		ix.places = append(ix.places, p)
+		ix.points = append(ix.points, unitVector(p.Lat, p.Long))
+	}
+	return ix, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,248 @@
+package geoid
+
+// geoid undulation (height of mean sea level above the WGS84 ellipsoid) from
+//  a regular grid such as NGA's EGM96 WW15MGH.GRD, bilinearly interpolated.
+//	Receivers report altitude either above MSL (NMEA GGA, with their own
+//	idea of the separation) or above the ellipsoid (UBX NAV-PVT), running both
+//	through the same grid keeps the two consistent. go generate resamples
+//	WW15MGH.GRD to 1 degree into data, which is built into the binary as the
+//	default grid, and SetDefault swaps in a finer one
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"embed"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+//go:generate go run ../../cmd/geoidgrid -in WW15MGH.GRD -step 1 -out data/egm96-1.grd.gz
+
+// BuiltinPath is the built in grid within data
+const BuiltinPath = "data/egm96-1.grd.gz"
+
+//go:embed data
+var data embed.FS
+
+// Grid holds undulations in meters, rows from north to south and columns from
+// west to east
+type Grid struct {
+	south, north float64
+	west, east   float64
+	dLat, dLong  float64
+	rows, cols   int
+	values       []float32
+}
+
+// ReadGRD reads the NGA .GRD text format: a header of south, north, west,
+// east, lat spacing and long spacing in degrees followed by the values
+func ReadGRD(r io.Reader) (*Grid, error) {
+	s := bufio.NewScanner(r)
+	s.Split(bufio.ScanWords)
+	next := func() (float64, error) {
+		if !s.Scan() {
+			if err := s.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.ErrUnexpectedEOF
+		}
+		return strconv.ParseFloat(s.Text(), 64)
+	}
+	var header [6]float64
+	for i := range header {
+		v, err := next()
+		if err != nil {
+			return nil, fmt.Errorf("could not read grid header: %w", err)
+		}
+		header[i] = v
+	}
+	g := &Grid{
+		south: header[0], north: header[1],
+		west: header[2], east: header[3],
+		dLat: header[4], dLong: header[5],
+	}
+	if g.dLat <= 0 || g.dLong <= 0 || g.north <= g.south || g.east <= g.west {
+		return nil, fmt.Errorf("invalid grid header %v", header)
+	}
+	g.rows = int(math.Round((g.north-g.south)/g.dLat)) + 1
+	g.cols = int(math.Round((g.east-g.west)/g.dLong)) + 1
+	g.values = make([]float32, 0, g.rows*g.cols)
+	for len(g.values) < g.rows*g.cols {
+		v, err := next()
+		if err != nil {
+			return nil, fmt.Errorf("grid value %d of %d: %w", len(g.values), g.rows*g.cols, err)
+		}
+		g.values = append(g.values, float32(v))
+	}
+	return g, nil
+}
+
+// Open reads a .GRD file, gzipped or not
+func Open(path string) (*Grid, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return read(f)
+}
+
+// Builtin reads the grid built into the binary
+func Builtin() (*Grid, error) {
+	b, err := data.ReadFile(BuiltinPath)
+	if err != nil {
+		return nil, err
+	}
+	return read(bytes.NewReader(b))
+}
+
+func read(f io.Reader) (*Grid, error) {
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	return ReadGRD(r)
+}
+
+// WriteGRD writes the grid in the format ReadGRD reads, gzip it for Open
+func (g *Grid) WriteGRD(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, " %f %f %f %f %f %f\n", g.south, g.north, g.west, g.east, g.dLat, g.dLong)
+	for row := 0; row < g.rows; row++ {
+		for col := 0; col < g.cols; col++ {
+			sep := " "
+			if col%10 == 9 || col == g.cols-1 {
+				sep = "\n"
+			}
+			fmt.Fprintf(bw, "%.2f%s", g.at(row, col), sep)
+		}
+	}
+	return bw.Flush()
+}
+
+// Resample is the grid every step degrees over the same area, interpolated
+// where the nodes don't line up
+func (g *Grid) Resample(step float64) *Grid {
+	r := &Grid{
+		south: g.south, north: g.north, west: g.west, east: g.east,
+		dLat: step, dLong: step,
+	}
+	r.rows = int(math.Floor((g.north-g.south)/step+1e-9)) + 1
+	r.cols = int(math.Floor((g.east-g.west)/step+1e-9)) + 1
+	r.north = r.south + float64(r.rows-1)*step
+	r.east = r.west + float64(r.cols-1)*step
+	r.values = make([]float32, 0, r.rows*r.cols)
+	for row := 0; row < r.rows; row++ {
+		lat := r.north - float64(row)*step
+		for col := 0; col < r.cols; col++ {
+			r.values = append(r.values, float32(g.Undulation(lat, r.west+float64(col)*step)))
+		}
+	}
+	return r
+}
+
+func (g *Grid) at(row, col int) float64 {
+	return float64(g.values[row*g.cols+col])
+}
+
+// Undulation returns the geoid height above the ellipsoid in meters
+func (g *Grid) Undulation(lat, long float64) float64 {
+	lat = math.Max(g.south, math.Min(g.north, lat))
+	// grids cover 0 to 360, bring the longitude into range
+	for long < g.west {
+		long += 360
+	}
+	for long > g.east {
+		long -= 360
+	}
+
+	y := (g.north - lat) / g.dLat
+	x := (long - g.west) / g.dLong
+	r0, c0 := int(math.Floor(y)), int(math.Floor(x))
+	if r0 >= g.rows-1 {
+		r0 = g.rows - 2
+	}
+	if c0 >= g.cols-1 {
+		c0 = g.cols - 2
+	}
+	fy, fx := y-float64(r0), x-float64(c0)
+	top := g.at(r0, c0)*(1-fx) + g.at(r0, c0+1)*fx
+	bottom := g.at(r0+1, c0)*(1-fx) + g.at(r0+1, c0+1)*fx
+	return top*(1-fy) + bottom*fy
+}
+
+var (
+	mu          sync.RWMutex
+	defaultGrid *Grid
+
+	builtinOnce sync.Once
+	builtinGrid *Grid
+)
+
+// SetDefault sets the grid used by FromMSL and FromEllipsoid instead of the
+// built in one, nil goes back to it
+func SetDefault(g *Grid) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultGrid = g
+}
+
+// Default returns the grid set with SetDefault or else the built in one, nil
+// if the binary was built without it
+func Default() *Grid {
+	mu.RLock()
+	g := defaultGrid
+	mu.RUnlock()
+	if g != nil {
+		return g
+	}
+	builtinOnce.Do(func() {
+		var err error
+		if builtinGrid, err = Builtin(); err != nil {
+			logrus.WithError(err).Warn("No built in geoid grid, run go generate in common/geoid with WW15MGH.GRD; " +
+				"altitudes use the receiver's separation")
+		}
+	})
+	return builtinGrid
+}
+
+// FromMSL takes an altitude above mean sea level and the receiver's geoid
+// separation (0 if it didn't report one), all in meters, and returns the
+// height above the ellipsoid and the altitude above MSL according to the
+// default grid. Without a grid the receiver's values are passed through
+func FromMSL(lat, long, msl, separation float64) (ellipsoid, mslOut float64) {
+	g := Default()
+	if g == nil {
+		return msl + separation, msl
+	}
+	n := g.Undulation(lat, long)
+	if separation == 0 {
+		// assume the receiver's MSL came from a model close enough to ours
+		return msl + n, msl
+	}
+	ellipsoid = msl + separation
+	return ellipsoid, ellipsoid - n
+}
+
+// FromEllipsoid converts a height above the ellipsoid to MSL using the
+// default grid, false if there is no grid loaded
+func FromEllipsoid(lat, long, ellipsoid float64) (float64, bool) {
+	g := Default()
+	if g == nil {
+		return ellipsoid, false
+	}
+	return ellipsoid - g.Undulation(lat, long), true
+}
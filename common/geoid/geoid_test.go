@@ -0,0 +1,113 @@
+package geoid
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+// a 90 degree grid in the WW15MGH.GRD layout, north row first
+const testGRD = ` -90.000000 90.000000 .000000 360.000000 90.000000 90.000000
+ -10 -10 -10 -10 -10
+ 20 40 -20 0 20
+ -30 -30 -30 -30 -30
+`
+
+func TestUndulation(t *testing.T) {
+	g, err := ReadGRD(strings.NewReader(testGRD))
+	if err != nil {
+		t.Fatalf("ReadGRD() error = %v", err)
+	}
+	tests := []struct {
+		name      string
+		lat, long float64
+		want      float64
+	}{
+		{name: "grid node", lat: 0, long: 90, want: 40},
+		{name: "between columns", lat: 0, long: 45, want: 30},
+		{name: "negative longitude wraps", lat: 0, long: -45, want: 10},
+		{name: "between rows", lat: 45, long: 0, want: 5},
+		{name: "north pole", lat: 90, long: 123, want: -10},
+		{name: "past the pole is clamped", lat: -95, long: 0, want: -30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := g.Undulation(tt.lat, tt.long); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Undulation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromMSL(t *testing.T) {
+	defer SetDefault(nil)
+
+	// without a grid the receiver's separation is all there is
+	SetDefault(nil)
+	builtinOnce.Do(func() {})
+	builtin := builtinGrid
+	builtinGrid = nil
+	defer func() { builtinGrid = builtin }()
+	if ell, msl := FromMSL(0, 90, 100, 35); ell != 135 || msl != 100 {
+		t.Errorf("FromMSL() without grid = %v, %v, want 135, 100", ell, msl)
+	}
+	if _, ok := FromEllipsoid(0, 90, 100); ok {
+		t.Errorf("FromEllipsoid() without grid should not be ok")
+	}
+
+	g, err := ReadGRD(strings.NewReader(testGRD))
+	if err != nil {
+		t.Fatalf("ReadGRD() error = %v", err)
+	}
+	SetDefault(g)
+	// the grid says 40 at this node, the receiver thought 35
+	if ell, msl := FromMSL(0, 90, 100, 35); ell != 135 || msl != 95 {
+		t.Errorf("FromMSL() with grid = %v, %v, want 135, 95", ell, msl)
+	}
+	if ell, msl := FromMSL(0, 90, 100, 0); ell != 140 || msl != 100 {
+		t.Errorf("FromMSL() with no receiver separation = %v, %v, want 140, 100", ell, msl)
+	}
+	if msl, ok := FromEllipsoid(0, 90, 135); !ok || msl != 95 {
+		t.Errorf("FromEllipsoid() = %v, %v, want 95, true", msl, ok)
+	}
+}
+
+func TestResampleRoundTrip(t *testing.T) {
+	g, err := ReadGRD(strings.NewReader(testGRD))
+	if err != nil {
+		t.Fatalf("ReadGRD() error = %v", err)
+	}
+	r := g.Resample(45)
+	if r.rows != 5 || r.cols != 9 {
+		t.Fatalf("Resample() is %dx%d, want 5x9", r.rows, r.cols)
+	}
+	var buf bytes.Buffer
+	if err := r.WriteGRD(&buf); err != nil {
+		t.Fatalf("WriteGRD() error = %v", err)
+	}
+	back, err := ReadGRD(&buf)
+	if err != nil {
+		t.Fatalf("ReadGRD() of WriteGRD() error = %v", err)
+	}
+	for _, p := range [][2]float64{{0, 90}, {0, 45}, {45, 0}, {22.5, 200}, {-60, 300}} {
+		if got, want := back.Undulation(p[0], p[1]), g.Undulation(p[0], p[1]); math.Abs(got-want) > 0.01 {
+			t.Errorf("Undulation(%v, %v) = %v after resampling, want %v", p[0], p[1], got, want)
+		}
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	g, err := Builtin()
+	if err != nil {
+		t.Fatalf("no built in grid, see data/README.md to generate it: %v", err)
+	}
+	// EGM96 has the ocean off India about 100m under the ellipsoid and the
+	// North Atlantic about 60m over
+	if n := g.Undulation(5, 78); n > -80 || n < -110 {
+		t.Errorf("Undulation() off India = %v, want about -100", n)
+	}
+	if n := g.Undulation(60, -20); n < 40 || n > 75 {
+		t.Errorf("Undulation() in the North Atlantic = %v, want about 60", n)
+	}
+}
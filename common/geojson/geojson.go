@@ -0,0 +1,214 @@
+package geojson
+
+// RFC 7946 GeoJSON for web maps and QGIS: a FeatureCollection with the track
+//  as a LineString, its times in a coordTimes property the way togeojson and
+//	Mapbox write them, and waypoints as Point features. Positions are
+//	long,lat,alt in meters, optionally rounded to keep files small
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/spool"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+)
+
+// Options controls what's written
+type Options struct {
+	Name string
+	// Precision is how many decimals positions keep, 0 for all of them.
+	// RFC 7946 suggests 6, about 10cm
+	Precision int
+}
+
+// DefaultOptions keeps full precision
+func DefaultOptions() Options {
+	return Options{Name: "Track"}
+}
+
+// FeatureCollection is the document
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature is a geometry with properties
+type Feature struct {
+	Type       string                 `json:"type"`
+	Geometry   Geometry               `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Geometry is a Point with one position or a LineString with many
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// Position is long, lat and optionally altitude
+type Position []float64
+
+func round(v float64, prec int) float64 {
+	if prec <= 0 {
+		return v
+	}
+	p := math.Pow(10, float64(prec))
+	return math.Round(v*p) / p
+}
+
+func newPosition(lat, long float64, alt *float64, prec int) Position {
+	p := Position{round(long, prec), round(lat, prec)}
+	if alt != nil {
+		// a decimeter is as good as the altitude gets
+		p = append(p, math.Round(*alt*10)/10)
+	}
+	return p
+}
+
+func recordPosition(gr gps.GPSRecord, prec int) Position {
+	alt := gr.Alt / 3.28084 // meters above sea level
+	return newPosition(gr.Lat, gr.Long, &alt, prec)
+}
+
+func recordTime(gr gps.GPSRecord) string {
+	return gr.Time().UTC().Format(time.RFC3339Nano)
+}
+
+func waypointFeature(w waypoint.Waypoint, prec int) Feature {
+	props := map[string]interface{}{
+		"name":   w.Name(),
+		"number": w.Number,
+		"time":   w.Time.UTC().Format(time.RFC3339Nano),
+	}
+	if w.Coords != "" {
+		props["coords"] = w.Coords
+	}
+	if w.Note != "" {
+		props["note"] = w.Note
+	}
+	if w.Interpolated {
+		props["interpolated"] = true
+	}
+	return Feature{
+		Type:       "Feature",
+		Geometry:   Geometry{Type: "Point", Coordinates: newPosition(w.Lat, w.Long, nil, prec)},
+		Properties: props,
+	}
+}
+
+// Writer writes a collection a record at a time, the track first then the
+// waypoints. The track's positions go out as they arrive and its times, in
+// a property after them, wait in a spool file
+type Writer struct {
+	w         io.Writer
+	bw        *bufio.Writer
+	waypoints []waypoint.Waypoint
+	opts      Options
+	times     spool.File
+	first     gps.GPSRecord
+	n         int
+}
+
+// NewWriter returns a writer for one collection
+func NewWriter(w io.Writer, waypoints []waypoint.Waypoint, opts Options) *Writer {
+	return &Writer{w: w, bw: bufio.NewWriter(w), waypoints: waypoints, opts: opts}
+}
+
+// writeJSON writes v as JSON after prefix
+func writeJSON(w io.Writer, prefix string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, prefix); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Write adds a record to the track. A LineString needs two positions, so
+// the first waits to see if there's a second
+func (gw *Writer) Write(gr gps.GPSRecord) error {
+	sep := ","
+	if gw.n == 0 {
+		sep, gw.first = "", gr
+	}
+	gw.n++
+	if err := writeJSON(&gw.times, sep, recordTime(gr)); err != nil {
+		return err
+	}
+	switch gw.n {
+	case 1:
+		return nil
+	case 2:
+		gw.bw.WriteString(`{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"LineString","coordinates":[`)
+		if err := writeJSON(gw.bw, "", recordPosition(gw.first, gw.opts.Precision)); err != nil {
+			return err
+		}
+	}
+	return writeJSON(gw.bw, ",", recordPosition(gr, gw.opts.Precision))
+}
+
+// Close writes the rest of the collection, it doesn't close the underlying
+// writer
+func (gw *Writer) Close() error {
+	defer gw.times.Close()
+	sep := ""
+	switch gw.n {
+	case 0:
+		gw.bw.WriteString(`{"type":"FeatureCollection","features":[`)
+	case 1:
+		gw.bw.WriteString(`{"type":"FeatureCollection","features":[`)
+		t := recordTime(gw.first)
+		err := writeJSON(gw.bw, "", Feature{
+			Type:       "Feature",
+			Geometry:   Geometry{Type: "Point", Coordinates: recordPosition(gw.first, gw.opts.Precision)},
+			Properties: map[string]interface{}{"name": gw.opts.Name, "time": t, "coordTimes": []string{t}},
+		})
+		if err != nil {
+			return err
+		}
+		sep = ","
+	default:
+		// properties in the order encoding/json sorts a map's keys
+		gw.bw.WriteString(`]},"properties":{"coordTimes":[`)
+		if err := gw.bw.Flush(); err != nil {
+			return err
+		}
+		if _, err := gw.times.WriteTo(gw.w); err != nil {
+			return err
+		}
+		if err := writeJSON(gw.bw, `],"name":`, gw.opts.Name); err != nil {
+			return err
+		}
+		if err := writeJSON(gw.bw, `,"time":`, recordTime(gw.first)); err != nil {
+			return err
+		}
+		gw.bw.WriteString("}}")
+		sep = ","
+	}
+	for _, w := range gw.waypoints {
+		if err := writeJSON(gw.bw, sep, waypointFeature(w, gw.opts.Precision)); err != nil {
+			return err
+		}
+		sep = ","
+	}
+	gw.bw.WriteString("]}\n")
+	return gw.bw.Flush()
+}
+
+// Write writes the collection for the records and waypoints
+func Write(w io.Writer, records []gps.GPSRecord, waypoints []waypoint.Waypoint, opts Options) error {
+	gw := NewWriter(w, waypoints, opts)
+	for _, gr := range records {
+		if err := gw.Write(gr); err != nil {
+			return err
+		}
+	}
+	return gw.Close()
+}
@@ -0,0 +1,135 @@
+package geojson
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/gpstest"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+)
+
+// decoded is the document read back generically, the way a web map would
+type decoded struct {
+	Type     string `json:"type"`
+	Features []struct {
+		Type     string `json:"type"`
+		Geometry struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	} `json:"features"`
+}
+
+func TestWrite(t *testing.T) {
+	wps := []waypoint.Waypoint{{Time: gpstest.Start, Lat: 38.6, Long: -90.2, Number: 1, Coords: "15S 744580E 4279364N"}}
+	precise := DefaultOptions()
+	precise.Precision = 6
+	// positions with more digits than precise keeps
+	surveyed := gpstest.Records(2, 0)
+	for i := range surveyed {
+		surveyed[i].Lat, surveyed[i].Long = 38.62470251+float64(i)*1e-4, -90.18490427
+	}
+	tests := []struct {
+		name      string
+		records   []gps.GPSRecord
+		waypoints []waypoint.Waypoint
+		opts      Options
+		types     []string
+		first     string // the first feature's coordinates
+	}{
+		{"track and waypoint", surveyed, wps, DefaultOptions(), []string{"LineString", "Point"},
+			"[[-90.18490427,38.62470251,152.4],[-90.18490427,38.62480251,152.4]]"},
+		{"precision", surveyed, nil, precise, []string{"LineString"},
+			"[[-90.184904,38.624703,152.4],[-90.184904,38.624803,152.4]]"},
+		{"one record", gpstest.Records(1, 0), nil, DefaultOptions(), []string{"Point"},
+			"[-90.2,38.6,152.4]"},
+		{"waypoints only", nil, wps, DefaultOptions(), []string{"Point"}, "[-90.2,38.6]"},
+		{"nothing", nil, nil, DefaultOptions(), nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, tt.records, tt.waypoints, tt.opts); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			var doc decoded
+			if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+				t.Fatalf("not JSON: %v", err)
+			}
+			if doc.Type != "FeatureCollection" || doc.Features == nil {
+				t.Fatalf("got %s", buf.String())
+			}
+			if len(doc.Features) != len(tt.types) {
+				t.Fatalf("got %d features, want %d", len(doc.Features), len(tt.types))
+			}
+			for i, f := range doc.Features {
+				if f.Type != "Feature" || f.Geometry.Type != tt.types[i] || f.Properties == nil {
+					t.Errorf("feature %d is a %s %s, want a Feature %s", i, f.Type, f.Geometry.Type, tt.types[i])
+				}
+			}
+			if len(doc.Features) > 0 && string(doc.Features[0].Geometry.Coordinates) != tt.first {
+				t.Errorf("coordinates = %s, want %s", doc.Features[0].Geometry.Coordinates, tt.first)
+			}
+			if len(tt.records) > 0 {
+				times, _ := doc.Features[0].Properties["coordTimes"].([]interface{})
+				last := tt.records[len(tt.records)-1].Time().Format(time.RFC3339)
+				if len(times) != len(tt.records) || times[len(times)-1] != last {
+					t.Errorf("coordTimes = %v", times)
+				}
+			}
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	var written bytes.Buffer
+	if err := Write(&written, gpstest.Records(3, 0), nil, DefaultOptions()); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		doc     string
+		records int
+		skipped int
+		timed   bool
+	}{
+		{"written", written.String(), 3, 0, true},
+		{"bare LineString", `{"type":"LineString","coordinates":[[-90.2,38.6],[-90.2,38.7]]}`, 2, 0, false},
+		{"MultiLineString", `{"type":"Feature","geometry":{"type":"MultiLineString","coordinates":
+			[[[-90.2,38.6],[-90.2,38.7]],[[-90.3,38.6]]]},"properties":{"coordTimes":
+			[["2022-05-20T12:00:00Z","2022-05-20T12:00:01Z"],["2022-05-20T12:01:00Z"]]}}`, 3, 0, true},
+		{"bad position", `{"type":"LineString","coordinates":[[-90.2,38.6],[-190.2,38.7],[1]]}`, 1, 2, false},
+		{"times don't match", `{"type":"Feature","geometry":{"type":"LineString","coordinates":[[-90.2,38.6]]},
+			"properties":{"coordTimes":["2022-05-20T12:00:00Z","2022-05-20T12:00:01Z"]}}`, 1, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			skipped := 0
+			got, err := Read(bytes.NewBufferString(tt.doc), func(error) error {
+				skipped++
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if len(got) != tt.records || skipped != tt.skipped {
+				t.Errorf("Read() = %d records, skipped %d, want %d and %d", len(got), skipped, tt.records, tt.skipped)
+			}
+			for _, gr := range got {
+				if gr.GPSTime.IsZero() == tt.timed {
+					t.Errorf("record %+v timed = %v, want %v", gr, !tt.timed, tt.timed)
+				}
+			}
+			if _, err := Read(bytes.NewBufferString(tt.doc), nil); (err != nil) != (tt.skipped > 0) {
+				t.Errorf("strict Read() error = %v", err)
+			}
+		})
+	}
+	if _, err := Read(bytes.NewBufferString("[]"), nil); err == nil {
+		t.Error("Read() of a non-object didn't fail")
+	}
+}
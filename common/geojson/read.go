@@ -0,0 +1,166 @@
+package geojson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// readGeometry is any geometry, coordinates left for the type to decode
+type readGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometries  []readGeometry  `json:"geometries"`
+}
+
+type readFeature struct {
+	Type       string          `json:"type"`
+	Geometry   *readGeometry   `json:"geometry"`
+	Properties json.RawMessage `json:"properties"`
+	Features   []readFeature   `json:"features"`
+	// a bare geometry at the top level
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometries  []readGeometry  `json:"geometries"`
+}
+
+// lineTimes is coordTimes for a LineString, or one list per line of a
+// MultiLineString
+type lineTimes struct {
+	CoordTimes json.RawMessage `json:"coordTimes"`
+}
+
+func toRecord(pos []float64) (gps.GPSRecord, error) {
+	var gr gps.GPSRecord
+	if len(pos) < 2 {
+		return gr, fmt.Errorf("position %v needs long and lat", pos)
+	}
+	gr.Long, gr.Lat = pos[0], pos[1]
+	if gr.Long < -180 || gr.Long > 180 || gr.Lat < -90 || gr.Lat > 90 {
+		return gr, fmt.Errorf("position %v out of range", pos)
+	}
+	if len(pos) > 2 {
+		gr.Alt = pos[2] * 3.28084 // feet like the logger
+	}
+	return gr, nil
+}
+
+// reader keeps the records and the bad func while walking the document
+type reader struct {
+	records []gps.GPSRecord
+	bad     func(error) error
+}
+
+func (r *reader) report(err error) error {
+	if r.bad == nil {
+		return err
+	}
+	return r.bad(err)
+}
+
+// line adds a LineString's positions with their times, when there are as
+// many as there are positions
+func (r *reader) line(coords json.RawMessage, times []string) error {
+	var positions [][]float64
+	if err := json.Unmarshal(coords, &positions); err != nil {
+		return r.report(fmt.Errorf("bad LineString coordinates: %w", err))
+	}
+	if times != nil && len(times) != len(positions) {
+		if err := r.report(fmt.Errorf("%d coordTimes for %d positions", len(times), len(positions))); err != nil {
+			return err
+		}
+		times = nil
+	}
+	for i, pos := range positions {
+		gr, err := toRecord(pos)
+		if err == nil && times != nil {
+			var t time.Time
+			if t, err = time.Parse(time.RFC3339Nano, times[i]); err == nil {
+				gr.GPSTime = t.UTC()
+				gr.UnixMicro = uint64(t.UnixMicro())
+			}
+		}
+		if err != nil {
+			if err := r.report(err); err != nil {
+				return err
+			}
+			continue
+		}
+		r.records = append(r.records, gr)
+	}
+	return nil
+}
+
+// geometry adds the lines in g, points are waypoints and aren't part of
+// the track
+func (r *reader) geometry(g readGeometry, props json.RawMessage) error {
+	var lt lineTimes
+	if len(props) > 0 {
+		// properties that aren't an object just don't have times
+		_ = json.Unmarshal(props, &lt)
+	}
+	switch g.Type {
+	case "LineString":
+		var times []string
+		_ = json.Unmarshal(lt.CoordTimes, &times)
+		return r.line(g.Coordinates, times)
+	case "MultiLineString":
+		var lines []json.RawMessage
+		if err := json.Unmarshal(g.Coordinates, &lines); err != nil {
+			return r.report(fmt.Errorf("bad MultiLineString coordinates: %w", err))
+		}
+		var times [][]string
+		_ = json.Unmarshal(lt.CoordTimes, &times)
+		for i, l := range lines {
+			var t []string
+			if i < len(times) {
+				t = times[i]
+			}
+			if err := r.line(l, t); err != nil {
+				return err
+			}
+		}
+	case "GeometryCollection":
+		for _, sub := range g.Geometries {
+			if err := r.geometry(sub, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Read reads the track from the LineStrings and MultiLineStrings in a
+// FeatureCollection, Feature or bare geometry, with coordTimes when the
+// feature has them. bad is called with each position that can't be used;
+// it stops with bad's error if it returns one, a nil bad stops at the first
+func Read(rd io.Reader, bad func(error) error) ([]gps.GPSRecord, error) {
+	var doc readFeature
+	if err := json.NewDecoder(rd).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("not a GeoJSON file: %w", err)
+	}
+	r := &reader{records: []gps.GPSRecord{}, bad: bad}
+	var err error
+	switch doc.Type {
+	case "FeatureCollection":
+		for _, f := range doc.Features {
+			if f.Geometry == nil {
+				continue
+			}
+			if err = r.geometry(*f.Geometry, f.Properties); err != nil {
+				break
+			}
+		}
+	case "Feature":
+		if doc.Geometry != nil {
+			err = r.geometry(*doc.Geometry, doc.Properties)
+		}
+	case "":
+		return nil, fmt.Errorf("not a GeoJSON file: no type")
+	default:
+		err = r.geometry(readGeometry{Type: doc.Type, Coordinates: doc.Coordinates, Geometries: doc.Geometries}, nil)
+	}
+	return r.records, err
+}
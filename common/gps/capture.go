@@ -0,0 +1,225 @@
+package gps
+
+// raw NMEA capture files: every chunk read from the receiver on its own line
+//  with the time it arrived, so a bad drive can be looked at by hand and
+//	replayed through the same parsing later. A capture looks like
+//
+//	# raspigogps nmea capture v1
+//	2022-05-20T18:04:05.123456789Z "$GPRMC,180405.00,A,...\r\n$GPVTG,..."
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const captureHeader = "# raspigogps nmea capture v1"
+
+// CaptureChunk is one read from the receiver
+type CaptureChunk struct {
+	Received time.Time
+	Data     []byte
+}
+
+// CaptureWriter writes chunks to a capture file
+type CaptureWriter struct {
+	mu    sync.Mutex
+	w     io.Writer
+	c     io.Closer
+	wrote bool
+}
+
+// NewCaptureWriter writes a capture to w, closing it on Close if it's an
+// io.Closer
+func NewCaptureWriter(w io.Writer) *CaptureWriter {
+	cw := &CaptureWriter{w: w}
+	if c, ok := w.(io.Closer); ok {
+		cw.c = c
+	}
+	return cw
+}
+
+// CreateCapture starts a new capture in dir named by the current UTC time
+func CreateCapture(dir string) (*CaptureWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("nmea-%s.txt", time.Now().UTC().Format("20060102T150405Z"))
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return NewCaptureWriter(f), nil
+}
+
+// Name is the capture's file name, empty if it isn't writing to a file
+func (cw *CaptureWriter) Name() string {
+	if f, ok := cw.w.(*os.File); ok {
+		return f.Name()
+	}
+	return ""
+}
+
+// WriteChunk appends one read. Each chunk is written straight through so a
+// capture is complete up to the moment the power goes
+func (cw *CaptureWriter) WriteChunk(received time.Time, data []byte) error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	var sb strings.Builder
+	if !cw.wrote {
+		sb.WriteString(captureHeader + "\n")
+	}
+	sb.WriteString(received.UTC().Format(time.RFC3339Nano))
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.Quote(string(data)))
+	sb.WriteByte('\n')
+	if _, err := io.WriteString(cw.w, sb.String()); err != nil {
+		return err
+	}
+	cw.wrote = true
+	return nil
+}
+
+// Close closes the underlying file
+func (cw *CaptureWriter) Close() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.c == nil {
+		return nil
+	}
+	return cw.c.Close()
+}
+
+// teeReader copies every non-empty read into a capture
+type teeReader struct {
+	r       io.Reader
+	capture *CaptureWriter
+	failed  bool
+}
+
+func (t *teeReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if cerr := t.capture.WriteChunk(time.Now(), p[:n]); cerr != nil && !t.failed {
+			// keep reading the receiver, the capture is only a diagnostic
+			logrus.WithError(cerr).Error("Error writing nmea capture: ")
+			t.failed = true
+		}
+	}
+	return n, err
+}
+
+// CaptureReader reads the chunks back from a capture
+type CaptureReader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+// NewCaptureReader checks the header and returns a reader for the chunks
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("empty capture")
+	}
+	if strings.TrimSpace(s.Text()) != captureHeader {
+		return nil, fmt.Errorf("not an nmea capture, first line is '%s'", s.Text())
+	}
+	return &CaptureReader{s: s, line: 1}, nil
+}
+
+// Read returns the next chunk, io.EOF at the end of the capture
+func (cr *CaptureReader) Read() (CaptureChunk, error) {
+	for cr.s.Scan() {
+		cr.line++
+		line := cr.s.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		ts, quoted, ok := strings.Cut(line, " ")
+		if !ok {
+			return CaptureChunk{}, fmt.Errorf("line %d: missing data", cr.line)
+		}
+		received, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return CaptureChunk{}, fmt.Errorf("line %d: could not parse time: %w", cr.line, err)
+		}
+		data, err := strconv.Unquote(quoted)
+		if err != nil {
+			return CaptureChunk{}, fmt.Errorf("line %d: could not unquote data: %w", cr.line, err)
+		}
+		return CaptureChunk{Received: received, Data: []byte(data)}, nil
+	}
+	if err := cr.s.Err(); err != nil {
+		return CaptureChunk{}, err
+	}
+	return CaptureChunk{}, io.EOF
+}
+
+// replayReader hands out a capture one chunk per Read, the same boundaries the
+// serial port produced, optionally waiting out the original gaps
+type replayReader struct {
+	cr       *CaptureReader
+	realtime bool
+	pending  []byte
+	last     time.Time
+}
+
+func (rr *replayReader) Read(p []byte) (int, error) {
+	if len(rr.pending) == 0 {
+		chunk, err := rr.cr.Read()
+		if err != nil {
+			return 0, err
+		}
+		if rr.realtime && !rr.last.IsZero() {
+			if gap := chunk.Received.Sub(rr.last); gap > 0 {
+				time.Sleep(gap)
+			}
+		}
+		rr.last = chunk.Received
+		rr.pending = chunk.Data
+	}
+	n := copy(p, rr.pending)
+	rr.pending = rr.pending[n:]
+	return n, nil
+}
+
+// received is when the chunk last read from was received
+func (rr *replayReader) received() time.Time {
+	return rr.last
+}
+
+// StartReplay feeds a capture through the same reading and parsing as
+// StartSerial, closing the channel at the end of the file. Records are stamped
+// with when their chunk was received, and with realtime they come out at that
+// pace
+func StartReplay(path string, realtime bool) (chan GPSRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	cr, err := NewCaptureReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	grC := make(chan GPSRecord)
+	go func() {
+		defer f.Close()
+		// the replay reader already waits out the gaps, no need to settle
+		rr := &replayReader{cr: cr, realtime: realtime}
+		readLoop(rr, 0, true, rr.received, grC)
+	}()
+	return grC, nil
+}
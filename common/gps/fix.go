@@ -0,0 +1,74 @@
+package gps
+
+import "github.com/adrianmo/go-nmea"
+
+// FixQuality is GGA's fix quality, FixUnknown for records from logs or
+// sentences that didn't have it
+type FixQuality int
+
+const (
+	FixUnknown   FixQuality = iota // not reported
+	FixInvalid                     // no fix
+	FixGPS                         // autonomous GPS
+	FixDGPS                        // differential, SBAS or a base station
+	FixPPS                         // precise positioning service
+	FixRTK                         // real time kinematic, fixed
+	FixFloatRTK                    // real time kinematic, float
+	FixEstimated                   // dead reckoning
+)
+
+// FixQualities is every quality in order
+var FixQualities = []FixQuality{
+	FixUnknown, FixInvalid, FixGPS, FixDGPS, FixPPS, FixRTK, FixFloatRTK, FixEstimated,
+}
+
+func (f FixQuality) String() string {
+	switch f {
+	case FixInvalid:
+		return "invalid"
+	case FixGPS:
+		return "gps"
+	case FixDGPS:
+		return "dgps"
+	case FixPPS:
+		return "pps"
+	case FixRTK:
+		return "rtk"
+	case FixFloatRTK:
+		return "float-rtk"
+	case FixEstimated:
+		return "estimated"
+	}
+	return "unknown"
+}
+
+// ParseFixQuality is the inverse of FixQuality.String
+func ParseFixQuality(s string) FixQuality {
+	for _, f := range FixQualities {
+		if f.String() == s {
+			return f
+		}
+	}
+	return FixUnknown
+}
+
+// ggaFixQuality converts GGA's fix quality field
+func ggaFixQuality(q string) FixQuality {
+	switch q {
+	case nmea.Invalid:
+		return FixInvalid
+	case nmea.GPS:
+		return FixGPS
+	case nmea.DGPS:
+		return FixDGPS
+	case nmea.PPS:
+		return FixPPS
+	case nmea.RTK:
+		return FixRTK
+	case nmea.FRTK:
+		return FixFloatRTK
+	case nmea.EST:
+		return FixEstimated
+	}
+	return FixUnknown
+}
@@ -0,0 +1,81 @@
+package gps
+
+import "math"
+
+// WGS84 ellipsoid
+const (
+	wgs84A = 6378137.0
+	wgs84F = 1 / 298.257223563
+	wgs84B = wgs84A * (1 - wgs84F)
+)
+
+// Distance returns the geodesic distance in meters between two records on the
+// WGS84 ellipsoid (Vincenty's inverse formula, falling back to the great
+// circle for the nearly antipodal points where it doesn't converge)
+func Distance(from, to GPSRecord) float64 {
+	if from.Lat == to.Lat && from.Long == to.Long {
+		return 0
+	}
+	phi1, phi2 := from.Lat*math.Pi/180, to.Lat*math.Pi/180
+	l := (to.Long - from.Long) * math.Pi / 180
+	u1 := math.Atan((1 - wgs84F) * math.Tan(phi1))
+	u2 := math.Atan((1 - wgs84F) * math.Tan(phi2))
+	sinU1, cosU1 := math.Sincos(u1)
+	sinU2, cosU2 := math.Sincos(u2)
+
+	lambda := l
+	for i := 0; i < 100; i++ {
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma := math.Hypot(cosU2*sinLambda, cosU1*sinU2-sinU1*cosU2*cosLambda)
+		if sinSigma == 0 {
+			return 0
+		}
+		cosSigma := sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma := math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cos2Alpha := 1 - sinAlpha*sinAlpha
+		cos2SigmaM := 0.0
+		if cos2Alpha != 0 {
+			// zero on the equator
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cos2Alpha
+		}
+		c := wgs84F / 16 * cos2Alpha * (4 + wgs84F*(4-3*cos2Alpha))
+		prev := lambda
+		lambda = l + (1-c)*wgs84F*sinAlpha*
+			(sigma+c*sinSigma*(cos2SigmaM+c*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-prev) < 1e-12 {
+			uSq := cos2Alpha * (wgs84A*wgs84A - wgs84B*wgs84B) / (wgs84B * wgs84B)
+			a := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+			b := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+			deltaSigma := b * sinSigma * (cos2SigmaM + b/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+				b/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+			return wgs84B * a * (sigma - deltaSigma)
+		}
+	}
+	return greatCircle(from, to)
+}
+
+// greatCircle is the haversine distance in meters on a sphere of the mean
+// earth radius
+func greatCircle(from, to GPSRecord) float64 {
+	const r = 6371008.8
+	phi1, phi2 := from.Lat*math.Pi/180, to.Lat*math.Pi/180
+	dPhi := phi2 - phi1
+	dLambda := (to.Long - from.Long) * math.Pi / 180
+	h := math.Sin(dPhi/2)*math.Sin(dPhi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * r * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Bearing is the initial compass bearing in degrees (clockwise from true
+// north) from one record to another
+func Bearing(from, to GPSRecord) float64 {
+	phi1, phi2 := from.Lat*math.Pi/180, to.Lat*math.Pi/180
+	dLambda := (to.Long - from.Long) * math.Pi / 180
+	y := math.Sin(dLambda) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dLambda)
+	b := math.Atan2(y, x) * 180 / math.Pi
+	if b < 0 {
+		b += 360
+	}
+	return b
+}
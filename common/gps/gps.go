@@ -2,24 +2,49 @@ package gps
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"strings"
 	"time"
 
 	"github.com/adrianmo/go-nmea"
+	"github.com/samiam2013/raspigogps/common/geoid"
+	"github.com/samiam2013/raspigogps/common/wmm"
 	"github.com/sirupsen/logrus"
 	"github.com/tarm/serial"
 )
 
 type GPSRecord struct {
-	UnixMicro uint64
-	Lat       float64
-	Long      float64
-	Alt       float64
-	Speed     float64
-	Heading   float64
-	NumSats   int64
-	TimeStr   string
+	UnixMicro     uint64
+	Lat           float64
+	Long          float64
+	Alt           float64 // feet above mean sea level
+	AltEllipsoid  float64 // feet above the WGS84 ellipsoid
+	GeoidSep      float64 // feet, AltEllipsoid - Alt
+	Speed         float64
+	Heading       float64 // true track
+	HeadingSource HeadingSource
+	MagHeading    float64 // Heading corrected by Declination
+	Declination   float64 // degrees east of true north, from the WMM
+	NumSats       int64
+	HDOP          float64 // horizontal dilution of precision, 0 if not reported
+	FixQuality    FixQuality
+	TimeStr       string
+	GPSTime       time.Time // UTC date and time from RMC, zero until one is seen
+}
+
+// Time is when the fix was taken, from the receiver if it sent a date and
+// the Pi's clock otherwise
+func (g GPSRecord) Time() time.Time {
+	if !g.GPSTime.IsZero() {
+		return g.GPSTime
+	}
+	return time.UnixMicro(int64(g.UnixMicro)).UTC()
+}
+
+// SpeedMetersPerSecond converts Speed, which Parse stores as km/h / 1.852
+func (g GPSRecord) SpeedMetersPerSecond() float64 {
+	return g.Speed * 1.852 / 3.6
 }
 
 // to get the actual heading spin 90 degrees counterclockwise
@@ -76,12 +101,26 @@ func (g GPSRecord) Turned(from, to GPSRecord) bool {
 	return turned
 }
 
+// Parse reads a burst of NMEA sentences into a record received now
 func Parse(data string) (GPSRecord, error) {
+	return parseAt(data, time.Now())
+}
+
+// parseAt is Parse for a burst received at received, a replay's original
+// receive time rather than when it's read back
+func parseAt(data string, received time.Time) (GPSRecord, error) {
 	data = strings.Trim(data, "\x00")
 	data = strings.TrimRight(data, "\r\n")
 	sentences := strings.Split(data, "\r\n")
 
 	var gr GPSRecord
+	var haveAlt bool
+	var mslMeters, sepMeters float64
+	// GLL's position and VTG's speed are used first, these are for logs
+	//  without them
+	var fixLat, fixLong float64
+	var haveVTG, haveRMCTrack bool
+	var rmcSpeed, rmcCourse float64
 	for i := range sentences {
 		if len(sentences[i]) == 0 || sentences[i][0] != '$' {
 			continue
@@ -100,18 +139,64 @@ func Parse(data string) (GPSRecord, error) {
 			gr.TimeStr = m.Time.String()
 		} else if s.DataType() == nmea.TypeGGA {
 			// fmt.Println("alt:", s.(nmea.GGA).Altitude, "sats:", s.(nmea.GGA).NumSatellites)
-			gr.Alt = s.(nmea.GGA).Altitude * 3.28084 // convert to feet
+			haveAlt = true
+			mslMeters = s.(nmea.GGA).Altitude
+			sepMeters = s.(nmea.GGA).Separation
+			if s.(nmea.GGA).FixQuality != nmea.Invalid {
+				fixLat, fixLong = s.(nmea.GGA).Latitude, s.(nmea.GGA).Longitude
+			}
 			gr.NumSats = s.(nmea.GGA).NumSatellites
+			gr.HDOP = s.(nmea.GGA).HDOP
+			gr.FixQuality = ggaFixQuality(s.(nmea.GGA).FixQuality)
 		} else if s.DataType() == nmea.TypeVTG {
 			// fmt.Println("speed:", s.(nmea.VTG).GroundSpeedKPH, "heading:", s.(nmea.VTG).TrueTrack)
-			gr.Speed = s.(nmea.VTG).GroundSpeedKPH / 1.852 // convert to mph
+			haveVTG = true
+			gr.Speed = s.(nmea.VTG).GroundSpeedKPH / 1.852 // convert to knots
 			gr.Heading = s.(nmea.VTG).TrueTrack
+			// receivers leave the track empty when they don't have one
+			if f := s.(nmea.VTG).Fields; len(f) > 0 && f[0] != "" {
+				gr.HeadingSource = HeadingTrack
+			}
+		} else if s.DataType() == nmea.TypeRMC {
+			m := s.(nmea.RMC)
+			if m.Validity == nmea.ValidRMC && fixLat == 0 && fixLong == 0 {
+				fixLat, fixLong = m.Latitude, m.Longitude
+			}
+			// knots, the same as VTG's km/h / 1.852
+			rmcSpeed, rmcCourse = m.Speed, m.Course
+			haveRMCTrack = len(m.Fields) > 7 && m.Fields[7] != ""
+			if m.Date.Valid && m.Time.Valid {
+				gr.GPSTime = time.Date(2000+m.Date.YY, time.Month(m.Date.MM), m.Date.DD,
+					m.Time.Hour, m.Time.Minute, m.Time.Second, m.Time.Millisecond*1e6, time.UTC)
+			}
+		}
+	}
+	if gr.Lat == 0.0 && gr.Long == 0.0 {
+		gr.Lat, gr.Long = fixLat, fixLong
+	}
+	if !haveVTG {
+		gr.Speed, gr.Heading = rmcSpeed, rmcCourse
+		if haveRMCTrack {
+			gr.HeadingSource = HeadingTrack
 		}
 	}
 	if gr.Lat == 0.0 || gr.Long == 0.0 {
 		return GPSRecord{}, fmt.Errorf("no lat/long")
 	}
-	gr.UnixMicro = uint64(time.Now().UnixNano() / 1000)
+	if haveAlt {
+		// the ellipsoidal height is what the receiver actually measured, MSL
+		//  is derived from it so it matches records from other sources
+		ellipsoid, msl := geoid.FromMSL(gr.Lat, gr.Long, mslMeters, sepMeters)
+		gr.Alt = msl * 3.28084 // convert to feet
+		gr.AltEllipsoid = ellipsoid * 3.28084
+		gr.GeoidSep = gr.AltEllipsoid - gr.Alt
+	}
+	gr.UnixMicro = uint64(received.UnixNano() / 1000)
+	// WMM wants height above the ellipsoid in km
+	gr.Declination = wmm.Declination(gr.Lat, gr.Long, gr.AltEllipsoid/3.28084/1000, gr.Time())
+	if gr.HeadingSource != HeadingNone {
+		gr.MagHeading = wmm.MagneticHeading(gr.Heading, gr.Declination)
+	}
 	return gr, nil
 }
 
@@ -119,6 +204,12 @@ type SerialRead func() (GPSRecord, error)
 type SerialClose func() error
 
 func StartSerial(serialPortPath string, baudrate int) chan GPSRecord {
+	return StartSerialCapture(serialPortPath, baudrate, nil)
+}
+
+// StartSerialCapture is StartSerial that also tees every raw read from the
+// port to capture (see NewCaptureWriter) when it isn't nil
+func StartSerialCapture(serialPortPath string, baudrate int, capture *CaptureWriter) chan GPSRecord {
 
 	// look for the gps dongle and open it
 	config := &serial.Config{
@@ -134,34 +225,57 @@ func StartSerial(serialPortPath string, baudrate int) chan GPSRecord {
 		logrus.Error("Error opening serial port: ", err)
 		return nil
 	}
+	var r io.Reader = port
+	if capture != nil {
+		r = &teeReader{r: port, capture: capture}
+	}
 	grC := make(chan GPSRecord)
-	go func(grC chan GPSRecord) {
-		for {
-			buf := make([]byte, 1024)
-			delayedBuf := ""
-			nRead, err := port.Read(buf)
+	go readLoop(r, time.Millisecond*100, false, time.Now, grC)
+	return grC
+}
+
+// readLoop parses records from r, settle is how long to wait for the rest of
+// a burst of sentences after the first read and received is when the burst's
+// first read arrived. The serial port reports EOF whenever it has nothing, so
+// only a replay (stopAtEnd) finishes, closing grC at its first error
+func readLoop(r io.Reader, settle time.Duration, stopAtEnd bool, received func() time.Time, grC chan GPSRecord) {
+	headings := NewHeadingEstimator()
+	for {
+		buf := make([]byte, 1024)
+		delayedBuf := ""
+		nRead, err := r.Read(buf)
+		if err != nil && stopAtEnd {
+			if err != io.EOF {
+				logrus.WithError(err).Error("Error reading capture: ")
+			}
+			close(grC)
+			return
+		}
+		if err != nil && !strings.Contains(err.Error(), "EOF") {
+			logrus.WithError(err).Error("Error reading serial port: ")
+			continue
+		}
+		if nRead > 0 {
+			at := received()
+			delayedBuf += string(buf[:nRead])
+			// clear the buffer
+			buf = make([]byte, 1024)
+			time.Sleep(settle) // wait for the next read
+			nRead, err = r.Read(buf)
 			if err != nil && !strings.Contains(err.Error(), "EOF") {
-				logrus.WithError(err).Error("Error reading serial port: ")
-				continue
+				// parse what there is, the next read reports it again or recovers
+				logrus.WithError(err).Error("Error reading the rest of a burst: ")
 			}
-			if nRead > 0 {
-				delayedBuf += string(buf[:nRead])
-				// clear the buffer
-				buf = make([]byte, 1024)
-				time.Sleep(time.Millisecond * 100) // wait for the next read
-				nRead, _ = port.Read(buf)          // assuming if there was no error before there is none now :D
-				// append the new data to the delayed buffer
-				delayedBuf += string(buf[:nRead])
-				logrus.Info("Read from serial port: ", delayedBuf)
-
-				gr, err := Parse(delayedBuf)
-				if err != nil {
-					logrus.WithError(err).Error("Error parsing data from serial port: ")
-					continue
-				}
-				grC <- gr
+			// append the new data to the delayed buffer
+			delayedBuf += string(buf[:nRead])
+			logrus.Info("Read from serial port: ", delayedBuf)
+
+			gr, err := parseAt(delayedBuf, at)
+			if err != nil {
+				logrus.WithError(err).Error("Error parsing data from serial port: ")
+				continue
 			}
+			grC <- headings.Update(gr)
 		}
-	}(grC)
-	return grC
+	}
 }
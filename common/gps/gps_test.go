@@ -1,8 +1,15 @@
 package gps
 
 import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_getUnitCircleAngle(t *testing.T) {
@@ -152,3 +159,241 @@ func Test_getUnitCircleAngle(t *testing.T) {
 		})
 	}
 }
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to GPSRecord
+		want     float64
+	}{
+		{
+			name: "same point",
+			from: GPSRecord{Lat: 38.0, Long: -90.0},
+			to:   GPSRecord{Lat: 38.0, Long: -90.0},
+			want: 0,
+		},
+		{
+			// one degree of latitude at the equator is shorter than at the pole
+			name: "degree of latitude at the equator",
+			from: GPSRecord{Lat: 0.0, Long: 0.0},
+			to:   GPSRecord{Lat: 1.0, Long: 0.0},
+			want: 110574.389,
+		},
+		{
+			name: "degree of longitude on the equator",
+			from: GPSRecord{Lat: 0.0, Long: 0.0},
+			to:   GPSRecord{Lat: 0.0, Long: 1.0},
+			want: 111319.491,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Distance(tt.from, tt.to); math.Abs(got-tt.want) > 0.01 {
+				t.Errorf("Distance() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBearing(t *testing.T) {
+	from := GPSRecord{Lat: 38.0, Long: -90.0}
+	tests := []struct {
+		name string
+		to   GPSRecord
+		want float64
+	}{
+		{name: "north", to: GPSRecord{Lat: 38.1, Long: -90.0}, want: 0},
+		{name: "east", to: GPSRecord{Lat: 38.0, Long: -89.999}, want: 90},
+		{name: "south", to: GPSRecord{Lat: 37.9, Long: -90.0}, want: 180},
+		{name: "west", to: GPSRecord{Lat: 38.0, Long: -90.001}, want: 270},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Bearing(from, tt.to); math.Abs(got-tt.want) > 0.01 {
+				t.Errorf("Bearing() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeadingEstimator(t *testing.T) {
+	// a car pulls up to a stop sign heading east, then turns north at a crawl
+	//  with the receiver no longer reporting a track
+	e := NewHeadingEstimator()
+	e.Smoothing = 1 // no filtering so the positions are easy to reason about
+	steps := []struct {
+		gr         GPSRecord
+		wantSource HeadingSource
+		want       float64
+	}{
+		{GPSRecord{Lat: 38.0, Long: -90.0, Speed: 20, Heading: 91, HeadingSource: HeadingTrack}, HeadingTrack, 91},
+		{GPSRecord{Lat: 38.0, Long: -89.99997, Speed: 1, Heading: 12, HeadingSource: HeadingTrack}, HeadingHeld, 91},
+		{GPSRecord{Lat: 38.00001, Long: -90.0, Speed: 0}, HeadingHeld, 91},
+		{GPSRecord{Lat: 38.0001, Long: -90.0, Speed: 2}, HeadingDerived, 0},
+		{GPSRecord{Lat: 38.0001, Long: -90.0, Speed: 0}, HeadingHeld, 0},
+	}
+	for i, step := range steps {
+		got := e.Update(step.gr)
+		if got.HeadingSource != step.wantSource || math.Abs(got.Heading-step.want) > 0.5 {
+			t.Errorf("step %d: Update() = %v %v, want %v %v",
+				i, got.Heading, got.HeadingSource, step.want, step.wantSource)
+		}
+	}
+
+	// no heading, no magnetic heading, whatever the declination
+	got := NewHeadingEstimator().Update(GPSRecord{Lat: 38.0, Long: -90.0, Declination: -1.5})
+	if got.HeadingSource != HeadingNone || got.MagHeading != 0 {
+		t.Errorf("Update() without a heading = %v %v magnetic", got.HeadingSource, got.MagHeading)
+	}
+}
+
+// nmeaSentence adds the $ and checksum to a sentence body
+func nmeaSentence(body string) string {
+	var sum byte
+	for i := 0; i < len(body); i++ {
+		sum ^= body[i]
+	}
+	return fmt.Sprintf("$%s*%02X\r\n", body, sum)
+}
+
+// chunkReader returns one chunk per Read like the serial port does
+type chunkReader struct {
+	chunks []string
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.chunks[0])
+	c.chunks = c.chunks[1:]
+	return n, nil
+}
+
+func TestCaptureReplay(t *testing.T) {
+	fixes := []string{
+		nmeaSentence("GPGLL,3836.4194,N,09011.0866,W,180405.00,A,A") +
+			nmeaSentence("GPVTG,271.5,T,,M,16.8,N,31.2,K,A") +
+			nmeaSentence("GPRMC,180405.00,A,3836.4194,N,09011.0866,W,16.8,271.5,200522,,,A"),
+		nmeaSentence("GPGLL,3836.4200,N,09011.1000,W,180406.00,A,A") +
+			nmeaSentence("GPRMC,180406.00,A,3836.4200,N,09011.1000,W,16.8,271.5,200522,,,A"),
+	}
+	// a burst split mid-sentence and some line noise, which must survive as is
+	raw := []string{fixes[0][:40], fixes[0][40:], "\xff garbage\r\n", fixes[1]}
+
+	path := filepath.Join(t.TempDir(), "capture.txt")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw := NewCaptureWriter(f)
+	tee := &teeReader{r: &chunkReader{chunks: append([]string(nil), raw...)}, capture: cw}
+	buf := make([]byte, 1024)
+	for {
+		if _, err := tee.Read(buf); err == io.EOF {
+			break
+		}
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr, err := NewCaptureReader(f)
+	if err != nil {
+		t.Fatalf("NewCaptureReader() error = %v", err)
+	}
+	var got []string
+	var last time.Time
+	var receipts []time.Time
+	for {
+		chunk, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if chunk.Received.Before(last) {
+			t.Errorf("receive times went backwards: %v after %v", chunk.Received, last)
+		}
+		last = chunk.Received
+		receipts = append(receipts, chunk.Received)
+		got = append(got, string(chunk.Data))
+	}
+	f.Close()
+	if !reflect.DeepEqual(got, raw) {
+		t.Errorf("capture chunks = %q, want %q", got, raw)
+	}
+
+	grC, err := StartReplay(path, false)
+	if err != nil {
+		t.Fatalf("StartReplay() error = %v", err)
+	}
+	var records []GPSRecord
+	for gr := range grC {
+		records = append(records, gr)
+	}
+	if len(records) != 2 {
+		t.Fatalf("replayed %d records, want 2", len(records))
+	}
+	want := time.Date(2022, time.May, 20, 18, 4, 5, 0, time.UTC)
+	if !records[0].GPSTime.Equal(want) || math.Abs(records[0].Lat-38.60699) > 1e-5 {
+		t.Errorf("first record = %+v, want a fix at 38.60699 on %v", records[0], want)
+	}
+	if records[0].HeadingSource != HeadingTrack || records[0].Heading != 271.5 {
+		t.Errorf("first record heading = %v (%s), want 271.5 from the track", records[0].Heading, records[0].HeadingSource)
+	}
+	// stamped when the burst started arriving, not when it was replayed
+	for i, chunk := range []int{0, 2} {
+		if want := uint64(receipts[chunk].UnixMicro()); records[i].UnixMicro != want {
+			t.Errorf("record %d received at %d, want %d", i, records[i].UnixMicro, want)
+		}
+	}
+
+	if _, err := NewCaptureReader(strings.NewReader("unixmicro,lat,long\n")); err == nil {
+		t.Error("NewCaptureReader() accepted a file that isn't a capture")
+	}
+}
+
+func TestReadNMEA(t *testing.T) {
+	log := strings.Join([]string{
+		// before the fix: no position, skipped quietly
+		nmeaSentence("GPRMC,180404.00,V,,,,,,,200522,,,N"),
+		nmeaSentence("GPGGA,180405.00,3836.4194,N,09011.0866,W,1,09,0.9,142.2,M,-31.5,M,,"),
+		nmeaSentence("GPRMC,180405.00,A,3836.4194,N,09011.0866,W,16.8,271.5,200522,,,A"),
+		"$GPRMC,180406.00,A,bad checksum*00\r\n",
+		nmeaSentence("GPRMC,180407.00,A,3836.4200,N,09011.1000,W,16.8,271.5,200522,,,A"),
+		// a fix without a date
+		nmeaSentence("GPGGA,180408.00,3836.4210,N,09011.1100,W,1,09,0.9,142.2,M,-31.5,M,,"),
+	}, "")
+
+	var skipped []string
+	got, err := ReadNMEA(strings.NewReader(log), func(err error) error {
+		skipped = append(skipped, err.Error())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadNMEA() error = %v", err)
+	}
+	if len(got) != 2 || len(skipped) != 2 {
+		t.Fatalf("ReadNMEA() = %d records, skipped %q, want 2 and 2", len(got), skipped)
+	}
+	first := got[0]
+	if first.Time() != time.Date(2022, time.May, 20, 18, 4, 5, 0, time.UTC) || first.UnixMicro != uint64(first.Time().UnixMicro()) {
+		t.Errorf("first record at %v (%d)", first.Time(), first.UnixMicro)
+	}
+	if first.NumSats != 9 || first.HDOP != 0.9 || first.FixQuality != FixGPS || first.Speed != 16.8 || first.HeadingSource != HeadingTrack {
+		t.Errorf("first record = %+v, want GGA's sats and fix and RMC's speed and course", first)
+	}
+	if math.Abs(first.Lat-38.60699) > 1e-5 {
+		t.Errorf("first record latitude = %v", first.Lat)
+	}
+
+	if _, err := ReadNMEA(strings.NewReader(log), nil); err == nil || !strings.Contains(err.Error(), "line 4") {
+		t.Errorf("ReadNMEA() without bad = %v, want the line 4 error", err)
+	}
+}
@@ -0,0 +1,103 @@
+package gps
+
+import (
+	"github.com/samiam2013/raspigogps/common/wmm"
+)
+
+// HeadingSource says where a record's Heading came from
+type HeadingSource int
+
+const (
+	HeadingNone    HeadingSource = iota // no heading yet
+	HeadingTrack                        // the receiver's VTG true track
+	HeadingDerived                      // bearing between successive positions
+	HeadingHeld                         // stationary, last good heading repeated
+)
+
+func (h HeadingSource) String() string {
+	switch h {
+	case HeadingTrack:
+		return "track"
+	case HeadingDerived:
+		return "derived"
+	case HeadingHeld:
+		return "held"
+	}
+	return "none"
+}
+
+// HeadingEstimator fills in a usable heading when the receiver's track is
+// missing or too noisy to trust at low speed
+type HeadingEstimator struct {
+	// MinTrackSpeed is the speed in knots under which the VTG track is ignored
+	MinTrackSpeed float64
+	// MinDisplacement is how far in meters the filtered position has to move
+	// before a bearing is computed from it
+	MinDisplacement float64
+	// Smoothing is the weight given to each new position in the low pass
+	// filter, 1 disables filtering
+	Smoothing float64
+
+	haveFiltered bool
+	filtered     GPSRecord
+	anchor       GPSRecord
+	haveLast     bool
+	last         float64
+}
+
+// NewHeadingEstimator returns an estimator with defaults tuned for a car and
+// a cheap USB receiver
+func NewHeadingEstimator() *HeadingEstimator {
+	return &HeadingEstimator{
+		MinTrackSpeed:   3.0,
+		MinDisplacement: 8.0,
+		Smoothing:       0.5,
+	}
+}
+
+// Update returns the record with Heading, MagHeading and HeadingSource set
+// from the best method available. Records need to be passed in order
+func (e *HeadingEstimator) Update(gr GPSRecord) GPSRecord {
+	if !e.haveFiltered {
+		e.filtered = gr
+		e.anchor = gr
+		e.haveFiltered = true
+	} else {
+		e.filtered.Lat += e.Smoothing * (gr.Lat - e.filtered.Lat)
+		e.filtered.Long += e.Smoothing * (gr.Long - e.filtered.Long)
+	}
+
+	switch {
+	case gr.HeadingSource == HeadingTrack && gr.Speed >= e.MinTrackSpeed:
+		// the receiver's own track is best once it's moving
+		e.last, e.haveLast = gr.Heading, true
+		e.anchor = e.filtered
+	case Distance(e.anchor, e.filtered) >= e.MinDisplacement:
+		e.last, e.haveLast = Bearing(e.anchor, e.filtered), true
+		e.anchor = e.filtered
+		gr.HeadingSource = HeadingDerived
+		gr.Heading = e.last
+	case e.haveLast:
+		gr.HeadingSource = HeadingHeld
+		gr.Heading = e.last
+	default:
+		gr.HeadingSource = HeadingNone
+		gr.Heading, gr.MagHeading = 0, 0
+		return gr
+	}
+	gr.MagHeading = wmm.MagneticHeading(gr.Heading, gr.Declination)
+	return gr
+}
+
+// ParseHeadingSource is the inverse of HeadingSource.String
+func ParseHeadingSource(s string) HeadingSource {
+	switch s {
+	case "track":
+		return HeadingTrack
+	case "derived":
+		return HeadingDerived
+	case "held":
+		return HeadingHeld
+	}
+	return HeadingNone
+}
@@ -0,0 +1,97 @@
+package gps
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/adrianmo/go-nmea"
+)
+
+// sentenceTime is the UTC time of day a sentence carries, empty for ones
+// without
+func sentenceTime(s nmea.Sentence) string {
+	switch m := s.(type) {
+	case nmea.RMC:
+		return m.Time.String()
+	case nmea.GGA:
+		return m.Time.String()
+	case nmea.GLL:
+		return m.Time.String()
+	}
+	return ""
+}
+
+// ReadNMEA reads records from a log of raw sentences, one per line, or from
+// a capture (see CaptureWriter). Sentences are grouped into a record until
+// the time of day changes; the date comes from RMC, so a fix without one is
+// bad. bad is called with each sentence or fix that can't be used, it
+// stops with bad's error if it returns one, a nil bad stops at the first.
+// Sentences from before the receiver has a fix are skipped quietly
+func ReadNMEA(r io.Reader, bad func(error) error) ([]GPSRecord, error) {
+	br := bufio.NewReader(r)
+	if first, err := br.Peek(len(captureHeader)); err == nil && string(first) == captureHeader {
+		cr, err := NewCaptureReader(br)
+		if err != nil {
+			return nil, err
+		}
+		r = &replayReader{cr: cr}
+	} else {
+		r = br
+	}
+	report := func(err error) error {
+		if bad == nil {
+			return err
+		}
+		return bad(err)
+	}
+
+	records := make([]GPSRecord, 0)
+	headings := NewHeadingEstimator()
+	var epoch []string
+	epochTime, epochLine := "", 0
+	flush := func() error {
+		if len(epoch) == 0 {
+			return nil
+		}
+		gr, err := Parse(strings.Join(epoch, "\r\n"))
+		epoch = epoch[:0]
+		if err != nil {
+			return nil // no fix yet
+		}
+		if gr.GPSTime.IsZero() {
+			return report(fmt.Errorf("line %d: fix at %s has no date, it needs an RMC sentence", epochLine, epochTime))
+		}
+		gr.UnixMicro = uint64(gr.GPSTime.UnixMicro())
+		records = append(records, headings.Update(gr))
+		return nil
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		s, err := nmea.Parse(text)
+		if err != nil {
+			if err := report(fmt.Errorf("line %d: %w", line, err)); err != nil {
+				return records, err
+			}
+			continue
+		}
+		if t := sentenceTime(s); t != "" && t != epochTime {
+			if err := flush(); err != nil {
+				return records, err
+			}
+			epochTime, epochLine = t, line
+		}
+		epoch = append(epoch, text)
+	}
+	if err := sc.Err(); err != nil {
+		return records, err
+	}
+	return records, flush()
+}
@@ -0,0 +1,32 @@
+package gpstest
+
+// the track the tests of the packages that read, write and summarize records
+//  share: fixes a second apart heading north from St. Louis, so a test only
+//	spells out what it changes about them
+
+import (
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// Start is the time of the first record
+var Start = time.Date(2022, time.May, 20, 12, 0, 0, 0, time.UTC)
+
+// Records is n fixes a second apart from 38.6,-90.2 heading north about 11m
+// at a time, 500ft up and moving at knots
+func Records(n int, knots float64) []gps.GPSRecord {
+	records := make([]gps.GPSRecord, 0, n)
+	for i := 0; i < n; i++ {
+		t := Start.Add(time.Duration(i) * time.Second)
+		records = append(records, gps.GPSRecord{
+			UnixMicro: uint64(t.UnixMicro()),
+			Lat:       38.6 + float64(i)*1e-4,
+			Long:      -90.2,
+			Alt:       500,
+			Speed:     knots,
+			GPSTime:   t,
+		})
+	}
+	return records
+}
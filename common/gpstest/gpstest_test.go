@@ -0,0 +1,24 @@
+package gpstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+func TestRecords(t *testing.T) {
+	records := Records(3, 10.8)
+	if len(records) != 3 || !records[0].Time().Equal(Start) || records[2].Time().Sub(Start) != 2*time.Second {
+		t.Fatalf("Records() = %+v", records)
+	}
+	if d := gps.Distance(records[0], records[1]); d < 11 || d > 11.2 {
+		t.Errorf("records are %vm apart, want about 11", d)
+	}
+	if s := records[1].SpeedMetersPerSecond(); s < 5.55 || s > 5.56 {
+		t.Errorf("speed = %v m/s, want 20 km/h", s)
+	}
+	if len(Records(0, 0)) != 0 {
+		t.Error("Records(0) isn't empty")
+	}
+}
@@ -0,0 +1,267 @@
+package gpx
+
+// GPX 1.1 tracks for OsmAnd, JOSM, BaseCamp and most everything else: one
+//  trk with a trkseg per stretch of continuous fixes. Speed and course go in
+//	Garmin's TrackPointExtension, which is what those tools read them from
+
+import (
+	"encoding/xml"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+)
+
+// the GPX and Garmin track point extension namespaces
+const (
+	Namespace    = "http://www.topografix.com/GPX/1/1"
+	TPXNamespace = "http://www.garmin.com/xmlschemas/TrackPointExtension/v2"
+	schemaLocs   = Namespace + " http://www.topografix.com/GPX/1/1/gpx.xsd " +
+		TPXNamespace + " http://www.garmin.com/xmlschemas/TrackPointExtensionv2.xsd"
+)
+
+// Options controls what's written
+type Options struct {
+	Name    string
+	Creator string
+	// MaxGap between fixes, longer starts a new segment
+	MaxGap time.Duration
+	// Waypoints are written as wpt elements before the track
+	Waypoints []waypoint.Waypoint
+}
+
+// DefaultOptions splits segments at gaps of more than 30 seconds
+func DefaultOptions() Options {
+	return Options{
+		Name:    "Track",
+		Creator: "raspigogps",
+		MaxGap:  30 * time.Second,
+	}
+}
+
+type metadata struct {
+	Name string `xml:"name,omitempty"`
+	Time string `xml:"time,omitempty"`
+}
+
+// point is a wptType, elements in the schema's order
+type point struct {
+	Lat         string      `xml:"lat,attr"`
+	Lon         string      `xml:"lon,attr"`
+	Ele         string      `xml:"ele,omitempty"`
+	Time        string      `xml:"time,omitempty"`
+	MagVar      string      `xml:"magvar,omitempty"`
+	GeoidHeight string      `xml:"geoidheight,omitempty"`
+	Sat         string      `xml:"sat,omitempty"`
+	HDOP        string      `xml:"hdop,omitempty"`
+	Extensions  *extensions `xml:"extensions,omitempty"`
+}
+
+// wpt is a waypoint's wptType, elements in the schema's order
+type wpt struct {
+	Lat  string `xml:"lat,attr"`
+	Lon  string `xml:"lon,attr"`
+	Time string `xml:"time,omitempty"`
+	Name string `xml:"name"`
+	Cmt  string `xml:"cmt,omitempty"`
+	Desc string `xml:"desc,omitempty"`
+	Sym  string `xml:"sym"`
+}
+
+func newWpt(w waypoint.Waypoint) wpt {
+	p := wpt{
+		Lat:  formatFloat(w.Lat, 7),
+		Lon:  formatFloat(w.Long, 7),
+		Time: w.Time.UTC().Format(time.RFC3339Nano),
+		Name: w.Name(),
+		Cmt:  w.Coords,
+		Desc: w.Note,
+		Sym:  "Flag, Blue",
+	}
+	if w.Interpolated {
+		p.Desc = strings.TrimPrefix(p.Desc+", position interpolated from the track", ", ")
+	}
+	return p
+}
+
+type extensions struct {
+	TPX tpx `xml:"gpxtpx:TrackPointExtension"`
+}
+
+type tpx struct {
+	Speed  string `xml:"gpxtpx:speed,omitempty"`
+	Course string `xml:"gpxtpx:course,omitempty"`
+}
+
+func formatFloat(v float64, prec int) string {
+	return strconv.FormatFloat(v, 'f', prec, 64)
+}
+
+// Segments splits records where the time between fixes is more than maxGap
+// or runs backwards
+func Segments(records []gps.GPSRecord, maxGap time.Duration) [][]gps.GPSRecord {
+	var segs [][]gps.GPSRecord
+	begin := 0
+	for i := 1; i <= len(records); i++ {
+		if i < len(records) {
+			dt := records[i].Time().Sub(records[i-1].Time())
+			if dt >= 0 && dt <= maxGap {
+				continue
+			}
+		}
+		segs = append(segs, records[begin:i])
+		begin = i
+	}
+	return segs
+}
+
+func newPoint(gr gps.GPSRecord) point {
+	p := point{
+		Lat:  formatFloat(gr.Lat, 7),
+		Lon:  formatFloat(gr.Long, 7),
+		Ele:  formatFloat(gr.Alt/3.28084, 1), // meters above sea level
+		Time: gr.Time().UTC().Format(time.RFC3339Nano),
+	}
+	if gr.Declination != 0 {
+		// degreesType is 0 up to 360, west is the top of the range
+		p.MagVar = formatFloat(math.Mod(gr.Declination+360, 360), 2)
+	}
+	if gr.GeoidSep != 0 {
+		p.GeoidHeight = formatFloat(gr.GeoidSep/3.28084, 1)
+	}
+	if gr.NumSats > 0 {
+		p.Sat = strconv.FormatInt(gr.NumSats, 10)
+	}
+	if gr.HDOP > 0 {
+		p.HDOP = formatFloat(gr.HDOP, 2)
+	}
+	ext := tpx{Speed: formatFloat(gr.SpeedMetersPerSecond(), 2)}
+	if gr.HeadingSource != gps.HeadingNone {
+		ext.Course = formatFloat(math.Mod(gr.Heading+360, 360), 1)
+	}
+	p.Extensions = &extensions{TPX: ext}
+	return p
+}
+
+// Writer writes a GPX document a point at a time, splitting segments as it
+// goes, so a track never has to be held in memory
+type Writer struct {
+	w       io.Writer
+	enc     *xml.Encoder
+	opts    Options
+	started bool
+	inSeg   bool
+	last    time.Time
+}
+
+// NewWriter returns a writer for one document, the header is written with
+// the first record or on Close
+func NewWriter(w io.Writer, opts Options) *Writer {
+	enc := xml.NewEncoder(w)
+	enc.Indent("", " ")
+	return &Writer{w: w, enc: enc, opts: opts}
+}
+
+func name(local string) xml.Name {
+	return xml.Name{Local: local}
+}
+
+func (gw *Writer) start(first time.Time) error {
+	gw.started = true
+	if _, err := io.WriteString(gw.w, xml.Header); err != nil {
+		return err
+	}
+	root := xml.StartElement{Name: name("gpx"), Attr: []xml.Attr{
+		{Name: name("version"), Value: "1.1"},
+		{Name: name("creator"), Value: gw.opts.Creator},
+		{Name: name("xmlns"), Value: Namespace},
+		{Name: name("xmlns:gpxtpx"), Value: TPXNamespace},
+		{Name: name("xmlns:xsi"), Value: "http://www.w3.org/2001/XMLSchema-instance"},
+		{Name: name("xsi:schemaLocation"), Value: schemaLocs},
+	}}
+	if err := gw.enc.EncodeToken(root); err != nil {
+		return err
+	}
+	md := metadata{Name: gw.opts.Name}
+	if !first.IsZero() {
+		md.Time = first.UTC().Format(time.RFC3339)
+	}
+	if err := gw.enc.EncodeElement(md, xml.StartElement{Name: name("metadata")}); err != nil {
+		return err
+	}
+	for _, w := range gw.opts.Waypoints {
+		if err := gw.enc.EncodeElement(newWpt(w), xml.StartElement{Name: name("wpt")}); err != nil {
+			return err
+		}
+	}
+	if err := gw.enc.EncodeToken(xml.StartElement{Name: name("trk")}); err != nil {
+		return err
+	}
+	if gw.opts.Name == "" {
+		return nil
+	}
+	return gw.enc.EncodeElement(gw.opts.Name, xml.StartElement{Name: name("name")})
+}
+
+// Write adds a record, starting a new segment after a gap of more than
+// MaxGap or when time runs backwards
+func (gw *Writer) Write(gr gps.GPSRecord) error {
+	t := gr.Time()
+	if !gw.started {
+		if err := gw.start(t); err != nil {
+			return err
+		}
+	}
+	if dt := t.Sub(gw.last); gw.inSeg && (dt < 0 || dt > gw.opts.MaxGap) {
+		if err := gw.enc.EncodeToken(xml.EndElement{Name: name("trkseg")}); err != nil {
+			return err
+		}
+		gw.inSeg = false
+	}
+	if !gw.inSeg {
+		if err := gw.enc.EncodeToken(xml.StartElement{Name: name("trkseg")}); err != nil {
+			return err
+		}
+		gw.inSeg = true
+	}
+	gw.last = t
+	return gw.enc.EncodeElement(newPoint(gr), xml.StartElement{Name: name("trkpt")})
+}
+
+// Close ends the document, it doesn't close the underlying writer
+func (gw *Writer) Close() error {
+	if !gw.started {
+		if err := gw.start(time.Time{}); err != nil {
+			return err
+		}
+	}
+	ends := []string{"trk", "gpx"}
+	if gw.inSeg {
+		ends = append([]string{"trkseg"}, ends...)
+	}
+	for _, e := range ends {
+		if err := gw.enc.EncodeToken(xml.EndElement{Name: name(e)}); err != nil {
+			return err
+		}
+	}
+	if err := gw.enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(gw.w, "\n")
+	return err
+}
+
+// Write writes a GPX document with the records as one track
+func Write(w io.Writer, records []gps.GPSRecord, opts Options) error {
+	gw := NewWriter(w, opts)
+	for _, gr := range records {
+		if err := gw.Write(gr); err != nil {
+			return err
+		}
+	}
+	return gw.Close()
+}
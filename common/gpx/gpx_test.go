@@ -0,0 +1,310 @@
+package gpx
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+	"github.com/samiam2013/raspigogps/common/xmltest"
+)
+
+var start = time.Date(2022, time.May, 20, 12, 0, 0, 0, time.UTC)
+
+func testRecord(sec int) gps.GPSRecord {
+	t := start.Add(time.Duration(sec) * time.Second)
+	return gps.GPSRecord{
+		UnixMicro:     uint64(t.UnixMicro()),
+		Lat:           38.6 + float64(sec)*1e-4,
+		Long:          -90.2,
+		Alt:           500,
+		GeoidSep:      -103.3,
+		Speed:         27, // 50 kph
+		Heading:       271.5,
+		HeadingSource: gps.HeadingDerived,
+		Declination:   -0.6,
+		NumSats:       9,
+		HDOP:          0.9,
+		GPSTime:       t,
+	}
+}
+
+func testRecords(secs ...int) []gps.GPSRecord {
+	records := make([]gps.GPSRecord, 0, len(secs))
+	for _, s := range secs {
+		records = append(records, testRecord(s))
+	}
+	return records
+}
+
+func TestSegments(t *testing.T) {
+	tests := []struct {
+		name string
+		secs []int
+		want []int // records in each segment
+	}{
+		{"none", nil, nil},
+		{"one", []int{0}, []int{1}},
+		{"continuous", []int{0, 1, 2, 3}, []int{4}},
+		{"gap", []int{0, 1, 60, 61, 62}, []int{2, 3}},
+		{"exactly max gap", []int{0, 30, 60}, []int{3}},
+		{"backwards", []int{0, 1, 2, 1, 2}, []int{3, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segs := Segments(testRecords(tt.secs...), 30*time.Second)
+			var got []int
+			for _, s := range segs {
+				got = append(got, len(s))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Segments() lengths = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func inRange(s string, min, max float64) error {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	if v < min || v > max {
+		return fmt.Errorf("%s out of range %g to %g", s, min, max)
+	}
+	return nil
+}
+
+// validate checks a document against the GPX 1.1 schema (gpx.xsd, from
+// GPX_XSD) and the parts of it written here by hand, returning the root and
+// the points in each segment
+func validate(t *testing.T, data []byte) (*xmltest.Node, []int) {
+	t.Helper()
+	t.Run("schema", func(t *testing.T) {
+		xmltest.Validate(t, data, "GPX_XSD", "")
+	})
+	root := xmltest.Parse(t, data, nil)
+	segs, err := checkGPX(root)
+	if err != nil {
+		t.Fatalf("invalid GPX: %v\n%s", err, data)
+	}
+	return root, segs
+}
+
+// checkGPX checks the elements used here are in the schema's order and range
+func checkGPX(root *xmltest.Node) ([]int, error) {
+	if root.Space != Namespace || root.Name != "gpx" {
+		return nil, fmt.Errorf("root is {%s}%s, want {%s}gpx", root.Space, root.Name, Namespace)
+	}
+	if root.Attr("version") != "1.1" || root.Attr("creator") == "" {
+		return nil, fmt.Errorf("gpx needs version 1.1 and a creator")
+	}
+	if err := xmltest.CheckSequence(root, []string{"metadata", "wpt", "trk"}, map[string]bool{"wpt": true, "trk": true}); err != nil {
+		return nil, err
+	}
+	trk := root.Child("trk")
+	if trk == nil {
+		return nil, fmt.Errorf("no trk")
+	}
+	if err := xmltest.CheckSequence(trk, []string{"name", "trkseg"}, map[string]bool{"trkseg": true}); err != nil {
+		return nil, err
+	}
+	for _, w := range root.Children {
+		if w.Name != "wpt" {
+			continue
+		}
+		order := []string{"ele", "time", "magvar", "geoidheight", "name", "cmt", "desc", "src", "link", "sym"}
+		if err := xmltest.CheckSequence(w, order, nil); err != nil {
+			return nil, err
+		}
+		if err := inRange(w.Attr("lat"), -90, 90); err != nil {
+			return nil, err
+		}
+		if err := inRange(w.Attr("lon"), -180, 180); err != nil {
+			return nil, err
+		}
+	}
+	var segs []int
+	for _, seg := range trk.Children {
+		if seg.Name != "trkseg" {
+			continue
+		}
+		if err := xmltest.CheckSequence(seg, []string{"trkpt"}, map[string]bool{"trkpt": true}); err != nil {
+			return nil, err
+		}
+		for _, pt := range seg.Children {
+			if err := inRange(pt.Attr("lat"), -90, 90); err != nil {
+				return nil, err
+			}
+			if err := inRange(pt.Attr("lon"), -180, 180); err != nil {
+				return nil, err
+			}
+			order := []string{"ele", "time", "magvar", "geoidheight", "sat", "hdop", "extensions"}
+			if err := xmltest.CheckSequence(pt, order, nil); err != nil {
+				return nil, err
+			}
+			if tm := pt.Child("time"); tm != nil {
+				if _, err := time.Parse(time.RFC3339, tm.Text); err != nil {
+					return nil, err
+				}
+			}
+			if mv := pt.Child("magvar"); mv != nil {
+				if err := inRange(mv.Text, 0, 360); err != nil {
+					return nil, err
+				}
+			}
+			if sat := pt.Child("sat"); sat != nil {
+				if _, err := strconv.ParseUint(sat.Text, 10, 32); err != nil {
+					return nil, err
+				}
+			}
+			if ext := pt.Child("extensions"); ext != nil {
+				tpx := ext.Child("TrackPointExtension")
+				if tpx == nil || tpx.Space != TPXNamespace {
+					return nil, fmt.Errorf("extensions without a TrackPointExtension")
+				}
+				if err := xmltest.CheckSequence(tpx, []string{"speed", "course"}, nil); err != nil {
+					return nil, err
+				}
+			}
+		}
+		segs = append(segs, len(seg.Children))
+	}
+	return segs, nil
+}
+
+func TestWrite(t *testing.T) {
+	noHeading := testRecords(0, 1)
+	for i := range noHeading {
+		noHeading[i].HeadingSource = gps.HeadingNone
+	}
+	tests := []struct {
+		name    string
+		records []gps.GPSRecord
+		segs    []int
+	}{
+		{"one segment", testRecords(0, 1, 2), []int{3}},
+		{"two segments", testRecords(0, 1, 120, 121), []int{2, 2}},
+		{"no heading", noHeading, []int{2}},
+		{"no records", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, tt.records, DefaultOptions()); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			root, segs := validate(t, buf.Bytes())
+			if fmt.Sprint(segs) != fmt.Sprint(tt.segs) {
+				t.Errorf("segments = %v, want %v", segs, tt.segs)
+			}
+			if len(tt.records) == 0 {
+				return
+			}
+			pt := root.Child("trk").Child("trkseg").Children[0]
+			tpx := pt.Child("extensions").Child("TrackPointExtension")
+			if got := tpx.Child("speed").Text; got != "13.89" {
+				t.Errorf("speed = %s m/s, want 13.89", got)
+			}
+			wantCourse := tt.records[0].HeadingSource != gps.HeadingNone
+			if (tpx.Child("course") != nil) != wantCourse {
+				t.Errorf("course written = %v, want %v", !wantCourse, wantCourse)
+			}
+			for name, want := range map[string]string{
+				"ele": "152.4", "magvar": "359.40", "geoidheight": "-31.5", "sat": "9", "hdop": "0.90",
+			} {
+				if c := pt.Child(name); c == nil || c.Text != want {
+					t.Errorf("<%s> = %v, want %s", name, c, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteWaypoints(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Waypoints = []waypoint.Waypoint{
+		{Time: start, Lat: 38.6, Long: -90.2, Number: 1, Coords: "15S 744580E 4279364N"},
+		{Time: start.Add(time.Second), Lat: 38.6001, Long: -90.2, Number: 2, Interpolated: true, Note: "Oak St"},
+	}
+	for _, records := range [][]gps.GPSRecord{testRecords(0, 1, 2), nil} {
+		var buf bytes.Buffer
+		if err := Write(&buf, records, opts); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		root, _ := validate(t, buf.Bytes())
+		var names, descs []string
+		for _, c := range root.Children {
+			if c.Name == "wpt" {
+				names = append(names, c.Child("name").Text)
+				if d := c.Child("desc"); d != nil {
+					descs = append(descs, d.Text)
+				}
+			}
+		}
+		if fmt.Sprint(names) != "[WP1 WP2]" || len(descs) != 1 || descs[0] != "Oak St, position interpolated from the track" {
+			t.Errorf("waypoints %v with descriptions %q, want WP1 and WP2, one interpolated", names, descs)
+		}
+		// waypoints aren't part of the track
+		if got, err := Read(&buf, nil); err != nil || len(got) != len(records) {
+			t.Errorf("Read() = %d records, %v, want %d", len(got), err, len(records))
+		}
+	}
+}
+
+func TestReadRoundTrip(t *testing.T) {
+	want := testRecords(0, 1, 120, 121)
+	var buf bytes.Buffer
+	if err := Write(&buf, want, DefaultOptions()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(&buf, nil)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Read() = %d records, want %d", len(got), len(want))
+	}
+	for i := range got {
+		g, w := got[i], want[i]
+		close := func(a, b, tol float64) bool { return a-b < tol && b-a < tol }
+		if !g.Time().Equal(w.Time()) || !close(g.Lat, w.Lat, 1e-7) || !close(g.Alt, w.Alt, 0.5) ||
+			!close(g.Speed, w.Speed, 0.05) || !close(g.Declination, w.Declination, 0.01) ||
+			g.NumSats != w.NumSats || g.HDOP != w.HDOP || g.HeadingSource != gps.HeadingTrack {
+			t.Errorf("record %d = %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+func TestReadBadPoints(t *testing.T) {
+	doc := `<?xml version="1.0"?>
+<gpx version="1.0" xmlns="http://www.topografix.com/GPX/1/0"><trk><trkseg>
+<trkpt lat="38.6" lon="-90.2"><time>2022-05-20T12:00:00Z</time><speed>5</speed></trkpt>
+<trkpt lat="98.6" lon="-90.2"><time>2022-05-20T12:00:01Z</time></trkpt>
+<trkpt lat="38.6" lon="-90.2"><time>yesterday</time></trkpt>
+</trkseg></trk><rte><rtept lat="38.7" lon="-90.3"></rtept></rte></gpx>`
+	var skipped []string
+	got, err := Read(strings.NewReader(doc), func(err error) error {
+		skipped = append(skipped, err.Error())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(got) != 2 || len(skipped) != 2 || !strings.HasPrefix(skipped[0], "point 2:") {
+		t.Errorf("Read() = %d records, skipped %q, want 2 and 2", len(got), skipped)
+	}
+	if len(got) > 0 && (got[0].SpeedMetersPerSecond() < 4.99 || got[0].SpeedMetersPerSecond() > 5.01) {
+		t.Errorf("GPX 1.0 speed = %v m/s, want 5", got[0].SpeedMetersPerSecond())
+	}
+	if _, err := Read(strings.NewReader(doc), nil); err == nil {
+		t.Error("Read() without bad skipped a bad point")
+	}
+	if _, err := Read(strings.NewReader("<gpx><trk>"), nil); err == nil {
+		t.Error("Read() of a truncated file didn't fail")
+	}
+}
@@ -0,0 +1,127 @@
+package gpx
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// readPoint is any wptType, matched by local name so GPX 1.0 files and any
+// speed or course extension are read too
+type readPoint struct {
+	Lat         string `xml:"lat,attr"`
+	Lon         string `xml:"lon,attr"`
+	Ele         string `xml:"ele"`
+	Time        string `xml:"time"`
+	MagVar      string `xml:"magvar"`
+	GeoidHeight string `xml:"geoidheight"`
+	Sat         string `xml:"sat"`
+	HDOP        string `xml:"hdop"`
+	Speed       string `xml:"speed"` // GPX 1.0
+	Course      string `xml:"course"`
+	ExtSpeed    string `xml:"extensions>TrackPointExtension>speed"`
+	ExtCourse   string `xml:"extensions>TrackPointExtension>course"`
+}
+
+// record converts a point, only the position is required
+func (p readPoint) record() (gps.GPSRecord, error) {
+	var gr gps.GPSRecord
+	var err error
+	if gr.Lat, err = strconv.ParseFloat(strings.TrimSpace(p.Lat), 64); err != nil || gr.Lat < -90 || gr.Lat > 90 {
+		return gr, fmt.Errorf("bad lat '%s'", p.Lat)
+	}
+	if gr.Long, err = strconv.ParseFloat(strings.TrimSpace(p.Lon), 64); err != nil || gr.Long < -180 || gr.Long > 180 {
+		return gr, fmt.Errorf("bad lon '%s'", p.Lon)
+	}
+	if p.Time != "" {
+		t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(p.Time))
+		if err != nil {
+			return gr, fmt.Errorf("bad time '%s'", p.Time)
+		}
+		gr.GPSTime = t.UTC()
+		gr.UnixMicro = uint64(t.UnixMicro())
+	}
+	floats := []struct {
+		name, v string
+		dst     *float64
+		scale   float64
+	}{
+		{"ele", p.Ele, &gr.Alt, 3.28084}, // feet like the logger
+		{"geoidheight", p.GeoidHeight, &gr.GeoidSep, 3.28084},
+		{"hdop", p.HDOP, &gr.HDOP, 1},
+		{"magvar", p.MagVar, &gr.Declination, 1},
+		{"speed", p.Speed, &gr.Speed, 3.6 / 1.852}, // m/s to Speed's units
+		{"speed", p.ExtSpeed, &gr.Speed, 3.6 / 1.852},
+		{"course", p.Course, &gr.Heading, 1},
+		{"course", p.ExtCourse, &gr.Heading, 1},
+	}
+	for _, f := range floats {
+		if f.v == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(f.v), 64)
+		if err != nil {
+			return gr, fmt.Errorf("bad %s '%s'", f.name, f.v)
+		}
+		*f.dst = v * f.scale
+	}
+	if gr.Declination > 180 {
+		gr.Declination -= 360 // written 0 to 360, west at the top
+	}
+	if p.Course != "" || p.ExtCourse != "" {
+		gr.HeadingSource = gps.HeadingTrack
+	}
+	if gr.GeoidSep != 0 {
+		gr.AltEllipsoid = gr.Alt + gr.GeoidSep
+	}
+	if p.Sat != "" {
+		if gr.NumSats, err = strconv.ParseInt(strings.TrimSpace(p.Sat), 10, 64); err != nil {
+			return gr, fmt.Errorf("bad sat '%s'", p.Sat)
+		}
+	}
+	return gr, nil
+}
+
+// Read reads every track and route point in order, calling bad with each
+// point that can't be used. It stops with bad's error if it returns one, a
+// nil bad stops at the first
+func Read(r io.Reader, bad func(error) error) ([]gps.GPSRecord, error) {
+	dec := xml.NewDecoder(r)
+	records := make([]gps.GPSRecord, 0)
+	n := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, fmt.Errorf("not a GPX file: %w", err)
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || (se.Name.Local != "trkpt" && se.Name.Local != "rtept") {
+			continue
+		}
+		var p readPoint
+		if err := dec.DecodeElement(&p, &se); err != nil {
+			return records, fmt.Errorf("not a GPX file: %w", err)
+		}
+		n++
+		gr, err := p.record()
+		if err != nil {
+			err = fmt.Errorf("point %d: %w", n, err)
+			if bad == nil {
+				return records, err
+			}
+			if err := bad(err); err != nil {
+				return records, err
+			}
+			continue
+		}
+		records = append(records, gr)
+	}
+}
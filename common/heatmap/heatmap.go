@@ -0,0 +1,247 @@
+package heatmap
+
+// where the car goes: every track rasterized into a grid of Web Mercator
+//  pixels at one zoom, each pixel counting the times a track passed through
+//	it. The grid renders to a PNG colored by how often, with a world file to
+//	place it in GIS tools and a KML GroundOverlay for Google Earth, no map
+//	tiles needed
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/kml"
+)
+
+// the defaults for a Grid, chosen for a car logging a few times a second
+const (
+	// StopSpeed in m/s, a car going slower isn't driving and its jitter
+	// isn't drawn
+	StopSpeed = 0.6
+	// MaxGap between records, a longer one isn't joined up
+	MaxGap = 30 * time.Second
+)
+
+// Grid counts passes through each pixel. It's a pipeline sink, each Close
+// ends a track and the grid takes more after it
+type Grid struct {
+	Zoom   int
+	counts map[uint64]uint32
+	last   gps.GPSRecord
+	lastX  int
+	lastY  int
+	inside bool // last is part of a track still going
+}
+
+// NewGrid returns an empty grid at zoom
+func NewGrid(zoom int) (*Grid, error) {
+	if zoom < 0 || zoom > MaxZoom {
+		return nil, fmt.Errorf("zoom %d is outside 0 to %d", zoom, MaxZoom)
+	}
+	return &Grid{Zoom: zoom, counts: map[uint64]uint32{}}, nil
+}
+
+func key(x, y int) uint64 {
+	return uint64(y)<<32 | uint64(uint32(x))
+}
+
+func (g *Grid) add(x, y int) {
+	g.counts[key(x, y)]++
+}
+
+// Write draws the track from the last record to gr. The pixel a segment
+// starts in was counted by the segment before, so a track lingering in a
+// pixel counts it once
+func (g *Grid) Write(gr gps.GPSRecord) error {
+	fx, fy := Project(gr.Lat, gr.Long, g.Zoom)
+	x, y := int(fx), int(fy)
+	joined := g.inside && gr.Time().Sub(g.last.Time()) <= MaxGap &&
+		math.Abs(float64(x-g.lastX)) < WorldSize(g.Zoom)/2 // not across the antimeridian
+	switch {
+	case !joined:
+		g.add(x, y)
+	case gr.SpeedMetersPerSecond() < StopSpeed:
+		// stopped, the jitter isn't drawn but the track goes on
+		g.last = gr
+		return nil
+	default:
+		g.line(g.lastX, g.lastY, x, y)
+	}
+	g.last, g.lastX, g.lastY, g.inside = gr, x, y, true
+	return nil
+}
+
+// line counts the pixels from x0, y0 to x1, y1, leaving out the first
+func (g *Grid) line(x0, y0, x1, y1 int) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := sign(x1-x0), sign(y1-y0)
+	e := dx + dy
+	for x0 != x1 || y0 != y1 {
+		if e2 := 2 * e; e2 >= dy {
+			e += dy
+			x0 += sx
+		} else {
+			e += dx
+			y0 += sy
+		}
+		g.add(x0, y0)
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func sign(v int) int {
+	switch {
+	case v < 0:
+		return -1
+	case v > 0:
+		return 1
+	}
+	return 0
+}
+
+// Close ends the track
+func (g *Grid) Close() error {
+	g.inside = false
+	return nil
+}
+
+// Pixels is how many pixels have been passed through
+func (g *Grid) Pixels() int {
+	return len(g.counts)
+}
+
+// Bounds is the global pixels covered, empty if nothing was drawn
+func (g *Grid) Bounds() image.Rectangle {
+	var r image.Rectangle
+	for k := range g.counts {
+		x, y := int(uint32(k)), int(k>>32)
+		r = r.Union(image.Rect(x, y, x+1, y+1))
+	}
+	return r
+}
+
+// DefaultGradient runs from a faint blue for roads driven once to white hot
+// for the daily commute
+var DefaultGradient = kml.Gradient{
+	{0x30, 0x60, 0xff, 0x90}, {0x00, 0xd0, 0xff, 0xd0}, {0xff, 0xe0, 0x00, 0xff},
+	{0xff, 0x30, 0x00, 0xff}, {0xff, 0xff, 0xff, 0xff},
+}
+
+// Options says how to render a grid
+type Options struct {
+	Gradient kml.Gradient
+	// Radius widens each pixel to a square Radius pixels out, so roads show
+	// at low zooms
+	Radius int
+	// MaxSize caps the image's width and height
+	MaxSize int
+}
+
+// DefaultOptions renders with DefaultGradient up to 8192 pixels across
+func DefaultOptions() Options {
+	return Options{Gradient: DefaultGradient, Radius: 1, MaxSize: 8192}
+}
+
+// Render colors the grid, counts on a log scale so roads driven once still
+// show next to the commute. Pixels never passed through are transparent.
+// It returns the image and the global pixels it covers
+func (g *Grid) Render(opts Options) (*image.NRGBA, image.Rectangle, error) {
+	if len(opts.Gradient) == 0 {
+		opts.Gradient = DefaultGradient
+	}
+	bounds := g.Bounds()
+	if bounds.Empty() {
+		return nil, bounds, fmt.Errorf("nothing to draw")
+	}
+	bounds = bounds.Inset(-opts.Radius)
+	if opts.MaxSize > 0 && (bounds.Dx() > opts.MaxSize || bounds.Dy() > opts.MaxSize) {
+		return nil, bounds, fmt.Errorf("%dx%d pixels is over %d, try a lower zoom", bounds.Dx(), bounds.Dy(), opts.MaxSize)
+	}
+
+	// widen by taking the highest count within the radius
+	counts := g.counts
+	if opts.Radius > 0 {
+		counts = make(map[uint64]uint32, len(g.counts)*(2*opts.Radius+1))
+		for k, c := range g.counts {
+			x, y := int(uint32(k)), int(k>>32)
+			for dy := -opts.Radius; dy <= opts.Radius; dy++ {
+				for dx := -opts.Radius; dx <= opts.Radius; dx++ {
+					if kk := key(x+dx, y+dy); counts[kk] < c {
+						counts[kk] = c
+					}
+				}
+			}
+		}
+	}
+	var max uint32
+	for _, c := range counts {
+		if c > max {
+			max = c
+		}
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for k, c := range counts {
+		x, y := int(uint32(k))-bounds.Min.X, int(k>>32)-bounds.Min.Y
+		t := 0.0
+		if max > 1 {
+			t = math.Log(float64(c)) / math.Log(float64(max))
+		}
+		img.SetNRGBA(x, y, opts.Gradient.At(t))
+	}
+	return img, bounds, nil
+}
+
+// WorldFile is the six line world file placing an image of global pixels
+// bounds in EPSG:3857 meters
+func WorldFile(bounds image.Rectangle, zoom int) string {
+	res := MetersPerPixel(zoom)
+	// the world file gives the middle of the top left pixel
+	east, north := mercatorMeters(float64(bounds.Min.X)+0.5, float64(bounds.Min.Y)+0.5, zoom)
+	return fmt.Sprintf("%.6f\n0\n0\n%.6f\n%.6f\n%.6f\n", res, -res, east, north)
+}
+
+// LatLonBox is the edges of global pixels bounds
+func LatLonBox(bounds image.Rectangle, zoom int) kml.LatLonBox {
+	north, west := Unproject(float64(bounds.Min.X), float64(bounds.Min.Y), zoom)
+	south, east := Unproject(float64(bounds.Max.X), float64(bounds.Max.Y), zoom)
+	return kml.LatLonBox{North: north, South: south, East: east, West: west}
+}
+
+// Equirectangular resamples a Web Mercator image of global pixels bounds so
+// its rows are evenly spaced in latitude, the way a GroundOverlay is drawn
+func Equirectangular(img *image.NRGBA, bounds image.Rectangle, zoom int) *image.NRGBA {
+	box := LatLonBox(bounds, zoom)
+	out := image.NewNRGBA(img.Rect)
+	h := img.Rect.Dy()
+	for row := 0; row < h; row++ {
+		lat := box.North - (float64(row)+0.5)/float64(h)*(box.North-box.South)
+		_, y := Project(lat, box.West, zoom)
+		src := int(y) - bounds.Min.Y
+		if src < 0 || src >= h {
+			continue
+		}
+		copy(out.Pix[row*out.Stride:(row+1)*out.Stride], img.Pix[src*img.Stride:(src+1)*img.Stride])
+	}
+	return out
+}
+
+// Overlay is a KML document showing the image at href, drawn by
+// Equirectangular from global pixels bounds
+func Overlay(name, href string, bounds image.Rectangle, zoom int) *kml.Document {
+	box := LatLonBox(bounds, zoom)
+	return &kml.Document{
+		Name: name,
+		GroundOverlays: []kml.GroundOverlay{{
+			Name: name, Icon: &kml.Icon{Href: href}, LatLonBox: &box,
+		}},
+	}
+}
@@ -0,0 +1,180 @@
+package heatmap
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+func TestProject(t *testing.T) {
+	tests := []struct {
+		lat, long float64
+		zoom      int
+		x, y      float64
+	}{
+		{0, 0, 0, 128, 128},
+		{0, -180, 1, 0, 256},
+		{MaxLat, 180, 2, 1024, 0},
+		{-MaxLat, 0, 2, 512, 1024},
+		{89.9, 0, 0, 128, 0}, // clamped
+	}
+	for _, tt := range tests {
+		x, y := Project(tt.lat, tt.long, tt.zoom)
+		if math.Abs(x-tt.x) > 1e-6 || math.Abs(y-tt.y) > 1e-6 {
+			t.Errorf("Project(%g, %g, %d) = %g, %g, want %g, %g", tt.lat, tt.long, tt.zoom, x, y, tt.x, tt.y)
+		}
+	}
+	x, y := Project(38.627003, -90.199404, 15)
+	lat, long := Unproject(x, y, 15)
+	if math.Abs(lat-38.627003) > 1e-9 || math.Abs(long+90.199404) > 1e-9 {
+		t.Errorf("Unproject(Project) = %g, %g", lat, long)
+	}
+}
+
+var start = time.Date(2022, 5, 20, 12, 0, 0, 0, time.UTC)
+
+// drive east along a parallel from long0 to long1 in steps at 10 m/s, a
+// second apart from at
+func drive(g *Grid, at time.Duration, lat, long0, long1 float64, steps int) {
+	for i := 0; i <= steps; i++ {
+		g.Write(gps.GPSRecord{
+			GPSTime: start.Add(at + time.Duration(i)*time.Second),
+			Lat:     lat, Long: long0 + (long1-long0)*float64(i)/float64(steps),
+			Speed: 10 * 3.6 / 1.852,
+		})
+	}
+	g.Close()
+}
+
+func TestGrid(t *testing.T) {
+	const zoom = 16
+	g, _ := NewGrid(zoom)
+	x0, y := Project(38.6, -90.2, zoom)
+	x1, _ := Project(38.6, -90.19, zoom)
+	width := int(x1) - int(x0) + 1
+
+	// the same road twice, with many records per pixel the second time
+	drive(g, 0, 38.6, -90.2, -90.19, 10)
+	drive(g, time.Hour, 38.6, -90.2, -90.19, 1000)
+	if g.Pixels() != width {
+		t.Errorf("Pixels = %d, want %d", g.Pixels(), width)
+	}
+	for px := int(x0); px <= int(x1); px++ {
+		if c := g.counts[key(px, int(y))]; c != 2 {
+			t.Fatalf("pixel %d along counted %d, want 2", px-int(x0), c)
+		}
+	}
+
+	// parked, jittering around a pixel for a while
+	before := g.Pixels()
+	for i := 0; i < 100; i++ {
+		g.Write(gps.GPSRecord{
+			GPSTime: start.Add(2*time.Hour + time.Duration(i)*time.Second),
+			Lat:     38.65 + 0.0001*math.Sin(float64(i)), Long: -90.25 + 0.0001*math.Cos(float64(i)),
+		})
+	}
+	g.Close()
+	if got := g.Pixels() - before; got != 1 {
+		t.Errorf("parking drew %d pixels, want 1", got)
+	}
+
+	// a gap isn't joined up
+	before = g.Pixels()
+	for i, long := range []float64{-90.3, -90.29} {
+		g.Write(gps.GPSRecord{
+			GPSTime: start.Add(3*time.Hour + time.Duration(i)*time.Minute),
+			Lat:     38.7, Long: long, Speed: 20,
+		})
+	}
+	if got := g.Pixels() - before; got != 2 {
+		t.Errorf("a gap drew %d pixels, want 2", got)
+	}
+
+	west, north := Project(38.7, -90.3, zoom)
+	east, south := Project(38.6, -90.19, zoom)
+	want := image.Rect(int(west), int(north), int(east)+1, int(south)+1)
+	if b := g.Bounds(); b != want {
+		t.Errorf("Bounds = %v, want %v", b, want)
+	}
+}
+
+func TestRender(t *testing.T) {
+	const zoom = 16
+	g, _ := NewGrid(zoom)
+	drive(g, 0, 38.6, -90.2, -90.19, 10)
+	for i := 0; i < 9; i++ {
+		drive(g, time.Duration(i+1)*time.Hour, 38.6, -90.2, -90.195, 10)
+	}
+	if _, _, err := (&Grid{Zoom: zoom, counts: map[uint64]uint32{}}).Render(DefaultOptions()); err == nil {
+		t.Error("rendering an empty grid didn't fail")
+	}
+	opts := DefaultOptions()
+	opts.Radius = 0
+	img, bounds, err := g.Render(opts)
+	if err != nil {
+		t.Fatalf("Render: %s", err.Error())
+	}
+	if bounds != g.Bounds() || img.Rect != image.Rect(0, 0, bounds.Dx(), bounds.Dy()) {
+		t.Fatalf("image %v for bounds %v", img.Rect, bounds)
+	}
+	// driven ten times at the west end and once at the east
+	y := bounds.Dy() - 1
+	if got := img.NRGBAAt(0, y); got != DefaultGradient[len(DefaultGradient)-1] {
+		t.Errorf("busiest pixel is %v", got)
+	}
+	if got := img.NRGBAAt(bounds.Dx()-1, y); got != DefaultGradient[0] {
+		t.Errorf("quietest pixel is %v", got)
+	}
+
+	opts.Radius = 2
+	wide, wideBounds, _ := g.Render(opts)
+	if wideBounds != bounds.Inset(-2) || wide.NRGBAAt(0, 0) != DefaultGradient[len(DefaultGradient)-1] || wide.NRGBAAt(wide.Rect.Dx()-1, 4) != DefaultGradient[0] {
+		t.Errorf("Radius 2 gave bounds %v", wideBounds)
+	}
+	opts.MaxSize = 10
+	if _, _, err := g.Render(opts); err == nil || !strings.Contains(err.Error(), "lower zoom") {
+		t.Errorf("over MaxSize got %v", err)
+	}
+
+	eq := Equirectangular(img, bounds, zoom)
+	if eq.Rect != img.Rect {
+		t.Errorf("Equirectangular is %v, want %v", eq.Rect, img.Rect)
+	}
+}
+
+func TestGeoreference(t *testing.T) {
+	const zoom = 12
+	bounds := image.Rect(1024, 1536, 1536, 1792)
+	box := LatLonBox(bounds, zoom)
+	north, west := Unproject(1024, 1536, zoom)
+	if box.North != north || box.West != west || box.South >= box.North || box.East <= box.West {
+		t.Errorf("LatLonBox = %+v", box)
+	}
+
+	lines := strings.Fields(WorldFile(bounds, zoom))
+	res := MetersPerPixel(zoom)
+	if len(lines) != 6 {
+		t.Fatalf("world file %v", lines)
+	}
+	if math.Abs(res-38.218514) > 1e-6 {
+		t.Errorf("MetersPerPixel(12) = %f, want 38.218514", res)
+	}
+	// the middle of the top left pixel, EPSG:3857's origin is the middle of
+	// the world
+	half := math.Pi * earthRadius
+	wantEast := 1024.5*res - half
+	wantNorth := half - 1536.5*res
+	if lines[4] != fmt.Sprintf("%.6f", wantEast) || lines[5] != fmt.Sprintf("%.6f", wantNorth) {
+		t.Errorf("world file origin %s, %s, want %.6f, %.6f", lines[4], lines[5], wantEast, wantNorth)
+	}
+
+	doc := Overlay("Heat", "heat-kml.png", bounds, zoom)
+	if len(doc.GroundOverlays) != 1 || doc.GroundOverlays[0].Icon.Href != "heat-kml.png" || *doc.GroundOverlays[0].LatLonBox != box {
+		t.Errorf("Overlay = %+v", doc)
+	}
+}
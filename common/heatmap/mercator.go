@@ -0,0 +1,51 @@
+package heatmap
+
+import "math"
+
+// TileSize is the pixels across a zoom 0 world, the same as web map tiles
+const TileSize = 256
+
+// MaxLat is as far north or south as Web Mercator goes
+const MaxLat = 85.05112878
+
+// MaxZoom keeps global pixel coordinates in 32 bits
+const MaxZoom = 22
+
+const earthRadius = 6378137.0 // meters, WGS84's semi-major axis as EPSG:3857 uses
+
+// WorldSize is the pixels across the world at zoom
+func WorldSize(zoom int) float64 {
+	return TileSize * math.Exp2(float64(zoom))
+}
+
+// Project is a position's global pixel at zoom, x east from the antimeridian
+// and y south from MaxLat
+func Project(lat, long float64, zoom int) (x, y float64) {
+	lat = math.Max(-MaxLat, math.Min(MaxLat, lat))
+	size := WorldSize(zoom)
+	sin := math.Sin(lat * math.Pi / 180)
+	x = (long + 180) / 360 * size
+	y = (0.5 - math.Log((1+sin)/(1-sin))/(4*math.Pi)) * size
+	return x, y
+}
+
+// Unproject is the position at a global pixel at zoom
+func Unproject(x, y float64, zoom int) (lat, long float64) {
+	size := WorldSize(zoom)
+	long = x/size*360 - 180
+	lat = 180 / math.Pi * math.Atan(math.Sinh(math.Pi*(1-2*y/size)))
+	return lat, long
+}
+
+// MetersPerPixel is a pixel's size in EPSG:3857 meters at zoom, on the
+// ground it's that times the cosine of the latitude
+func MetersPerPixel(zoom int) float64 {
+	return 2 * math.Pi * earthRadius / WorldSize(zoom)
+}
+
+// mercatorMeters is a global pixel's EPSG:3857 easting and northing
+func mercatorMeters(x, y float64, zoom int) (east, north float64) {
+	res := MetersPerPixel(zoom)
+	half := math.Pi * earthRadius
+	return x*res - half, half - y*res
+}
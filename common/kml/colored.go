@@ -0,0 +1,278 @@
+package kml
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/samiam2013/raspigogps/common/chart"
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// Metric is the record field a track is colored by
+type Metric int
+
+const (
+	Speed    Metric = iota // in the Coloring's Speed unit
+	Altitude               // above sea level, in the Coloring's Altitude unit
+	Sats                   // satellites in the fix
+)
+
+var metricNames = map[Metric]string{
+	Speed:    "speed",
+	Altitude: "altitude",
+	Sats:     "sats",
+}
+
+// Metrics lists every metric, in the order used for help text
+var Metrics = []Metric{Speed, Altitude, Sats}
+
+func (m Metric) String() string {
+	if name, ok := metricNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("Metric(%d)", int(m))
+}
+
+// ParseMetric looks up a metric by the name used in command line flags
+func ParseMetric(name string) (Metric, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for m, n := range metricNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	switch name {
+	case "alt", "elevation":
+		return Altitude, nil
+	case "satellites":
+		return Sats, nil
+	}
+	names := make([]string, len(Metrics))
+	for i, m := range Metrics {
+		names[i] = m.String()
+	}
+	return Speed, fmt.Errorf("unknown metric '%s' (want one of %s)", name, strings.Join(names, ", "))
+}
+
+// Gradient is colors evenly spaced from the low end to the high end
+type Gradient []color.NRGBA
+
+// the named gradients ParseGradient knows
+var Gradients = map[string]Gradient{
+	"rainbow": {{0, 0, 255, 255}, {0, 255, 255, 255}, {0, 255, 0, 255}, {255, 255, 0, 255}, {255, 0, 0, 255}},
+	"traffic": {{255, 0, 0, 255}, {255, 255, 0, 255}, {0, 255, 0, 255}},
+	"heat":    {{0, 0, 0, 255}, {255, 0, 0, 255}, {255, 255, 0, 255}, {255, 255, 255, 255}},
+}
+
+// ParseGradient takes a named gradient or colors separated by commas, each
+// #rrggbb or aabbggrr like ParseColor
+func ParseGradient(s string) (Gradient, error) {
+	if g, ok := Gradients[strings.ToLower(s)]; ok {
+		return g, nil
+	}
+	var g Gradient
+	for _, part := range strings.Split(s, ",") {
+		c, err := ParseColor(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		v, _ := strconv.ParseUint(c, 16, 32)
+		g = append(g, color.NRGBA{R: uint8(v), G: uint8(v >> 8), B: uint8(v >> 16), A: uint8(v >> 24)})
+	}
+	if len(g) < 2 {
+		return nil, fmt.Errorf("gradient '%s' needs at least two colors", s)
+	}
+	return g, nil
+}
+
+// At is the color a fraction t of the way along, clamped to 0 to 1
+func (g Gradient) At(t float64) color.NRGBA {
+	if len(g) == 1 || t <= 0 {
+		return g[0]
+	}
+	if t >= 1 {
+		return g[len(g)-1]
+	}
+	pos := t * float64(len(g)-1)
+	i := int(pos)
+	f := pos - float64(i)
+	a, b := g[i], g[i+1]
+	mix := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + (float64(y)-float64(x))*f))
+	}
+	return color.NRGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: mix(a.A, b.A)}
+}
+
+// colorString is a color as KML's aabbggrr
+func colorString(c color.NRGBA) string {
+	return fmt.Sprintf("%02x%02x%02x%02x", c.A, c.B, c.G, c.R)
+}
+
+// Coloring is how a track is broken up and colored
+type Coloring struct {
+	Metric Metric
+	// Speed and Altitude are the units those metrics are shown in
+	Speed, Altitude chart.Unit
+	Gradient        Gradient
+	Steps           int // how many colors the range is split into
+	// Min and Max are the ends of the gradient, when they're equal the
+	// records' own range is used
+	Min, Max float64
+}
+
+// DefaultColoring colors by speed in mph in 10 steps of the rainbow
+func DefaultColoring() Coloring {
+	return Coloring{Metric: Speed, Speed: chart.MPH, Altitude: chart.Feet, Gradient: Gradients["rainbow"], Steps: 10}
+}
+
+// unit is the metric's unit, mph and feet when Speed and Altitude are unset
+func (c Coloring) unit() chart.Unit {
+	switch c.Metric {
+	case Speed:
+		if c.Speed.Per == 0 {
+			return chart.MPH
+		}
+		return c.Speed
+	case Altitude:
+		if c.Altitude.Per == 0 {
+			return chart.Feet
+		}
+		return c.Altitude
+	}
+	return chart.Unit{Name: "sats", Per: 1}
+}
+
+// Unit is what the metric's values are in
+func (c Coloring) Unit() string {
+	return c.unit().Name
+}
+
+// Value is the metric for a record
+func (c Coloring) Value(gr gps.GPSRecord) float64 {
+	switch c.Metric {
+	case Altitude:
+		return altitude(gr) * c.unit().Per
+	case Sats:
+		return float64(gr.NumSats)
+	}
+	return gr.SpeedMetersPerSecond() * c.unit().Per
+}
+
+// WithRange fills in Min and Max from the records if they weren't set
+func (c Coloring) WithRange(records []gps.GPSRecord) Coloring {
+	var r valueRange
+	for _, gr := range records {
+		r.add(c.Value(gr))
+	}
+	return c.withRange(r)
+}
+
+// valueRange is the lowest and highest of the values added
+type valueRange struct {
+	min, max float64
+	n        int
+}
+
+func (r *valueRange) add(v float64) {
+	if r.n == 0 || v < r.min {
+		r.min = v
+	}
+	if r.n == 0 || v > r.max {
+		r.max = v
+	}
+	r.n++
+}
+
+func (c Coloring) withRange(r valueRange) Coloring {
+	if c.Min != c.Max || r.n == 0 {
+		return c
+	}
+	c.Min, c.Max = r.min, r.max
+	if c.Max == c.Min {
+		c.Max = c.Min + 1
+	}
+	return c
+}
+
+// Step is which of the Steps colors a value falls in
+func (c Coloring) Step(v float64) int {
+	if c.Max <= c.Min {
+		return 0
+	}
+	i := int(math.Floor((v - c.Min) / (c.Max - c.Min) * float64(c.Steps)))
+	if i < 0 {
+		return 0
+	}
+	if i >= c.Steps {
+		return c.Steps - 1
+	}
+	return i
+}
+
+// StepColor is the color of a step, the first and last are the gradient's ends
+func (c Coloring) StepColor(i int) color.NRGBA {
+	if c.Steps <= 1 {
+		return c.Gradient.At(0)
+	}
+	return c.Gradient.At(float64(i) / float64(c.Steps-1))
+}
+
+// StepRange is the lowest and highest values of a step
+func (c Coloring) StepRange(i int) (float64, float64) {
+	width := (c.Max - c.Min) / float64(c.Steps)
+	return c.Min + float64(i)*width, c.Min + float64(i+1)*width
+}
+
+// formatValue keeps a decimal for ranges too small to tell apart without one
+func (c Coloring) formatValue(v float64) string {
+	if c.Max-c.Min < 10 {
+		return formatFloat(v, 1)
+	}
+	return formatFloat(v, 0)
+}
+
+// LegendFile is the legend image's name in a KMZ
+const LegendFile = "legend.png"
+
+// title names the colored track's folder and legend
+func (c Coloring) title(opts Options) string {
+	return fmt.Sprintf("%s by %s (%s)", opts.Name, c.Metric, c.Unit())
+}
+
+// stepStyle is the id of a step's style
+func stepStyle(i int) string {
+	return "step" + strconv.Itoa(i)
+}
+
+// styles is a line style per step
+func (c Coloring) styles(width float64) []Style {
+	styles := make([]Style, c.Steps)
+	for i := range styles {
+		styles[i] = Style{
+			ID:        stepStyle(i),
+			LineStyle: &LineStyle{Color: colorString(c.StepColor(i)), Width: width},
+		}
+	}
+	return styles
+}
+
+// legendOverlay shows LegendFile in the top left of the screen
+func (c Coloring) legendOverlay(opts Options) ScreenOverlay {
+	return ScreenOverlay{
+		Name: c.title(opts),
+		Icon: &Icon{Href: LegendFile},
+		// the legend's top left corner in the top left of the screen
+		OverlayXY: &Vec2{X: 0, Y: 1, XUnits: "fraction", YUnits: "fraction"},
+		ScreenXY:  &Vec2{X: 10, Y: 1, XUnits: "pixels", YUnits: "fraction"},
+		Size:      &Vec2{X: -1, Y: -1, XUnits: "pixels", YUnits: "pixels"},
+	}
+}
+
+// runName is what a run of a step is called, its range of values
+func (c Coloring) runName(step int) string {
+	lo, hi := c.StepRange(step)
+	return c.formatValue(lo) + " to " + c.formatValue(hi) + " " + c.Unit()
+}
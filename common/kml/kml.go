@@ -0,0 +1,333 @@
+package kml
+
+// KML 2.2 documents for Google Earth and friends: the track as a LineString
+//  for anything that can draw a line, and as a gx:Track with a timestamp per
+//	point for the ones that can play it back, plus start and end placemarks.
+//	Elements are written in the order the OGC schema's sequences require
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+)
+
+// the KML and Google extension namespaces
+const (
+	Namespace   = "http://www.opengis.net/kml/2.2"
+	GxNamespace = "http://www.google.com/kml/ext/2.2"
+)
+
+// the altitudeMode values KML 2.2 allows
+const (
+	ClampToGround    = "clampToGround"
+	RelativeToGround = "relativeToGround"
+	Absolute         = "absolute"
+)
+
+// Options controls what's written and how it's styled
+type Options struct {
+	Name         string
+	AltitudeMode string // one of the altitude mode constants
+	LineString   bool   // write the track as a LineString
+	Track        bool   // write the track as a gx:Track with timestamps
+	Endpoints    bool   // start and end placemarks
+	LineColor    string // aabbggrr, as KML wants it
+	LineWidth    float64
+	StartIcon    string
+	EndIcon      string
+	IconScale    float64
+	// Waypoints go in a folder of numbered placemarks
+	Waypoints    []waypoint.Waypoint
+	WaypointIcon string
+}
+
+// DefaultOptions writes everything in a red line clamped to the ground
+func DefaultOptions() Options {
+	return Options{
+		Name:         "Track",
+		AltitudeMode: ClampToGround,
+		LineString:   true,
+		Track:        true,
+		Endpoints:    true,
+		LineColor:    "ff0000ff",
+		LineWidth:    4,
+		StartIcon:    "http://maps.google.com/mapfiles/kml/paddle/grn-circle.png",
+		EndIcon:      "http://maps.google.com/mapfiles/kml/paddle/red-square.png",
+		IconScale:    1,
+		WaypointIcon: "http://maps.google.com/mapfiles/kml/paddle/blu-blank.png",
+	}
+}
+
+// ParseColor takes aabbggrr or the more usual #rrggbb (made opaque) and
+// returns KML's aabbggrr
+func ParseColor(s string) (string, error) {
+	s = strings.ToLower(strings.TrimPrefix(s, "#"))
+	if _, err := strconv.ParseUint(s, 16, 32); err != nil {
+		return "", fmt.Errorf("color '%s' isn't hex", s)
+	}
+	switch len(s) {
+	case 8:
+		return s, nil
+	case 6:
+		return "ff" + s[4:6] + s[2:4] + s[0:2], nil
+	}
+	return "", fmt.Errorf("color '%s' should be rrggbb or aabbggrr", s)
+}
+
+// ParseAltitudeMode accepts the KML names and the short clamp, relative and
+// absolute
+func ParseAltitudeMode(s string) (string, error) {
+	switch strings.ToLower(s) {
+	case "clamp", strings.ToLower(ClampToGround):
+		return ClampToGround, nil
+	case "relative", strings.ToLower(RelativeToGround):
+		return RelativeToGround, nil
+	case "absolute":
+		return Absolute, nil
+	}
+	return "", fmt.Errorf("unknown altitude mode %s, want clamp, relative or absolute", s)
+}
+
+type kmlRoot struct {
+	XMLName  xml.Name  `xml:"kml"`
+	Xmlns    string    `xml:"xmlns,attr"`
+	XmlnsGx  string    `xml:"xmlns:gx,attr"`
+	Document *Document `xml:"Document"`
+}
+
+// Document is the one Document in a KML file
+type Document struct {
+	Name           string          `xml:"name,omitempty"`
+	Styles         []Style         `xml:"Style"`
+	Placemarks     []Placemark     `xml:"Placemark"`
+	Folders        []Folder        `xml:"Folder"`
+	ScreenOverlays []ScreenOverlay `xml:"ScreenOverlay"`
+	GroundOverlays []GroundOverlay `xml:"GroundOverlay"`
+}
+
+// Folder groups placemarks so they can be shown and hidden together
+type Folder struct {
+	Name       string      `xml:"name,omitempty"`
+	Open       int         `xml:"open,omitempty"`
+	Placemarks []Placemark `xml:"Placemark"`
+}
+
+// ScreenOverlay is an image fixed to the screen, like a legend
+type ScreenOverlay struct {
+	Name      string `xml:"name,omitempty"`
+	Icon      *Icon  `xml:"Icon"`
+	OverlayXY *Vec2  `xml:"overlayXY,omitempty"`
+	ScreenXY  *Vec2  `xml:"screenXY,omitempty"`
+	Size      *Vec2  `xml:"size,omitempty"`
+}
+
+// GroundOverlay is an image draped over the ground, stretched evenly in
+// latitude and longitude between LatLonBox's edges
+type GroundOverlay struct {
+	Name      string     `xml:"name,omitempty"`
+	Icon      *Icon      `xml:"Icon"`
+	LatLonBox *LatLonBox `xml:"LatLonBox"`
+}
+
+// LatLonBox is the edges of a GroundOverlay in degrees
+type LatLonBox struct {
+	North float64 `xml:"north"`
+	South float64 `xml:"south"`
+	East  float64 `xml:"east"`
+	West  float64 `xml:"west"`
+}
+
+// Vec2 is a point on the screen or image, fractions of it by default
+type Vec2 struct {
+	X      float64 `xml:"x,attr"`
+	Y      float64 `xml:"y,attr"`
+	XUnits string  `xml:"xunits,attr"`
+	YUnits string  `xml:"yunits,attr"`
+}
+
+// Style is a shared style, referred to by Placemark.StyleURL "#ID"
+type Style struct {
+	ID        string     `xml:"id,attr"`
+	IconStyle *IconStyle `xml:"IconStyle,omitempty"`
+	LineStyle *LineStyle `xml:"LineStyle,omitempty"`
+}
+
+// IconStyle is how a Point placemark is drawn
+type IconStyle struct {
+	Scale float64 `xml:"scale,omitempty"`
+	Icon  *Icon   `xml:"Icon,omitempty"`
+}
+
+// Icon is an image by URL or path inside a KMZ
+type Icon struct {
+	Href string `xml:"href"`
+}
+
+// LineStyle is how a line is drawn, Color is aabbggrr
+type LineStyle struct {
+	Color string  `xml:"color,omitempty"`
+	Width float64 `xml:"width,omitempty"`
+}
+
+// Placemark is a feature with one geometry
+type Placemark struct {
+	Name        string      `xml:"name,omitempty"`
+	Description string      `xml:"description,omitempty"`
+	TimeStamp   *TimeStamp  `xml:"TimeStamp,omitempty"`
+	StyleURL    string      `xml:"styleUrl,omitempty"`
+	Point       *Point      `xml:"Point,omitempty"`
+	LineString  *LineString `xml:"LineString,omitempty"`
+	Track       *Track      `xml:"gx:Track,omitempty"`
+}
+
+// TimeStamp is when a feature happened
+type TimeStamp struct {
+	When string `xml:"when"`
+}
+
+// Point is a single long,lat,alt coordinate
+type Point struct {
+	AltitudeMode string `xml:"altitudeMode,omitempty"`
+	Coordinates  string `xml:"coordinates"`
+}
+
+// LineString is a line through space separated long,lat,alt coordinates
+type LineString struct {
+	Tessellate   int    `xml:"tessellate,omitempty"`
+	AltitudeMode string `xml:"altitudeMode,omitempty"`
+	Coordinates  string `xml:"coordinates"`
+}
+
+// Track is a gx:Track, a when for every gx:coord
+type Track struct {
+	AltitudeMode string   `xml:"altitudeMode,omitempty"`
+	When         []string `xml:"when"`
+	Coords       []string `xml:"gx:coord"`
+}
+
+// altitude is KML's meters above sea level from the record's feet
+func altitude(gr gps.GPSRecord) float64 {
+	return gr.Alt / 3.28084
+}
+
+func formatFloat(v float64, prec int) string {
+	return strconv.FormatFloat(v, 'f', prec, 64)
+}
+
+// Coordinate is a record as a KML tuple, long,lat,alt
+func Coordinate(gr gps.GPSRecord) string {
+	return formatFloat(gr.Long, 7) + "," + formatFloat(gr.Lat, 7) + "," + formatFloat(altitude(gr), 1)
+}
+
+// When is a record's time as KML wants it
+func When(gr gps.GPSRecord) string {
+	return gr.Time().UTC().Format(time.RFC3339Nano)
+}
+
+// head is the document without the track: its name, the styles the track,
+// endpoints and waypoints use and the waypoint folder
+func head(opts Options) *Document {
+	doc := &Document{Name: opts.Name}
+	doc.Styles = append(doc.Styles, Style{
+		ID:        "track",
+		LineStyle: &LineStyle{Color: opts.LineColor, Width: opts.LineWidth},
+	})
+	if opts.Endpoints {
+		doc.Styles = append(doc.Styles,
+			Style{ID: "start", IconStyle: &IconStyle{Scale: opts.IconScale, Icon: &Icon{Href: opts.StartIcon}}},
+			Style{ID: "end", IconStyle: &IconStyle{Scale: opts.IconScale, Icon: &Icon{Href: opts.EndIcon}}},
+		)
+	}
+	addWaypoints(doc, opts)
+	return doc
+}
+
+// endpoints is the start and end placemarks
+func endpoints(first, last gps.GPSRecord, opts Options) []Placemark {
+	var pms []Placemark
+	for _, p := range []struct {
+		name string
+		gr   gps.GPSRecord
+	}{{"start", first}, {"end", last}} {
+		pms = append(pms, Placemark{
+			Name:      strings.ToUpper(p.name[:1]) + p.name[1:],
+			TimeStamp: &TimeStamp{When: When(p.gr)},
+			StyleURL:  "#" + p.name,
+			Point:     &Point{AltitudeMode: altitudeMode(opts.AltitudeMode), Coordinates: Coordinate(p.gr)},
+		})
+	}
+	return pms
+}
+
+// addWaypoints adds a folder with a placemark per waypoint, labeled with
+// its number like the button's blinks count it out
+func addWaypoints(doc *Document, opts Options) {
+	if len(opts.Waypoints) == 0 {
+		return
+	}
+	doc.Styles = append(doc.Styles, Style{
+		ID:        "waypoint",
+		IconStyle: &IconStyle{Scale: opts.IconScale, Icon: &Icon{Href: opts.WaypointIcon}},
+	})
+	folder := Folder{Name: "Waypoints"}
+	for _, w := range opts.Waypoints {
+		var desc []string
+		if w.Note != "" {
+			desc = append(desc, w.Note)
+		}
+		if w.Coords != "" {
+			desc = append(desc, w.Coords)
+		}
+		if w.Interpolated {
+			desc = append(desc, "position interpolated from the track")
+		}
+		folder.Placemarks = append(folder.Placemarks, Placemark{
+			Name:        strconv.Itoa(w.Number),
+			Description: strings.Join(desc, ", "),
+			TimeStamp:   &TimeStamp{When: w.Time.UTC().Format(time.RFC3339Nano)},
+			StyleURL:    "#waypoint",
+			Point:       &Point{Coordinates: formatFloat(w.Long, 7) + "," + formatFloat(w.Lat, 7)},
+		})
+	}
+	doc.Folders = append(doc.Folders, folder)
+}
+
+// altitudeMode leaves out clampToGround, it's the default and leaving it out
+// keeps files smaller
+func altitudeMode(mode string) string {
+	if mode == ClampToGround {
+		return ""
+	}
+	return mode
+}
+
+// Encode writes the document as a KML file
+func (d *Document) Encode(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", " ")
+	if err := enc.Encode(kmlRoot{Xmlns: Namespace, XmlnsGx: GxNamespace, Document: d}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Write writes a document for the records
+func Write(w io.Writer, records []gps.GPSRecord, opts Options) error {
+	kw := NewWriter(w, opts)
+	for _, gr := range records {
+		if err := kw.Write(gr); err != nil {
+			return err
+		}
+	}
+	return kw.Close()
+}
@@ -0,0 +1,382 @@
+package kml
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/chart"
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/gpstest"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+	"github.com/samiam2013/raspigogps/common/xmltest"
+)
+
+// prefixes names the Google extension elements the way the schema does
+var prefixes = map[string]string{GxNamespace: "gx"}
+
+// validate checks a document against the KML 2.2 schema with Google's
+// extensions (testdata/kml22gx.xsd, or KML_XSD) and for what a schema can't:
+// styles that exist, a when for every gx:coord and one geometry per placemark
+func validate(t *testing.T, data []byte) *xmltest.Node {
+	t.Helper()
+	t.Run("schema", func(t *testing.T) {
+		xmltest.Validate(t, data, "KML_XSD", "testdata/kml22gx.xsd")
+	})
+	root := xmltest.Parse(t, data, prefixes)
+	if root.Space != Namespace || root.Name != "kml" || len(root.Children) != 1 || root.Children[0].Name != "Document" {
+		t.Fatalf("root isn't a {%s}kml holding one Document", Namespace)
+	}
+	doc := root.Children[0]
+	if err := xmltest.CheckSequence(doc, []string{"name", "Style", "Placemark", "Folder", "ScreenOverlay"},
+		map[string]bool{"Style": true, "Placemark": true, "Folder": true, "ScreenOverlay": true}); err != nil {
+		t.Error(err)
+	}
+	styles := map[string]bool{}
+	for _, s := range doc.All("Style") {
+		styles[s.Attr("id")] = true
+	}
+	placemarks := doc.All("Placemark")
+	for _, f := range doc.All("Folder") {
+		placemarks = append(placemarks, f.All("Placemark")...)
+	}
+	for _, pm := range placemarks {
+		if err := checkPlacemark(pm, styles); err != nil {
+			t.Error(err)
+		}
+	}
+	return root
+}
+
+// checkPlacemark checks a placemark has one geometry, uses a style that
+// exists and has a time for every gx:Track coordinate
+func checkPlacemark(pm *xmltest.Node, styles map[string]bool) error {
+	if err := xmltest.CheckSequence(pm, []string{"name", "description", "TimeStamp", "styleUrl",
+		"Point", "LineString", "gx:Track"}, nil); err != nil {
+		return err
+	}
+	if su := pm.Child("styleUrl"); su != nil && !styles[strings.TrimPrefix(su.Text, "#")] {
+		return fmt.Errorf("styleUrl %s refers to no style", su.Text)
+	}
+	var geoms int
+	for _, g := range []string{"Point", "LineString", "gx:Track"} {
+		if pm.Child(g) != nil {
+			geoms++
+		}
+	}
+	if geoms != 1 {
+		return fmt.Errorf("placemark has %d geometries", geoms)
+	}
+	if ts := pm.Child("TimeStamp"); ts != nil {
+		if _, err := time.Parse(time.RFC3339Nano, ts.Child("when").Text); err != nil {
+			return err
+		}
+	}
+	if track := pm.Child("gx:Track"); track != nil {
+		whens, coords := track.All("when"), track.All("gx:coord")
+		if len(whens) != len(coords) || len(whens) == 0 {
+			return fmt.Errorf("gx:Track has %d whens and %d coords", len(whens), len(coords))
+		}
+		for _, w := range whens {
+			if _, err := time.Parse(time.RFC3339Nano, w.Text); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestWrite(t *testing.T) {
+	absolute := DefaultOptions()
+	absolute.AltitudeMode = Absolute
+	lineOnly := DefaultOptions()
+	lineOnly.Track, lineOnly.Endpoints = false, false
+	trackOnly := DefaultOptions()
+	trackOnly.LineString = false
+	tests := []struct {
+		name       string
+		opts       Options
+		records    []gps.GPSRecord
+		placemarks int
+	}{
+		{"defaults", DefaultOptions(), gpstest.Records(5, 0), 4},
+		{"absolute", absolute, gpstest.Records(5, 0), 4},
+		{"line only", lineOnly, gpstest.Records(5, 0), 1},
+		{"track only", trackOnly, gpstest.Records(5, 0), 3},
+		{"no records", DefaultOptions(), nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, tt.records, tt.opts); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			root := validate(t, buf.Bytes())
+			if got := len(root.Children[0].All("Placemark")); got != tt.placemarks {
+				t.Errorf("got %d placemarks, want %d", got, tt.placemarks)
+			}
+			if tt.opts.AltitudeMode == Absolute && !strings.Contains(buf.String(), "<altitudeMode>absolute</altitudeMode>") {
+				t.Error("absolute altitude mode wasn't written")
+			}
+		})
+	}
+}
+
+func TestWriteWaypoints(t *testing.T) {
+	opts := DefaultOptions()
+	start := gpstest.Records(5, 0)[0].GPSTime
+	opts.Waypoints = []waypoint.Waypoint{
+		{Time: start, Lat: 38.6, Long: -90.2, Number: 1},
+		{Time: start.Add(2 * time.Second), Lat: 38.6002, Long: -90.2, Number: 2, Interpolated: true},
+	}
+	for _, records := range [][]gps.GPSRecord{gpstest.Records(5, 0), nil} {
+		var buf bytes.Buffer
+		if err := Write(&buf, records, opts); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		root := validate(t, buf.Bytes())
+		folder := root.Children[0].Child("Folder")
+		if folder == nil || len(folder.Children) != 3 {
+			t.Fatalf("no folder of 2 waypoints:\n%s", buf.String())
+		}
+		if name := folder.Children[1].Child("name").Text; name != "1" {
+			t.Errorf("first waypoint is named %s, want 1", name)
+		}
+		if d := folder.Children[2].Child("description"); d == nil || !strings.Contains(d.Text, "interpolated") {
+			t.Errorf("interpolated waypoint description = %v", d)
+		}
+		// waypoints aren't part of the track
+		if got, err := Read(&buf, nil); err != nil || len(got) != len(records) {
+			t.Errorf("Read() = %d records, %v, want %d", len(got), err, len(records))
+		}
+	}
+}
+
+func TestCoordinate(t *testing.T) {
+	gr := gps.GPSRecord{Lat: 38.6270025, Long: -90.1994042, Alt: 3280.84}
+	if got, want := Coordinate(gr), "-90.1994042,38.6270025,1000.0"; got != want {
+		t.Errorf("Coordinate() = %s, want %s", got, want)
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{"#ff8000", "ff0080ff", false},
+		{"FF8000", "ff0080ff", false},
+		{"7f00ff00", "7f00ff00", false},
+		{"#fff", "", true},
+		{"orange", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseColor(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseColor(%s) = %s, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestColoring(t *testing.T) {
+	c := Coloring{Metric: Speed, Gradient: Gradients["traffic"], Steps: 4, Min: 0, Max: 100}
+	tests := []struct {
+		v    float64
+		step int
+	}{
+		{-5, 0}, {0, 0}, {24.9, 0}, {25, 1}, {60, 2}, {99.9, 3}, {100, 3}, {250, 3},
+	}
+	for _, tt := range tests {
+		if got := c.Step(tt.v); got != tt.step {
+			t.Errorf("Step(%v) = %d, want %d", tt.v, got, tt.step)
+		}
+	}
+	if got := colorString(c.StepColor(0)); got != "ff0000ff" {
+		t.Errorf("first step color = %s, want red", got)
+	}
+	if got := colorString(c.StepColor(3)); got != "ff00ff00" {
+		t.Errorf("last step color = %s, want green", got)
+	}
+
+	// mph like the rest of the UI unless told otherwise
+	moving := gpstest.Records(1, 54)[0]
+	if c.Unit() != "mph" || math.Abs(c.Value(moving)-62.14) > 0.01 {
+		t.Errorf("%v %s, want 62.14 mph", c.Value(moving), c.Unit())
+	}
+	c.Speed = chart.Unit{Name: "km/h", Per: 3.6}
+	if c.Unit() != "km/h" || math.Abs(c.Value(moving)-100.01) > 0.01 {
+		t.Errorf("%v %s, want 100.01 km/h", c.Value(moving), c.Unit())
+	}
+	if d := DefaultColoring(); d.Speed != chart.MPH || d.Altitude != chart.Feet {
+		t.Errorf("DefaultColoring() in %s and %s, want mph and ft", d.Speed.Name, d.Altitude.Name)
+	}
+
+	ranged := DefaultColoring().WithRange(gpstest.Records(5, 0))
+	if ranged.Min != 0 || ranged.Max != 1 {
+		t.Errorf("WithRange() of all stopped records = %v to %v, want 0 to 1", ranged.Min, ranged.Max)
+	}
+}
+
+func TestParseGradient(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"rainbow", 5, false},
+		{"Heat", 4, false},
+		{"#000000,#ffffff", 2, false},
+		{"#000000, 80ffffff, #ff0000", 3, false},
+		{"#000000", 0, true},
+		{"#000000,white", 0, true},
+	}
+	for _, tt := range tests {
+		g, err := ParseGradient(tt.in)
+		if (err != nil) != tt.wantErr || len(g) != tt.want {
+			t.Errorf("ParseGradient(%s) = %d colors, %v, want %d", tt.in, len(g), err, tt.want)
+		}
+	}
+	g, _ := ParseGradient("#000000,#ffffff")
+	if got := g.At(0.5); got != (color.NRGBA{128, 128, 128, 255}) {
+		t.Errorf("At(0.5) = %v, want mid grey", got)
+	}
+}
+
+func TestWriteColoredKMZ(t *testing.T) {
+	records := gpstest.Records(5, 0)
+	for i := range records {
+		records[i].Speed = []float64{0, 0, 27, 54, 54}[i] // 0, 0, 31 and 62 mph
+	}
+	c := DefaultColoring()
+	c.Steps = 4
+
+	var buf bytes.Buffer
+	if err := WriteColoredKMZ(&buf, records, DefaultOptions(), c); err != nil {
+		t.Fatalf("WriteColoredKMZ() error = %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("not a zip: %v", err)
+	}
+	if len(zr.File) != 2 || zr.File[0].Name != "doc.kml" || zr.File[1].Name != LegendFile {
+		t.Fatalf("KMZ holds %v, want doc.kml then %s", zr.File, LegendFile)
+	}
+	read := func(i int) []byte {
+		rc, err := zr.File[i].Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	doc := read(0)
+	root := validate(t, doc)
+	// stopped, speeding up through two steps, then steady at the top
+	var styles []string
+	for _, pm := range root.Children[0].Child("Folder").All("Placemark") {
+		styles = append(styles, pm.Child("styleUrl").Text)
+	}
+	if got := strings.Join(styles, " "); got != "#step0 #step1 #step2 #step3" {
+		t.Errorf("segments are styled %s", got)
+	}
+	if root.Children[0].Child("ScreenOverlay") == nil {
+		t.Error("no legend overlay")
+	}
+
+	img, err := png.Decode(bytes.NewReader(read(1)))
+	if err != nil {
+		t.Fatalf("legend isn't a PNG: %v", err)
+	}
+	// the bottom of the bar is the lowest step's color
+	bottom := color.NRGBAModel.Convert(img.At(legendPad+barWidth/2, img.Bounds().Dy()-legendPad-glyphHeight/2-1))
+	if bottom != c.WithRange(records).StepColor(0) {
+		t.Errorf("bottom of the legend is %v, want %v", bottom, c.StepColor(0))
+	}
+}
+
+func TestRead(t *testing.T) {
+	lineOnly := DefaultOptions()
+	lineOnly.Track, lineOnly.Endpoints = false, false
+	tests := []struct {
+		name  string
+		opts  Options
+		times bool
+	}{
+		{"gx:Track", DefaultOptions(), true},
+		{"LineString", lineOnly, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := gpstest.Records(5, 0)
+			var buf bytes.Buffer
+			if err := Write(&buf, want, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			got, err := Read(&buf, nil)
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("Read() = %d records, want %d", len(got), len(want))
+			}
+			for i := range got {
+				if Coordinate(got[i]) != Coordinate(want[i]) {
+					t.Errorf("record %d at %s, want %s", i, Coordinate(got[i]), Coordinate(want[i]))
+				}
+				if tt.times && !got[i].Time().Equal(want[i].Time()) {
+					t.Errorf("record %d at %v, want %v", i, got[i].Time(), want[i].Time())
+				}
+			}
+		})
+	}
+}
+
+func TestReadBad(t *testing.T) {
+	doc := `<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">
+<Placemark><gx:Track>
+<when>2022-05-20T12:00:00Z</when><when>2022-05-20T12:00:01Z</when><when>noon</when>
+<gx:coord>-90.2 38.6 152.4</gx:coord><gx:coord>-90.2 98.6 152.4</gx:coord><gx:coord>-90.2 38.6 152.4</gx:coord>
+</gx:Track></Placemark></kml>`
+	var skipped []string
+	got, err := Read(strings.NewReader(doc), func(err error) error {
+		skipped = append(skipped, err.Error())
+		return nil
+	})
+	if err != nil || len(got) != 1 || len(skipped) != 2 {
+		t.Errorf("Read() = %d records, skipped %q, error %v, want 1 and 2", len(got), skipped, err)
+	}
+	if _, err := Read(strings.NewReader(doc), nil); err == nil {
+		t.Error("Read() without bad skipped a bad point")
+	}
+}
+
+func TestReadKMZ(t *testing.T) {
+	records := gpstest.Records(5, 0)
+	var buf bytes.Buffer
+	if err := WriteColoredKMZ(&buf, records, DefaultOptions(), DefaultColoring()); err != nil {
+		t.Fatal(err)
+	}
+	// colored runs share their ends, which mustn't come back twice
+	got, err := ReadKMZ(buf.Bytes(), nil)
+	if err != nil {
+		t.Fatalf("ReadKMZ() error = %v", err)
+	}
+	if len(got) != len(records) {
+		t.Errorf("ReadKMZ() = %d records, want %d", len(got), len(records))
+	}
+	if _, err := ReadKMZ([]byte("not a zip"), nil); err == nil {
+		t.Error("ReadKMZ() of junk didn't fail")
+	}
+}
@@ -0,0 +1,153 @@
+package kml
+
+import (
+	"archive/zip"
+	"io"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/spool"
+)
+
+// ColoredWriter writes a KMZ of a track colored by a metric a record at a
+// time: doc.kml, first as Google Earth expects, with the track as lines
+// colored by step in a folder of their own, then the legend it shows. The
+// colors need the whole track's range, so the records wait in a spool file
+// until Close. The lines replace the LineString and gx:Track, which would be
+// drawn over them in one color
+type ColoredWriter struct {
+	w        io.Writer
+	opts     Options
+	coloring Coloring
+	records  spool.Records
+	values   valueRange
+	first    gps.GPSRecord
+	last     gps.GPSRecord
+}
+
+// NewColoredWriter returns a writer for one KMZ colored by c, its range
+// comes from the records if it isn't set
+func NewColoredWriter(w io.Writer, opts Options, c Coloring) *ColoredWriter {
+	if c.Steps < 1 {
+		c.Steps = 1
+	}
+	return &ColoredWriter{w: w, opts: opts, coloring: c}
+}
+
+// Write adds a record to the track
+func (cw *ColoredWriter) Write(gr gps.GPSRecord) error {
+	if cw.records.Len() == 0 {
+		cw.first = gr
+	}
+	cw.last = gr
+	cw.values.add(cw.coloring.Value(gr))
+	return cw.records.Write(gr)
+}
+
+// Close writes the KMZ, it doesn't close the underlying writer
+func (cw *ColoredWriter) Close() error {
+	defer cw.records.Close()
+	c := cw.coloring.withRange(cw.values)
+	zw := zip.NewWriter(cw.w)
+	now := time.Now()
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: "doc.kml", Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return err
+	}
+	if err := cw.document(f, c); err != nil {
+		return err
+	}
+	// images are compressed already
+	f, err = zw.CreateHeader(&zip.FileHeader{Name: LegendFile, Method: zip.Store, Modified: now})
+	if err != nil {
+		return err
+	}
+	if err := WriteLegend(f, c); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// document writes doc.kml from the spooled records
+func (cw *ColoredWriter) document(w io.Writer, c Coloring) error {
+	opts := cw.opts
+	doc := head(opts)
+	doc.Styles = append(doc.Styles, c.styles(opts.LineWidth)...)
+	e := newEncoder(w)
+	if err := e.start(doc); err != nil {
+		return err
+	}
+
+	if cw.records.Len() > 0 && opts.Endpoints {
+		for _, pm := range endpoints(cw.first, cw.last, opts) {
+			if err := e.element(pm, "Placemark"); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range doc.Folders {
+		if err := e.element(f, "Folder"); err != nil {
+			return err
+		}
+	}
+
+	if err := e.open("Folder"); err != nil {
+		return err
+	}
+	if err := e.element(c.title(opts), "name"); err != nil {
+		return err
+	}
+	// consecutive pieces in the same step share a line, each piece colored
+	// by the average of its ends
+	var prev gps.GPSRecord
+	step, i := -1, 0
+	err := cw.records.Each(func(gr gps.GPSRecord) error {
+		defer func() { prev, i = gr, i+1 }()
+		if i == 0 {
+			return nil
+		}
+		s := c.Step((c.Value(prev) + c.Value(gr)) / 2)
+		if s != step {
+			if step >= 0 {
+				if err := e.closeLine(); err != nil {
+					return err
+				}
+			}
+			if err := e.openLine(c.runName(s), "#"+stepStyle(s), opts.AltitudeMode); err != nil {
+				return err
+			}
+			if err := e.coord(prev, true); err != nil {
+				return err
+			}
+			step = s
+		}
+		return e.coord(gr, false)
+	})
+	if err != nil {
+		return err
+	}
+	if step >= 0 {
+		if err := e.closeLine(); err != nil {
+			return err
+		}
+	}
+	if err := e.close("Folder"); err != nil {
+		return err
+	}
+	if err := e.element(c.legendOverlay(opts), "ScreenOverlay"); err != nil {
+		return err
+	}
+	return e.end()
+}
+
+// WriteColoredKMZ writes a KMZ of the records colored by c with its legend,
+// c's range comes from the records if it isn't set
+func WriteColoredKMZ(w io.Writer, records []gps.GPSRecord, opts Options, c Coloring) error {
+	cw := NewColoredWriter(w, opts, c)
+	for _, gr := range records {
+		if err := cw.Write(gr); err != nil {
+			return err
+		}
+	}
+	return cw.Close()
+}
@@ -0,0 +1,73 @@
+package kml
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+
+	"github.com/samiam2013/raspigogps/common/font"
+)
+
+const (
+	fontScale   = 2
+	glyphHeight = font.Height * fontScale
+	glyphAdv    = font.Advance * fontScale
+	legendPad   = 8
+	barWidth    = 16
+	barHeight   = 200
+)
+
+// Legend draws c's steps as a bar, highest at the top, labelled with the
+// values between them and the metric's unit
+func Legend(c Coloring) *image.NRGBA {
+	if c.Steps < 1 {
+		c.Steps = 1
+	}
+	// at most about 10 labels
+	every := (c.Steps + 9) / 10
+	var labels []int
+	for i := 0; i <= c.Steps; i += every {
+		labels = append(labels, i)
+	}
+	if labels[len(labels)-1] != c.Steps {
+		labels = append(labels, c.Steps)
+	}
+	widest := len(c.Unit())
+	for _, i := range labels {
+		lo, _ := c.StepRange(i)
+		if n := len(c.formatValue(lo)); n > widest {
+			widest = n
+		}
+	}
+
+	barTop := legendPad + glyphHeight + legendPad
+	w := legendPad + barWidth + legendPad + widest*glyphAdv + legendPad
+	h := barTop + barHeight + glyphHeight/2 + legendPad
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.NRGBA{0, 0, 0, 160}), image.Point{}, draw.Src)
+	font.Draw(img, legendPad, legendPad, c.Unit(), color.White, fontScale)
+
+	// y of the boundary below step i
+	boundary := func(i int) int {
+		return barTop + barHeight - i*barHeight/c.Steps
+	}
+	for i := 0; i < c.Steps; i++ {
+		r := image.Rect(legendPad, boundary(i+1), legendPad+barWidth, boundary(i))
+		draw.Draw(img, r, image.NewUniform(c.StepColor(i)), image.Point{}, draw.Src)
+	}
+	for _, i := range labels {
+		v, _ := c.StepRange(i)
+		y := boundary(i)
+		tick := image.Rect(legendPad+barWidth, y-1, legendPad+barWidth+legendPad/2, y+1)
+		draw.Draw(img, tick, image.NewUniform(color.White), image.Point{}, draw.Src)
+		font.Draw(img, legendPad+barWidth+legendPad, y-glyphHeight/2, c.formatValue(v), color.White, fontScale)
+	}
+	return img
+}
+
+// WriteLegend writes the legend as a PNG
+func WriteLegend(w io.Writer, c Coloring) error {
+	return png.Encode(w, Legend(c))
+}
@@ -0,0 +1,172 @@
+package kml
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// parseTuple reads long,lat[,alt] or gx:coord's space separated long lat alt
+func parseTuple(fields []string) (gps.GPSRecord, error) {
+	var gr gps.GPSRecord
+	if len(fields) < 2 || len(fields) > 3 {
+		return gr, fmt.Errorf("bad coordinate '%s'", strings.Join(fields, ","))
+	}
+	var err error
+	if gr.Long, err = strconv.ParseFloat(fields[0], 64); err != nil || gr.Long < -180 || gr.Long > 180 {
+		return gr, fmt.Errorf("bad longitude '%s'", fields[0])
+	}
+	if gr.Lat, err = strconv.ParseFloat(fields[1], 64); err != nil || gr.Lat < -90 || gr.Lat > 90 {
+		return gr, fmt.Errorf("bad latitude '%s'", fields[1])
+	}
+	if len(fields) == 3 {
+		alt, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return gr, fmt.Errorf("bad altitude '%s'", fields[2])
+		}
+		gr.Alt = alt * 3.28084 // feet like the logger
+	}
+	return gr, nil
+}
+
+func setTime(gr *gps.GPSRecord, t time.Time) {
+	gr.GPSTime = t.UTC()
+	gr.UnixMicro = uint64(t.UnixMicro())
+}
+
+// Read reads the track from a KML file. A gx:Track has a time for every
+// point, so when there are any only they are read, otherwise the points of
+// every LineString are, without times and dropping a point that repeats the
+// one before. bad is called with each point that can't be used; it stops with
+// bad's error if it returns one, a nil bad stops at the first
+func Read(r io.Reader, bad func(error) error) ([]gps.GPSRecord, error) {
+	dec := xml.NewDecoder(r)
+	lines, tracks := []gps.GPSRecord{}, []gps.GPSRecord{}
+	var whens []string
+	var coords []string
+	inLine, inTrack, haveTrack := false, false, false
+	report := func(err error) error {
+		if bad == nil {
+			return err
+		}
+		return bad(err)
+	}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("not a KML file: %w", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			switch tok.Name.Local {
+			case "LineString":
+				inLine = true
+			case "Track":
+				inTrack, haveTrack = true, true
+				whens, coords = whens[:0], coords[:0]
+			case "coordinates":
+				if !inLine {
+					continue
+				}
+				var text string
+				if err := dec.DecodeElement(&text, &tok); err != nil {
+					return nil, fmt.Errorf("not a KML file: %w", err)
+				}
+				for _, tuple := range strings.Fields(text) {
+					gr, err := parseTuple(strings.Split(tuple, ","))
+					if err != nil {
+						if err := report(err); err != nil {
+							return nil, err
+						}
+						continue
+					}
+					// lines split by color share their ends
+					if n := len(lines); n > 0 && lines[n-1] == gr {
+						continue
+					}
+					lines = append(lines, gr)
+				}
+			case "when", "coord":
+				if !inTrack {
+					continue
+				}
+				var text string
+				if err := dec.DecodeElement(&text, &tok); err != nil {
+					return nil, fmt.Errorf("not a KML file: %w", err)
+				}
+				if tok.Name.Local == "when" {
+					whens = append(whens, strings.TrimSpace(text))
+				} else {
+					coords = append(coords, text)
+				}
+			}
+		case xml.EndElement:
+			switch tok.Name.Local {
+			case "LineString":
+				inLine = false
+			case "Track":
+				inTrack = false
+				if len(whens) != len(coords) {
+					err := fmt.Errorf("gx:Track has %d whens for %d coords", len(whens), len(coords))
+					if err := report(err); err != nil {
+						return nil, err
+					}
+				}
+				for i := 0; i < len(whens) && i < len(coords); i++ {
+					gr, err := parseTuple(strings.Fields(coords[i]))
+					if err == nil {
+						var t time.Time
+						if t, err = time.Parse(time.RFC3339Nano, whens[i]); err == nil {
+							setTime(&gr, t)
+						} else {
+							err = fmt.Errorf("bad when '%s'", whens[i])
+						}
+					}
+					if err != nil {
+						if err := report(err); err != nil {
+							return nil, err
+						}
+						continue
+					}
+					tracks = append(tracks, gr)
+				}
+			}
+		}
+	}
+	if haveTrack {
+		return tracks, nil
+	}
+	return lines, nil
+}
+
+// ReadKMZ reads the track from the first KML file in a KMZ, doc.kml by
+// convention
+func ReadKMZ(data []byte, bad func(error) error) ([]gps.GPSRecord, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("not a KMZ file: %w", err)
+	}
+	for _, f := range zr.File {
+		if !strings.EqualFold(path.Ext(f.Name), ".kml") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return Read(rc, bad)
+	}
+	return nil, fmt.Errorf("no KML file in the KMZ")
+}
@@ -0,0 +1,261 @@
+package kml
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/spool"
+)
+
+func name(local string) xml.Name {
+	return xml.Name{Local: local}
+}
+
+// encoder writes a document an element at a time in the order the schema
+// wants them, indented like Document.Encode
+type encoder struct {
+	w   io.Writer
+	enc *xml.Encoder
+}
+
+func newEncoder(w io.Writer) *encoder {
+	enc := xml.NewEncoder(w)
+	enc.Indent("", " ")
+	return &encoder{w: w, enc: enc}
+}
+
+// start writes everything up to and including doc's styles
+func (e *encoder) start(doc *Document) error {
+	if _, err := io.WriteString(e.w, xml.Header); err != nil {
+		return err
+	}
+	root := xml.StartElement{Name: name("kml"), Attr: []xml.Attr{
+		{Name: name("xmlns"), Value: Namespace},
+		{Name: name("xmlns:gx"), Value: GxNamespace},
+	}}
+	if err := e.enc.EncodeToken(root); err != nil {
+		return err
+	}
+	if err := e.open("Document"); err != nil {
+		return err
+	}
+	if doc.Name != "" {
+		if err := e.element(doc.Name, "name"); err != nil {
+			return err
+		}
+	}
+	for _, s := range doc.Styles {
+		if err := e.element(s, "Style"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// end closes the Document and the kml element, it doesn't close the
+// underlying writer
+func (e *encoder) end() error {
+	if err := e.close("Document", "kml"); err != nil {
+		return err
+	}
+	if err := e.enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "\n")
+	return err
+}
+
+func (e *encoder) element(v interface{}, local string) error {
+	return e.enc.EncodeElement(v, xml.StartElement{Name: name(local)})
+}
+
+func (e *encoder) open(locals ...string) error {
+	for _, l := range locals {
+		if err := e.enc.EncodeToken(xml.StartElement{Name: name(l)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) close(locals ...string) error {
+	for _, l := range locals {
+		if err := e.enc.EncodeToken(xml.EndElement{Name: name(l)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openLine starts a Placemark with a LineString, its coordinates follow with
+// coord and closeLine ends it
+func (e *encoder) openLine(placemark, style, mode string) error {
+	if err := e.open("Placemark"); err != nil {
+		return err
+	}
+	if placemark != "" {
+		if err := e.element(placemark, "name"); err != nil {
+			return err
+		}
+	}
+	if err := e.element(style, "styleUrl"); err != nil {
+		return err
+	}
+	if err := e.open("LineString"); err != nil {
+		return err
+	}
+	if err := e.element(1, "tessellate"); err != nil {
+		return err
+	}
+	if mode = altitudeMode(mode); mode != "" {
+		if err := e.element(mode, "altitudeMode"); err != nil {
+			return err
+		}
+	}
+	return e.open("coordinates")
+}
+
+// coord adds a record to the line, first is whether it's the line's first
+func (e *encoder) coord(gr gps.GPSRecord, first bool) error {
+	c := Coordinate(gr)
+	if !first {
+		c = " " + c
+	}
+	return e.enc.EncodeToken(xml.CharData(c))
+}
+
+func (e *encoder) closeLine() error {
+	return e.close("coordinates", "LineString", "Placemark")
+}
+
+// raw writes straight to the underlying writer, for what was spooled
+func (e *encoder) raw(from ...io.WriterTo) error {
+	if err := e.enc.Flush(); err != nil {
+		return err
+	}
+	for _, f := range from {
+		if _, err := f.WriteTo(e.w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// text is a string to write with raw
+type text string
+
+func (t text) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, string(t))
+	return int64(n), err
+}
+
+// Writer writes a KML document a record at a time. The LineString goes out
+// as records arrive, the gx:Track after it has all its whens before its
+// coords so both wait in spool files, and the endpoints are written last
+type Writer struct {
+	e             *encoder
+	opts          Options
+	head          *Document
+	whens, coords spool.File
+	first, last   gps.GPSRecord
+	n             int
+	started       bool
+}
+
+// NewWriter returns a writer for one document, the header is written with
+// the first record or on Close
+func NewWriter(w io.Writer, opts Options) *Writer {
+	return &Writer{e: newEncoder(w), opts: opts, head: head(opts)}
+}
+
+// the gx:Track's children sit four deep, under kml, Document and Placemark
+const trackIndent = "\n    "
+
+// Write adds a record to the track
+func (kw *Writer) Write(gr gps.GPSRecord) error {
+	if !kw.started {
+		kw.started = true
+		if err := kw.e.start(kw.head); err != nil {
+			return err
+		}
+	}
+	if kw.opts.LineString {
+		if kw.n == 0 {
+			if err := kw.e.openLine(kw.opts.Name, "#track", kw.opts.AltitudeMode); err != nil {
+				return err
+			}
+		}
+		if err := kw.e.coord(gr, kw.n == 0); err != nil {
+			return err
+		}
+	}
+	if kw.opts.Track {
+		if _, err := io.WriteString(&kw.whens, trackIndent+"<when>"+When(gr)+"</when>"); err != nil {
+			return err
+		}
+		coord := formatFloat(gr.Long, 7) + " " + formatFloat(gr.Lat, 7) + " " + formatFloat(altitude(gr), 1)
+		if _, err := io.WriteString(&kw.coords, trackIndent+"<gx:coord>"+coord+"</gx:coord>"); err != nil {
+			return err
+		}
+	}
+	if kw.n == 0 {
+		kw.first = gr
+	}
+	kw.last = gr
+	kw.n++
+	return nil
+}
+
+// Close writes the rest of the document, it doesn't close the underlying
+// writer
+func (kw *Writer) Close() error {
+	defer kw.whens.Close()
+	defer kw.coords.Close()
+	e := kw.e
+	if !kw.started {
+		kw.started = true
+		if err := e.start(kw.head); err != nil {
+			return err
+		}
+	}
+	if kw.n > 0 && kw.opts.LineString {
+		if err := e.closeLine(); err != nil {
+			return err
+		}
+	}
+	if kw.n > 0 && kw.opts.Track {
+		if err := e.open("Placemark"); err != nil {
+			return err
+		}
+		if err := e.element(kw.opts.Name+" (timed)", "name"); err != nil {
+			return err
+		}
+		if err := e.element("#track", "styleUrl"); err != nil {
+			return err
+		}
+		open := text("\n   <gx:Track>")
+		if mode := altitudeMode(kw.opts.AltitudeMode); mode != "" {
+			open += text(trackIndent + "<altitudeMode>" + mode + "</altitudeMode>")
+		}
+		if err := e.raw(open, &kw.whens, &kw.coords, text("\n   </gx:Track>")); err != nil {
+			return err
+		}
+		if err := e.close("Placemark"); err != nil {
+			return err
+		}
+	}
+	if kw.n > 0 && kw.opts.Endpoints {
+		for _, pm := range endpoints(kw.first, kw.last, kw.opts) {
+			if err := e.element(pm, "Placemark"); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range kw.head.Folders {
+		if err := e.element(f, "Folder"); err != nil {
+			return err
+		}
+	}
+	return e.end()
+}
@@ -0,0 +1,202 @@
+package mapmatch
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+const earthRadius = 6371008.8 // meters, the mean radius
+
+// cellSize is the spatial index's cell in degrees, about 500m of latitude
+const cellSize = 0.005
+
+// Edge is a stretch of road between two nodes in the direction it can be
+// driven, a two way road has an edge each way
+type Edge struct {
+	From, To int // node indexes
+	WayID    int64
+	Name     string
+	Highway  string
+	Length   float64 // meters
+}
+
+// Graph is the roads as a directed graph, with edges indexed by where they
+// are
+type Graph struct {
+	Nodes []Node
+	Edges []Edge
+	out   [][]int // edges leaving each node
+	cells map[[2]int32][]int
+}
+
+// NewGraph builds the road graph, leaving out ways through nodes the
+// extract doesn't have
+func NewGraph(d *Data) *Graph {
+	g := &Graph{cells: map[[2]int32][]int{}}
+	index := make(map[int64]int, len(d.Nodes))
+	node := func(id int64) int {
+		if i, ok := index[id]; ok {
+			return i
+		}
+		index[id] = len(g.Nodes)
+		g.Nodes = append(g.Nodes, d.Nodes[id])
+		g.out = append(g.out, nil)
+		return index[id]
+	}
+	for _, w := range d.Ways {
+		complete := true
+		for _, id := range w.Nodes {
+			if _, ok := d.Nodes[id]; !ok {
+				complete = false
+				break
+			}
+		}
+		if !complete {
+			continue
+		}
+		for i := 1; i < len(w.Nodes); i++ {
+			a, b := node(w.Nodes[i-1]), node(w.Nodes[i])
+			if a == b {
+				continue
+			}
+			if w.Oneway != Backward {
+				g.addEdge(Edge{From: a, To: b, WayID: w.ID, Name: w.Name, Highway: w.Highway})
+			}
+			if w.Oneway != Forward {
+				g.addEdge(Edge{From: b, To: a, WayID: w.ID, Name: w.Name, Highway: w.Highway})
+			}
+		}
+	}
+	return g
+}
+
+func (g *Graph) addEdge(e Edge) {
+	a, b := g.Nodes[e.From], g.Nodes[e.To]
+	e.Length = distance(a, b)
+	i := len(g.Edges)
+	g.Edges = append(g.Edges, e)
+	g.out[e.From] = append(g.out[e.From], i)
+	minX, minY := cell(math.Min(a.Lat, b.Lat), math.Min(a.Long, b.Long))
+	maxX, maxY := cell(math.Max(a.Lat, b.Lat), math.Max(a.Long, b.Long))
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			g.cells[[2]int32{x, y}] = append(g.cells[[2]int32{x, y}], i)
+		}
+	}
+}
+
+func cell(lat, long float64) (x, y int32) {
+	return int32(math.Floor(long / cellSize)), int32(math.Floor(lat / cellSize))
+}
+
+// distance is the great circle distance, plenty for a block of road
+func distance(a, b Node) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat, dLong := lat2-lat1, (b.Long-a.Long)*math.Pi/180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLong/2)*math.Sin(dLong/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Projection is the nearest point on an edge to a position
+type Projection struct {
+	Edge     int
+	Offset   float64 // meters along the edge from its From
+	Distance float64 // meters from the position
+	Node             // where it is
+}
+
+// project finds the nearest point on edge e to p, in a flat projection
+// around p that's true to well under a meter over a block
+func (g *Graph) project(e int, p Node) Projection {
+	edge := g.Edges[e]
+	a, b := g.Nodes[edge.From], g.Nodes[edge.To]
+	cos := math.Cos(p.Lat * math.Pi / 180)
+	k := math.Pi / 180 * earthRadius
+	ax, ay := (a.Long-p.Long)*cos*k, (a.Lat-p.Lat)*k
+	bx, by := (b.Long-p.Long)*cos*k, (b.Lat-p.Lat)*k
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if l2 := dx*dx + dy*dy; l2 > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l2))
+	}
+	x, y := ax+t*dx, ay+t*dy
+	return Projection{
+		Edge: e, Offset: t * edge.Length, Distance: math.Hypot(x, y),
+		Node: Node{a.Lat + t*(b.Lat-a.Lat), a.Long + t*(b.Long-a.Long)},
+	}
+}
+
+// Nearby is the projections onto edges within radius meters of p, nearest
+// first
+func (g *Graph) Nearby(p Node, radius float64) []Projection {
+	dLat := radius / (math.Pi / 180 * earthRadius)
+	dLong := dLat / math.Max(0.01, math.Cos(p.Lat*math.Pi/180))
+	minX, minY := cell(p.Lat-dLat, p.Long-dLong)
+	maxX, maxY := cell(p.Lat+dLat, p.Long+dLong)
+	seen := map[int]bool{}
+	var found []Projection
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			for _, e := range g.cells[[2]int32{x, y}] {
+				if seen[e] {
+					continue
+				}
+				seen[e] = true
+				if pr := g.project(e, p); pr.Distance <= radius {
+					found = append(found, pr)
+				}
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Distance != found[j].Distance {
+			return found[i].Distance < found[j].Distance
+		}
+		return found[i].Edge < found[j].Edge
+	})
+	return found
+}
+
+type queueItem struct {
+	node int
+	dist float64
+}
+
+type queue []queueItem
+
+func (q queue) Len() int            { return len(q) }
+func (q queue) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q queue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *queue) Push(x interface{}) { *q = append(*q, x.(queueItem)) }
+func (q *queue) Pop() interface{} {
+	old := *q
+	it := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return it
+}
+
+// distances is the driving distance from node from, starting at start
+// meters, to every node within max meters
+func (g *Graph) distances(from int, start, max float64) map[int]float64 {
+	dist := map[int]float64{from: start}
+	q := &queue{{from, start}}
+	for q.Len() > 0 {
+		it := heap.Pop(q).(queueItem)
+		if it.dist > dist[it.node] {
+			continue
+		}
+		for _, e := range g.out[it.node] {
+			edge := g.Edges[e]
+			d := it.dist + edge.Length
+			if d > max {
+				continue
+			}
+			if old, ok := dist[edge.To]; !ok || d < old {
+				dist[edge.To] = d
+				heap.Push(q, queueItem{edge.To, d})
+			}
+		}
+	}
+	return dist
+}
@@ -0,0 +1,325 @@
+package mapmatch
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// a few blocks of town: Main St running east, 1st Ave north from it to Oak
+// St, and a dead end alley 40m north of Main off 1st Ave
+const testOSM = `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6">
+ <node id="1" lat="38.6" lon="-90.2"/>
+ <node id="2" lat="38.6" lon="-90.195"/>
+ <node id="3" lat="38.6" lon="-90.19"/>
+ <node id="4" lat="38.6018" lon="-90.195"/>
+ <node id="5" lat="38.6018" lon="-90.2"/>
+ <node id="6" lat="38.6018" lon="-90.19"/>
+ <node id="7" lat="38.60036" lon="-90.2"/>
+ <node id="8" lat="38.60036" lon="-90.195"/>
+ <node id="9" lat="38.61" lon="-90.2"/>
+ <way id="100"><nd ref="1"/><nd ref="2"/><nd ref="3"/><tag k="highway" v="secondary"/><tag k="name" v="Main St"/></way>
+ <way id="200"><nd ref="2"/><nd ref="8"/><nd ref="4"/><tag k="highway" v="residential"/><tag k="name" v="1st Ave"/></way>
+ <way id="300"><nd ref="5"/><nd ref="4"/><nd ref="6"/><tag k="highway" v="residential"/><tag k="name" v="Oak St"/></way>
+ <way id="400"><nd ref="7"/><nd ref="8"/><tag k="highway" v="service"/><tag k="name" v="Alley"/><tag k="oneway" v="-1"/></way>
+ <way id="500"><nd ref="1"/><nd ref="9"/><tag k="highway" v="footway"/></way>
+ <way id="600"><nd ref="3"/><nd ref="99"/><tag k="highway" v="primary"/><tag k="ref" v="MO 100"/></way>
+</osm>`
+
+func TestReadXML(t *testing.T) {
+	d, err := ReadXML(strings.NewReader(testOSM))
+	if err != nil {
+		t.Fatalf("ReadXML: %s", err.Error())
+	}
+	if len(d.Ways) != 5 {
+		t.Fatalf("%d ways, want 5 without the footway", len(d.Ways))
+	}
+	if w := d.Ways[3]; w.Name != "Alley" || w.Oneway != Backward || w.Highway != "service" {
+		t.Errorf("alley = %+v", w)
+	}
+	if w := d.Ways[4]; w.Name != "MO 100" {
+		t.Errorf("way with only a ref is named %s", w.Name)
+	}
+	if _, ok := d.Nodes[9]; ok || len(d.Nodes) != 8 {
+		t.Errorf("%d nodes, the footway's end should be dropped", len(d.Nodes))
+	}
+
+	g := NewGraph(d)
+	// Main, 1st Ave and Oak both ways, the alley one way and MO 100 left out
+	// for its missing node
+	if len(g.Edges) != 2*(2+2+2)+1 {
+		t.Errorf("%d edges", len(g.Edges))
+	}
+	for _, e := range g.Edges {
+		if e.Name == "Alley" && g.Nodes[e.From].Long != -90.195 {
+			t.Errorf("alley edge runs the wrong way")
+		}
+	}
+}
+
+// protobuf encoding, for building a PBF
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func pbKey(b []byte, field, wire int) []byte {
+	return appendUvarint(b, uint64(field<<3|wire))
+}
+
+func pbBytes(b []byte, field int, v []byte) []byte {
+	b = pbKey(b, field, 2)
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func pbVarint(b []byte, field int, v uint64) []byte {
+	return appendUvarint(pbKey(b, field, 0), v)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func pbPacked(b []byte, field int, vs []uint64) []byte {
+	var packed []byte
+	for _, v := range vs {
+		packed = appendUvarint(packed, v)
+	}
+	return pbBytes(b, field, packed)
+}
+
+// pbf encodes d's nodes as dense nodes and its ways in one block
+func pbf(d *Data, ids []int64) []byte {
+	strs := []string{""}
+	str := func(s string) uint64 {
+		for i, have := range strs {
+			if have == s {
+				return uint64(i)
+			}
+		}
+		strs = append(strs, s)
+		return uint64(len(strs) - 1)
+	}
+	var dense []byte
+	var dIDs, dLats, dLongs []uint64
+	var pid, plat, plong int64
+	for _, id := range ids {
+		n := d.Nodes[id]
+		lat, long := int64(math.Round(n.Lat*1e7)), int64(math.Round(n.Long*1e7))
+		dIDs, dLats, dLongs = append(dIDs, zigzag(id-pid)), append(dLats, zigzag(lat-plat)), append(dLongs, zigzag(long-plong))
+		pid, plat, plong = id, lat, long
+	}
+	dense = pbPacked(dense, 1, dIDs)
+	dense = pbPacked(dense, 8, dLats)
+	dense = pbPacked(dense, 9, dLongs)
+	group := pbBytes(nil, 2, dense)
+	for _, w := range d.Ways {
+		var way []byte
+		way = pbVarint(way, 1, uint64(w.ID))
+		tags := map[string]string{"highway": w.Highway, "name": w.Name}
+		if w.Oneway == Backward {
+			tags["oneway"] = "-1"
+		}
+		var keys, vals []uint64
+		for _, k := range []string{"highway", "name", "oneway"} {
+			if v, ok := tags[k]; ok {
+				keys, vals = append(keys, str(k)), append(vals, str(v))
+			}
+		}
+		way = pbPacked(way, 2, keys)
+		way = pbPacked(way, 3, vals)
+		var refs []uint64
+		var prev int64
+		for _, n := range w.Nodes {
+			refs = append(refs, zigzag(n-prev))
+			prev = n
+		}
+		way = pbPacked(way, 8, refs)
+		group = pbBytes(group, 3, way)
+	}
+	var table []byte
+	for _, s := range strs {
+		table = pbBytes(table, 1, []byte(s))
+	}
+	// the string table after the groups, which readers have to allow
+	block := pbBytes(nil, 2, group)
+	block = pbBytes(block, 1, table)
+
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(block)
+	zw.Close()
+	blob := pbVarint(nil, 2, uint64(len(block)))
+	blob = pbBytes(blob, 3, z.Bytes())
+
+	var out []byte
+	for _, b := range []struct {
+		kind string
+		blob []byte
+	}{{"OSMHeader", pbBytes(nil, 1, []byte("ignored"))}, {"OSMData", blob}} {
+		header := pbBytes(nil, 1, []byte(b.kind))
+		header = pbVarint(header, 3, uint64(len(b.blob)))
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(header)))
+		out = append(out, size[:]...)
+		out = append(out, header...)
+		out = append(out, b.blob...)
+	}
+	return out
+}
+
+func TestReadPBF(t *testing.T) {
+	want, _ := ReadXML(strings.NewReader(testOSM))
+	data := pbf(want, []int64{1, 2, 3, 4, 5, 6, 7, 8})
+	got, err := ReadPBF(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadPBF: %s", err.Error())
+	}
+	if fmt.Sprint(got.Ways) != fmt.Sprint(want.Ways) {
+		t.Errorf("ways\n%v\nwant\n%v", got.Ways, want.Ways)
+	}
+	if len(got.Nodes) != len(want.Nodes) {
+		t.Errorf("%d nodes, want %d", len(got.Nodes), len(want.Nodes))
+	}
+	for id, n := range want.Nodes {
+		if g := got.Nodes[id]; math.Abs(g.Lat-n.Lat) > 1e-7 || math.Abs(g.Long-n.Long) > 1e-7 {
+			t.Errorf("node %d at %v, want %v", id, g, n)
+		}
+	}
+	if _, err := ReadPBF(bytes.NewReader(data[:len(data)-10])); err == nil {
+		t.Error("a cut off PBF didn't fail")
+	}
+	// a blob size past an int's range fails rather than panicking
+	size := make([]byte, binary.MaxVarintLen64)
+	size = size[:binary.PutUvarint(size, 1<<63)]
+	header := append(append([]byte{0x0a, 7}, "OSMData"...), 0x18)
+	header = append(header, size...)
+	huge := append([]byte{0, 0, 0, byte(len(header))}, header...)
+	if _, err := ReadPBF(bytes.NewReader(huge)); err == nil {
+		t.Error("a blob of 2^63 bytes didn't fail")
+	}
+}
+
+var start = time.Date(2022, 5, 20, 12, 0, 0, 0, time.UTC)
+
+// trace drives east on Main from -90.199, north on 1st Ave and east on Oak,
+// a fix a second at 15 m/s wobbling 8m either side of the road. One fix on
+// Main is thrown 38m north, right by the alley
+func trace() (records []gps.GPSRecord, outlier int) {
+	const step = 15.0
+	mLat := 180 / math.Pi / earthRadius // degrees of latitude per meter
+	mLong := mLat / math.Cos(38.6*math.Pi/180)
+	add := func(lat, long float64) {
+		wobble := 8.0
+		if len(records)%2 == 1 {
+			wobble = -8
+		}
+		records = append(records, gps.GPSRecord{
+			GPSTime: start.Add(time.Duration(len(records)) * time.Second),
+			Lat:     lat, Long: long, Speed: step * 3.6 / 1.852,
+		})
+		if long == -90.195 {
+			records[len(records)-1].Long += wobble * mLong
+		} else {
+			records[len(records)-1].Lat += wobble * mLat
+		}
+	}
+	for long := -90.199; long < -90.195; long += step * mLong {
+		add(38.6, long)
+		if outlier == 0 && long > -90.1968 {
+			outlier = len(records)
+			add(38.6+38*mLat, long+step*mLong)
+			records[outlier].Lat = 38.6 + 38*mLat
+		}
+	}
+	for lat := 38.6; lat < 38.6018; lat += step * mLat {
+		add(lat, -90.195)
+	}
+	for long := -90.195; long < -90.191; long += step * mLong {
+		add(38.6018, long)
+	}
+	return records, outlier
+}
+
+func TestMatch(t *testing.T) {
+	d, _ := ReadXML(strings.NewReader(testOSM))
+	m := NewMatcher(NewGraph(d), DefaultOptions())
+	records, outlier := trace()
+	matches := m.Match(records)
+	if len(matches) != len(records) {
+		t.Fatalf("%d matches for %d records", len(matches), len(records))
+	}
+
+	var roads []string
+	for i, mt := range matches {
+		if !mt.Matched {
+			t.Errorf("record %d isn't matched", i)
+			continue
+		}
+		if len(roads) == 0 || roads[len(roads)-1] != mt.Name {
+			roads = append(roads, mt.Name)
+		}
+		// on the road's line
+		switch mt.Name {
+		case "Main St":
+			if math.Abs(mt.Lat-38.6) > 1e-6 {
+				t.Errorf("record %d matched off Main at %f", i, mt.Lat)
+			}
+		case "Oak St":
+			if math.Abs(mt.Lat-38.6018) > 1e-6 {
+				t.Errorf("record %d matched off Oak at %f", i, mt.Lat)
+			}
+		case "1st Ave":
+			if math.Abs(mt.Long+90.195) > 1e-6 {
+				t.Errorf("record %d matched off 1st Ave at %f", i, mt.Long)
+			}
+		}
+	}
+	if got := strings.Join(roads, ", "); got != "Main St, 1st Ave, Oak St" {
+		t.Errorf("drove %s", got)
+	}
+	// the alley is nearer but there's no driving there and back in a second
+	if mt := matches[outlier]; mt.Name != "Main St" || mt.WayID != 100 || math.Abs(mt.Distance-38) > 0.5 {
+		t.Errorf("outlier matched to %+v", mt)
+	}
+
+	snapped := Snapped(records, matches)
+	if snapped[0].Lat != 38.6 || snapped[0].GPSTime != records[0].GPSTime {
+		t.Errorf("Snapped[0] = %+v", snapped[0])
+	}
+
+	wps := Roads(records, matches)
+	var notes []string
+	for i, wp := range wps {
+		notes = append(notes, wp.Note)
+		if wp.Number != i+1 {
+			t.Errorf("road waypoint %d numbered %d", i, wp.Number)
+		}
+	}
+	if got := strings.Join(notes, "; "); got != "Main St, way 100; 1st Ave, way 200; Oak St, way 300" {
+		t.Errorf("Roads = %s", got)
+	}
+	if wps[0].Lat != matches[0].Lat || !wps[0].Time.Equal(records[0].Time()) {
+		t.Errorf("Roads[0] = %+v", wps[0])
+	}
+	// an unmatched record breaks the run, an unnamed way gets its ID
+	gap := []Match{{Matched: true, WayID: 100, Name: "Main St"}, {}, {Matched: true, WayID: 100, Name: "Main St"}, {Matched: true, WayID: 600}}
+	if wps := Roads(records[:4], gap); len(wps) != 3 || wps[2].Note != "way 600" {
+		t.Errorf("Roads across a gap = %+v", wps)
+	}
+
+	// nothing near
+	far := []gps.GPSRecord{{GPSTime: start, Lat: 39, Long: -91}}
+	if got := m.Match(far); got[0].Matched {
+		t.Errorf("a fix miles from a road matched %+v", got[0])
+	}
+}
@@ -0,0 +1,286 @@
+package mapmatch
+
+// map matching: snapping a GPS trace to the roads it most likely drove,
+//  from a local OpenStreetMap extract (XML or PBF) so it runs offline. Each
+//	fix's nearby roads are the states of a hidden Markov model, a fix far from
+//	a road is unlikely and so is a move between roads whose driving distance
+//	doesn't match how far the fixes moved, and Viterbi picks the likeliest
+//	path through them (Newson and Krumm, "Hidden Markov Map Matching Through
+//	Noise and Sparseness", 2009)
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+)
+
+// Options tunes the matcher
+type Options struct {
+	// Sigma is the GPS error's standard deviation in meters
+	Sigma float64
+	// Beta is in meters, how much the driving distance between two matches
+	// can differ from the straight line between the fixes before it's
+	// unlikely
+	Beta float64
+	// Radius in meters, roads further from a fix aren't considered
+	Radius float64
+	// MaxCandidates is the most roads considered for a fix, nearest first
+	MaxCandidates int
+	// MinDistance in meters, fixes closer than this to the last one matched
+	// are snapped to the same road rather than matched on their own
+	MinDistance float64
+	// MaxGap between fixes starts the matching over
+	MaxGap time.Duration
+}
+
+// DefaultOptions suits a consumer receiver in a car in town
+func DefaultOptions() Options {
+	return Options{Sigma: 10, Beta: 30, Radius: 50, MaxCandidates: 8, MinDistance: 20, MaxGap: 30 * time.Second}
+}
+
+// Match is where a fix was matched
+type Match struct {
+	Matched   bool // false for fixes with no road within Radius
+	Lat, Long float64
+	WayID     int64
+	Name      string
+	Distance  float64 // meters from the fix
+}
+
+// Matcher matches traces against a graph
+type Matcher struct {
+	Graph *Graph
+	Opts  Options
+}
+
+// NewMatcher returns a matcher on g
+func NewMatcher(g *Graph, opts Options) *Matcher {
+	return &Matcher{Graph: g, Opts: opts}
+}
+
+// state is a candidate in the Viterbi lattice
+type state struct {
+	Projection
+	score float64 // log probability of the best path here
+	back  int     // the previous step's state on that path
+}
+
+// step is a fix matched on its own
+type step struct {
+	record int
+	states []state
+}
+
+// Match matches records in time order, one Match per record
+func (m *Matcher) Match(records []gps.GPSRecord) []Match {
+	matches := make([]Match, len(records))
+	var chain []step
+	last := -1 // the record the chain last matched
+	for i, gr := range records {
+		p := Node{gr.Lat, gr.Long}
+		if last >= 0 && gr.Time().Sub(records[last].Time()) > m.Opts.MaxGap {
+			m.finish(chain, records, matches)
+			chain, last = nil, -1
+		}
+		if last >= 0 && distance(p, Node{records[last].Lat, records[last].Long}) < m.Opts.MinDistance {
+			continue
+		}
+		cands := m.Graph.Nearby(p, m.Opts.Radius)
+		if len(cands) == 0 {
+			continue
+		}
+		if len(cands) > m.Opts.MaxCandidates {
+			cands = cands[:m.Opts.MaxCandidates]
+		}
+		st := step{record: i, states: make([]state, len(cands))}
+		for j, c := range cands {
+			st.states[j] = state{Projection: c, score: m.emission(c.Distance), back: -1}
+		}
+		if len(chain) > 0 && !m.transition(chain[len(chain)-1], &st, records) {
+			// no way to drive here from the last match, start over
+			m.finish(chain, records, matches)
+			chain = nil
+			for j, c := range cands {
+				st.states[j] = state{Projection: c, score: m.emission(c.Distance), back: -1}
+			}
+		}
+		chain = append(chain, st)
+		last = i
+	}
+	m.finish(chain, records, matches)
+	return matches
+}
+
+// emission is the log likelihood of a fix d meters from the road
+func (m *Matcher) emission(d float64) float64 {
+	return -0.5 * (d / m.Opts.Sigma) * (d / m.Opts.Sigma)
+}
+
+// transition scores cur's states from prev's, false if none can be reached
+func (m *Matcher) transition(prev step, cur *step, records []gps.GPSRecord) bool {
+	a, b := records[prev.record], records[cur.record]
+	straight := distance(Node{a.Lat, a.Long}, Node{b.Lat, b.Long})
+	max := 3*straight + 2*m.Opts.Radius
+	best := make([]float64, len(cur.states))
+	for j := range best {
+		best[j] = math.Inf(-1)
+	}
+	for i, ps := range prev.states {
+		if math.IsInf(ps.score, -1) {
+			continue
+		}
+		from := m.Graph.Edges[ps.Edge]
+		dist := m.Graph.distances(from.To, from.Length-ps.Offset, max)
+		for j, cs := range cur.states {
+			route := math.Inf(1)
+			if cs.Edge == ps.Edge && cs.Offset >= ps.Offset {
+				route = cs.Offset - ps.Offset
+			}
+			if d, ok := dist[m.Graph.Edges[cs.Edge].From]; ok && d+cs.Offset < route {
+				route = d + cs.Offset
+			}
+			if math.IsInf(route, 1) || route > max {
+				continue
+			}
+			score := ps.score - math.Abs(route-straight)/m.Opts.Beta
+			if score > best[j] {
+				best[j] = score
+				cur.states[j].back = i
+			}
+		}
+	}
+	reached := false
+	for j := range cur.states {
+		cur.states[j].score += best[j]
+		if !math.IsInf(best[j], -1) {
+			reached = true
+		}
+	}
+	return reached
+}
+
+// finish follows the chain's likeliest path back and fills in matches,
+// snapping the fixes between steps to the roads either side
+func (m *Matcher) finish(chain []step, records []gps.GPSRecord, matches []Match) {
+	if len(chain) == 0 {
+		return
+	}
+	picked := make([]Projection, len(chain))
+	best := 0
+	lastStates := chain[len(chain)-1].states
+	for j, s := range lastStates {
+		if s.score > lastStates[best].score {
+			best = j
+		}
+	}
+	for k := len(chain) - 1; k >= 0; k-- {
+		s := chain[k].states[best]
+		picked[k] = s.Projection
+		best = s.back
+	}
+	for k, st := range chain {
+		matches[st.record] = m.match(picked[k])
+		end := len(records)
+		if k+1 < len(chain) {
+			end = chain[k+1].record
+		} else {
+			// the fixes after the last step up to the next one matched on
+			// its own, which is MinDistance away at most
+			for end = st.record + 1; end < len(records); end++ {
+				p := Node{records[end].Lat, records[end].Long}
+				if distance(p, Node{records[st.record].Lat, records[st.record].Long}) >= m.Opts.MinDistance ||
+					records[end].Time().Sub(records[st.record].Time()) > m.Opts.MaxGap {
+					break
+				}
+			}
+		}
+		for i := st.record + 1; i < end; i++ {
+			p := Node{records[i].Lat, records[i].Long}
+			pr := m.Graph.project(picked[k].Edge, p)
+			if k+1 < len(chain) {
+				if next := m.Graph.project(picked[k+1].Edge, p); next.Distance < pr.Distance {
+					pr = next
+				}
+			}
+			if pr.Distance <= m.Opts.Radius {
+				matches[i] = m.match(pr)
+			}
+		}
+	}
+}
+
+func (m *Matcher) match(p Projection) Match {
+	e := m.Graph.Edges[p.Edge]
+	return Match{Matched: true, Lat: p.Lat, Long: p.Long, WayID: e.WayID, Name: e.Name, Distance: p.Distance}
+}
+
+// Snapped is the records moved onto their matches, unmatched ones as they
+// were
+func Snapped(records []gps.GPSRecord, matches []Match) []gps.GPSRecord {
+	out := make([]gps.GPSRecord, len(records))
+	for i, gr := range records {
+		if i < len(matches) && matches[i].Matched {
+			gr.Lat, gr.Long = matches[i].Lat, matches[i].Long
+		}
+		out[i] = gr
+	}
+	return out
+}
+
+// Roads is a waypoint wherever the matched track turns onto another way,
+// numbered from 1 and noting the road's name and way ID. Unmatched records
+// break a run, so a way picked up again after one gets a waypoint too
+func Roads(records []gps.GPSRecord, matches []Match) []waypoint.Waypoint {
+	var roads []waypoint.Waypoint
+	var way int64
+	on := false
+	for i, gr := range records {
+		if i >= len(matches) || !matches[i].Matched {
+			on = false
+			continue
+		}
+		m := matches[i]
+		if on && m.WayID == way {
+			continue
+		}
+		on, way = true, m.WayID
+		note := "way " + strconv.FormatInt(m.WayID, 10)
+		if m.Name != "" {
+			note = m.Name + ", " + note
+		}
+		roads = append(roads, waypoint.Waypoint{
+			Time: gr.Time(), Lat: m.Lat, Long: m.Long, Number: len(roads) + 1, Note: note,
+		})
+	}
+	return roads
+}
+
+// CSVColumns is the header WriteCSV writes
+var CSVColumns = []string{"time", "lat", "long", "matched_lat", "matched_long", "way_id", "road", "distance"}
+
+// WriteCSV writes a row per record with where it was matched, the matched
+// columns empty for ones that weren't
+func WriteCSV(w io.Writer, records []gps.GPSRecord, matches []Match) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(CSVColumns); err != nil {
+		return err
+	}
+	f := func(v float64, prec int) string { return strconv.FormatFloat(v, 'f', prec, 64) }
+	for i, gr := range records {
+		row := []string{gr.Time().Format(time.RFC3339Nano), f(gr.Lat, 7), f(gr.Long, 7), "", "", "", "", ""}
+		if i < len(matches) && matches[i].Matched {
+			m := matches[i]
+			copy(row[3:], []string{f(m.Lat, 7), f(m.Long, 7), strconv.FormatInt(m.WayID, 10), m.Name, f(m.Distance, 1)})
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
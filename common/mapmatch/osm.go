@@ -0,0 +1,209 @@
+package mapmatch
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Node is an OpenStreetMap node's position
+type Node struct {
+	Lat, Long float64
+}
+
+// Oneway is which way a road can be driven
+type Oneway int
+
+const (
+	BothWays Oneway = iota
+	Forward         // in the order of the way's nodes
+	Backward        // against it, oneway=-1
+)
+
+// Way is a drivable OpenStreetMap way
+type Way struct {
+	ID      int64
+	Name    string // the name tag, or ref for roads with only a number
+	Highway string
+	Oneway  Oneway
+	Nodes   []int64
+}
+
+// Data is the roads from an extract and the nodes they go through
+type Data struct {
+	Nodes map[int64]Node
+	Ways  []Way
+}
+
+// Drivable is the highway tags a car can use
+var Drivable = map[string]bool{
+	"motorway": true, "trunk": true, "primary": true, "secondary": true, "tertiary": true,
+	"motorway_link": true, "trunk_link": true, "primary_link": true, "secondary_link": true,
+	"tertiary_link": true, "unclassified": true, "residential": true, "living_street": true,
+	"service": true, "road": true,
+}
+
+// newWay makes a way from its tags, false if it isn't a drivable road
+func newWay(id int64, tags map[string]string, nodes []int64) (Way, bool) {
+	highway := tags["highway"]
+	if !Drivable[highway] || len(nodes) < 2 || tags["area"] == "yes" {
+		return Way{}, false
+	}
+	w := Way{ID: id, Name: tags["name"], Highway: highway, Nodes: nodes}
+	if w.Name == "" {
+		w.Name = tags["ref"]
+	}
+	switch tags["oneway"] {
+	case "yes", "true", "1":
+		w.Oneway = Forward
+	case "-1", "reverse":
+		w.Oneway = Backward
+	case "no", "false", "0":
+	default:
+		if highway == "motorway" || tags["junction"] == "roundabout" {
+			w.Oneway = Forward
+		}
+	}
+	return w, true
+}
+
+// nodeTable holds every node of an extract until the roads pick theirs out,
+// as parallel slices sorted by id at a fraction of a map's size
+type nodeTable struct {
+	ids   []int64
+	nodes []Node
+}
+
+func (t *nodeTable) add(id int64, n Node) {
+	t.ids = append(t.ids, id)
+	t.nodes = append(t.nodes, n)
+}
+
+func (t *nodeTable) Len() int           { return len(t.ids) }
+func (t *nodeTable) Less(i, j int) bool { return t.ids[i] < t.ids[j] }
+func (t *nodeTable) Swap(i, j int) {
+	t.ids[i], t.ids[j] = t.ids[j], t.ids[i]
+	t.nodes[i], t.nodes[j] = t.nodes[j], t.nodes[i]
+}
+
+// get finds a node once the table is sorted
+func (t *nodeTable) get(id int64) (Node, bool) {
+	i := sort.Search(len(t.ids), func(i int) bool { return t.ids[i] >= id })
+	if i == len(t.ids) || t.ids[i] != id {
+		return Node{}, false
+	}
+	return t.nodes[i], true
+}
+
+// keepUsed sets the nodes to the ones a road goes through
+func (d *Data) keepUsed(t *nodeTable) {
+	// extracts are written in id order, so this is usually only a check
+	if !sort.IsSorted(t) {
+		sort.Stable(t)
+	}
+	d.Nodes = make(map[int64]Node)
+	for _, w := range d.Ways {
+		for _, id := range w.Nodes {
+			if n, ok := t.get(id); ok {
+				d.Nodes[id] = n
+			}
+		}
+	}
+}
+
+type xmlTag struct {
+	K string `xml:"k,attr"`
+	V string `xml:"v,attr"`
+}
+
+type xmlNode struct {
+	ID  int64   `xml:"id,attr"`
+	Lat float64 `xml:"lat,attr"`
+	Lon float64 `xml:"lon,attr"`
+}
+
+type xmlWay struct {
+	ID   int64 `xml:"id,attr"`
+	Refs []struct {
+		Ref int64 `xml:"ref,attr"`
+	} `xml:"nd"`
+	Tags []xmlTag `xml:"tag"`
+}
+
+// ReadXML reads the roads from an .osm file
+func ReadXML(r io.Reader) (*Data, error) {
+	d := &Data{}
+	var nodes nodeTable
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch se.Name.Local {
+		case "node":
+			var n xmlNode
+			if err := dec.DecodeElement(&n, &se); err != nil {
+				return nil, err
+			}
+			nodes.add(n.ID, Node{n.Lat, n.Lon})
+		case "way":
+			var xw xmlWay
+			if err := dec.DecodeElement(&xw, &se); err != nil {
+				return nil, err
+			}
+			tags := make(map[string]string, len(xw.Tags))
+			for _, t := range xw.Tags {
+				tags[t.K] = t.V
+			}
+			nodes := make([]int64, len(xw.Refs))
+			for i, nd := range xw.Refs {
+				nodes[i] = nd.Ref
+			}
+			if w, ok := newWay(xw.ID, tags, nodes); ok {
+				d.Ways = append(d.Ways, w)
+			}
+		}
+	}
+	d.keepUsed(&nodes)
+	return d, nil
+}
+
+// ReadFile reads an .osm, .osm.gz or .osm.pbf extract
+func ReadFile(path string) (*Data, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.HasSuffix(strings.ToLower(path), ".pbf") {
+		return ReadPBF(f)
+	}
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if head, _ := br.Peek(2); len(head) == 2 && head[0] == 0x1f && head[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	d, err := ReadXML(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return d, nil
+}
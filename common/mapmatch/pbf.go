@@ -0,0 +1,493 @@
+package mapmatch
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// the PBF format (https://wiki.openstreetmap.org/wiki/PBF_Format) decoded by
+// hand, it's only a few protobuf messages and the roads need fewer fields
+
+// maxBlob is the largest blob the format allows
+const maxBlob = 32 << 20
+
+var errProto = errors.New("bad protobuf")
+
+// proto reads protobuf fields off the front of a message
+type proto struct {
+	b []byte
+}
+
+func (p *proto) varint() (uint64, error) {
+	v, n := binary.Uvarint(p.b)
+	if n <= 0 {
+		return 0, errProto
+	}
+	p.b = p.b[n:]
+	return v, nil
+}
+
+// next is the next field's number and wire type, false at the end
+func (p *proto) next() (field int, wire int, ok bool, err error) {
+	if len(p.b) == 0 {
+		return 0, 0, false, nil
+	}
+	key, err := p.varint()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return int(key >> 3), int(key & 7), true, nil
+}
+
+func (p *proto) bytes() ([]byte, error) {
+	n, err := p.varint()
+	if err != nil || n > uint64(len(p.b)) {
+		return nil, errProto
+	}
+	v := p.b[:n]
+	p.b = p.b[n:]
+	return v, nil
+}
+
+// skip passes over a field of the wire type
+func (p *proto) skip(wire int) error {
+	var err error
+	switch wire {
+	case 0:
+		_, err = p.varint()
+	case 1:
+		if len(p.b) < 8 {
+			return errProto
+		}
+		p.b = p.b[8:]
+	case 2:
+		_, err = p.bytes()
+	case 5:
+		if len(p.b) < 4 {
+			return errProto
+		}
+		p.b = p.b[4:]
+	default:
+		return errProto
+	}
+	return err
+}
+
+// unzigzag decodes a sint64
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// varints reads a repeated varint field, packed or not
+func (p *proto) varints(wire int, dst []uint64) ([]uint64, error) {
+	if wire == 0 {
+		v, err := p.varint()
+		return append(dst, v), err
+	}
+	b, err := p.bytes()
+	if err != nil {
+		return dst, err
+	}
+	packed := proto{b}
+	for len(packed.b) > 0 {
+		v, err := packed.varint()
+		if err != nil {
+			return dst, err
+		}
+		dst = append(dst, v)
+	}
+	return dst, nil
+}
+
+// pbfReader reads an extract in two passes, the roads and then only the
+// nodes they go through, so the rest of the extract's nodes are never held
+type pbfReader struct {
+	d      *Data
+	ways   bool    // the first pass
+	needed []int64 // sorted, the nodes the roads go through
+}
+
+// ReadPBF reads the roads from an .osm.pbf file
+func ReadPBF(r io.ReadSeeker) (*Data, error) {
+	pr := &pbfReader{d: &Data{Nodes: map[int64]Node{}}, ways: true}
+	if err := pr.pass(bufio.NewReader(r)); err != nil {
+		return nil, err
+	}
+	for _, w := range pr.d.Ways {
+		pr.needed = append(pr.needed, w.Nodes...)
+	}
+	sort.Slice(pr.needed, func(i, j int) bool { return pr.needed[i] < pr.needed[j] })
+	unique := pr.needed[:0]
+	for i, id := range pr.needed {
+		if i == 0 || id != pr.needed[i-1] {
+			unique = append(unique, id)
+		}
+	}
+	pr.needed = unique
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	pr.ways = false
+	if err := pr.pass(bufio.NewReader(r)); err != nil {
+		return nil, err
+	}
+	return pr.d, nil
+}
+
+// pass reads the file's data blocks
+func (pr *pbfReader) pass(r io.Reader) error {
+	var size [4]byte
+	for {
+		if _, err := io.ReadFull(r, size[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		n := binary.BigEndian.Uint32(size[:])
+		if n > 64<<10 {
+			return fmt.Errorf("blob header of %d bytes is too big", n)
+		}
+		header := make([]byte, n)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return err
+		}
+		kind, dataSize, err := blobHeader(header)
+		if err != nil {
+			return err
+		}
+		blob := make([]byte, dataSize)
+		if _, err := io.ReadFull(r, blob); err != nil {
+			return err
+		}
+		if kind != "OSMData" {
+			continue
+		}
+		block, err := blobData(blob)
+		if err != nil {
+			return err
+		}
+		if err := pr.primitiveBlock(block); err != nil {
+			return err
+		}
+	}
+}
+
+// addNode keeps a node the roads go through
+func (pr *pbfReader) addNode(id int64, n Node) {
+	i := sort.Search(len(pr.needed), func(i int) bool { return pr.needed[i] >= id })
+	if i < len(pr.needed) && pr.needed[i] == id {
+		pr.d.Nodes[id] = n
+	}
+}
+
+func blobHeader(b []byte) (kind string, size int, err error) {
+	p := proto{b}
+	for {
+		field, wire, ok, err := p.next()
+		if err != nil || !ok {
+			return kind, size, err
+		}
+		switch {
+		case field == 1 && wire == 2:
+			v, err := p.bytes()
+			if err != nil {
+				return "", 0, err
+			}
+			kind = string(v)
+		case field == 3 && wire == 0:
+			v, err := p.varint()
+			if err != nil {
+				return "", 0, err
+			}
+			if v > maxBlob {
+				return "", 0, fmt.Errorf("blob of %d bytes is too big", v)
+			}
+			size = int(v)
+		default:
+			if err := p.skip(wire); err != nil {
+				return "", 0, err
+			}
+		}
+	}
+}
+
+// blobData is a blob's contents, uncompressed
+func blobData(b []byte) ([]byte, error) {
+	p := proto{b}
+	for {
+		field, wire, ok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("blob has no data this reader understands, only raw and zlib are")
+		}
+		switch {
+		case field == 1 && wire == 2:
+			return p.bytes()
+		case field == 3 && wire == 2:
+			z, err := p.bytes()
+			if err != nil {
+				return nil, err
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(z))
+			if err != nil {
+				return nil, err
+			}
+			defer zr.Close()
+			return io.ReadAll(io.LimitReader(zr, maxBlob))
+		default:
+			if err := p.skip(wire); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// block is a PrimitiveBlock's string table and coordinate scaling
+type block struct {
+	strings               [][]byte
+	granularity           int64
+	latOffset, longOffset int64
+}
+
+func (b block) coord(v, offset int64) float64 {
+	return 1e-9 * float64(offset+b.granularity*v)
+}
+
+func (pr *pbfReader) primitiveBlock(buf []byte) error {
+	b := block{granularity: 100}
+	var groups [][]byte
+	p := proto{buf}
+	for {
+		field, wire, ok, err := p.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		switch {
+		case field == 1 && wire == 2:
+			st, err := p.bytes()
+			if err != nil {
+				return err
+			}
+			sp := proto{st}
+			for {
+				f, w, ok, err := sp.next()
+				if err != nil {
+					return err
+				}
+				if !ok {
+					break
+				}
+				if f != 1 || w != 2 {
+					if err := sp.skip(w); err != nil {
+						return err
+					}
+					continue
+				}
+				s, err := sp.bytes()
+				if err != nil {
+					return err
+				}
+				b.strings = append(b.strings, s)
+			}
+		case field == 2 && wire == 2:
+			g, err := p.bytes()
+			if err != nil {
+				return err
+			}
+			groups = append(groups, g)
+		case (field == 17 || field == 19 || field == 20) && wire == 0:
+			v, err := p.varint()
+			if err != nil {
+				return err
+			}
+			switch field {
+			case 17:
+				b.granularity = int64(v)
+			case 19:
+				b.latOffset = int64(v)
+			case 20:
+				b.longOffset = int64(v)
+			}
+		default:
+			if err := p.skip(wire); err != nil {
+				return err
+			}
+		}
+	}
+	// the string table can come after the groups, so they're read last
+	for _, g := range groups {
+		if err := pr.primitiveGroup(b, g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pr *pbfReader) primitiveGroup(b block, buf []byte) error {
+	p := proto{buf}
+	for {
+		field, wire, ok, err := p.next()
+		if err != nil || !ok {
+			return err
+		}
+		// nodes are 1 and 2, ways 3, each read in its own pass
+		if wire != 2 || field < 1 || field > 3 || (field == 3) != pr.ways {
+			if err := p.skip(wire); err != nil {
+				return err
+			}
+			continue
+		}
+		msg, err := p.bytes()
+		if err != nil {
+			return err
+		}
+		switch field {
+		case 1:
+			err = pr.node(b, msg)
+		case 2:
+			err = pr.denseNodes(b, msg)
+		case 3:
+			err = pr.way(b, msg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (pr *pbfReader) node(b block, buf []byte) error {
+	var id, lat, long int64
+	p := proto{buf}
+	for {
+		field, wire, ok, err := p.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if wire != 0 || (field != 1 && field != 8 && field != 9) {
+			if err := p.skip(wire); err != nil {
+				return err
+			}
+			continue
+		}
+		v, err := p.varint()
+		if err != nil {
+			return err
+		}
+		switch field {
+		case 1:
+			id = unzigzag(v)
+		case 8:
+			lat = unzigzag(v)
+		case 9:
+			long = unzigzag(v)
+		}
+	}
+	pr.addNode(id, Node{b.coord(lat, b.latOffset), b.coord(long, b.longOffset)})
+	return nil
+}
+
+func (pr *pbfReader) denseNodes(b block, buf []byte) error {
+	var ids, lats, longs []uint64
+	p := proto{buf}
+	for {
+		field, wire, ok, err := p.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		switch field {
+		case 1:
+			ids, err = p.varints(wire, ids)
+		case 8:
+			lats, err = p.varints(wire, lats)
+		case 9:
+			longs, err = p.varints(wire, longs)
+		default:
+			err = p.skip(wire)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(lats) != len(ids) || len(longs) != len(ids) {
+		return fmt.Errorf("dense nodes have %d ids, %d lats and %d longs", len(ids), len(lats), len(longs))
+	}
+	var id, lat, long int64
+	for i := range ids {
+		id += unzigzag(ids[i])
+		lat += unzigzag(lats[i])
+		long += unzigzag(longs[i])
+		pr.addNode(id, Node{b.coord(lat, b.latOffset), b.coord(long, b.longOffset)})
+	}
+	return nil
+}
+
+func (pr *pbfReader) way(b block, buf []byte) error {
+	var id int64
+	var keys, vals, refs []uint64
+	p := proto{buf}
+	for {
+		field, wire, ok, err := p.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		switch field {
+		case 1:
+			var v uint64
+			if v, err = p.varint(); err == nil {
+				id = int64(v)
+			}
+		case 2:
+			keys, err = p.varints(wire, keys)
+		case 3:
+			vals, err = p.varints(wire, vals)
+		case 8:
+			refs, err = p.varints(wire, refs)
+		default:
+			err = p.skip(wire)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(keys) != len(vals) {
+		return fmt.Errorf("way %d has %d keys and %d values", id, len(keys), len(vals))
+	}
+	tags := make(map[string]string, len(keys))
+	for i := range keys {
+		if keys[i] >= uint64(len(b.strings)) || vals[i] >= uint64(len(b.strings)) {
+			return fmt.Errorf("way %d has a tag past the string table", id)
+		}
+		tags[string(b.strings[keys[i]])] = string(b.strings[vals[i]])
+	}
+	nodes := make([]int64, len(refs))
+	var ref int64
+	for i, r := range refs {
+		ref += unzigzag(r)
+		nodes[i] = ref
+	}
+	if w, ok := newWay(id, tags, nodes); ok {
+		pr.d.Ways = append(pr.d.Ways, w)
+	}
+	return nil
+}
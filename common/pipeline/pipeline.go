@@ -0,0 +1,180 @@
+package pipeline
+
+// streaming track conversion: a Source read a record at a time, Filters
+//  that drop records, a Simplifier that thins them and a Sink that writes
+//	them, so a month of 10Hz logs converts on a Pi without holding it all.
+//	Gzipped input is decompressed on the fly and Run reports progress through
+//	the file as it goes
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+)
+
+// Source gives records one at a time, io.EOF after the last
+type Source interface {
+	Next() (gps.GPSRecord, error)
+}
+
+// Sink takes records one at a time, Close finishes the output
+type Sink interface {
+	Write(gr gps.GPSRecord) error
+	Close() error
+}
+
+// Filter reports whether to keep a record
+type Filter func(gr gps.GPSRecord) bool
+
+// HasFix drops the zeros and lat == long records the receiver logs before it
+// has a fix
+func HasFix(gr gps.GPSRecord) bool {
+	return gr.Lat != 0.0 && gr.Lat != gr.Long
+}
+
+// Simplifier keeps the first record, then one every Interval and any where
+// the track turns more than 5 degrees
+type Simplifier struct {
+	Interval time.Duration
+	prev     gps.GPSRecord // the kept record before last
+	last     gps.GPSRecord
+	started  bool
+}
+
+// NewSimplifier returns a simplifier keeping a record at least every
+// interval
+func NewSimplifier(interval time.Duration) *Simplifier {
+	return &Simplifier{Interval: interval}
+}
+
+// Keep reports whether gr is kept, it must be called with every record in
+// order
+func (s *Simplifier) Keep(gr gps.GPSRecord) bool {
+	if !s.started {
+		s.started = true
+		s.prev, s.last = gr, gr
+		return true
+	}
+	if gr.UnixMicro > s.last.UnixMicro+uint64(s.Interval/time.Microsecond) || turned(s.prev, s.last, gr) {
+		s.prev, s.last = s.last, gr
+		return true
+	}
+	return false
+}
+
+// turned is whether the track bends more than 5 degrees at mid
+func turned(from, mid, to gps.GPSRecord) bool {
+	if from == mid || gps.Distance(mid, to) < 1 {
+		return false
+	}
+	diff := math.Abs(gps.Bearing(from, mid) - gps.Bearing(mid, to))
+	if diff > 180 {
+		diff = 360 - diff
+	}
+	return diff > 5.0
+}
+
+// Stats is how far a run has got
+type Stats struct {
+	Read    int // records from the source
+	Written int // records past the filters and simplifier
+	// Done and Size are bytes of the input file read and in total, zero if
+	// the source isn't a File
+	Done, Size int64
+}
+
+func (s Stats) String() string {
+	msg := fmt.Sprintf("read %d records, kept %d", s.Read, s.Written)
+	if s.Size > 0 {
+		msg += fmt.Sprintf(", %.0f%% through", 100*float64(s.Done)/float64(s.Size))
+	}
+	return msg
+}
+
+// Pipeline moves records from Source to Sink
+type Pipeline struct {
+	Source  Source
+	Filters []Filter
+	// Simplifier thins what's left after the filters, nil keeps it all
+	Simplifier *Simplifier
+	Sink       Sink
+	// Progress is called every ProgressEvery while running, if both are set
+	Progress      func(Stats)
+	ProgressEvery time.Duration
+}
+
+func (p *Pipeline) stats(s Stats) Stats {
+	if f, ok := p.Source.(*File); ok {
+		s.Done, s.Size = f.Progress()
+	}
+	return s
+}
+
+// Run copies every record through, then closes the sink. The sink is closed
+// even if the source fails part way, so what was read so far is written
+func (p *Pipeline) Run() (Stats, error) {
+	var s Stats
+	next := time.Now().Add(p.ProgressEvery)
+	var err error
+	for {
+		var gr gps.GPSRecord
+		if gr, err = p.Source.Next(); err != nil {
+			break
+		}
+		s.Read++
+		if p.Progress != nil && p.ProgressEvery > 0 && s.Read%1024 == 0 && time.Now().After(next) {
+			p.Progress(p.stats(s))
+			next = time.Now().Add(p.ProgressEvery)
+		}
+		if !p.keep(gr) {
+			continue
+		}
+		if err = p.Sink.Write(gr); err != nil {
+			break
+		}
+		s.Written++
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	if cerr := p.Sink.Close(); err == nil {
+		err = cerr
+	}
+	return p.stats(s), err
+}
+
+func (p *Pipeline) keep(gr gps.GPSRecord) bool {
+	for _, f := range p.Filters {
+		if !f(gr) {
+			return false
+		}
+	}
+	return p.Simplifier == nil || p.Simplifier.Keep(gr)
+}
+
+// LocateWaypoints places the waypoints marked without a fix on the track in
+// the file at path, reading it through once before the conversion proper.
+// Bad rows are passed over quietly, the conversion reports them. It returns
+// the waypoints that have a position and how many couldn't be placed
+func LocateWaypoints(path, format string, waypoints []waypoint.Waypoint, maxGap time.Duration) ([]waypoint.Waypoint, int, error) {
+	l := waypoint.NewLocator(waypoints, maxGap)
+	if !l.Pending() {
+		placed, unplaced := l.Waypoints()
+		return placed, unplaced, nil
+	}
+	src, err := Open(path, format, func(error) error { return nil })
+	if err != nil {
+		return nil, 0, err
+	}
+	defer src.Close()
+	p := &Pipeline{Source: src, Filters: []Filter{HasFix}, Sink: l}
+	if _, err := p.Run(); err != nil {
+		return nil, 0, err
+	}
+	placed, unplaced := l.Waypoints()
+	return placed, unplaced, nil
+}
@@ -0,0 +1,263 @@
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/gpstest"
+	"github.com/samiam2013/raspigogps/common/gpx"
+	"github.com/samiam2013/raspigogps/common/trackio"
+	"github.com/samiam2013/raspigogps/common/tracklog"
+	"github.com/samiam2013/raspigogps/common/trackstore"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+)
+
+var start = gpstest.Start
+
+// testRecords is gpstest's records after the receiver's zeros before the
+// first fix
+func testRecords(n int) []gps.GPSRecord {
+	zero := gps.GPSRecord{UnixMicro: uint64(start.Add(-time.Second).UnixMicro())}
+	return append([]gps.GPSRecord{zero}, gpstest.Records(n, 0)...)
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func csvLog(t *testing.T, records []gps.GPSRecord) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := tracklog.WriteAll(&buf, records); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func storeFile(t *testing.T, records []gps.GPSRecord) string {
+	t.Helper()
+	w := trackstore.NewWriter(t.TempDir())
+	for _, gr := range records {
+		if err := w.Write(gr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := w.Path()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// sliceSink keeps what it's given
+type sliceSink struct {
+	records []gps.GPSRecord
+	closed  bool
+}
+
+func (s *sliceSink) Write(gr gps.GPSRecord) error {
+	s.records = append(s.records, gr)
+	return nil
+}
+
+func (s *sliceSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestOpen(t *testing.T) {
+	records := testRecords(100)
+	gpxDoc := new(bytes.Buffer)
+	if err := gpx.Write(gpxDoc, records[1:], gpx.DefaultOptions()); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		path   string
+		format string
+		want   int
+	}{
+		{"csv", writeFile(t, "gps.log", csvLog(t, records)), trackio.CSV, len(records)},
+		{"gzipped csv", writeFile(t, "gps.log.gz", gzipped(t, csvLog(t, records))), trackio.CSV, len(records)},
+		{"store", storeFile(t, records), trackio.Store, len(records)},
+		{"gzipped gpx", writeFile(t, "track.gpx.gz", gzipped(t, gpxDoc.Bytes())), trackio.GPX, len(records) - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := Open(tt.path, "", nil)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer src.Close()
+			if src.Format != tt.format {
+				t.Errorf("Format = %s, want %s", src.Format, tt.format)
+			}
+			sink := &sliceSink{}
+			stats, err := (&Pipeline{Source: src, Sink: sink}).Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if stats.Read != tt.want || len(sink.records) != tt.want || !sink.closed {
+				t.Errorf("Run() = %+v, sink got %d, want %d", stats, len(sink.records), tt.want)
+			}
+			if stats.Size == 0 || stats.Done != stats.Size {
+				t.Errorf("progress at the end = %d of %d bytes", stats.Done, stats.Size)
+			}
+		})
+	}
+}
+
+func TestOpenBadRows(t *testing.T) {
+	data := string(csvLog(t, testRecords(3)))
+	data = strings.Replace(data, "\n", "\nnot,a,row\n", 3) // after the version line, header and first row
+	path := writeFile(t, "gps.log", []byte(data))
+
+	var skipped []string
+	src, err := Open(path, "", func(err error) error {
+		skipped = append(skipped, err.Error())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer src.Close()
+	stats, err := (&Pipeline{Source: src, Sink: &sliceSink{}}).Run()
+	if err != nil || stats.Read != 4 || len(skipped) != 3 {
+		t.Errorf("Run() = %+v, %v, skipped %q", stats, err, skipped)
+	}
+
+	strict, err := Open(path, "", nil)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer strict.Close()
+	sink := &sliceSink{}
+	if _, err := (&Pipeline{Source: strict, Sink: sink}).Run(); err == nil || !sink.closed {
+		t.Errorf("strict Run() error = %v, sink closed %v", err, sink.closed)
+	}
+
+	if _, err := Open(writeFile(t, "x.trk.gz", gzipped(t, []byte("RGPSTRK1"))), "", nil); err == nil {
+		t.Error("Open() of a gzipped store didn't fail")
+	}
+}
+
+func TestSimplifier(t *testing.T) {
+	// north for 30 seconds then east, a record a second
+	var records []gps.GPSRecord
+	for i := 0; i < 60; i++ {
+		gr := gps.GPSRecord{UnixMicro: uint64(start.Add(time.Duration(i) * time.Second).UnixMicro())}
+		if i < 30 {
+			gr.Lat, gr.Long = 38.6+float64(i)*1e-4, -90.2
+		} else {
+			gr.Lat, gr.Long = 38.6+29e-4, -90.2+float64(i-29)*1e-4
+		}
+		records = append(records, gr)
+	}
+	s := NewSimplifier(10 * time.Second)
+	var kept []int
+	for i, gr := range records {
+		if s.Keep(gr) {
+			kept = append(kept, i)
+		}
+	}
+	// every 11 seconds, strictly more than the interval, plus the corner (30
+	// is already a few degrees off 11 to 22) and the first record after it
+	want := []int{0, 11, 22, 30, 31, 42, 53}
+	if len(kept) != len(want) {
+		t.Fatalf("kept %v, want %v", kept, want)
+	}
+	for i := range want {
+		if kept[i] != want[i] {
+			t.Fatalf("kept %v, want %v", kept, want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	records := testRecords(100)
+	for _, format := range []string{trackio.CSV, trackio.GPX, trackio.KML, trackio.KMZ, trackio.GeoJSON} {
+		t.Run(format, func(t *testing.T) {
+			path := writeFile(t, "gps.log.gz", gzipped(t, csvLog(t, records)))
+			src, err := Open(path, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Close()
+			var out bytes.Buffer
+			sink, err := trackio.NewWriter(&out, format, trackio.DefaultOptions("test"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			progress := 0
+			p := &Pipeline{
+				Source:        src,
+				Filters:       []Filter{HasFix},
+				Simplifier:    NewSimplifier(10 * time.Second),
+				Sink:          sink,
+				Progress:      func(Stats) { progress++ },
+				ProgressEvery: time.Nanosecond,
+			}
+			stats, err := p.Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			// the zeros go, then one every 11 seconds of the rest
+			if stats.Read != 101 || stats.Written != 10 {
+				t.Errorf("Run() = %+v, want 101 read and 10 written", stats)
+			}
+			got, _, err := trackio.Read(&out, format, trackio.Strict)
+			if err != nil {
+				t.Fatalf("output doesn't read back: %v", err)
+			}
+			if len(got) != 10 || got[1].Lat != records[12].Lat {
+				t.Errorf("output has %d records, want 10", len(got))
+			}
+			if progress != 0 {
+				t.Errorf("progress called %d times for fewer than 1024 records", progress)
+			}
+		})
+	}
+}
+
+func TestLocateWaypoints(t *testing.T) {
+	path := writeFile(t, "gps.log.gz", gzipped(t, csvLog(t, testRecords(100))))
+	waypoints := []waypoint.Waypoint{
+		{Time: start.Add(10500 * time.Millisecond), Number: 1},
+		{Time: start.Add(20 * time.Second), Lat: 40, Long: -91, Number: 2},
+		{Time: start.Add(time.Hour), Number: 3},
+	}
+	got, unplaced, err := LocateWaypoints(path, "", waypoints, waypoint.DefaultMaxGap)
+	if err != nil {
+		t.Fatalf("LocateWaypoints() error = %v", err)
+	}
+	if unplaced != 1 || len(got) != 2 || !got[0].Interpolated || got[1].Interpolated {
+		t.Fatalf("LocateWaypoints() = %+v, %d unplaced", got, unplaced)
+	}
+	if want := 38.6 + 10.5e-4; got[0].Lat-want > 1e-9 || want-got[0].Lat > 1e-9 || got[0].Long != -90.2 {
+		t.Errorf("WP1 at %v,%v, want %v,-90.2", got[0].Lat, got[0].Long, want)
+	}
+}
@@ -0,0 +1,172 @@
+package pipeline
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/trackio"
+	"github.com/samiam2013/raspigogps/common/tracklog"
+	"github.com/samiam2013/raspigogps/common/trackstore"
+)
+
+// countingReader counts the bytes read through it, for progress
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// File is a Source reading a track file. CSV logs are streamed a row at a
+// time and track stores a block at a time; the other formats trackio reads
+// are read whole when opened, they're never the multi-gigabyte ones
+type File struct {
+	// Format the file was read as, see trackio
+	Format string
+
+	f       *os.File
+	count   *countingReader
+	size    int64
+	bad     func(error) error
+	csv     *tracklog.Reader
+	store   *trackstore.Store
+	block   int   // next store block to read
+	done    int64 // store bytes read
+	records []gps.GPSRecord
+}
+
+// Open opens a track file, in format or when that's empty the one its
+// contents or extension suggest. A gzipped file is decompressed as it's read,
+// except a track store which has to be read in place. bad is called with
+// each row, point or block that can't be used; Next stops with bad's error
+// if it returns one, a nil bad stops at the first
+func Open(path, format string, bad func(error) error) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if bad == nil {
+		bad = func(err error) error { return err }
+	}
+	file := &File{f: f, count: &countingReader{r: f}, size: fi.Size(), bad: bad}
+	if err := file.open(path, format); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file, nil
+}
+
+func (file *File) open(path, format string) error {
+	br := bufio.NewReaderSize(file.count, 64*1024)
+	r := br
+	gzipped := false
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		r, gzipped = bufio.NewReaderSize(gz, 64*1024), true
+		path = strings.TrimSuffix(path, ".gz")
+	}
+	if format == "" {
+		head, _ := r.Peek(512)
+		if format = trackio.Sniff(head); format == "" {
+			format = trackio.FromExt(path)
+		}
+		if format == "" {
+			return fmt.Errorf("can't tell what format it's in")
+		}
+	}
+	file.Format = format
+
+	var err error
+	switch format {
+	case trackio.Store:
+		if gzipped {
+			return fmt.Errorf("a gzipped track store can't be read in place, gunzip it first")
+		}
+		if file.store, err = trackstore.Open(file.f.Name()); err != nil {
+			return err
+		}
+		if file.store.Skipped > 0 || file.store.Torn > 0 {
+			return file.bad(fmt.Errorf("%d corrupt bytes and %d bytes of torn writes",
+				file.store.Skipped, file.store.Torn))
+		}
+	case trackio.CSV:
+		file.csv, err = tracklog.NewReader(r)
+	default:
+		file.records, err = trackio.ReadFunc(r, format, file.bad)
+	}
+	return err
+}
+
+// Next returns the next record, io.EOF after the last
+func (file *File) Next() (gps.GPSRecord, error) {
+	switch {
+	case file.csv != nil:
+		for {
+			gr, err := file.csv.Read()
+			if _, ok := err.(*tracklog.RowError); ok {
+				if err := file.bad(err); err != nil {
+					return gr, err
+				}
+				continue
+			}
+			return gr, err
+		}
+	case file.store != nil:
+		for len(file.records) == 0 {
+			if file.block == len(file.store.Blocks) {
+				return gps.GPSRecord{}, io.EOF
+			}
+			b := file.store.Blocks[file.block]
+			file.block++
+			file.done = b.Offset + b.Size
+			records, err := file.store.ReadBlock(b)
+			if err != nil {
+				if err := file.bad(err); err != nil {
+					return gps.GPSRecord{}, err
+				}
+				continue
+			}
+			file.records = records
+		}
+	}
+	if len(file.records) == 0 {
+		return gps.GPSRecord{}, io.EOF
+	}
+	gr := file.records[0]
+	file.records = file.records[1:]
+	return gr, nil
+}
+
+// Progress is bytes of the file read so far and its size. For a gzipped
+// file both are compressed bytes
+func (file *File) Progress() (done, size int64) {
+	if file.store != nil {
+		return file.done, file.size
+	}
+	return file.count.n, file.size
+}
+
+// Close closes the file
+func (file *File) Close() error {
+	if file.store != nil {
+		file.store.Close()
+	}
+	return file.f.Close()
+}
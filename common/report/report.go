@@ -0,0 +1,155 @@
+package report
+
+// trip reports: one HTML file with everything inline, a map of the track
+//  drawn as SVG in a local projection, the trip's stats and speed and
+//	altitude profiles, so it opens offline on a phone and can be mailed or
+//	kept with the logs
+
+import (
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/chart"
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/tripstats"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+)
+
+// Options controls what's shown and how
+type Options struct {
+	Title string
+	Units Units
+	// Location times are shown in
+	Location *time.Location
+}
+
+// DefaultOptions shows imperial units in local time
+func DefaultOptions() Options {
+	return Options{Title: "Trip report", Units: Imperial, Location: time.Local}
+}
+
+type row struct {
+	Name, Value string
+}
+
+type wpRow struct {
+	Name, Time, Position, Note string
+}
+
+type page struct {
+	Title     string
+	Subtitle  string
+	Map       template.HTML
+	Stats     []row
+	Waypoints []wpRow
+	Charts    []template.HTML
+	Generated string
+}
+
+var pageTmpl = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Roboto, sans-serif; margin: 0 auto; max-width: 860px; padding: 12px; color: #222; background: #fff; }
+h1 { font-size: 1.4em; margin: 0.2em 0; }
+h2 { font-size: 1.1em; margin: 1.2em 0 0.4em; }
+p.sub { color: #666; margin: 0 0 1em; }
+svg { width: 100%; height: auto; display: block; }
+svg.map { border: 1px solid #ccc; border-radius: 4px; }
+table { border-collapse: collapse; width: 100%; }
+td, th { padding: 4px 8px; border-bottom: 1px solid #eee; text-align: left; vertical-align: top; }
+td.v { text-align: right; font-variant-numeric: tabular-nums; }
+footer { color: #999; font-size: 0.8em; margin-top: 2em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="sub">{{.Subtitle}}</p>
+{{.Map}}
+<h2>Stats</h2>
+<table>
+{{range .Stats}}<tr><td>{{.Name}}</td><td class="v">{{.Value}}</td></tr>
+{{end}}</table>
+{{if .Waypoints}}<h2>Waypoints</h2>
+<table>
+<tr><th>#</th><th>Time</th><th>Position</th><th></th></tr>
+{{range .Waypoints}}<tr><td>{{.Name}}</td><td>{{.Time}}</td><td>{{.Position}}</td><td>{{.Note}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Charts}}<h2>Profiles</h2>
+{{range .Charts}}{{.}}
+{{end}}{{end}}<footer>Generated {{.Generated}} by raspigogps</footer>
+</body>
+</html>
+`))
+
+// Write writes the report for records in time order and the waypoints
+// marked on the way
+func Write(w io.Writer, records []gps.GPSRecord, waypoints []waypoint.Waypoint, opts Options) error {
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	u := opts.Units
+	s := tripstats.Compute(records, nil, time.UTC)
+	when := func(t time.Time) string { return t.In(opts.Location).Format("Mon Jan 2 2006 15:04:05 MST") }
+
+	p := page{
+		Title:     opts.Title,
+		Map:       template.HTML(MapSVG(records, waypoints, u)),
+		Generated: when(time.Now()),
+	}
+	if len(records) > 0 {
+		p.Subtitle = when(s.Start) + " to " + s.End.In(opts.Location).Format("15:04:05 MST")
+		p.Stats = []row{
+			{"Start", when(s.Start)},
+			{"End", when(s.End)},
+			{"Duration", FormatDuration(s.Duration())},
+			{"Moving time", FormatDuration(s.Moving)},
+			{"Distance", u.FormatDistance(s.Distance)},
+			{"Max speed", u.FormatSpeed(s.MaxSpeed)},
+			{"Average moving speed", u.FormatSpeed(s.AvgSpeed())},
+		}
+		if s.HasAltitude {
+			p.Stats = append(p.Stats,
+				row{"Elevation gain", u.FormatAltitude(s.Climb)},
+				row{"Elevation loss", u.FormatAltitude(s.Descent)},
+				row{"Lowest / highest", u.FormatAltitude(s.MinAlt) + " / " + u.FormatAltitude(s.MaxAlt)},
+			)
+		}
+		p.Stats = append(p.Stats, row{"Points", strconv.Itoa(s.Records)})
+
+		co := chart.DefaultOptions()
+		co.Speed, co.Distance, co.Altitude = u.SpeedUnit(), u.DistanceUnit(), u.AltitudeUnit()
+		co.Location = opts.Location
+		co.Waypoints = waypoints
+		p.Charts = append(p.Charts, template.HTML(chart.SpeedChart(records, co).SVG()))
+		if s.HasAltitude {
+			co.X = chart.ByDistance
+			p.Charts = append(p.Charts, template.HTML(chart.AltitudeChart(records, co).SVG()))
+		}
+	}
+	for _, wp := range waypoints {
+		r := wpRow{
+			Name:     strconv.Itoa(wp.Number),
+			Time:     wp.Time.In(opts.Location).Format("15:04:05"),
+			Position: formatCoord(wp.Lat, wp.Long),
+		}
+		var notes []string
+		for _, n := range []string{wp.Note, wp.Coords} {
+			if n != "" {
+				notes = append(notes, n)
+			}
+		}
+		if wp.Interpolated {
+			notes = append(notes, "placed on the track, no fix when marked")
+		}
+		r.Note = strings.Join(notes, ", ")
+		p.Waypoints = append(p.Waypoints, r)
+	}
+	return pageTmpl.Execute(w, p)
+}
@@ -0,0 +1,122 @@
+package report
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+)
+
+var start = time.Date(2022, 5, 20, 12, 0, 0, 0, time.UTC)
+
+// trip heads north at 10 m/s for 50s then parks for 10s. Altitude wobbles
+// 2.5m for the first 30s then climbs a meter a second
+func trip() []gps.GPSRecord {
+	var records []gps.GPSRecord
+	lat := 38.6
+	for i := 0; i <= 60; i++ {
+		speed := 10 * 3.6 / 1.852
+		if i > 50 {
+			speed = 0
+		} else if i > 0 {
+			lat += 10 / 110996.0 // meters per degree of latitude there
+		}
+		alt := 100 + 2.5*float64(i%2)
+		if i >= 30 {
+			alt = 100 + float64(i-30)
+		}
+		records = append(records, gps.GPSRecord{
+			GPSTime: start.Add(time.Duration(i) * time.Second),
+			Lat:     lat, Long: -90.2, Alt: alt * 3.28084, Speed: speed,
+		})
+	}
+	return records
+}
+
+func TestUnits(t *testing.T) {
+	tests := []struct {
+		name   string
+		meters float64
+		want   string
+	}{
+		{"imperial", 16093.44, "10.0 mi"},
+		{"imperial", 100, "328 ft"},
+		{"Metric", 12345, "12.3 km"},
+		{"km", 250, "250 m"},
+	}
+	for _, tt := range tests {
+		u, err := ParseUnits(tt.name)
+		if err != nil {
+			t.Fatalf("ParseUnits(%s): %s", tt.name, err.Error())
+		}
+		if got := u.FormatDistance(tt.meters); got != tt.want {
+			t.Errorf("%s FormatDistance(%g) = %s, want %s", tt.name, tt.meters, got, tt.want)
+		}
+	}
+	if _, err := ParseUnits("furlongs"); err == nil {
+		t.Error("ParseUnits(furlongs) didn't fail")
+	}
+	if got := FormatDuration(3*time.Hour + 4*time.Minute + 5*time.Second); got != "3:04:05" {
+		t.Errorf("FormatDuration = %s, want 3:04:05", got)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	records := trip()
+	waypoints := []waypoint.Waypoint{
+		{Time: start.Add(10 * time.Second), Lat: records[10].Lat, Long: -90.2, Number: 1, Coords: "N38 36.0540", Note: "Main St, way 100"},
+		{Time: start.Add(20 * time.Second), Lat: records[20].Lat, Long: -90.2, Number: 2, Interpolated: true},
+	}
+	opts := DefaultOptions()
+	opts.Title = "Arch <run>"
+	opts.Units = Metric
+	opts.Location = time.UTC
+	var b bytes.Buffer
+	if err := Write(&b, records, waypoints, opts); err != nil {
+		t.Fatalf("Write: %s", err.Error())
+	}
+	out := b.String()
+
+	// everything inline, the SVG namespace is the only URL
+	if n := strings.Count(out, "http"); n != strings.Count(out, `xmlns="http://www.w3.org/2000/svg"`) {
+		t.Errorf("%d URLs in the report, want only the SVG namespaces", n)
+	}
+	for _, bad := range []string{"src=", "<link", "<script"} {
+		if strings.Contains(out, bad) {
+			t.Errorf("report has %s", bad)
+		}
+	}
+	svgs := regexp.MustCompile(`(?s)<svg.*?</svg>`).FindAllString(out, -1)
+	if len(svgs) != 3 {
+		t.Errorf("got %d SVGs, want map, speed and altitude", len(svgs))
+	}
+	for _, s := range svgs {
+		d := xml.NewDecoder(strings.NewReader(s))
+		for {
+			if _, err := d.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Errorf("bad SVG: %s", err.Error())
+				break
+			}
+		}
+	}
+	for _, want := range []string{
+		"Arch &lt;run&gt;",
+		"<td>Distance</td><td class=\"v\">500 m</td>",
+		"<td>Duration</td><td class=\"v\">0:01:00</td>",
+		"<td>Moving time</td><td class=\"v\">0:00:50</td>",
+		"Main St, way 100, N38 36.0540",
+		"placed on the track, no fix when marked",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report is missing %s", want)
+		}
+	}
+}
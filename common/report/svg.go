@@ -0,0 +1,156 @@
+package report
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"strings"
+
+	"github.com/samiam2013/raspigogps/common/chart"
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/tripstats"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+)
+
+// the map's size in SVG units, it's scaled to the page by CSS
+const (
+	mapWidth     = 800
+	mapMinHeight = 240
+	mapMaxHeight = 800
+	mapPad       = 24
+)
+
+const earthRadius = 6371008.8 // meters, the mean radius
+
+// projection is an equirectangular projection in meters around the
+// track's middle, close enough to true over a day's drive
+type projection struct {
+	lat0, long0, cos float64
+}
+
+func newProjection(records []gps.GPSRecord) projection {
+	minLat, maxLat := records[0].Lat, records[0].Lat
+	minLong, maxLong := records[0].Long, records[0].Long
+	for _, gr := range records {
+		minLat, maxLat = math.Min(minLat, gr.Lat), math.Max(maxLat, gr.Lat)
+		minLong, maxLong = math.Min(minLong, gr.Long), math.Max(maxLong, gr.Long)
+	}
+	lat0 := (minLat + maxLat) / 2
+	return projection{lat0: lat0, long0: (minLong + maxLong) / 2, cos: math.Cos(lat0 * math.Pi / 180)}
+}
+
+// xy is meters east and south of the middle, SVG's y runs down
+func (p projection) xy(lat, long float64) (x, y float64) {
+	return (long - p.long0) * math.Pi / 180 * p.cos * earthRadius, -(lat - p.lat0) * math.Pi / 180 * earthRadius
+}
+
+// frame maps projected meters into the SVG's box
+type frame struct {
+	proj          projection
+	minX, minY    float64
+	scale         float64 // SVG units per meter
+	offX, offY    float64
+	width, height float64
+}
+
+func newFrame(records []gps.GPSRecord, waypoints []waypoint.Waypoint) frame {
+	f := frame{proj: newProjection(records), width: mapWidth}
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	extend := func(lat, long float64) {
+		x, y := f.proj.xy(lat, long)
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	for _, gr := range records {
+		extend(gr.Lat, gr.Long)
+	}
+	for _, w := range waypoints {
+		extend(w.Lat, w.Long)
+	}
+	// at least 100m across so a parked car isn't infinitely zoomed
+	dx, dy := math.Max(maxX-minX, 100), math.Max(maxY-minY, 100)
+	f.height = math.Max(mapMinHeight, math.Min(mapMaxHeight, mapWidth*dy/dx))
+	f.scale = math.Min((f.width-2*mapPad)/dx, (f.height-2*mapPad)/dy)
+	f.minX, f.minY = minX, minY
+	f.offX = (f.width - (maxX-minX)*f.scale) / 2
+	f.offY = (f.height - (maxY-minY)*f.scale) / 2
+	return f
+}
+
+func (f frame) point(lat, long float64) (x, y float64) {
+	px, py := f.proj.xy(lat, long)
+	return f.offX + (px-f.minX)*f.scale, f.offY + (py-f.minY)*f.scale
+}
+
+// trackPath is the track as path data, broken at gaps and leaving out points
+// less than half a unit from the last one drawn
+func (f frame) trackPath(records []gps.GPSRecord) string {
+	var b strings.Builder
+	lastX, lastY := math.Inf(1), math.Inf(1)
+	for i, gr := range records {
+		x, y := f.point(gr.Lat, gr.Long)
+		gap := i > 0 && gr.Time().Sub(records[i-1].Time()) > tripstats.MaxGap
+		switch {
+		case i == 0 || gap:
+			fmt.Fprintf(&b, "M%.1f,%.1f", x, y)
+		case math.Hypot(x-lastX, y-lastY) < 0.5 && i < len(records)-1:
+			continue
+		default:
+			fmt.Fprintf(&b, "L%.1f,%.1f", x, y)
+		}
+		lastX, lastY = x, y
+	}
+	return b.String()
+}
+
+// scaleBar is a round distance about a fifth of the map wide, with its
+// length in SVG units
+func (f frame) scaleBar(u Units) (label string, length float64) {
+	meters := f.width / 5 / f.scale
+	if meters*u.PerMeter >= 1 {
+		n := chart.Nice(meters * u.PerMeter)
+		return fmt.Sprintf("%g %s", n, u.Distance), n / u.PerMeter * f.scale
+	}
+	n := chart.Nice(meters * u.ShortPerMeter)
+	return fmt.Sprintf("%g %s", n, u.Short), n / u.ShortPerMeter * f.scale
+}
+
+// MapSVG draws the track with start and end markers, numbered waypoints and
+// a scale bar, north up
+func MapSVG(records []gps.GPSRecord, waypoints []waypoint.Waypoint, u Units) string {
+	if len(records) == 0 {
+		return ""
+	}
+	f := newFrame(records, waypoints)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %.0f %.0f" class="map" role="img" aria-label="map of the track">`,
+		f.width, f.height)
+	fmt.Fprintf(&b, `<rect width="%.0f" height="%.0f" fill="#f4f1ea"/>`, f.width, f.height)
+	fmt.Fprintf(&b, `<path d="%s" fill="none" stroke="#fff" stroke-width="7" stroke-linejoin="round" stroke-linecap="round"/>`,
+		f.trackPath(records))
+	fmt.Fprintf(&b, `<path d="%s" fill="none" stroke="#d22" stroke-width="3.5" stroke-linejoin="round" stroke-linecap="round"/>`,
+		f.trackPath(records))
+
+	x, y := f.point(records[0].Lat, records[0].Long)
+	fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="8" fill="#2a2" stroke="#fff" stroke-width="2"><title>Start</title></circle>`, x, y)
+	last := records[len(records)-1]
+	x, y = f.point(last.Lat, last.Long)
+	fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="14" height="14" fill="#222" stroke="#fff" stroke-width="2"><title>End</title></rect>`,
+		x-7, y-7)
+	for _, w := range waypoints {
+		x, y := f.point(w.Lat, w.Long)
+		fmt.Fprintf(&b, `<g><title>%s</title><circle cx="%.1f" cy="%.1f" r="10" fill="#26c" stroke="#fff" stroke-width="2"/>`,
+			html.EscapeString(w.Name()), x, y)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle" font-size="11" font-weight="bold" fill="#fff">%d</text></g>`,
+			x, y+4, w.Number)
+	}
+
+	label, length := f.scaleBar(u)
+	sy := f.height - 14
+	fmt.Fprintf(&b, `<path d="M12,%.1fv6h%.1fv-6" fill="none" stroke="#333" stroke-width="2"/>`, sy-6, length)
+	fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" font-size="12" fill="#333">%s</text>`, 18+length, sy+1, html.EscapeString(label))
+	fmt.Fprintf(&b, `<text x="%.0f" y="20" text-anchor="end" font-size="12" fill="#333">N &#8593;</text>`, f.width-12)
+	b.WriteString(`</svg>`)
+	return b.String()
+}
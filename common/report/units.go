@@ -0,0 +1,91 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/chart"
+)
+
+// Units is how distances, speeds and altitudes are shown
+type Units struct {
+	Name string
+	// Distance, Short, Speed and Altitude are each unit's name and how many
+	// of it there are in a meter (a meter per second for Speed). Short is for
+	// distances under one Distance, like the scale bar
+	Distance, Short, Speed, Altitude string
+	PerMeter, ShortPerMeter          float64
+	PerMPS, AltPerMeter              float64
+}
+
+// the two systems
+var (
+	Imperial = Units{
+		Name: "imperial", Distance: "mi", Short: "ft", Speed: "mph", Altitude: "ft",
+		PerMeter: 1 / 1609.344, ShortPerMeter: 3.28084, PerMPS: 3600 / 1609.344, AltPerMeter: 3.28084,
+	}
+	Metric = Units{
+		Name: "metric", Distance: "km", Short: "m", Speed: "km/h", Altitude: "m",
+		PerMeter: 0.001, ShortPerMeter: 1, PerMPS: 3.6, AltPerMeter: 1,
+	}
+)
+
+// ParseUnits takes imperial or metric
+func ParseUnits(name string) (Units, error) {
+	switch strings.ToLower(name) {
+	case "imperial", "us", "mi":
+		return Imperial, nil
+	case "metric", "si", "km":
+		return Metric, nil
+	}
+	return Units{}, fmt.Errorf("unknown units '%s', want imperial or metric", name)
+}
+
+// SpeedUnit is the speed unit as charts and colored tracks take it
+func (u Units) SpeedUnit() chart.Unit {
+	return chart.Unit{Name: u.Speed, Per: u.PerMPS}
+}
+
+// DistanceUnit is the long distance unit as charts take it
+func (u Units) DistanceUnit() chart.Unit {
+	return chart.Unit{Name: u.Distance, Per: u.PerMeter}
+}
+
+// AltitudeUnit is the altitude unit as charts and colored tracks take it
+func (u Units) AltitudeUnit() chart.Unit {
+	return chart.Unit{Name: u.Altitude, Per: u.AltPerMeter}
+}
+
+// FormatDistance shows meters in the long unit, or the short one under one
+// long unit
+func (u Units) FormatDistance(m float64) string {
+	if m*u.PerMeter < 1 {
+		return fmt.Sprintf("%.0f %s", m*u.ShortPerMeter, u.Short)
+	}
+	return fmt.Sprintf("%.1f %s", m*u.PerMeter, u.Distance)
+}
+
+// FormatSpeed shows m/s
+func (u Units) FormatSpeed(mps float64) string {
+	return fmt.Sprintf("%.1f %s", mps*u.PerMPS, u.Speed)
+}
+
+// FormatAltitude shows meters
+func (u Units) FormatAltitude(m float64) string {
+	return fmt.Sprintf("%.0f %s", m*u.AltPerMeter, u.Altitude)
+}
+
+// FormatDuration is h:mm:ss, hours going past 24
+func FormatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := d / time.Hour
+	m := (d % time.Hour) / time.Minute
+	s := (d % time.Minute) / time.Second
+	return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+}
+
+// formatCoord is decimal degrees to about a meter
+func formatCoord(lat, long float64) string {
+	return fmt.Sprintf("%.5f, %.5f", lat, long)
+}
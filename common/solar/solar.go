@@ -0,0 +1,133 @@
+package solar
+
+// sun position from the NOAA solar calculator equations, good to about a
+//  minute for sunrise/sunset which is plenty for deciding how bright the
+//	display should be
+
+import (
+	"math"
+	"time"
+)
+
+// Phase is how dark it is outside
+type Phase int
+
+const (
+	Day      Phase = iota
+	Twilight       // civil twilight, sun less than 6 degrees below the horizon
+	Night
+)
+
+func (p Phase) String() string {
+	switch p {
+	case Day:
+		return "day"
+	case Twilight:
+		return "twilight"
+	case Night:
+		return "night"
+	}
+	return "unknown"
+}
+
+const (
+	// apparent sunrise/sunset, accounting for refraction and the sun's radius
+	sunriseElevation = -0.833
+	civilElevation   = -6.0
+)
+
+func rad(d float64) float64 { return d * math.Pi / 180 }
+func deg(r float64) float64 { return r * 180 / math.Pi }
+
+// julianCentury is the number of Julian centuries since J2000
+func julianCentury(t time.Time) float64 {
+	jd := float64(t.UnixNano())/float64(24*time.Hour) + 2440587.5
+	return (jd - 2451545.0) / 36525.0
+}
+
+// declinationAndEqTime returns the sun's declination (degrees) and the
+// equation of time (minutes)
+func declinationAndEqTime(jc float64) (float64, float64) {
+	l0 := math.Mod(280.46646+jc*(36000.76983+jc*0.0003032), 360)
+	m := 357.52911 + jc*(35999.05029-0.0001537*jc)
+	e := 0.016708634 - jc*(0.000042037+0.0000001267*jc)
+	c := math.Sin(rad(m))*(1.914602-jc*(0.004817+0.000014*jc)) +
+		math.Sin(rad(2*m))*(0.019993-0.000101*jc) +
+		math.Sin(rad(3*m))*0.000289
+	omega := 125.04 - 1934.136*jc
+	appLong := l0 + c - 0.00569 - 0.00478*math.Sin(rad(omega))
+	meanObliq := 23 + (26+(21.448-jc*(46.815+jc*(0.00059-jc*0.001813)))/60)/60
+	obliq := meanObliq + 0.00256*math.Cos(rad(omega))
+	decl := deg(math.Asin(math.Sin(rad(obliq)) * math.Sin(rad(appLong))))
+
+	y := math.Pow(math.Tan(rad(obliq/2)), 2)
+	eqTime := 4 * deg(y*math.Sin(2*rad(l0))-
+		2*e*math.Sin(rad(m))+
+		4*e*y*math.Sin(rad(m))*math.Cos(2*rad(l0))-
+		0.5*y*y*math.Sin(4*rad(l0))-
+		1.25*e*e*math.Sin(2*rad(m)))
+	return decl, eqTime
+}
+
+// Position returns the sun's elevation above the horizon and its azimuth
+// (clockwise from true north), both in degrees, without refraction
+func Position(lat, long float64, t time.Time) (elevation, azimuth float64) {
+	t = t.UTC()
+	decl, eqTime := declinationAndEqTime(julianCentury(t))
+	minutes := float64(t.Hour()*60+t.Minute()) + float64(t.Second())/60 +
+		float64(t.Nanosecond())/float64(time.Minute)
+	trueSolar := math.Mod(minutes+eqTime+4*long, 1440)
+	if trueSolar < 0 {
+		trueSolar += 1440
+	}
+	hourAngle := trueSolar/4 - 180
+
+	cosZenith := math.Sin(rad(lat))*math.Sin(rad(decl)) +
+		math.Cos(rad(lat))*math.Cos(rad(decl))*math.Cos(rad(hourAngle))
+	zenith := deg(math.Acos(math.Max(-1, math.Min(1, cosZenith))))
+	elevation = 90 - zenith
+
+	denom := math.Cos(rad(lat)) * math.Sin(rad(zenith))
+	if math.Abs(denom) < 1e-9 {
+		return elevation, 180
+	}
+	cosAz := (math.Sin(rad(lat))*math.Cos(rad(zenith)) - math.Sin(rad(decl))) / denom
+	az := deg(math.Acos(math.Max(-1, math.Min(1, cosAz))))
+	if hourAngle > 0 {
+		return elevation, math.Mod(az+180, 360)
+	}
+	return elevation, math.Mod(540-az, 360)
+}
+
+// PhaseAt reports whether it is day, twilight or night at a place and time
+func PhaseAt(lat, long float64, t time.Time) Phase {
+	elevation, _ := Position(lat, long, t)
+	switch {
+	case elevation > sunriseElevation:
+		return Day
+	case elevation > civilElevation:
+		return Twilight
+	}
+	return Night
+}
+
+// SunriseSunset returns the UTC sunrise and sunset on the UTC date of t, ok
+// is false during polar day or night
+func SunriseSunset(lat, long float64, t time.Time) (sunrise, sunset time.Time, ok bool) {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	// evaluate the sun's position around local solar noon
+	noonGuess := midnight.Add(time.Duration((720 - 4*long) * float64(time.Minute)))
+	decl, eqTime := declinationAndEqTime(julianCentury(noonGuess))
+
+	cosHA := math.Cos(rad(90-sunriseElevation))/(math.Cos(rad(lat))*math.Cos(rad(decl))) -
+		math.Tan(rad(lat))*math.Tan(rad(decl))
+	if cosHA < -1 || cosHA > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	ha := deg(math.Acos(cosHA))
+	noon := 720 - 4*long - eqTime
+	sunrise = midnight.Add(time.Duration((noon - 4*ha) * float64(time.Minute)))
+	sunset = midnight.Add(time.Duration((noon + 4*ha) * float64(time.Minute)))
+	return sunrise, sunset, true
+}
@@ -0,0 +1,73 @@
+package solar
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSunriseSunset(t *testing.T) {
+	tests := []struct {
+		name        string
+		lat, long   float64
+		date        time.Time
+		wantSunrise time.Time
+		wantSunset  time.Time
+		wantOK      bool
+	}{
+		{
+			name:        "london midsummer",
+			lat:         51.5074,
+			long:        -0.1278,
+			date:        time.Date(2021, time.June, 21, 12, 0, 0, 0, time.UTC),
+			wantSunrise: time.Date(2021, time.June, 21, 3, 43, 0, 0, time.UTC),
+			wantSunset:  time.Date(2021, time.June, 21, 20, 21, 0, 0, time.UTC),
+			wantOK:      true,
+		},
+		{
+			name:   "svalbard midnight sun",
+			lat:    78.0,
+			long:   15.0,
+			date:   time.Date(2021, time.June, 21, 12, 0, 0, 0, time.UTC),
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sunrise, sunset, ok := SunriseSunset(tt.lat, tt.long, tt.date)
+			if ok != tt.wantOK {
+				t.Fatalf("SunriseSunset() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if d := sunrise.Sub(tt.wantSunrise); d < -time.Minute || d > time.Minute {
+				t.Errorf("SunriseSunset() sunrise = %v, want %v", sunrise, tt.wantSunrise)
+			}
+			if d := sunset.Sub(tt.wantSunset); d < -time.Minute || d > time.Minute {
+				t.Errorf("SunriseSunset() sunset = %v, want %v", sunset, tt.wantSunset)
+			}
+		})
+	}
+}
+
+func TestPhaseAt(t *testing.T) {
+	// Springfield, IL on the winter solstice, times in UTC
+	lat, long := 39.8, -89.65
+	tests := []struct {
+		name string
+		t    time.Time
+		want Phase
+	}{
+		{name: "noon", t: time.Date(2021, time.December, 21, 18, 0, 0, 0, time.UTC), want: Day},
+		{name: "just after sunset", t: time.Date(2021, time.December, 21, 22, 50, 0, 0, time.UTC), want: Twilight},
+		{name: "evening", t: time.Date(2021, time.December, 22, 1, 0, 0, 0, time.UTC), want: Night},
+		{name: "before dawn", t: time.Date(2021, time.December, 21, 12, 0, 0, 0, time.UTC), want: Night},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PhaseAt(lat, long, tt.t); got != tt.want {
+				t.Errorf("PhaseAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package spool
+
+// temporary files for writers that can only finish part of a document once
+//  they've seen the whole track, like the gx:Track after a KML LineString
+//	or a FIT header that holds the size of the data after it. What they hold
+//	back waits on disk instead of in memory, so a long log converts on a Pi
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"os"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// File is a temporary file written through a buffer. It's created with the
+// first write, so a spool that's never used never touches the disk
+type File struct {
+	f  *os.File
+	bw *bufio.Writer
+	n  int64
+}
+
+func (s *File) Write(p []byte) (int, error) {
+	if s.f == nil {
+		f, err := os.CreateTemp("", "raspigogps-spool-*")
+		if err != nil {
+			return 0, err
+		}
+		s.f, s.bw = f, bufio.NewWriter(f)
+	}
+	n, err := s.bw.Write(p)
+	s.n += int64(n)
+	return n, err
+}
+
+// Len is how many bytes were written since the last Reset
+func (s *File) Len() int64 {
+	return s.n
+}
+
+// reader flushes what's buffered and returns a reader of everything written
+// since the last Reset, writes carry on after it once it's read
+func (s *File) reader() (io.Reader, error) {
+	if err := s.bw.Flush(); err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(s.f, 0, s.n), nil
+}
+
+// WriteTo copies what was written since the last Reset to w
+func (s *File) WriteTo(w io.Writer) (int64, error) {
+	if s.f == nil {
+		return 0, nil
+	}
+	r, err := s.reader()
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(w, r)
+}
+
+// Reset empties the file to be written again
+func (s *File) Reset() error {
+	if s.f == nil {
+		return nil
+	}
+	s.bw.Reset(s.f)
+	s.n = 0
+	if err := s.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := s.f.Seek(0, io.SeekStart)
+	return err
+}
+
+// Close removes the file
+func (s *File) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	if rmErr := os.Remove(s.f.Name()); err == nil {
+		err = rmErr
+	}
+	s.f = nil
+	return err
+}
+
+// Records is a temporary file of records, read back in the order they were
+// written
+type Records struct {
+	file File
+	enc  *gob.Encoder
+	n    int
+}
+
+func (r *Records) Write(gr gps.GPSRecord) error {
+	if r.enc == nil {
+		r.enc = gob.NewEncoder(&r.file)
+	}
+	r.n++
+	return r.enc.Encode(gr)
+}
+
+// Len is how many records were written
+func (r *Records) Len() int {
+	return r.n
+}
+
+// Each calls fn with every record written so far, in order, stopping at the
+// first error
+func (r *Records) Each(fn func(gps.GPSRecord) error) error {
+	if r.n == 0 {
+		return nil
+	}
+	rd, err := r.file.reader()
+	if err != nil {
+		return err
+	}
+	dec := gob.NewDecoder(bufio.NewReader(rd))
+	for i := 0; i < r.n; i++ {
+		var gr gps.GPSRecord
+		if err := dec.Decode(&gr); err != nil {
+			return err
+		}
+		if err := fn(gr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close removes the file
+func (r *Records) Close() error {
+	return r.file.Close()
+}
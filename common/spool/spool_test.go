@@ -0,0 +1,96 @@
+package spool
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+func TestFile(t *testing.T) {
+	var s File
+	var out bytes.Buffer
+	if n, err := s.WriteTo(&out); n != 0 || err != nil {
+		t.Fatalf("WriteTo() of an unused spool = %d, %v", n, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() of an unused spool error = %v", err)
+	}
+
+	s.Write([]byte("first "))
+	s.Write([]byte("lap"))
+	if _, err := s.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	if err := s.Reset(); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	s.Write([]byte(", second"))
+	if _, err := s.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	// writes after a copy carry on from the end
+	s.Write([]byte(" and more"))
+	if _, err := s.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	if got, want := out.String(), "first lap, second, second and more"; got != want {
+		t.Errorf("copied %q, want %q", got, want)
+	}
+	if s.Len() != int64(len(", second and more")) {
+		t.Errorf("Len() = %d", s.Len())
+	}
+
+	name := s.f.Name()
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("spool file still there after Close(): %v", err)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	var r Records
+	defer r.Close()
+	start := time.Date(2022, time.May, 20, 12, 0, 0, 0, time.UTC)
+	want := []gps.GPSRecord{
+		{Lat: 38.6, Long: -90.2, Alt: 500, Speed: 10.8, GPSTime: start, HeadingSource: gps.HeadingTrack},
+		{Lat: 38.6001, Long: -90.2, NumSats: 9, GPSTime: start.Add(time.Second), FixQuality: gps.FixDGPS},
+		{Lat: 38.6002, Long: -90.2001, UnixMicro: 1653048002000000},
+	}
+	for _, gr := range want {
+		if err := r.Write(gr); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	for pass := 0; pass < 2; pass++ {
+		var got []gps.GPSRecord
+		err := r.Each(func(gr gps.GPSRecord) error {
+			got = append(got, gr)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Each() error = %v", err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("Each() gave %d records, want %d", len(got), len(want))
+		}
+		for i := range want {
+			if !got[i].GPSTime.Equal(want[i].GPSTime) || got[i].Lat != want[i].Lat || got[i].Long != want[i].Long ||
+				got[i].Alt != want[i].Alt || got[i].NumSats != want[i].NumSats || got[i].FixQuality != want[i].FixQuality ||
+				got[i].HeadingSource != want[i].HeadingSource || got[i].UnixMicro != want[i].UnixMicro {
+				t.Errorf("record %d = %+v, want %+v", i, got[i], want[i])
+			}
+		}
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	if err := r.Each(func(gps.GPSRecord) error { n++; return stop }); err != stop || n != 1 {
+		t.Errorf("Each() = %v after %d records, want stop after 1", err, n)
+	}
+}
@@ -0,0 +1,250 @@
+package tcx
+
+// Garmin Training Center XML (TCX v2) activities: one Activity with a Lap
+//  per activity.Lap, each holding its Track of Trackpoints. Speeds go in the
+//	ActivityExtension TPX and LX elements, which is where Strava, Garmin
+//	Connect and TrainingPeaks read them from
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/activity"
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/spool"
+)
+
+// the TCX and activity extension namespaces
+const (
+	Namespace    = "http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2"
+	ExtNamespace = "http://www.garmin.com/xmlschemas/ActivityExtension/v2"
+	schemaLocs   = Namespace + " http://www.garmin.com/xmlschemas/TrainingCenterDatabasev2.xsd"
+)
+
+type trackpoint struct {
+	Time       string    `xml:"Time"`
+	Position   position  `xml:"Position"`
+	Altitude   string    `xml:"AltitudeMeters"`
+	Distance   string    `xml:"DistanceMeters"`
+	Extensions pointExts `xml:"Extensions"`
+}
+
+type position struct {
+	Lat  string `xml:"LatitudeDegrees"`
+	Long string `xml:"LongitudeDegrees"`
+}
+
+type pointExts struct {
+	TPX struct {
+		Speed string `xml:"ns3:Speed"`
+	} `xml:"ns3:TPX"`
+}
+
+type lapExts struct {
+	LX struct {
+		AvgSpeed string `xml:"ns3:AvgSpeed"`
+	} `xml:"ns3:LX"`
+}
+
+// sportName is the Sport attribute, TCX only knows these three
+func sportName(s activity.Sport) string {
+	switch s {
+	case activity.Cycling:
+		return "Biking"
+	case activity.Running:
+		return "Running"
+	}
+	return "Other"
+}
+
+var triggerMethods = map[activity.Trigger]string{
+	activity.TriggerEnd:      "Manual",
+	activity.TriggerDistance: "Distance",
+	activity.TriggerTime:     "Time",
+}
+
+func formatFloat(v float64, prec int) string {
+	return strconv.FormatFloat(v, 'f', prec, 64)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func newTrackpoint(gr gps.GPSRecord, distance float64) trackpoint {
+	tp := trackpoint{
+		Time:     formatTime(gr.Time()),
+		Position: position{Lat: formatFloat(gr.Lat, 7), Long: formatFloat(gr.Long, 7)},
+		Altitude: formatFloat(gr.Alt/3.28084, 1), // meters above sea level
+		Distance: formatFloat(distance, 1),
+	}
+	tp.Extensions.TPX.Speed = formatFloat(gr.SpeedMetersPerSecond(), 2)
+	return tp
+}
+
+func name(local string) xml.Name {
+	return xml.Name{Local: local}
+}
+
+// Writer writes one activity a record at a time, splitting laps as it goes.
+// A Lap's totals come before its Track, so the lap's trackpoints wait in a
+// spool file until it ends
+type Writer struct {
+	w       io.Writer
+	enc     *xml.Encoder
+	sport   activity.Sport
+	split   *activity.Splitter
+	points  spool.File
+	penc    *xml.Encoder // trackpoints into points
+	started bool
+}
+
+// NewWriter returns a writer for one activity split into laps by laps
+func NewWriter(w io.Writer, laps activity.Options, sport activity.Sport) *Writer {
+	tw := &Writer{w: w, enc: xml.NewEncoder(w), sport: sport, split: activity.NewSplitter(laps)}
+	tw.enc.Indent("", " ")
+	tw.penc = pointEncoder(&tw.points)
+	return tw
+}
+
+// pointEncoder encodes a lap's trackpoints, indented five deep to sit under
+// Activities, Activity, Lap and Track
+func pointEncoder(w io.Writer) *xml.Encoder {
+	enc := xml.NewEncoder(w)
+	enc.Indent("     ", " ")
+	return enc
+}
+
+func (tw *Writer) start() error {
+	tw.started = true
+	if _, err := io.WriteString(tw.w, xml.Header); err != nil {
+		return err
+	}
+	root := xml.StartElement{Name: name("TrainingCenterDatabase"), Attr: []xml.Attr{
+		{Name: name("xmlns"), Value: Namespace},
+		{Name: name("xmlns:ns3"), Value: ExtNamespace},
+		{Name: name("xmlns:xsi"), Value: "http://www.w3.org/2001/XMLSchema-instance"},
+		{Name: name("xsi:schemaLocation"), Value: schemaLocs},
+	}}
+	if err := tw.enc.EncodeToken(root); err != nil {
+		return err
+	}
+	return tw.enc.EncodeToken(xml.StartElement{Name: name("Activities")})
+}
+
+// lap writes a finished lap with the trackpoints waiting for it
+func (tw *Writer) lap(l activity.Lap) error {
+	err := tw.enc.EncodeToken(xml.StartElement{Name: name("Lap"), Attr: []xml.Attr{
+		{Name: name("StartTime"), Value: formatTime(l.Start)},
+	}})
+	if err != nil {
+		return err
+	}
+	// Lap_t's elements before the Track, in the schema's order
+	for _, el := range []struct{ name, value string }{
+		{"TotalTimeSeconds", formatFloat(l.Duration().Seconds(), 1)},
+		{"DistanceMeters", formatFloat(l.Distance, 1)},
+		{"MaximumSpeed", formatFloat(l.MaxSpeed, 2)},
+		{"Calories", "0"},
+		{"Intensity", "Active"},
+		{"TriggerMethod", triggerMethods[l.Trigger]},
+	} {
+		if err := tw.enc.EncodeElement(el.value, xml.StartElement{Name: name(el.name)}); err != nil {
+			return err
+		}
+	}
+	// the Track goes straight to w around the spooled trackpoints, indented
+	// to match
+	if err := tw.enc.Flush(); err != nil {
+		return err
+	}
+	if err := tw.penc.Flush(); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(tw.w, "\n    <Track>\n"); err != nil {
+		return err
+	}
+	if _, err := tw.points.WriteTo(tw.w); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(tw.w, "\n    </Track>"); err != nil {
+		return err
+	}
+	if err := tw.points.Reset(); err != nil {
+		return err
+	}
+	tw.penc = pointEncoder(&tw.points)
+	var ext lapExts
+	ext.LX.AvgSpeed = formatFloat(l.AvgSpeed(), 2)
+	if err := tw.enc.EncodeElement(ext, xml.StartElement{Name: name("Extensions")}); err != nil {
+		return err
+	}
+	return tw.enc.EncodeToken(xml.EndElement{Name: name("Lap")})
+}
+
+// Write adds a record, writing the lap before it if it starts a new one
+func (tw *Writer) Write(gr gps.GPSRecord) error {
+	distance, done := tw.split.Add(gr)
+	if !tw.started {
+		if err := tw.start(); err != nil {
+			return err
+		}
+		err := tw.enc.EncodeToken(xml.StartElement{Name: name("Activity"), Attr: []xml.Attr{
+			{Name: name("Sport"), Value: sportName(tw.sport)},
+		}})
+		if err != nil {
+			return err
+		}
+		if err := tw.enc.EncodeElement(formatTime(gr.Time()), xml.StartElement{Name: name("Id")}); err != nil {
+			return err
+		}
+	}
+	if done != nil {
+		if err := tw.lap(*done); err != nil {
+			return err
+		}
+	}
+	return tw.penc.EncodeElement(newTrackpoint(gr, distance), xml.StartElement{Name: name("Trackpoint")})
+}
+
+// Close writes the last lap and ends the document, it doesn't close the
+// underlying writer
+func (tw *Writer) Close() error {
+	defer tw.points.Close()
+	if !tw.started {
+		if err := tw.start(); err != nil {
+			return err
+		}
+	} else {
+		l, _ := tw.split.End()
+		if err := tw.lap(l); err != nil {
+			return err
+		}
+		if err := tw.enc.EncodeToken(xml.EndElement{Name: name("Activity")}); err != nil {
+			return err
+		}
+	}
+	for _, e := range []string{"Activities", "TrainingCenterDatabase"} {
+		if err := tw.enc.EncodeToken(xml.EndElement{Name: name(e)}); err != nil {
+			return err
+		}
+	}
+	if err := tw.enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(tw.w, "\n")
+	return err
+}
+
+// Write writes the records as one activity split into laps by laps
+func Write(w io.Writer, records []gps.GPSRecord, laps activity.Options, sport activity.Sport) error {
+	tw := NewWriter(w, laps, sport)
+	for _, gr := range records {
+		if err := tw.Write(gr); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
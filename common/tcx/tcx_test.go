@@ -0,0 +1,120 @@
+package tcx
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/activity"
+	"github.com/samiam2013/raspigogps/common/gpstest"
+)
+
+// the parts of the document a training platform reads, decoded by namespace
+type readLap struct {
+	StartTime     string  `xml:"StartTime,attr"`
+	TotalTime     float64 `xml:"TotalTimeSeconds"`
+	Distance      float64 `xml:"DistanceMeters"`
+	TriggerMethod string  `xml:"TriggerMethod"`
+	Points        []struct {
+		Time     string  `xml:"Time"`
+		Lat      float64 `xml:"Position>LatitudeDegrees"`
+		Distance float64 `xml:"DistanceMeters"`
+		Speed    float64 `xml:"Extensions>TPX>Speed"`
+	} `xml:"Track>Trackpoint"`
+}
+
+type readDB struct {
+	XMLName  xml.Name `xml:"http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2 TrainingCenterDatabase"`
+	Activity []struct {
+		Sport string    `xml:"Sport,attr"`
+		ID    string    `xml:"Id"`
+		Laps  []readLap `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+}
+
+// lapOrder is Lap_t's sequence, for the elements written
+var lapOrder = []string{"TotalTimeSeconds", "DistanceMeters", "MaximumSpeed", "Calories", "Intensity",
+	"TriggerMethod", "Track", "Extensions"}
+
+func TestWrite(t *testing.T) {
+	records := gpstest.Records(10, 10.8) // 20 km/h
+	laps := activity.Split(records, activity.Options{Duration: 4 * time.Second})
+	var buf bytes.Buffer
+	if err := Write(&buf, records, activity.Options{Duration: 4 * time.Second}, activity.Cycling); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	var db readDB
+	if err := xml.Unmarshal(buf.Bytes(), &db); err != nil {
+		t.Fatalf("Unmarshal() error = %v\n%s", err, buf.String())
+	}
+	if len(db.Activity) != 1 || db.Activity[0].Sport != "Biking" || len(db.Activity[0].Laps) != 3 {
+		t.Fatalf("got %+v, want one Biking activity with 3 laps", db.Activity)
+	}
+	if db.Activity[0].ID != "2022-05-20T12:00:00Z" {
+		t.Errorf("Id = %s", db.Activity[0].ID)
+	}
+	points := 0
+	for i, l := range db.Activity[0].Laps {
+		if l.StartTime != laps[i].Start.Format(time.RFC3339) || l.TotalTime != laps[i].Duration().Seconds() {
+			t.Errorf("lap %d starts %s lasting %vs", i, l.StartTime, l.TotalTime)
+		}
+		want := "Time"
+		if i == len(laps)-1 {
+			want = "Manual"
+		}
+		if l.TriggerMethod != want {
+			t.Errorf("lap %d TriggerMethod = %s, want %s", i, l.TriggerMethod, want)
+		}
+		for _, p := range l.Points {
+			if p.Speed != 5.56 {
+				t.Errorf("trackpoint speed %v, want 5.56", p.Speed)
+			}
+			if p.Time != records[points].Time().Format(time.RFC3339) {
+				t.Errorf("trackpoint %d at %s", points, p.Time)
+			}
+			points++
+		}
+	}
+	if points != len(records) {
+		t.Errorf("got %d trackpoints, want %d", points, len(records))
+	}
+
+	// elements in Lap_t's order
+	dec := xml.NewDecoder(bytes.NewReader(buf.Bytes()))
+	depth, lapDepth, pos := 0, -1, 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if tok.Name.Local == "Lap" {
+				lapDepth, pos = depth, 0
+			} else if depth == lapDepth+1 {
+				for pos < len(lapOrder) && lapOrder[pos] != tok.Name.Local {
+					pos++
+				}
+				if pos == len(lapOrder) {
+					t.Fatalf("<%s> out of order in Lap", tok.Name.Local)
+				}
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+func TestWriteEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, nil, activity.Options{}, activity.Running); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	var db readDB
+	if err := xml.Unmarshal(buf.Bytes(), &db); err != nil || len(db.Activity) != 0 {
+		t.Errorf("empty write = %v, %s", err, strconv.Quote(buf.String()))
+	}
+}
@@ -0,0 +1,305 @@
+package trackio
+
+// reading and writing tracks in every format the project knows, picked by
+//  name, file extension or a look at the first bytes. Readers run lenient,
+//	skipping and counting what they can't use, or strict, stopping at the
+//	first problem
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/samiam2013/raspigogps/common/activity"
+	"github.com/samiam2013/raspigogps/common/coord"
+	"github.com/samiam2013/raspigogps/common/fit"
+	"github.com/samiam2013/raspigogps/common/geojson"
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/gpx"
+	"github.com/samiam2013/raspigogps/common/kml"
+	"github.com/samiam2013/raspigogps/common/tcx"
+	"github.com/samiam2013/raspigogps/common/tracklog"
+	"github.com/samiam2013/raspigogps/common/trackstore"
+	"github.com/samiam2013/raspigogps/common/waypoint"
+)
+
+// the formats, by the names used in command line flags
+const (
+	CSV     = "csv"
+	Store   = "trk"
+	GPX     = "gpx"
+	KML     = "kml"
+	KMZ     = "kmz"
+	GeoJSON = "geojson"
+	NMEA    = "nmea"
+	TCX     = "tcx"
+	FIT     = "fit"
+)
+
+// Readable and Writable list the formats each way, in the order used for
+// help text
+var (
+	Readable = []string{CSV, Store, GPX, KML, KMZ, GeoJSON, NMEA}
+	Writable = []string{CSV, GPX, KML, KMZ, GeoJSON, TCX, FIT}
+)
+
+var extensions = map[string]string{
+	".csv":     CSV,
+	".log":     CSV,
+	".trk":     Store,
+	".gpx":     GPX,
+	".kml":     KML,
+	".kmz":     KMZ,
+	".geojson": GeoJSON,
+	".json":    GeoJSON,
+	".nmea":    NMEA,
+	".tcx":     TCX,
+	".fit":     FIT,
+}
+
+// FromExt is the format a file name's extension suggests, empty if none
+func FromExt(path string) string {
+	return extensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// Sniff guesses a format from the start of a file, empty if it can't tell
+func Sniff(head []byte) string {
+	head = bytes.TrimLeft(head, "\xef\xbb\xbf \t\r\n")
+	switch {
+	case trackstore.IsStore(head):
+		return Store
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return KMZ
+	case bytes.HasPrefix(head, []byte("$")), bytes.HasPrefix(head, []byte("# raspigogps nmea capture")):
+		return NMEA
+	case bytes.HasPrefix(head, []byte("{")):
+		return GeoJSON
+	case bytes.HasPrefix(head, []byte("<")):
+		if bytes.Contains(head, []byte("<gpx")) {
+			return GPX
+		}
+		if bytes.Contains(head, []byte("<kml")) {
+			return KML
+		}
+		return ""
+	case len(head) > 0:
+		// the logger's, or anything starting unixmicro,lat,long
+		return CSV
+	}
+	return ""
+}
+
+// Mode is what a reader does about rows or points it can't use
+type Mode int
+
+const (
+	Lenient Mode = iota // skip them and carry on
+	Strict              // stop at the first
+)
+
+// maxProblems is how many problems a Summary keeps the text of
+const maxProblems = 10
+
+// Summary is what a read got and what it skipped
+type Summary struct {
+	Format   string
+	Records  int
+	Skipped  int
+	Problems []string // the first few skipped, for showing
+}
+
+func (s *Summary) String() string {
+	msg := fmt.Sprintf("read %d records as %s", s.Records, s.Format)
+	if s.Skipped > 0 {
+		msg += fmt.Sprintf(", skipped %d: %s", s.Skipped, strings.Join(s.Problems, "; "))
+		if s.Skipped > len(s.Problems) {
+			msg += "; ..."
+		}
+	}
+	return msg
+}
+
+// Skip counts err as a skipped row or point, it can be passed as a bad func
+func (s *Summary) Skip(err error) error {
+	s.Skipped++
+	if len(s.Problems) < maxProblems {
+		s.Problems = append(s.Problems, err.Error())
+	}
+	return nil
+}
+
+// Read reads records in the named format, which can't be Store, that needs
+// the file (see ReadFile)
+func Read(r io.Reader, format string, mode Mode) ([]gps.GPSRecord, *Summary, error) {
+	sum := &Summary{Format: format}
+	bad := sum.Skip
+	if mode == Strict {
+		bad = nil
+	}
+	records, err := ReadFunc(r, format, bad)
+	sum.Records = len(records)
+	return records, sum, err
+}
+
+// ReadFunc reads records in the named format like Read, calling bad with
+// each row or point that can't be used. It stops with bad's error if it
+// returns one, a nil bad stops at the first
+func ReadFunc(r io.Reader, format string, bad func(error) error) ([]gps.GPSRecord, error) {
+	switch format {
+	case CSV:
+		return tracklog.ReadAllFunc(r, bad)
+	case GPX:
+		return gpx.Read(r, bad)
+	case KML:
+		return kml.Read(r, bad)
+	case KMZ:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return kml.ReadKMZ(data, bad)
+	case GeoJSON:
+		return geojson.Read(r, bad)
+	case NMEA:
+		return gps.ReadNMEA(r, bad)
+	}
+	return nil, fmt.Errorf("can't read %s, want one of %s", format, strings.Join(Readable, ", "))
+}
+
+// ReadFile reads the file at path, in format or when that's empty the one
+// its extension or contents suggest
+func ReadFile(path, format string, mode Mode) ([]gps.GPSRecord, *Summary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	if format == "" {
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(f, head)
+		if format = Sniff(head[:n]); format == "" {
+			format = FromExt(path)
+		}
+		if format == "" {
+			return nil, nil, fmt.Errorf("can't tell what format %s is in", path)
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+	}
+	if format != Store {
+		return Read(f, format, mode)
+	}
+
+	sum := &Summary{Format: format}
+	s, err := trackstore.Open(path)
+	if err != nil {
+		return nil, sum, err
+	}
+	defer s.Close()
+	records, err := s.ReadAll()
+	if s.Skipped > 0 || s.Torn > 0 {
+		err2 := fmt.Errorf("%d corrupt bytes and %d bytes of torn writes", s.Skipped, s.Torn)
+		if mode == Strict && err == nil {
+			err = err2
+		}
+		sum.Skip(err2)
+	}
+	sum.Records = len(records)
+	return records, sum, err
+}
+
+// Options has each writer's options, the ones for formats not written are
+// ignored
+type Options struct {
+	KML       kml.Options
+	Coloring  kml.Coloring // for KMZ
+	GPX       gpx.Options
+	GeoJSON   geojson.Options
+	Waypoints []waypoint.Waypoint // for GPX, KML, KMZ and GeoJSON
+	Laps      activity.Options    // for TCX and FIT
+	Sport     activity.Sport
+	Coords    coord.Format // for CSV, a coords column in this notation unless DD
+}
+
+// DefaultOptions is every writer's defaults, named name
+func DefaultOptions(name string) Options {
+	opts := Options{
+		KML:      kml.DefaultOptions(),
+		Coloring: kml.DefaultColoring(),
+		GPX:      gpx.DefaultOptions(),
+		GeoJSON:  geojson.DefaultOptions(),
+		Sport:    activity.Cycling,
+	}
+	opts.KML.Name, opts.GPX.Name, opts.GeoJSON.Name = name, name, name
+	return opts
+}
+
+// Writer takes a track a record at a time, Close finishes the document
+// without closing the underlying writer. Formats that can only finish part
+// of the document once they've seen the whole track hold what they need in
+// temporary files, never the track itself
+type Writer interface {
+	Write(gr gps.GPSRecord) error
+	Close() error
+}
+
+// csvWriter is a CSV log with its header written even when there are no
+// records
+type csvWriter struct {
+	cw *tracklog.CSVWriter
+}
+
+func (c csvWriter) Write(gr gps.GPSRecord) error {
+	return c.cw.Write(gr)
+}
+
+func (c csvWriter) Close() error {
+	if err := c.cw.WriteHeader(); err != nil {
+		return err
+	}
+	return c.cw.Flush()
+}
+
+// NewWriter returns a writer in the named format
+func NewWriter(w io.Writer, format string, opts Options) (Writer, error) {
+	switch format {
+	case CSV:
+		cw := tracklog.NewCSVWriter(w)
+		cw.Coords = opts.Coords
+		return csvWriter{cw: cw}, nil
+	case GPX:
+		opts.GPX.Waypoints = opts.Waypoints
+		return gpx.NewWriter(w, opts.GPX), nil
+	case KML:
+		opts.KML.Waypoints = opts.Waypoints
+		return kml.NewWriter(w, opts.KML), nil
+	case KMZ:
+		opts.KML.Waypoints = opts.Waypoints
+		return kml.NewColoredWriter(w, opts.KML, opts.Coloring), nil
+	case GeoJSON:
+		return geojson.NewWriter(w, opts.Waypoints, opts.GeoJSON), nil
+	case TCX:
+		return tcx.NewWriter(w, opts.Laps, opts.Sport), nil
+	case FIT:
+		return fit.NewWriter(w, opts.Laps, opts.Sport), nil
+	}
+	return nil, fmt.Errorf("can't write %s, want one of %s", format, strings.Join(Writable, ", "))
+}
+
+// Write writes the records in the named format
+func Write(w io.Writer, format string, records []gps.GPSRecord, opts Options) error {
+	tw, err := NewWriter(w, format, opts)
+	if err != nil {
+		return err
+	}
+	for _, gr := range records {
+		if err := tw.Write(gr); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
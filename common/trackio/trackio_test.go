@@ -0,0 +1,106 @@
+package trackio
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/samiam2013/raspigogps/common/gpstest"
+	"github.com/samiam2013/raspigogps/common/tracklog"
+)
+
+func TestSniff(t *testing.T) {
+	tests := []struct {
+		head string
+		want string
+	}{
+		{"unixmicro,lat,long\n", CSV},
+		{"\xef\xbb\xbf<?xml version=\"1.0\"?>\n<gpx version=\"1.1\">", GPX},
+		{"<?xml version=\"1.0\"?><kml xmlns=\"http://www.opengis.net/kml/2.2\">", KML},
+		{"PK\x03\x04", KMZ},
+		{"  {\"type\":\"FeatureCollection\"", GeoJSON},
+		{"$GPRMC,180405.00,A", NMEA},
+		{"<html>", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Sniff([]byte(tt.head)); got != tt.want {
+			t.Errorf("Sniff(%q) = %q, want %q", tt.head, got, tt.want)
+		}
+	}
+	for path, want := range map[string]string{"a.GPX": GPX, "b.geojson": GeoJSON, "c.fit": FIT, "d.txt": ""} {
+		if got := FromExt(path); got != want {
+			t.Errorf("FromExt(%s) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := gpstest.Records(5, 27)
+	for i := range want {
+		want[i].NumSats = 9
+	}
+	for _, format := range []string{CSV, GPX, KML, KMZ, GeoJSON} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, format, want, DefaultOptions("test")); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if got := Sniff(buf.Bytes()); got != format {
+				t.Errorf("Sniff() = %q, want %q", got, format)
+			}
+			got, sum, err := Read(&buf, format, Strict)
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if sum.Records != len(want) || sum.Skipped != 0 || len(got) != len(want) {
+				t.Fatalf("Read() = %d records, summary %s, want %d", len(got), sum, len(want))
+			}
+			for i := range got {
+				if math.Abs(got[i].Lat-want[i].Lat) > 1e-7 || math.Abs(got[i].Long-want[i].Long) > 1e-7 ||
+					math.Abs(got[i].Alt-want[i].Alt) > 0.5 {
+					t.Errorf("record %d at %v,%v,%v, want %v,%v,%v", i, got[i].Lat, got[i].Long, got[i].Alt,
+						want[i].Lat, want[i].Long, want[i].Alt)
+				}
+				// the colored KMZ only has lines, which don't keep times
+				if format != KMZ && !got[i].Time().Equal(want[i].Time()) {
+					t.Errorf("record %d at %v, want %v", i, got[i].Time(), want[i].Time())
+				}
+			}
+		})
+	}
+	for _, format := range []string{TCX, FIT} {
+		var buf bytes.Buffer
+		if err := Write(&buf, format, want, DefaultOptions("test")); err != nil || buf.Len() == 0 {
+			t.Errorf("Write(%s) error = %v, wrote %d bytes", format, err, buf.Len())
+		}
+	}
+	if err := Write(&bytes.Buffer{}, NMEA, want, DefaultOptions("test")); err == nil {
+		t.Error("Write(nmea) didn't fail")
+	}
+}
+
+func TestModes(t *testing.T) {
+	var buf bytes.Buffer
+	if err := tracklog.WriteAll(&buf, gpstest.Records(5, 27)); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString("not,a,record\n")
+	path := filepath.Join(t.TempDir(), "track.log")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	records, sum, err := ReadFile(path, "", Lenient)
+	if err != nil {
+		t.Fatalf("lenient ReadFile() error = %v", err)
+	}
+	if sum.Format != CSV || len(records) != 5 || sum.Records != 5 || sum.Skipped != 1 || len(sum.Problems) != 1 {
+		t.Errorf("lenient ReadFile() = %d records, summary %+v", len(records), sum)
+	}
+	if _, _, err := ReadFile(path, "", Strict); err == nil {
+		t.Error("strict ReadFile() didn't fail on the bad row")
+	}
+}
@@ -0,0 +1,354 @@
+package tracklog
+
+// CSV track logs: the format the logger writes and csvtokml reads. A file
+//  starts with a version comment and a header row naming the columns, so
+//	readers map columns by name and older logs (including the original bare
+//	unixmicro,lat,long layout) keep working as fields are added
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/coord"
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// Version is the schema version written by this package
+const Version = 3
+
+const versionPrefix = "#raspigogps-track v"
+
+// Columns is the header written for the current version, one per GPSRecord
+// field
+var Columns = []string{
+	"unixmicro",
+	"lat",
+	"long",
+	"alt",
+	"alt_ellipsoid",
+	"geoid_sep",
+	"speed",
+	"heading",
+	"heading_source",
+	"mag_heading",
+	"declination",
+	"num_sats",
+	"time_str",
+	"gps_time",
+	// version 2, new columns go on the end so rows appended to an older
+	// day's file still line up with its header
+	"hdop",
+	// version 3
+	"fix_quality",
+}
+
+// CoordsColumn is the optional last column holding the position in another
+// notation, for reading a log by eye. Readers ignore it, lat and long are the
+// position
+const CoordsColumn = "coords"
+
+// header is Columns, with the coords column unless coords is DD which lat and
+// long already are
+func header(coords coord.Format) []string {
+	if coords == coord.DD {
+		return Columns
+	}
+	return append(Columns[:len(Columns):len(Columns)], CoordsColumn)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// row converts a record to the CSV fields in header(coords) order
+func row(gr gps.GPSRecord, coords coord.Format) []string {
+	gpsTime := ""
+	if !gr.GPSTime.IsZero() {
+		gpsTime = gr.GPSTime.UTC().Format(time.RFC3339Nano)
+	}
+	fields := []string{
+		strconv.FormatUint(gr.UnixMicro, 10),
+		formatFloat(gr.Lat),
+		formatFloat(gr.Long),
+		formatFloat(gr.Alt),
+		formatFloat(gr.AltEllipsoid),
+		formatFloat(gr.GeoidSep),
+		formatFloat(gr.Speed),
+		formatFloat(gr.Heading),
+		gr.HeadingSource.String(),
+		formatFloat(gr.MagHeading),
+		formatFloat(gr.Declination),
+		strconv.FormatInt(gr.NumSats, 10),
+		gr.TimeStr,
+		gpsTime,
+		formatFloat(gr.HDOP),
+		gr.FixQuality.String(),
+	}
+	if coords != coord.DD {
+		fields = append(fields, coords.Format(gr.Lat, gr.Long))
+	}
+	return fields
+}
+
+// CSVWriter writes one log to w, the version line and header before the
+// first record. Unlike Writer it doesn't rotate or sync, for exports and
+// other files that are written in one go
+type CSVWriter struct {
+	// Coords adds a coords column in this notation unless it's DD, set it
+	// before the first record
+	Coords coord.Format
+
+	w       io.Writer
+	cw      *csv.Writer
+	started bool
+}
+
+// NewCSVWriter returns a writer for a single log
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: w, cw: csv.NewWriter(w)}
+}
+
+// WriteHeader writes the version line and header if they haven't been yet,
+// Write does this itself so it's only needed for a log with no records
+func (c *CSVWriter) WriteHeader() error {
+	if c.started {
+		return nil
+	}
+	c.started = true
+	if _, err := fmt.Fprintf(c.w, "%s%d\n", versionPrefix, Version); err != nil {
+		return err
+	}
+	return c.cw.Write(header(c.Coords))
+}
+
+// Write adds a record
+func (c *CSVWriter) Write(gr gps.GPSRecord) error {
+	if err := c.WriteHeader(); err != nil {
+		return err
+	}
+	return c.cw.Write(row(gr, c.Coords))
+}
+
+// Flush writes any buffered rows to the underlying writer
+func (c *CSVWriter) Flush() error {
+	c.cw.Flush()
+	return c.cw.Error()
+}
+
+// WriteAll writes a whole log to w: the version line, header and a row per
+// record
+func WriteAll(w io.Writer, records []gps.GPSRecord) error {
+	c := NewCSVWriter(w)
+	if err := c.WriteHeader(); err != nil {
+		return err
+	}
+	for _, gr := range records {
+		if err := c.Write(gr); err != nil {
+			return err
+		}
+	}
+	return c.Flush()
+}
+
+// Reader reads records from a track log
+type Reader struct {
+	// Version of the file's schema, 0 for logs written before versioning
+	Version int
+	csv     *csv.Reader
+	header  []string
+	cols    map[string]int
+}
+
+// NewReader reads the version line and header
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	tr := &Reader{}
+	if first, err := br.Peek(len(versionPrefix)); err == nil && string(first) == versionPrefix {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("could not read version line: %w", err)
+		}
+		v, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, versionPrefix)))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse version line '%s'", strings.TrimSpace(line))
+		}
+		if v > Version {
+			return nil, fmt.Errorf("track log version %d is newer than supported (%d)", v, Version)
+		}
+		tr.Version = v
+	}
+
+	tr.csv = csv.NewReader(br)
+	// a torn last line shouldn't stop the read, Read reports it as a bad row
+	tr.csv.FieldsPerRecord = -1
+	tr.csv.ReuseRecord = true
+	header, err := tr.csv.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read header: %w", err)
+	}
+	tr.header = append([]string(nil), header...)
+	tr.cols = make(map[string]int, len(header))
+	for i, name := range header {
+		tr.cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	_, hasLat := tr.cols["lat"]
+	_, hasLong := tr.cols["long"]
+	if _, ok := tr.cols["unixmicro"]; !ok || !hasLat || !hasLong {
+		// the original logs only promised the first three columns
+		tr.cols = map[string]int{"unixmicro": 0, "lat": 1, "long": 2}
+	}
+	return tr, nil
+}
+
+// RowError is a row that couldn't be read, the rows after it still can be
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err.Error())
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// Read returns the next record, io.EOF at the end of the log and a
+// *RowError for a bad row
+func (r *Reader) Read() (gps.GPSRecord, error) {
+	fields, err := r.csv.Read()
+	if pe, ok := err.(*csv.ParseError); ok {
+		return gps.GPSRecord{}, &RowError{Line: pe.Line, Err: pe.Err}
+	}
+	if err != nil {
+		return gps.GPSRecord{}, err
+	}
+	line, _ := r.csv.FieldPos(0)
+	// versioned logs write every column, a short row is one the power cut
+	// off. Only the unversioned logs had rows of just the first few columns
+	if r.Version > 0 && len(fields) < len(r.header) {
+		return gps.GPSRecord{}, &RowError{Line: line, Err: fmt.Errorf("%d fields, want %d", len(fields), len(r.header))}
+	}
+	gr, err := r.parse(fields)
+	if err != nil {
+		return gps.GPSRecord{}, &RowError{Line: line, Err: err}
+	}
+	return gr, nil
+}
+
+func (r *Reader) parse(fields []string) (gps.GPSRecord, error) {
+	var gr gps.GPSRecord
+	get := func(name string) (string, bool) {
+		i, ok := r.cols[name]
+		if !ok || i >= len(fields) {
+			return "", false
+		}
+		return fields[i], true
+	}
+	required := func(name string) (string, error) {
+		v, ok := get(name)
+		if !ok {
+			return "", fmt.Errorf("missing %s column", name)
+		}
+		return v, nil
+	}
+
+	v, err := required("unixmicro")
+	if err != nil {
+		return gr, err
+	}
+	if gr.UnixMicro, err = strconv.ParseUint(v, 10, 64); err != nil {
+		return gr, fmt.Errorf("could not parse time: %w", err)
+	}
+	for _, f := range []struct {
+		name string
+		dst  *float64
+	}{{"lat", &gr.Lat}, {"long", &gr.Long}} {
+		v, err := required(f.name)
+		if err != nil {
+			return gr, err
+		}
+		if *f.dst, err = strconv.ParseFloat(v, 64); err != nil {
+			return gr, fmt.Errorf("could not parse %s: %w", f.name, err)
+		}
+	}
+
+	// everything else is optional, old logs won't have it
+	for _, f := range []struct {
+		name string
+		dst  *float64
+	}{
+		{"alt", &gr.Alt},
+		{"alt_ellipsoid", &gr.AltEllipsoid},
+		{"geoid_sep", &gr.GeoidSep},
+		{"speed", &gr.Speed},
+		{"heading", &gr.Heading},
+		{"mag_heading", &gr.MagHeading},
+		{"declination", &gr.Declination},
+		{"hdop", &gr.HDOP},
+	} {
+		if v, ok := get(f.name); ok && v != "" {
+			if *f.dst, err = strconv.ParseFloat(v, 64); err != nil {
+				return gr, fmt.Errorf("could not parse %s: %w", f.name, err)
+			}
+		}
+	}
+	if v, ok := get("num_sats"); ok && v != "" {
+		if gr.NumSats, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return gr, fmt.Errorf("could not parse num_sats: %w", err)
+		}
+	}
+	if v, ok := get("heading_source"); ok {
+		gr.HeadingSource = gps.ParseHeadingSource(v)
+	}
+	if v, ok := get("fix_quality"); ok {
+		gr.FixQuality = gps.ParseFixQuality(v)
+	}
+	if v, ok := get("time_str"); ok {
+		gr.TimeStr = v
+	}
+	if v, ok := get("gps_time"); ok && v != "" {
+		if gr.GPSTime, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return gr, fmt.Errorf("could not parse gps_time: %w", err)
+		}
+	}
+	return gr, nil
+}
+
+// ReadAll reads every record, stopping at the first bad row
+func ReadAll(r io.Reader) ([]gps.GPSRecord, error) {
+	return ReadAllFunc(r, nil)
+}
+
+// ReadAllFunc reads every record, calling bad with each bad row's
+// *RowError. It stops with bad's error if it returns one, a nil bad stops at
+// the first
+func ReadAllFunc(r io.Reader, bad func(error) error) ([]gps.GPSRecord, error) {
+	tr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]gps.GPSRecord, 0)
+	for {
+		gr, err := tr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if _, ok := err.(*RowError); ok && bad != nil {
+			err = bad(err)
+			if err == nil {
+				continue
+			}
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, gr)
+	}
+}
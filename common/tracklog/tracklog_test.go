@@ -0,0 +1,270 @@
+package tracklog
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/coord"
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+func testRecord(t time.Time, lat float64) gps.GPSRecord {
+	return gps.GPSRecord{
+		UnixMicro:     uint64(t.UnixMicro()),
+		Lat:           lat,
+		Long:          -90.184776,
+		Alt:           466.5,
+		AltEllipsoid:  363.2,
+		GeoidSep:      -103.3,
+		Speed:         31.2,
+		Heading:       271.5,
+		HeadingSource: gps.HeadingDerived,
+		MagHeading:    272.1,
+		Declination:   -0.6,
+		NumSats:       9,
+		HDOP:          0.9,
+		FixQuality:    gps.FixDGPS,
+		TimeStr:       "18:04:05.0000",
+		GPSTime:       t.UTC(),
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir)
+	day1 := time.Date(2022, time.May, 20, 23, 59, 58, 0, time.UTC)
+	day2 := day1.Add(3 * time.Second)
+	want := []gps.GPSRecord{testRecord(day1, 38.1), testRecord(day1.Add(time.Second), 38.2)}
+	for _, gr := range want {
+		if err := w.Write(gr); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	// crossing midnight GPS time starts the next day's file
+	if err := w.Write(testRecord(day2, 38.3)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "track-2022-05-20.csv"))
+	if err != nil {
+		t.Fatalf("first day's log missing: %v", err)
+	}
+	defer f.Close()
+	got, err := ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAll() = %+v, want %+v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "track-2022-05-21.csv")); err != nil {
+		t.Errorf("second day's log missing: %v", err)
+	}
+}
+
+func TestWriterRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir)
+	w.MaxBytes = 400 // a header and a couple of records
+	start := time.Date(2022, time.May, 20, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		if err := w.Write(testRecord(start.Add(time.Duration(i)*time.Second), 38)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "track-2022-05-20*.csv"))
+	if len(matches) < 2 {
+		t.Fatalf("expected the log to rotate, got files %v", matches)
+	}
+	total := 0
+	for _, m := range matches {
+		f, err := os.Open(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("ReadAll(%s) error = %v", m, err)
+		}
+		total += len(records)
+	}
+	if total != 6 {
+		t.Errorf("read %d records across rotated files, want 6", total)
+	}
+}
+
+func TestWriterRecoversTornLine(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2022, time.May, 20, 12, 0, 0, 0, time.UTC)
+	w := NewWriter(dir)
+	if err := w.Write(testRecord(start, 38.1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the power went out half way through a line
+	path := filepath.Join(dir, "track-2022-05-20.csv")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cut in the longitude, everything the reader needs is there but wrong
+	f.WriteString("1653048001000000,38.1,-90.18")
+	f.Close()
+
+	w = NewWriter(dir)
+	if err := w.Write(testRecord(start.Add(2*time.Second), 38.3)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	f, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var good, bad int
+	for {
+		_, err := r.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			bad++
+			continue
+		}
+		good++
+	}
+	if good != 2 || bad != 1 {
+		t.Errorf("read %d good and %d bad rows, want 2 and 1", good, bad)
+	}
+}
+
+func TestReaderLegacyLayout(t *testing.T) {
+	// the original logs: a header of any names and unixmicro,lat,long
+	legacy := "time,latitude,longitude\n1653048000000000,38.5,-90.5\n1653048001000000,38.6,-90.6\n"
+	got, err := ReadAll(strings.NewReader(legacy))
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	want := []gps.GPSRecord{
+		{UnixMicro: 1653048000000000, Lat: 38.5, Long: -90.5},
+		{UnixMicro: 1653048001000000, Lat: 38.6, Long: -90.6},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAll() = %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteAll(t *testing.T) {
+	start := time.Date(2022, time.May, 20, 12, 0, 0, 0, time.UTC)
+	want := []gps.GPSRecord{testRecord(start, 38.1), testRecord(start.Add(time.Second), 38.2)}
+	var sb strings.Builder
+	if err := WriteAll(&sb, want); err != nil {
+		t.Fatalf("WriteAll() error = %v", err)
+	}
+	got, err := ReadAll(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAll() = %+v, want %+v", got, want)
+	}
+}
+
+func TestReadAllFunc(t *testing.T) {
+	in := "unixmicro,lat,long\n1653048000000000,38.5,-90.5\n1653048001000000,north,-90.6\n" +
+		"1653048002000000,\"38.7,-90.7\n"
+	var skipped []string
+	got, err := ReadAllFunc(strings.NewReader(in), func(err error) error {
+		skipped = append(skipped, err.Error())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadAllFunc() error = %v", err)
+	}
+	if len(got) != 1 || len(skipped) != 2 || !strings.HasPrefix(skipped[0], "line 3:") {
+		t.Errorf("ReadAllFunc() = %d records, skipped %q", len(got), skipped)
+	}
+
+	// without a bad func the first bad row stops the read
+	got, err = ReadAll(strings.NewReader(in))
+	var re *RowError
+	if !errors.As(err, &re) || re.Line != 3 || len(got) != 1 {
+		t.Errorf("ReadAll() = %d records, %v, want 1 and a line 3 error", len(got), err)
+	}
+}
+
+func TestWriterCoords(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2022, time.May, 20, 12, 0, 0, 0, time.UTC)
+	w := NewWriter(dir)
+	w.Coords = coord.MGRS
+	want := []gps.GPSRecord{testRecord(start, 38.1)}
+	if err := w.Write(want[0]); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	path := filepath.Join(dir, "track-2022-05-20.csv")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	mgrs := coord.MGRS.Format(38.1, -90.184776)
+	if !strings.HasSuffix(lines[1], ","+CoordsColumn) || !strings.HasSuffix(lines[2], ","+mgrs) {
+		t.Errorf("log is\n%s\nwant a coords column of %s", b, mgrs)
+	}
+	// the extra column doesn't get in the way of reading
+	f, _ := os.Open(path)
+	got, err := ReadAll(f)
+	f.Close()
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAll() = %+v, %v, want %+v", got, err, want)
+	}
+
+	// a logger restarted without the column doesn't append to the file
+	w = NewWriter(dir)
+	if err := w.Write(testRecord(start.Add(time.Second), 38.2)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if _, err := os.Stat(w.path("2022-05-20", 1)); err != nil {
+		t.Errorf("restart without coords didn't move on to a new file: %v", err)
+	}
+}
+
+func TestReaderTornLastRow(t *testing.T) {
+	start := time.Date(2022, time.May, 20, 12, 0, 0, 0, time.UTC)
+	var sb strings.Builder
+	if err := WriteAll(&sb, []gps.GPSRecord{testRecord(start, 38.1)}); err != nil {
+		t.Fatal(err)
+	}
+	sb.WriteString("1653048001000000,38.2,-90.1")
+	var skipped []string
+	got, err := ReadAllFunc(strings.NewReader(sb.String()), func(err error) error {
+		skipped = append(skipped, err.Error())
+		return nil
+	})
+	if err != nil || len(got) != 1 || len(skipped) != 1 || !strings.Contains(skipped[0], "3 fields, want 16") {
+		t.Errorf("ReadAllFunc() = %d records, %v, skipped %q, want the torn row skipped", len(got), err, skipped)
+	}
+}
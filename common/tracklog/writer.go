@@ -0,0 +1,230 @@
+package tracklog
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/coord"
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// Writer appends records to a directory of logs named by GPS date
+// (<prefix>-2006-01-02.csv, then .1.csv, .2.csv once MaxBytes is reached).
+// The Pi loses power with the ignition so the file is fsynced every
+// SyncEvery, at most that much driving is lost
+type Writer struct {
+	Dir       string
+	Prefix    string
+	MaxBytes  int64
+	SyncEvery time.Duration
+	// Coords adds a coords column in this notation unless it's DD
+	Coords coord.Format
+
+	f        *os.File
+	bw       *bufio.Writer
+	cw       *csv.Writer
+	day      string
+	seq      int
+	size     int64
+	lastSync time.Time
+}
+
+// NewWriter returns a writer with 64MB files synced every 5 seconds
+func NewWriter(dir string) *Writer {
+	return &Writer{
+		Dir:       dir,
+		Prefix:    "track",
+		MaxBytes:  64 << 20,
+		SyncEvery: 5 * time.Second,
+	}
+}
+
+// Path is the file currently being written, empty before the first record
+func (w *Writer) Path() string {
+	if w.f == nil {
+		return ""
+	}
+	return w.f.Name()
+}
+
+func (w *Writer) path(day string, seq int) string {
+	if seq == 0 {
+		return filepath.Join(w.Dir, fmt.Sprintf("%s-%s.csv", w.Prefix, day))
+	}
+	return filepath.Join(w.Dir, fmt.Sprintf("%s-%s.%d.csv", w.Prefix, day, seq))
+}
+
+// sameHeader reports whether the log at p starts with the version line and
+// header this writer writes, so rows appended to it line up
+func (w *Writer) sameHeader(p string) (bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	tr, err := NewReader(f)
+	if err != nil {
+		// not even a whole header, a fresh file is better than appending
+		return false, nil
+	}
+	return tr.Version == Version && reflect.DeepEqual(tr.header, header(w.Coords)), nil
+}
+
+// open finds the first file for the day with room left and the same
+// columns, appending to it if it's left over from before a reboot
+func (w *Writer) open(day string, seq int) error {
+	if err := os.MkdirAll(w.Dir, 0o755); err != nil {
+		return err
+	}
+	for {
+		p := w.path(day, seq)
+		info, err := os.Stat(p)
+		if err == nil && info.Size() >= w.MaxBytes {
+			seq++
+			continue
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil && info.Size() > 0 {
+			same, err := w.sameHeader(p)
+			if err != nil {
+				return err
+			}
+			if !same {
+				seq++
+				continue
+			}
+		}
+		f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		w.f, w.day, w.seq = f, day, seq
+		w.size = 0
+		if info != nil {
+			w.size = info.Size()
+		}
+		w.bw = bufio.NewWriter(f)
+		w.cw = csv.NewWriter(&countingWriter{w: w.bw, n: &w.size})
+		break
+	}
+
+	if w.size == 0 {
+		n, err := fmt.Fprintf(w.bw, "%s%d\n", versionPrefix, Version)
+		if err != nil {
+			return err
+		}
+		w.size += int64(n)
+		if err := w.cw.Write(header(w.Coords)); err != nil {
+			return err
+		}
+		// make sure the new directory entry survives a power cut too
+		if d, err := os.Open(w.Dir); err == nil {
+			_ = d.Sync()
+			d.Close()
+		}
+	} else if err := w.terminateTornLine(); err != nil {
+		return err
+	}
+	return w.Sync()
+}
+
+// terminateTornLine starts a fresh line if the file ends mid-record, which is
+// what a power cut during a write leaves behind
+func (w *Writer) terminateTornLine() error {
+	last := make([]byte, 1)
+	if _, err := w.f.ReadAt(last, w.size-1); err != nil && err != io.EOF {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err := w.bw.WriteString("\n")
+		w.size++
+		return err
+	}
+	return nil
+}
+
+// Write appends a record, rotating first if the GPS date changed or the file
+// is full
+func (w *Writer) Write(gr gps.GPSRecord) error {
+	day := gr.Time().UTC().Format("2006-01-02")
+	if w.f != nil && day != w.day {
+		if err := w.closeFile(); err != nil {
+			return err
+		}
+	} else if w.f != nil && w.size >= w.MaxBytes {
+		seq := w.seq + 1
+		if err := w.closeFile(); err != nil {
+			return err
+		}
+		if err := w.open(day, seq); err != nil {
+			return err
+		}
+	}
+	if w.f == nil {
+		if err := w.open(day, 0); err != nil {
+			return err
+		}
+	}
+
+	if err := w.cw.Write(row(gr, w.Coords)); err != nil {
+		return err
+	}
+	// push the row through to the bufio writer so size is current
+	w.cw.Flush()
+	if err := w.cw.Error(); err != nil {
+		return err
+	}
+	if time.Since(w.lastSync) >= w.SyncEvery {
+		return w.Sync()
+	}
+	return nil
+}
+
+// Sync flushes buffered records and fsyncs the file
+func (w *Writer) Sync() error {
+	if w.f == nil {
+		return nil
+	}
+	w.cw.Flush()
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	w.lastSync = time.Now()
+	return w.f.Sync()
+}
+
+func (w *Writer) closeFile() error {
+	err := w.Sync()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	w.f = nil
+	return err
+}
+
+// Close syncs and closes the current file
+func (w *Writer) Close() error {
+	if w.f == nil {
+		return nil
+	}
+	return w.closeFile()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,186 @@
+package trackstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// Block is one block's entry in the index
+type Block struct {
+	Offset int64 // from the start of the file
+	Size   int64 // header, payload and checksum
+	Count  int
+	Start  time.Time // earliest record
+	End    time.Time // latest record
+}
+
+const indexEntrySize = 32
+
+func (b Block) appendEntry(e []byte) []byte {
+	e = appendUint64(e, uint64(b.Offset))
+	e = appendUint32(e, uint32(b.Size))
+	e = appendUint32(e, uint32(b.Count))
+	e = appendUint64(e, uint64(b.Start.UnixMicro()))
+	return appendUint64(e, uint64(b.End.UnixMicro()))
+}
+
+func parseEntry(e []byte) Block {
+	return Block{
+		Offset: int64(binary.LittleEndian.Uint64(e)),
+		Size:   int64(binary.LittleEndian.Uint32(e[8:])),
+		Count:  int(binary.LittleEndian.Uint32(e[12:])),
+		Start:  time.UnixMicro(int64(binary.LittleEndian.Uint64(e[16:]))).UTC(),
+		End:    time.UnixMicro(int64(binary.LittleEndian.Uint64(e[24:]))).UTC(),
+	}
+}
+
+// readBlock reads and checks the block at off, ok is false if it isn't a
+// whole block with a good checksum
+func readBlock(r io.ReaderAt, off, size int64) (b Block, payload []byte, ok bool, err error) {
+	if off+headerSize+trailerSize > size {
+		return b, nil, false, nil
+	}
+	header := make([]byte, headerSize)
+	if _, err := r.ReadAt(header, off); err != nil {
+		return b, nil, false, err
+	}
+	if binary.LittleEndian.Uint32(header) != blockSync {
+		return b, nil, false, nil
+	}
+	n := int64(binary.LittleEndian.Uint32(header[4:]))
+	if n > maxPayload || off+headerSize+n+trailerSize > size {
+		return b, nil, false, nil
+	}
+	whole := make([]byte, headerSize+n+trailerSize)
+	copy(whole, header)
+	if _, err := r.ReadAt(whole[headerSize:], off+headerSize); err != nil {
+		return b, nil, false, err
+	}
+	body := whole[:headerSize+n]
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(whole[headerSize+n:]) {
+		return b, nil, false, nil
+	}
+	b = Block{
+		Offset: off,
+		Size:   int64(len(whole)),
+		Count:  int(binary.LittleEndian.Uint32(header[8:])),
+		Start:  time.UnixMicro(int64(binary.LittleEndian.Uint64(header[12:]))).UTC(),
+		End:    time.UnixMicro(int64(binary.LittleEndian.Uint64(header[20:]))).UTC(),
+	}
+	return b, body[headerSize:], true, nil
+}
+
+// findSync returns the offset of the next block sync marker at or after off,
+// -1 if there isn't one
+func findSync(r io.ReaderAt, off, size int64) (int64, error) {
+	var marker [4]byte
+	binary.LittleEndian.PutUint32(marker[:], blockSync)
+	buf := make([]byte, 64*1024)
+	for off < size {
+		n, err := r.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			return -1, err
+		}
+		if i := bytes.Index(buf[:n], marker[:]); i >= 0 {
+			return off + int64(i), nil
+		}
+		if n < len(marker) {
+			break
+		}
+		// overlap the windows so a marker across the boundary is found
+		off += int64(n - len(marker) + 1)
+	}
+	return -1, nil
+}
+
+// scanResult is what a recovery scan found
+type scanResult struct {
+	blocks   []Block
+	validEnd int64 // end of the last good block, anything after is torn
+	skipped  int64 // bytes of bad blocks between good ones
+}
+
+// scan walks the blocks from off, skipping over corrupt ones to the next
+// good block so one bad sector doesn't lose the rest of the drive
+func scan(r io.ReaderAt, off, size int64) (scanResult, error) {
+	res := scanResult{validEnd: off}
+	for off < size {
+		b, _, ok, err := readBlock(r, off, size)
+		if err != nil {
+			return res, err
+		}
+		if ok {
+			res.skipped += off - res.validEnd
+			res.blocks = append(res.blocks, b)
+			off += b.Size
+			res.validEnd = off
+			continue
+		}
+		if off, err = findSync(r, off+1, size); err != nil {
+			return res, err
+		}
+		if off < 0 {
+			break
+		}
+	}
+	return res, nil
+}
+
+func indexPath(path string) string {
+	return path + ".idx"
+}
+
+// load checks the magic and finds the blocks, from the index as far as it's
+// believable and by scanning the rest
+func load(f *os.File, path string) (scanResult, int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return scanResult{}, 0, err
+	}
+	size := info.Size()
+	magic := make([]byte, len(fileMagic))
+	if _, err := f.ReadAt(magic, 0); err != nil || !IsStore(magic) {
+		return scanResult{}, size, fmt.Errorf("%s is not a track store", path)
+	}
+
+	var trusted []Block
+	end := int64(len(fileMagic))
+	if idx, err := os.ReadFile(indexPath(path)); err == nil && bytes.HasPrefix(idx, []byte(indexMagic)) {
+		idx = idx[len(indexMagic):]
+		for len(idx) >= indexEntrySize {
+			b := parseEntry(idx)
+			idx = idx[indexEntrySize:]
+			if b.Offset < end || b.Offset+b.Size > size {
+				break
+			}
+			trusted = append(trusted, b)
+			end = b.Offset + b.Size
+		}
+	}
+	res, err := scan(f, end, size)
+	if err != nil {
+		return res, size, err
+	}
+	// indexed blocks aren't checksummed until they're read
+	res.blocks = append(trusted, res.blocks...)
+	return res, size, nil
+}
+
+// writeIndex replaces the index file
+func writeIndex(path string, blocks []Block) error {
+	b := make([]byte, 0, len(indexMagic)+len(blocks)*indexEntrySize)
+	b = append(b, indexMagic...)
+	for _, blk := range blocks {
+		b = blk.appendEntry(b)
+	}
+	tmp := indexPath(path) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, indexPath(path))
+}
@@ -0,0 +1,322 @@
+package trackstore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/tracklog"
+	"github.com/sirupsen/logrus"
+)
+
+// Store reads a track store file
+type Store struct {
+	// Blocks in file order
+	Blocks []Block
+	// Skipped is bytes of corrupt blocks passed over, Torn is the bytes after
+	// the last good block
+	Skipped int64
+	Torn    int64
+
+	f    *os.File
+	size int64
+}
+
+// Open loads a store's index, scanning whatever the index doesn't cover
+func Open(path string) (*Store, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	res, size, err := load(f, path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Store{
+		Blocks:  res.blocks,
+		Skipped: res.skipped,
+		Torn:    size - res.validEnd,
+		f:       f,
+		size:    size,
+	}, nil
+}
+
+// Close closes the file
+func (s *Store) Close() error {
+	return s.f.Close()
+}
+
+// ReadBlock reads and checksums one block's records
+func (s *Store) ReadBlock(b Block) ([]gps.GPSRecord, error) {
+	records, corrupt, err := s.readBlock(b)
+	if err != nil {
+		return nil, err
+	}
+	if corrupt != nil {
+		return nil, corrupt
+	}
+	return records, nil
+}
+
+// readBlock separates a block that's bad from failing to read it at all
+func (s *Store) readBlock(b Block) (records []gps.GPSRecord, corrupt, err error) {
+	_, payload, ok, err := readBlock(s.f, b.Offset, s.size)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("block at %d is corrupt", b.Offset), nil
+	}
+	records, corrupt = decodeRecords(payload, b.Count)
+	if corrupt != nil {
+		return nil, fmt.Errorf("block at %d: %w", b.Offset, corrupt), nil
+	}
+	return records, nil, nil
+}
+
+// Range returns the records from from up to but not including to, in file
+// order. A zero from or to leaves that end open. Only blocks whose time range
+// overlaps are read, and a block that turns out to be corrupt is skipped and
+// added to Skipped
+func (s *Store) Range(from, to time.Time) ([]gps.GPSRecord, error) {
+	records := make([]gps.GPSRecord, 0)
+	for _, b := range s.Blocks {
+		if (!from.IsZero() && b.End.Before(from)) || (!to.IsZero() && !b.Start.Before(to)) {
+			continue
+		}
+		block, corrupt, err := s.readBlock(b)
+		if err != nil {
+			return records, err
+		}
+		if corrupt != nil {
+			s.Skipped += b.Size
+			continue
+		}
+		for _, gr := range block {
+			t := gr.Time()
+			if (!from.IsZero() && t.Before(from)) || (!to.IsZero() && !t.Before(to)) {
+				continue
+			}
+			records = append(records, gr)
+		}
+	}
+	return records, nil
+}
+
+// ReadAll returns every record
+func (s *Store) ReadAll() ([]gps.GPSRecord, error) {
+	return s.Range(time.Time{}, time.Time{})
+}
+
+// ReadFile reads every record from a track store or, if the file doesn't
+// start with the store's magic, a CSV track log
+func ReadFile(path string) ([]gps.GPSRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, len(fileMagic))
+	n, _ := io.ReadFull(f, head)
+	if !IsStore(head[:n]) {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return tracklog.ReadAll(f)
+	}
+	s, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	records, err := s.ReadAll()
+	if s.Skipped > 0 || s.Torn > 0 {
+		logrus.Warnf("%s: skipped %d corrupt bytes and %d bytes of torn writes", path, s.Skipped, s.Torn)
+	}
+	return records, err
+}
+
+// Writer appends records to a directory of stores named by GPS date
+// (<prefix>-2006-01-02.trk). Records are collected into a block that's
+// written and fsynced once it has BlockRecords records or SyncEvery has
+// passed, so at most that much driving is lost when the power goes
+type Writer struct {
+	Dir          string
+	Prefix       string
+	SyncEvery    time.Duration
+	BlockRecords int
+	// Recovered is the bytes of torn writes cut off files that were reopened
+	Recovered int64
+
+	f        *os.File
+	idx      *os.File
+	day      string
+	payload  []byte
+	count    int
+	min, max int64
+	lastSync time.Time
+}
+
+// NewWriter returns a writer with blocks of up to 64 records written at
+// least every 5 seconds
+func NewWriter(dir string) *Writer {
+	return &Writer{
+		Dir:          dir,
+		Prefix:       "track",
+		SyncEvery:    5 * time.Second,
+		BlockRecords: 64,
+	}
+}
+
+// Path is the file currently being written, empty before the first record
+func (w *Writer) Path() string {
+	if w.f == nil {
+		return ""
+	}
+	return w.f.Name()
+}
+
+// open starts or reopens the day's file, cutting off any torn tail left by a
+// power cut and bringing its index up to date
+func (w *Writer) open(day string) error {
+	if err := os.MkdirAll(w.Dir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(w.Dir, fmt.Sprintf("%s-%s%s", w.Prefix, day, Ext))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	var blocks []Block
+	if info.Size() == 0 {
+		if _, err := f.Write([]byte(fileMagic)); err != nil {
+			f.Close()
+			return err
+		}
+		// make sure the new directory entry survives a power cut too
+		if d, err := os.Open(w.Dir); err == nil {
+			_ = d.Sync()
+			d.Close()
+		}
+	} else {
+		res, size, err := load(f, path)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if res.validEnd < size {
+			if err := f.Truncate(res.validEnd); err != nil {
+				f.Close()
+				return err
+			}
+			w.Recovered += size - res.validEnd
+		}
+		blocks = res.blocks
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := writeIndex(path, blocks); err != nil {
+		f.Close()
+		return err
+	}
+	idx, err := os.OpenFile(indexPath(path), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.idx, w.day = f, idx, day
+	w.lastSync = time.Now()
+	return nil
+}
+
+// Write adds a record to the current block, writing the block out if it's
+// full or due
+func (w *Writer) Write(gr gps.GPSRecord) error {
+	day := gr.Time().UTC().Format("2006-01-02")
+	if w.f != nil && day != w.day {
+		if err := w.closeFile(); err != nil {
+			return err
+		}
+	}
+	if w.f == nil {
+		if err := w.open(day); err != nil {
+			return err
+		}
+	}
+
+	t := timeMicros(gr)
+	if w.count == 0 || t < w.min {
+		w.min = t
+	}
+	if w.count == 0 || t > w.max {
+		w.max = t
+	}
+	w.payload = appendRecord(w.payload, gr)
+	w.count++
+	if w.count >= w.BlockRecords || time.Since(w.lastSync) >= w.SyncEvery {
+		return w.Sync()
+	}
+	return nil
+}
+
+// Sync writes out and fsyncs the current block
+func (w *Writer) Sync() error {
+	w.lastSync = time.Now()
+	if w.f == nil || w.count == 0 {
+		return nil
+	}
+	info, err := w.f.Stat()
+	if err != nil {
+		return err
+	}
+	block := encodeBlock(w.payload, w.count, w.min, w.max)
+	if _, err := w.f.Write(block); err != nil {
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	b := Block{
+		Offset: info.Size(),
+		Size:   int64(len(block)),
+		Count:  w.count,
+		Start:  time.UnixMicro(w.min).UTC(),
+		End:    time.UnixMicro(w.max).UTC(),
+	}
+	w.payload, w.count = w.payload[:0], 0
+	// the index is only a cache, no need to wait for it to hit the card
+	_, err = w.idx.Write(b.appendEntry(nil))
+	return err
+}
+
+func (w *Writer) closeFile() error {
+	err := w.Sync()
+	if cerr := w.idx.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	w.f, w.idx = nil, nil
+	return err
+}
+
+// Close writes the last block and closes the file
+func (w *Writer) Close() error {
+	if w.f == nil {
+		return nil
+	}
+	return w.closeFile()
+}
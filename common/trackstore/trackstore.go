@@ -0,0 +1,225 @@
+package trackstore
+
+// binary track store: an append-only file of checksummed blocks of records.
+//  The Pi loses power with the ignition, so a block is only ever appended
+//	whole and fsynced, and anything after the last block with a good checksum
+//	is a torn write that the next writer cuts off. A sidecar .idx file keeps
+//	each block's offset and time range so a range query only reads the blocks
+//	it needs; it's only a cache and is rebuilt by scanning when it's behind.
+//
+//	file:   magic "RGPSTRK1", then blocks
+//	block:  sync u32 | payload length u32 | record count u32 |
+//	        min time i64 | max time i64 | payload | crc32c u32
+//	record: uvarint length, then the fields in appendRecord's order
+//
+// Times in block headers are gps.GPSRecord.Time() in unix microseconds.
+// Records are length prefixed so fields can be added to the end without
+// breaking older readers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+const (
+	fileMagic   = "RGPSTRK1"
+	indexMagic  = "RGPSIDX1"
+	blockSync   = 0x4b4c4252 // "RBLK" little endian
+	headerSize  = 28
+	trailerSize = 4
+	// no writer makes blocks anywhere near this big, a bigger length is
+	// garbage rather than a reason to allocate
+	maxPayload = 1 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Ext is the extension the writer uses for store files
+const Ext = ".trk"
+
+// IsStore reports whether the start of a file is a track store's magic
+func IsStore(head []byte) bool {
+	return len(head) >= len(fileMagic) && string(head[:len(fileMagic)]) == fileMagic
+}
+
+func timeMicros(gr gps.GPSRecord) int64 {
+	return gr.Time().UnixMicro()
+}
+
+// appendRecord encodes a record, length prefixed
+func appendRecord(b []byte, gr gps.GPSRecord) []byte {
+	var rec []byte
+	rec = appendUvarint(rec, gr.UnixMicro)
+	for _, f := range []float64{
+		gr.Lat, gr.Long, gr.Alt, gr.AltEllipsoid, gr.GeoidSep, gr.Speed,
+		gr.Heading, gr.MagHeading, gr.Declination,
+	} {
+		rec = appendUint64(rec, math.Float64bits(f))
+	}
+	rec = append(rec, byte(gr.HeadingSource))
+	rec = appendVarint(rec, gr.NumSats)
+	rec = appendUvarint(rec, uint64(len(gr.TimeStr)))
+	rec = append(rec, gr.TimeStr...)
+	var gpsTime int64 // zero for no time from the receiver
+	if !gr.GPSTime.IsZero() {
+		gpsTime = gr.GPSTime.UnixNano()
+	}
+	rec = appendVarint(rec, gpsTime)
+	rec = appendUint64(rec, math.Float64bits(gr.HDOP))
+	rec = append(rec, byte(gr.FixQuality))
+
+	b = appendUvarint(b, uint64(len(rec)))
+	return append(b, rec...)
+}
+
+// recordDecoder reads fields off the front of one record
+type recordDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *recordDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = fmt.Errorf("bad uvarint")
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *recordDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = fmt.Errorf("bad varint")
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *recordDecoder) float() float64 {
+	return math.Float64frombits(d.fixed(8))
+}
+
+func (d *recordDecoder) fixed(n int) uint64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.b) < n {
+		d.err = fmt.Errorf("record too short")
+		return 0
+	}
+	var v uint64
+	if n == 8 {
+		v = binary.LittleEndian.Uint64(d.b)
+	} else {
+		v = uint64(d.b[0])
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *recordDecoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.b)) < n {
+		d.err = fmt.Errorf("record too short")
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+// decodeRecords decodes a block's payload
+func decodeRecords(payload []byte, count int) ([]gps.GPSRecord, error) {
+	records := make([]gps.GPSRecord, 0, count)
+	for len(payload) > 0 {
+		n, w := binary.Uvarint(payload)
+		if w <= 0 || uint64(len(payload)-w) < n {
+			return records, fmt.Errorf("record %d: bad length", len(records))
+		}
+		d := &recordDecoder{b: payload[w : w+int(n)]}
+		payload = payload[w+int(n):]
+
+		var gr gps.GPSRecord
+		gr.UnixMicro = d.uvarint()
+		for _, f := range []*float64{
+			&gr.Lat, &gr.Long, &gr.Alt, &gr.AltEllipsoid, &gr.GeoidSep, &gr.Speed,
+			&gr.Heading, &gr.MagHeading, &gr.Declination,
+		} {
+			*f = d.float()
+		}
+		gr.HeadingSource = gps.HeadingSource(d.fixed(1))
+		gr.NumSats = d.varint()
+		gr.TimeStr = string(d.bytes(d.uvarint()))
+		if ns := d.varint(); ns != 0 {
+			gr.GPSTime = time.Unix(0, ns).UTC()
+		}
+		// fields added since the first version aren't in older records
+		if len(d.b) > 0 {
+			gr.HDOP = d.float()
+		}
+		if len(d.b) > 0 {
+			gr.FixQuality = gps.FixQuality(d.fixed(1))
+		}
+		// anything left over is a field from a newer writer
+		if d.err != nil {
+			return records, fmt.Errorf("record %d: %w", len(records), d.err)
+		}
+		records = append(records, gr)
+	}
+	if len(records) != count {
+		return records, fmt.Errorf("block has %d records, header says %d", len(records), count)
+	}
+	return records, nil
+}
+
+// encodeBlock frames encoded records as a block
+func encodeBlock(payload []byte, count int, min, max int64) []byte {
+	b := make([]byte, 0, headerSize+len(payload)+trailerSize)
+	b = appendUint32(b, blockSync)
+	b = appendUint32(b, uint32(len(payload)))
+	b = appendUint32(b, uint32(count))
+	b = appendUint64(b, uint64(min))
+	b = appendUint64(b, uint64(max))
+	b = append(b, payload...)
+	return appendUint32(b, crc32.Checksum(b, crcTable))
+}
+
+// the append helpers are in encoding/binary from go 1.19, this is 1.18
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,243 @@
+package trackstore
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+var start = time.Date(2022, time.May, 20, 12, 0, 0, 0, time.UTC)
+
+func testRecord(i int) gps.GPSRecord {
+	t := start.Add(time.Duration(i) * time.Second)
+	return gps.GPSRecord{
+		UnixMicro:     uint64(t.UnixMicro()),
+		Lat:           38.6 + float64(i)*1e-4,
+		Long:          -90.184776,
+		Alt:           466.5,
+		AltEllipsoid:  363.2,
+		GeoidSep:      -103.3,
+		Speed:         31.2,
+		Heading:       271.5,
+		HeadingSource: gps.HeadingDerived,
+		MagHeading:    272.1,
+		Declination:   -0.6,
+		NumSats:       9,
+		HDOP:          0.9,
+		FixQuality:    gps.FixDGPS,
+		TimeStr:       "12:00:00.0000",
+		GPSTime:       t,
+	}
+}
+
+// writeStore writes n records in blocks of 4 and returns the file's path
+func writeStore(t *testing.T, n int) (string, []gps.GPSRecord) {
+	t.Helper()
+	dir := t.TempDir()
+	w := NewWriter(dir)
+	w.BlockRecords = 4
+	w.SyncEvery = time.Hour
+	want := make([]gps.GPSRecord, 0, n)
+	for i := 0; i < n; i++ {
+		gr := testRecord(i)
+		if err := w.Write(gr); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		want = append(want, gr)
+	}
+	path := w.Path()
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if path != filepath.Join(dir, "track-2022-05-20.trk") {
+		t.Fatalf("wrote to %s", path)
+	}
+	return path, want
+}
+
+func readAll(t *testing.T, path string) (*Store, []gps.GPSRecord) {
+	t.Helper()
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	got, err := s.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	return s, got
+}
+
+func TestRoundTrip(t *testing.T) {
+	path, want := writeStore(t, 10)
+	s, got := readAll(t, path)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAll() = %+v, want %+v", got, want)
+	}
+	if len(s.Blocks) != 3 || s.Skipped != 0 || s.Torn != 0 {
+		t.Errorf("got %d blocks, %d skipped, %d torn, want 3, 0, 0", len(s.Blocks), s.Skipped, s.Torn)
+	}
+
+	// the index is only a cache, the same blocks come from a scan
+	if err := os.Remove(indexPath(path)); err != nil {
+		t.Fatal(err)
+	}
+	scanned, got := readAll(t, path)
+	if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(scanned.Blocks, s.Blocks) {
+		t.Errorf("without the index got blocks %+v, want %+v", scanned.Blocks, s.Blocks)
+	}
+}
+
+func TestRange(t *testing.T) {
+	path, want := writeStore(t, 10)
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     []gps.GPSRecord
+	}{
+		{"all", time.Time{}, time.Time{}, want},
+		{"middle", start.Add(3 * time.Second), start.Add(6 * time.Second), want[3:6]},
+		{"open start", time.Time{}, start.Add(2 * time.Second), want[:2]},
+		{"open end", start.Add(8 * time.Second), time.Time{}, want[8:]},
+		{"none", start.Add(time.Hour), time.Time{}, []gps.GPSRecord{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Range(tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("Range() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Range() = %d records, want %d", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestTornTailRecovery(t *testing.T) {
+	path, want := writeStore(t, 8)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the power went out half way through the next block
+	block := encodeBlock(appendRecord(nil, testRecord(8)), 1, 0, 0)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(block[:len(block)/2])
+	f.Close()
+
+	s, got := readAll(t, path)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAll() after a torn write = %d records, want %d", len(got), len(want))
+	}
+	if s.Torn != int64(len(block)/2) {
+		t.Errorf("Torn = %d, want %d", s.Torn, len(block)/2)
+	}
+
+	// the next writer cuts it off and carries on
+	w := NewWriter(filepath.Dir(path))
+	if err := w.Write(testRecord(8)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Recovered != int64(len(block)/2) {
+		t.Errorf("Recovered = %d, want %d", w.Recovered, len(block)/2)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() != info.Size()+int64(len(block)) {
+		t.Errorf("size after recovery = %d, want %d", after.Size(), info.Size()+int64(len(block)))
+	}
+	_, got = readAll(t, path)
+	if want = append(want, testRecord(8)); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAll() after recovery = %d records, want %d", len(got), len(want))
+	}
+}
+
+func TestCorruptBlockSkipped(t *testing.T) {
+	path, want := writeStore(t, 12)
+	s, _ := readAll(t, path)
+	bad := s.Blocks[1]
+
+	// a bad sector in the middle block
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[bad.Offset+headerSize+5] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wantLeft := append(append([]gps.GPSRecord{}, want[:4]...), want[8:]...)
+
+	for _, withIndex := range []bool{true, false} {
+		if !withIndex {
+			os.Remove(indexPath(path))
+		}
+		s, got := readAll(t, path)
+		if !reflect.DeepEqual(got, wantLeft) {
+			t.Errorf("index %v: ReadAll() = %d records, want %d", withIndex, len(got), len(wantLeft))
+		}
+		if s.Skipped != bad.Size || s.Torn != 0 {
+			t.Errorf("index %v: Skipped = %d, Torn = %d, want %d, 0", withIndex, s.Skipped, s.Torn, bad.Size)
+		}
+	}
+}
+
+func TestOpenRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "track.csv")
+	if err := os.WriteFile(path, []byte("unixmicro,lat,long\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Error("Open() accepted a CSV log")
+	}
+}
+
+func TestDecodeOlderRecords(t *testing.T) {
+	full := testRecord(0)
+	noFix := full
+	noFix.FixQuality = gps.FixUnknown
+	noHDOP := noFix
+	noHDOP.HDOP = 0
+	tests := []struct {
+		name  string
+		short int // bytes the older writer didn't write
+		want  gps.GPSRecord
+	}{
+		{"before fix quality", 1, noFix},
+		{"before HDOP", 9, noHDOP},
+	}
+	for _, tt := range tests {
+		rec := appendRecord(nil, full)
+		_, n := binary.Uvarint(rec)
+		body := rec[n : len(rec)-tt.short]
+		old := append(appendUvarint(nil, uint64(len(body))), body...)
+
+		got, err := decodeRecords(old, 1)
+		if err != nil {
+			t.Fatalf("%s: decodeRecords() error = %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, []gps.GPSRecord{tt.want}) {
+			t.Errorf("%s: decodeRecords() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
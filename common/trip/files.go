@@ -0,0 +1,322 @@
+package trip
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/coord"
+	"github.com/samiam2013/raspigogps/common/geocode"
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/tracklog"
+	"github.com/sirupsen/logrus"
+)
+
+// IndexName is the trip index in a trips directory
+const IndexName = "trips.csv"
+
+var indexColumns = []string{
+	"id", "start", "end", "duration_s", "distance_m", "records",
+	"start_lat", "start_long", "end_lat", "end_long", "start_place", "end_place",
+}
+
+// TripPath is where a trip's records are kept in dir
+func TripPath(dir, id string) string {
+	return filepath.Join(dir, "trip-"+id+".csv")
+}
+
+// WriteIndex writes a trip index
+func WriteIndex(w io.Writer, trips []Trip) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(indexColumns); err != nil {
+		return err
+	}
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, t := range trips {
+		if err := cw.Write([]string{
+			t.ID,
+			t.Start.UTC().Format(time.RFC3339),
+			t.End.UTC().Format(time.RFC3339),
+			strconv.FormatInt(int64(t.Duration()/time.Second), 10),
+			strconv.FormatFloat(t.Distance, 'f', 1, 64),
+			strconv.Itoa(t.Records),
+			f(t.StartLat), f(t.StartLong), f(t.EndLat), f(t.EndLong),
+			t.StartPlace, t.EndPlace,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadIndex reads a trip index
+func ReadIndex(r io.Reader) ([]Trip, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read header: %w", err)
+	}
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		cols[name] = i
+	}
+	for _, name := range indexColumns {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("missing %s column", name)
+		}
+	}
+
+	trips := make([]Trip, 0)
+	for {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			return trips, nil
+		}
+		if err != nil {
+			return trips, err
+		}
+		line, _ := cr.FieldPos(0)
+		get := func(name string) string { return fields[cols[name]] }
+		var t Trip
+		t.ID = get("id")
+		t.StartPlace, t.EndPlace = get("start_place"), get("end_place")
+		if t.Start, err = time.Parse(time.RFC3339, get("start")); err != nil {
+			return trips, fmt.Errorf("line %d: could not parse start: %w", line, err)
+		}
+		if t.End, err = time.Parse(time.RFC3339, get("end")); err != nil {
+			return trips, fmt.Errorf("line %d: could not parse end: %w", line, err)
+		}
+		if t.Records, err = strconv.Atoi(get("records")); err != nil {
+			return trips, fmt.Errorf("line %d: could not parse records: %w", line, err)
+		}
+		for _, fl := range []struct {
+			name string
+			dst  *float64
+		}{
+			{"distance_m", &t.Distance},
+			{"start_lat", &t.StartLat}, {"start_long", &t.StartLong},
+			{"end_lat", &t.EndLat}, {"end_long", &t.EndLong},
+		} {
+			if *fl.dst, err = strconv.ParseFloat(get(fl.name), 64); err != nil {
+				return trips, fmt.Errorf("line %d: could not parse %s: %w", line, fl.name, err)
+			}
+		}
+		trips = append(trips, t)
+	}
+}
+
+// FileSink writes each trip to its own CSV track log in Dir and keeps the
+// directory's trip index up to date. Records are flushed as they come and
+// fsynced every SyncEvery; a trip cut off by the power going is finished
+// from its file the next time the directory is opened
+type FileSink struct {
+	Dir       string
+	SyncEvery time.Duration
+	// Places, if set, names the nearest town within MaxPlaceKm of each end
+	Places     *geocode.Index
+	MaxPlaceKm float64
+	// Coords adds a coords column in this notation to trip files unless DD
+	Coords coord.Format
+
+	trips    []Trip
+	f        *os.File
+	bw       *bufio.Writer
+	cw       *tracklog.CSVWriter
+	lastSync time.Time
+}
+
+// OpenFileSink opens (creating if needed) a trips directory, adding any
+// trips left unfinished to the index
+func OpenFileSink(dir string, places *geocode.Index) (*FileSink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	fs := &FileSink{Dir: dir, SyncEvery: 5 * time.Second, Places: places, MaxPlaceKm: geocode.DefaultMaxKm}
+	if f, err := os.Open(filepath.Join(dir, IndexName)); err == nil {
+		fs.trips, err = ReadIndex(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not read trip index: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	indexed := make(map[string]bool, len(fs.trips))
+	for _, t := range fs.trips {
+		indexed[t.ID] = true
+	}
+	paths, err := filepath.Glob(TripPath(dir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	recovered := false
+	for _, p := range paths {
+		id := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(p), "trip-"), ".csv")
+		if indexed[id] {
+			continue
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		// a torn last line is expected, keep everything before it
+		records, err := tracklog.ReadAll(f)
+		f.Close()
+		if err != nil {
+			logrus.WithError(err).Warnf("Unfinished trip %s ends early", id)
+		}
+		if len(records) == 0 {
+			continue
+		}
+		t := Summarize(records)
+		t.ID = id
+		fs.trips = append(fs.trips, fs.name(t))
+		recovered = true
+	}
+	if recovered {
+		if err := fs.writeIndex(); err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// Trips is the index, oldest first
+func (fs *FileSink) Trips() []Trip {
+	return fs.trips
+}
+
+func (fs *FileSink) name(t Trip) Trip {
+	if fs.Places == nil {
+		return t
+	}
+	t.StartPlace = fs.Places.NearestLabel(t.StartLat, t.StartLong, fs.MaxPlaceKm)
+	t.EndPlace = fs.Places.NearestLabel(t.EndLat, t.EndLong, fs.MaxPlaceKm)
+	return t
+}
+
+// writeIndex replaces the index so a power cut leaves the old one or the
+// new one, never half of each
+func (fs *FileSink) writeIndex() error {
+	sort.Slice(fs.trips, func(i, j int) bool { return fs.trips[i].Start.Before(fs.trips[j].Start) })
+	tmp := filepath.Join(fs.Dir, IndexName+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if err := WriteIndex(bw, fs.trips); err != nil {
+		f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(fs.Dir, IndexName))
+}
+
+// Start creates the trip's file
+func (fs *FileSink) Start(t Trip) error {
+	if fs.f != nil {
+		if err := fs.closeFile(); err != nil {
+			return err
+		}
+	}
+	f, err := os.Create(TripPath(fs.Dir, t.ID))
+	if err != nil {
+		return err
+	}
+	fs.f = f
+	fs.bw = bufio.NewWriter(f)
+	fs.cw = tracklog.NewCSVWriter(fs.bw)
+	fs.cw.Coords = fs.Coords
+	fs.lastSync = time.Now()
+	return nil
+}
+
+// Record appends a record to the trip's file
+func (fs *FileSink) Record(gr gps.GPSRecord) error {
+	if fs.f == nil {
+		return fmt.Errorf("record outside of a trip")
+	}
+	if err := fs.cw.Write(gr); err != nil {
+		return err
+	}
+	if err := fs.cw.Flush(); err != nil {
+		return err
+	}
+	if err := fs.bw.Flush(); err != nil {
+		return err
+	}
+	if time.Since(fs.lastSync) >= fs.SyncEvery {
+		fs.lastSync = time.Now()
+		return fs.f.Sync()
+	}
+	return nil
+}
+
+// End closes the trip's file and adds it to the index, replacing any trip
+// already there with the same ID
+func (fs *FileSink) End(t Trip) error {
+	if err := fs.closeFile(); err != nil {
+		return err
+	}
+	t = fs.name(t)
+	replaced := false
+	for i := range fs.trips {
+		if fs.trips[i].ID == t.ID {
+			fs.trips[i] = t
+			replaced = true
+		}
+	}
+	if !replaced {
+		fs.trips = append(fs.trips, t)
+	}
+	return fs.writeIndex()
+}
+
+// Discard removes a trip that was too short to keep
+func (fs *FileSink) Discard(t Trip) error {
+	if err := fs.closeFile(); err != nil {
+		return err
+	}
+	return os.Remove(TripPath(fs.Dir, t.ID))
+}
+
+func (fs *FileSink) closeFile() error {
+	if fs.f == nil {
+		return nil
+	}
+	err := fs.bw.Flush()
+	if serr := fs.f.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := fs.f.Close(); err == nil {
+		err = cerr
+	}
+	fs.f = nil
+	return err
+}
+
+// Close closes a trip file left open, the segmenter should be closed first
+// so the trip makes it into the index
+func (fs *FileSink) Close() error {
+	return fs.closeFile()
+}
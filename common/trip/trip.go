@@ -0,0 +1,202 @@
+package trip
+
+// splits a stream of records into trips. A log is one long stream per boot
+//  with parked time, restarts and dropouts in it; a trip ends when the car
+//	has been stopped for a while, when there's a long gap between fixes or
+//	when the Pi's clock jumps against GPS time (the process or Pi restarted)
+
+import (
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// Trip is one trip's summary
+type Trip struct {
+	ID         string // start time, 20060102-150405 UTC
+	Start      time.Time
+	End        time.Time
+	StartLat   float64
+	StartLong  float64
+	EndLat     float64
+	EndLong    float64
+	StartPlace string
+	EndPlace   string
+	Distance   float64 // meters
+	Records    int
+}
+
+// Duration is the time from the first record to the last
+func (t Trip) Duration() time.Duration {
+	return t.End.Sub(t.Start)
+}
+
+// ID returns the trip ID for a trip starting at start
+func ID(start time.Time) string {
+	return start.UTC().Format("20060102-150405")
+}
+
+// add extends the summary with the trip's next record
+func (t *Trip) add(gr gps.GPSRecord, prev gps.GPSRecord) {
+	if t.Records == 0 {
+		t.ID = ID(gr.Time())
+		t.Start = gr.Time()
+		t.StartLat, t.StartLong = gr.Lat, gr.Long
+	} else {
+		t.Distance += gps.Distance(prev, gr)
+	}
+	t.End = gr.Time()
+	t.EndLat, t.EndLong = gr.Lat, gr.Long
+	t.Records++
+}
+
+// Summarize builds the summary of a trip's records
+func Summarize(records []gps.GPSRecord) Trip {
+	var t Trip
+	for i, gr := range records {
+		if i == 0 {
+			t.add(gr, gr)
+			continue
+		}
+		t.add(gr, records[i-1])
+	}
+	return t
+}
+
+// Sink receives the trips a Segmenter finds as they happen: Start before a
+// trip's first record, Record for each record and then End, or Discard if the
+// trip turned out too short to keep
+type Sink interface {
+	Start(t Trip) error
+	Record(gr gps.GPSRecord) error
+	End(t Trip) error
+	Discard(t Trip) error
+}
+
+// Segmenter finds trips in records fed to it in order
+type Segmenter struct {
+	// StopSpeed is the speed (in GPSRecord.Speed's units) below which the
+	// car counts as stopped
+	StopSpeed float64
+	// StopDuration stopped ends the trip, shorter stops are part of it
+	StopDuration time.Duration
+	// MaxGap without a fix ends the trip
+	MaxGap time.Duration
+	// MaxClockJump is how far the Pi's clock can move against GPS time
+	// between records before it's taken as a restart
+	MaxClockJump time.Duration
+	// MinDistance in meters, shorter trips are discarded
+	MinDistance float64
+
+	sink    Sink
+	trip    *Trip
+	last    gps.GPSRecord // last record seen
+	lastRec gps.GPSRecord // last record given to the sink
+	pending []gps.GPSRecord
+}
+
+// NewSegmenter returns a segmenter that ends a trip after 3 minutes stopped
+// or 5 minutes without a fix, and drops trips under 200 meters
+func NewSegmenter(sink Sink) *Segmenter {
+	return &Segmenter{
+		StopSpeed:    2,
+		StopDuration: 3 * time.Minute,
+		MaxGap:       5 * time.Minute,
+		MaxClockJump: time.Minute,
+		MinDistance:  200,
+		sink:         sink,
+	}
+}
+
+// InTrip reports whether a trip is in progress
+func (s *Segmenter) InTrip() bool {
+	return s.trip != nil
+}
+
+// restarted reports whether the clocks say the logger restarted between
+// prev and gr: the Pi has no RTC so its clock jumps after a boot, and it
+// never runs backwards otherwise
+func (s *Segmenter) restarted(prev, gr gps.GPSRecord) bool {
+	if gr.UnixMicro < prev.UnixMicro {
+		return true
+	}
+	if prev.GPSTime.IsZero() || gr.GPSTime.IsZero() {
+		return false
+	}
+	before := int64(prev.UnixMicro) - prev.GPSTime.UnixMicro()
+	after := int64(gr.UnixMicro) - gr.GPSTime.UnixMicro()
+	jump := time.Duration(after-before) * time.Microsecond
+	return jump > s.MaxClockJump || jump < -s.MaxClockJump
+}
+
+// Add feeds the next record
+func (s *Segmenter) Add(gr gps.GPSRecord) error {
+	if gr.Lat == 0.0 || gr.Long == 0.0 {
+		return nil // no fix
+	}
+	if s.trip != nil {
+		if gr.Time().Sub(s.last.Time()) > s.MaxGap || s.restarted(s.last, gr) {
+			if err := s.end(); err != nil {
+				return err
+			}
+		}
+	}
+	s.last = gr
+
+	moving := gr.Speed >= s.StopSpeed
+	if s.trip == nil {
+		if !moving {
+			return nil // parked
+		}
+		s.trip = &Trip{ID: ID(gr.Time()), Start: gr.Time()}
+		if err := s.sink.Start(*s.trip); err != nil {
+			return err
+		}
+		return s.record(gr)
+	}
+	if !moving {
+		// hold stopped records until it's clear whether the trip is over
+		s.pending = append(s.pending, gr)
+		if gr.Time().Sub(s.pending[0].Time()) >= s.StopDuration {
+			return s.end()
+		}
+		return nil
+	}
+	for _, p := range s.pending {
+		if err := s.record(p); err != nil {
+			return err
+		}
+	}
+	s.pending = s.pending[:0]
+	return s.record(gr)
+}
+
+func (s *Segmenter) record(gr gps.GPSRecord) error {
+	s.trip.add(gr, s.lastRec)
+	s.lastRec = gr
+	return s.sink.Record(gr)
+}
+
+// end finishes the current trip where the car first stopped
+func (s *Segmenter) end() error {
+	if len(s.pending) > 0 {
+		if err := s.record(s.pending[0]); err != nil {
+			return err
+		}
+		s.pending = s.pending[:0]
+	}
+	t := *s.trip
+	s.trip = nil
+	if t.Distance < s.MinDistance {
+		return s.sink.Discard(t)
+	}
+	return s.sink.End(t)
+}
+
+// Close ends the trip in progress, if any
+func (s *Segmenter) Close() error {
+	if s.trip == nil {
+		return nil
+	}
+	return s.end()
+}
@@ -0,0 +1,226 @@
+package trip
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+	"github.com/samiam2013/raspigogps/common/tracklog"
+)
+
+var start = time.Date(2022, time.May, 20, 12, 0, 0, 0, time.UTC)
+
+// drive builds a record a second from at for secs seconds, moving east at
+// speed (about 17 meters a second when moving)
+type drive struct {
+	at    time.Time
+	clock time.Duration // Pi clock minus GPS time
+	long  float64
+	out   []gps.GPSRecord
+}
+
+func (d *drive) run(secs int, speed float64) {
+	for i := 0; i < secs; i++ {
+		if speed > 0 {
+			d.long += 0.0002
+		}
+		d.out = append(d.out, gps.GPSRecord{
+			UnixMicro: uint64(d.at.Add(d.clock).UnixMicro()),
+			Lat:       38.6,
+			Long:      -90.2 + d.long,
+			Speed:     speed,
+			GPSTime:   d.at,
+		})
+		d.at = d.at.Add(time.Second)
+	}
+}
+
+type memSink struct {
+	trips     []Trip
+	discarded []Trip
+	records   map[string]int
+	current   string
+}
+
+func (m *memSink) Start(t Trip) error {
+	if m.records == nil {
+		m.records = make(map[string]int)
+	}
+	m.current = t.ID
+	return nil
+}
+
+func (m *memSink) Record(gr gps.GPSRecord) error {
+	m.records[m.current]++
+	return nil
+}
+
+func (m *memSink) End(t Trip) error {
+	m.trips = append(m.trips, t)
+	return nil
+}
+
+func (m *memSink) Discard(t Trip) error {
+	m.discarded = append(m.discarded, t)
+	return nil
+}
+
+func TestSegmenter(t *testing.T) {
+	tests := []struct {
+		name      string
+		build     func(d *drive)
+		wantTrips int
+		wantDrop  int
+	}{
+		{"one drive", func(d *drive) { d.run(600, 30) }, 1, 0},
+		{"parked before and after", func(d *drive) { d.run(300, 0); d.run(600, 30); d.run(600, 0) }, 1, 0},
+		{"traffic light", func(d *drive) { d.run(300, 30); d.run(60, 0); d.run(300, 30) }, 1, 0},
+		{"errand", func(d *drive) { d.run(300, 30); d.run(600, 0); d.run(300, 30) }, 2, 0},
+		{"gap", func(d *drive) { d.run(300, 30); d.at = d.at.Add(time.Hour); d.run(300, 30) }, 2, 0},
+		{"restart", func(d *drive) { d.run(300, 30); d.clock = -time.Hour; d.run(300, 30) }, 2, 0},
+		{"too short", func(d *drive) { d.run(5, 30); d.run(600, 0); d.run(300, 30) }, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &drive{at: start}
+			tt.build(d)
+			sink := &memSink{}
+			seg := NewSegmenter(sink)
+			for _, gr := range d.out {
+				if err := seg.Add(gr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := seg.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if len(sink.trips) != tt.wantTrips || len(sink.discarded) != tt.wantDrop {
+				t.Fatalf("got %d trips and %d discarded, want %d and %d",
+					len(sink.trips), len(sink.discarded), tt.wantTrips, tt.wantDrop)
+			}
+			for _, tr := range sink.trips {
+				if tr.Records != sink.records[tr.ID] {
+					t.Errorf("trip %s summarizes %d records, sink got %d", tr.ID, tr.Records, sink.records[tr.ID])
+				}
+				if tr.Distance <= 0 || tr.Duration() <= 0 {
+					t.Errorf("trip %s has distance %f and duration %s", tr.ID, tr.Distance, tr.Duration())
+				}
+			}
+		})
+	}
+}
+
+func TestSegmenterTripBounds(t *testing.T) {
+	d := &drive{at: start}
+	d.run(60, 0)
+	d.run(300, 30)
+	d.run(600, 0)
+	sink := &memSink{}
+	seg := NewSegmenter(sink)
+	for _, gr := range d.out {
+		if err := seg.Add(gr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sink.trips) != 1 {
+		t.Fatalf("got %d trips, want 1", len(sink.trips))
+	}
+	tr := sink.trips[0]
+	// the trip runs from the first moving record to where the car stopped
+	if want := start.Add(60 * time.Second); !tr.Start.Equal(want) || tr.ID != "20220520-120100" {
+		t.Errorf("trip %s starts %v, want %v", tr.ID, tr.Start, want)
+	}
+	if want := start.Add(360 * time.Second); !tr.End.Equal(want) {
+		t.Errorf("trip ends %v, want %v", tr.End, want)
+	}
+	if tr.Records != 301 {
+		t.Errorf("trip has %d records, want 301", tr.Records)
+	}
+	if tr.Distance < 5000 || tr.Distance > 5400 {
+		t.Errorf("trip distance = %f m, want about 5200", tr.Distance)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := OpenFileSink(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &drive{at: start}
+	d.run(300, 30)
+	d.run(600, 0)
+	d.run(300, 30)
+	seg := NewSegmenter(sink)
+	for _, gr := range d.out {
+		if err := seg.Add(gr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the power goes during the second trip: no Close
+	sink.bw.Flush()
+
+	sink, err = OpenFileSink(dir, nil)
+	if err != nil {
+		t.Fatalf("OpenFileSink() error = %v", err)
+	}
+	trips := sink.Trips()
+	if len(trips) != 2 {
+		t.Fatalf("index has %d trips, want the finished one and the recovered one", len(trips))
+	}
+	f, err := os.Open(filepath.Join(dir, IndexName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	indexed, err := ReadIndex(f)
+	if err != nil {
+		t.Fatalf("ReadIndex() error = %v", err)
+	}
+	for i := range trips {
+		// the index rounds distance to decimeters
+		trips[i].Distance = float64(int64(trips[i].Distance*10+0.5)) / 10
+	}
+	if !reflect.DeepEqual(indexed, trips) {
+		t.Errorf("ReadIndex() = %+v, want %+v", indexed, trips)
+	}
+
+	for _, tr := range trips {
+		tf, err := os.Open(TripPath(dir, tr.ID))
+		if err != nil {
+			t.Fatalf("trip %s has no file: %v", tr.ID, err)
+		}
+		records, err := tracklog.ReadAll(tf)
+		tf.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != tr.Records {
+			t.Errorf("trip %s file has %d records, index says %d", tr.ID, len(records), tr.Records)
+		}
+	}
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	want := []Trip{{
+		ID: "20220520-120000", Start: start, End: start.Add(10 * time.Minute),
+		StartLat: 38.6, StartLong: -90.2, EndLat: 38.7, EndLong: -90.1,
+		StartPlace: "St. Louis, MO", EndPlace: "Florissant, MO",
+		Distance: 14210.5, Records: 601,
+	}}
+	var buf bytes.Buffer
+	if err := WriteIndex(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadIndex(&buf)
+	if err != nil {
+		t.Fatalf("ReadIndex() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadIndex() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,260 @@
+package tripcomputer
+
+// the dash's trip computer: a total odometer that's never reset, trip meters
+//  A and B, average moving speed, max speed and moving vs stopped time, all
+//	from the live records. Distance is geodesic between accepted points, and a
+//	point is only accepted once the car is moving and it's a few meters from
+//	the last one, so a parked receiver wandering around doesn't rack up miles.
+//	The state is a small JSON file replaced atomically, so a power cut loses at
+//	most SaveEvery of driving and never the odometer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// MetersPerMile for showing distances
+const MetersPerMile = 1609.344
+
+// Meter is one resettable trip meter
+type Meter struct {
+	Distance float64       `json:"distance_m"`
+	Moving   time.Duration `json:"moving_ns"`
+	Stopped  time.Duration `json:"stopped_ns"`
+	MaxSpeed float64       `json:"max_speed"` // GPSRecord.Speed's units
+	Since    time.Time     `json:"since"`     // last reset
+}
+
+// AvgSpeed is the average while moving, in GPSRecord.Speed's units
+func (m Meter) AvgSpeed() float64 {
+	if m.Moving <= 0 {
+		return 0
+	}
+	return speedUnits(m.Distance / m.Moving.Seconds())
+}
+
+// MPH converts a speed in GPSRecord.Speed's units, like AvgSpeed and
+// MaxSpeed, to miles per hour
+func MPH(speed float64) float64 {
+	return speed * 1.852 / 3.6 * 3600 / MetersPerMile
+}
+
+// speedUnits converts meters per second to what the receiver's speed is
+// stored as (see gps.Parse)
+func speedUnits(mps float64) float64 {
+	return mps * 3.6 / 1.852
+}
+
+// State is everything that's saved
+type State struct {
+	Odometer float64   `json:"odometer_m"`
+	A        Meter     `json:"trip_a"`
+	B        Meter     `json:"trip_b"`
+	Updated  time.Time `json:"updated"`
+}
+
+// Computer keeps the meters up to date from records
+type Computer struct {
+	Path      string
+	SaveEvery time.Duration
+	// MinMoveSpeed is the speed (GPSRecord.Speed's units) below which time
+	// counts as stopped and distance isn't added
+	MinMoveSpeed float64
+	// MinStep in meters between accepted points
+	MinStep float64
+	// MaxGap between records, longer and the time isn't counted and distance
+	// restarts from the next fix instead of jumping across the gap
+	MaxGap time.Duration
+	// MaxStepSpeed in meters per second, faster jumps are bad fixes
+	MaxStepSpeed float64
+
+	state      State
+	anchor     gps.GPSRecord // last point distance was measured to
+	haveAnchor bool
+	last       gps.GPSRecord
+	haveLast   bool
+	lastSave   time.Time
+}
+
+// Open loads the state at path, starting from zero if there isn't one
+func Open(path string) (*Computer, error) {
+	c := &Computer{
+		Path:         path,
+		SaveEvery:    10 * time.Second,
+		MinMoveSpeed: 2,
+		MinStep:      5,
+		MaxGap:       10 * time.Second,
+		MaxStepSpeed: 100,
+	}
+	s, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	c.state = s
+	c.applyResets()
+	c.lastSave = time.Now()
+	return c, nil
+}
+
+// Load reads a saved state, a zero state if the file doesn't exist
+func Load(path string) (State, error) {
+	var s State
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// State returns the current values
+func (c *Computer) State() State {
+	return c.state
+}
+
+// Update adds a record, saving if it's been SaveEvery since the last save
+func (c *Computer) Update(gr gps.GPSRecord) error {
+	if gr.Lat == 0.0 || gr.Long == 0.0 {
+		return nil // no fix
+	}
+	moving := gr.Speed >= c.MinMoveSpeed
+	if c.haveLast {
+		dt := gr.Time().Sub(c.last.Time())
+		if dt > 0 && dt <= c.MaxGap {
+			for _, m := range []*Meter{&c.state.A, &c.state.B} {
+				if moving {
+					m.Moving += dt
+				} else {
+					m.Stopped += dt
+				}
+			}
+		} else {
+			c.haveAnchor = false
+		}
+	}
+	c.last, c.haveLast = gr, true
+
+	if moving {
+		for _, m := range []*Meter{&c.state.A, &c.state.B} {
+			if gr.Speed > m.MaxSpeed {
+				m.MaxSpeed = gr.Speed
+			}
+		}
+		c.advance(gr)
+	}
+	c.state.Updated = gr.Time()
+
+	if time.Since(c.lastSave) >= c.SaveEvery {
+		return c.Save()
+	}
+	return nil
+}
+
+// advance adds the distance from the anchor once the car is far enough from
+// it to be more than jitter
+func (c *Computer) advance(gr gps.GPSRecord) {
+	if !c.haveAnchor {
+		c.anchor, c.haveAnchor = gr, true
+		return
+	}
+	d := gps.Distance(c.anchor, gr)
+	if d < c.MinStep {
+		return
+	}
+	if dt := gr.Time().Sub(c.anchor.Time()).Seconds(); dt > 0 && d/dt > c.MaxStepSpeed {
+		// a bad fix, start again from here rather than count the jump
+		c.anchor = gr
+		return
+	}
+	c.state.Odometer += d
+	c.state.A.Distance += d
+	c.state.B.Distance += d
+	c.anchor = gr
+}
+
+// Reset zeroes trip meter "a" or "b"
+func (c *Computer) Reset(meter string, at time.Time) error {
+	switch strings.ToLower(meter) {
+	case "a":
+		c.state.A = Meter{Since: at}
+	case "b":
+		c.state.B = Meter{Since: at}
+	default:
+		return fmt.Errorf("no trip meter %s, want a or b", meter)
+	}
+	return nil
+}
+
+// ResetRequestPath is the file that asks the running computer to reset a
+// meter, so the command line doesn't race it writing the state
+func ResetRequestPath(path, meter string) string {
+	return path + ".reset-" + strings.ToLower(meter)
+}
+
+// RequestReset asks whichever computer owns the state at path to reset a
+// meter on its next save (or when it's next opened)
+func RequestReset(path, meter string) error {
+	if m := strings.ToLower(meter); m != "a" && m != "b" {
+		return fmt.Errorf("no trip meter %s, want a or b", meter)
+	}
+	return os.WriteFile(ResetRequestPath(path, meter), nil, 0o644)
+}
+
+// applyResets handles reset requests from the command line
+func (c *Computer) applyResets() {
+	for _, m := range []string{"a", "b"} {
+		p := ResetRequestPath(c.Path, m)
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+		_ = c.Reset(m, time.Now().UTC())
+		os.Remove(p)
+	}
+}
+
+// Save writes the state to a temporary file and renames it over the old one,
+// a power cut leaves one or the other whole
+func (c *Computer) Save() error {
+	c.applyResets()
+	c.lastSave = time.Now()
+	b, err := json.MarshalIndent(c.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.Path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.Path); err != nil {
+		return err
+	}
+	// and make the rename itself durable
+	if d, err := os.Open(filepath.Dir(c.Path)); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
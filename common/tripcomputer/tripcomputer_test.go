@@ -0,0 +1,187 @@
+package tripcomputer
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+var start = time.Date(2022, time.May, 20, 12, 0, 0, 0, time.UTC)
+
+// metersPerDegLat is close enough for building test tracks
+const metersPerDegLat = 111_000.0
+
+func record(secs int, north float64, speed float64) gps.GPSRecord {
+	t := start.Add(time.Duration(secs) * time.Second)
+	return gps.GPSRecord{
+		UnixMicro: uint64(t.UnixMicro()),
+		Lat:       38.6 + north/metersPerDegLat,
+		Long:      -90.2,
+		Speed:     speed,
+		GPSTime:   t,
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name        string
+		records     func() []gps.GPSRecord
+		wantDist    float64 // meters, within 1%
+		wantMoving  time.Duration
+		wantStopped time.Duration
+	}{
+		{
+			name: "steady drive",
+			records: func() (rs []gps.GPSRecord) {
+				for i := 0; i <= 100; i++ {
+					rs = append(rs, record(i, float64(i)*20, 39))
+				}
+				return
+			},
+			wantDist:   2000,
+			wantMoving: 100 * time.Second,
+		},
+		{
+			name: "parked with jitter",
+			records: func() (rs []gps.GPSRecord) {
+				for i := 0; i <= 100; i++ {
+					// wanders 3 meters either way and now and then reads a
+					// little speed
+					speed := 0.5
+					if i%10 == 0 {
+						speed = 2.5
+					}
+					rs = append(rs, record(i, 3*math.Sin(float64(i)), speed))
+				}
+				return
+			},
+			wantDist:    0,
+			wantMoving:  10 * time.Second,
+			wantStopped: 90 * time.Second,
+		},
+		{
+			name: "bad fix mid drive",
+			records: func() (rs []gps.GPSRecord) {
+				for i := 0; i <= 100; i++ {
+					north := float64(i) * 20
+					if i == 50 {
+						north += 5000
+					}
+					rs = append(rs, record(i, north, 39))
+				}
+				return
+			},
+			// the jump is dropped, and so is the 40 meters either side of it
+			wantDist:   1960,
+			wantMoving: 100 * time.Second,
+		},
+		{
+			name: "gap in fixes",
+			records: func() (rs []gps.GPSRecord) {
+				for i := 0; i <= 50; i++ {
+					rs = append(rs, record(i, float64(i)*20, 39))
+				}
+				// an hour in a tunnel and 10 km further on
+				for i := 0; i <= 50; i++ {
+					rs = append(rs, record(3600+i, 10000+float64(i)*20, 39))
+				}
+				return
+			},
+			wantDist:   2000,
+			wantMoving: 100 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Open(filepath.Join(t.TempDir(), "odometer.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.SaveEvery = time.Hour
+			for _, gr := range tt.records() {
+				if err := c.Update(gr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s := c.State()
+			if math.Abs(s.Odometer-tt.wantDist) > tt.wantDist/100+0.5 {
+				t.Errorf("Odometer = %f, want %f", s.Odometer, tt.wantDist)
+			}
+			if s.A.Distance != s.Odometer || s.B.Distance != s.Odometer {
+				t.Errorf("trip meters %f and %f, want both %f", s.A.Distance, s.B.Distance, s.Odometer)
+			}
+			if s.A.Moving != tt.wantMoving || s.A.Stopped != tt.wantStopped {
+				t.Errorf("moving %s stopped %s, want %s and %s", s.A.Moving, s.A.Stopped, tt.wantMoving, tt.wantStopped)
+			}
+		})
+	}
+}
+
+func TestAvgAndMaxSpeed(t *testing.T) {
+	c, err := Open(filepath.Join(t.TempDir(), "odometer.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 20 m/s is 38.9 in the receiver's units, then a minute at the light
+	for i := 0; i <= 100; i++ {
+		c.Update(record(i, float64(i)*20, 38.9))
+	}
+	for i := 101; i <= 160; i++ {
+		c.Update(record(i, 2000, 0))
+	}
+	c.Update(record(161, 2000, 45))
+	s := c.State()
+	if got := s.A.AvgSpeed(); math.Abs(got-38.9) > 0.5 {
+		t.Errorf("AvgSpeed() = %f, want about 38.9", got)
+	}
+	if s.A.MaxSpeed != 45 {
+		t.Errorf("MaxSpeed = %f, want 45", s.A.MaxSpeed)
+	}
+	// 20 m/s is 44.7 mph
+	if got := MPH(s.A.AvgSpeed()); math.Abs(got-44.7) > 0.6 {
+		t.Errorf("MPH(AvgSpeed()) = %f, want about 44.7", got)
+	}
+}
+
+func TestSaveAndReset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "odometer.json")
+	c, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= 100; i++ {
+		c.Update(record(i, float64(i)*20, 39))
+	}
+	if err := c.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	saved, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !reflect.DeepEqual(saved, c.State()) {
+		t.Errorf("Load() = %+v, want %+v", saved, c.State())
+	}
+
+	if err := RequestReset(path, "c"); err == nil {
+		t.Error("RequestReset() accepted meter c")
+	}
+	if err := RequestReset(path, "B"); err != nil {
+		t.Fatal(err)
+	}
+	c, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := c.State()
+	if s.B.Distance != 0 || s.B.Since.IsZero() {
+		t.Errorf("trip B after reset = %+v", s.B)
+	}
+	if s.A.Distance != saved.A.Distance || s.Odometer != saved.Odometer {
+		t.Errorf("reset B changed A or the odometer: %+v", s)
+	}
+}
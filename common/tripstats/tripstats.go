@@ -0,0 +1,262 @@
+package tripstats
+
+// trip statistics summed a record at a time, so a year of logs adds up
+//  without holding it: distance, moving time, speeds and the time spent in
+//	speed bands, climbing, stops, fix quality and satellite counts, and how
+//	the driving falls across the hours of the day
+
+import (
+	"math"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// the thresholds, chosen for a car with a consumer receiver
+const (
+	// MovingSpeed in m/s, below it the time counts as stopped
+	MovingSpeed = 0.6
+	// MaxGap between records, longer isn't counted as moving or stopped
+	MaxGap = 30 * time.Second
+	// ClimbThreshold in meters, altitude has to change this much before it
+	// counts, so receiver noise doesn't add up to a mountain
+	ClimbThreshold = 3.0
+)
+
+// Band is a range of speeds in m/s, To is +Inf for the top one, and the
+// time and distance spent in it
+type Band struct {
+	From, To float64
+	Time     time.Duration
+	Distance float64
+}
+
+// Hour is the driving done in one hour of the day
+type Hour struct {
+	Moving   time.Duration
+	Distance float64
+}
+
+// Stop is the car standing still
+type Stop struct {
+	Start     time.Time
+	Duration  time.Duration
+	Lat, Long float64
+}
+
+// Stats is the summary of one or more logs, distances and altitudes in
+// meters and speeds in m/s
+type Stats struct {
+	Logs    int
+	Records int
+	// Start and End are the first and last records' times
+	Start, End time.Time
+	// Logged is the time covered by records, gaps left out
+	Logged   time.Duration
+	Distance float64
+	Moving   time.Duration
+	MaxSpeed float64
+	// HasAltitude is false for logs without altitudes, the altitude fields
+	// are zero then
+	HasAltitude    bool
+	Climb, Descent float64
+	MinAlt, MaxAlt float64
+	Bands          []Band
+	LongestStop    Stop
+	// Fix counts records by fix quality and Sats by satellites in use
+	Fix  map[gps.FixQuality]int
+	Sats map[int64]int
+	// Hours is indexed by the hour of the day in the Accumulator's location
+	Hours [24]Hour
+}
+
+// Duration is the time from the first record to the last
+func (s Stats) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// AvgSpeed is the average while moving
+func (s Stats) AvgSpeed() float64 {
+	if s.Moving <= 0 {
+		return 0
+	}
+	return s.Distance / s.Moving.Seconds()
+}
+
+// Accumulator sums stats from records written to it in time order. It's a
+// pipeline sink, each Close ends a log
+type Accumulator struct {
+	loc      *time.Location
+	s        Stats
+	prev     gps.GPSRecord
+	inLog    bool
+	haveAlt  bool
+	ref      float64 // meters, the last altitude that counted toward climb
+	stopping bool
+	stop     Stop
+}
+
+// NewAccumulator returns an accumulator splitting speeds at bands, the
+// upper edges in m/s from lowest up, and hours of the day in loc
+func NewAccumulator(bands []float64, loc *time.Location) *Accumulator {
+	if loc == nil {
+		loc = time.Local
+	}
+	a := &Accumulator{loc: loc}
+	a.s.Fix = map[gps.FixQuality]int{}
+	a.s.Sats = map[int64]int{}
+	from := 0.0
+	for _, to := range bands {
+		a.s.Bands = append(a.s.Bands, Band{From: from, To: to})
+		from = to
+	}
+	if len(bands) > 0 {
+		a.s.Bands = append(a.s.Bands, Band{From: from, To: math.Inf(1)})
+	}
+	return a
+}
+
+// Write adds a record
+func (a *Accumulator) Write(gr gps.GPSRecord) error {
+	s := &a.s
+	t := gr.Time()
+	if s.Records == 0 || t.Before(s.Start) {
+		s.Start = t
+	}
+	if t.After(s.End) {
+		s.End = t
+	}
+	s.Records++
+	s.Fix[gr.FixQuality]++
+	s.Sats[gr.NumSats]++
+	speed := gr.SpeedMetersPerSecond()
+	if speed > s.MaxSpeed {
+		s.MaxSpeed = speed
+	}
+	a.altitude(gr)
+
+	if !a.inLog {
+		a.inLog = true
+		s.Logs++
+		a.prev = gr
+		a.stopAt(gr, speed)
+		return nil
+	}
+	prev := a.prev
+	a.prev = gr
+	dt := t.Sub(prev.Time())
+	if dt <= 0 || dt > MaxGap {
+		// the log stopped, a stop and the distance don't carry across
+		a.endStop(prev.Time())
+		a.stopAt(gr, speed)
+		return nil
+	}
+	d := gps.Distance(prev, gr)
+	s.Distance += d
+	s.Logged += dt
+	if speed >= MovingSpeed {
+		s.Moving += dt
+		h := &s.Hours[prev.Time().In(a.loc).Hour()]
+		h.Moving += dt
+		h.Distance += d
+	}
+	for i := range s.Bands {
+		if b := &s.Bands[i]; speed >= b.From && speed < b.To {
+			b.Time += dt
+			b.Distance += d
+			break
+		}
+	}
+	if speed >= MovingSpeed {
+		a.endStop(t)
+	}
+	a.stopAt(gr, speed)
+	return nil
+}
+
+// altitude counts climb with hysteresis, leaving out records without one
+func (a *Accumulator) altitude(gr gps.GPSRecord) {
+	if gr.Alt == 0 {
+		return
+	}
+	s := &a.s
+	alt := gr.Alt / 3.28084
+	if !s.HasAltitude {
+		s.HasAltitude = true
+		s.MinAlt, s.MaxAlt = alt, alt
+	}
+	s.MinAlt, s.MaxAlt = math.Min(s.MinAlt, alt), math.Max(s.MaxAlt, alt)
+	if !a.haveAlt {
+		a.haveAlt, a.ref = true, alt
+		return
+	}
+	if alt-a.ref >= ClimbThreshold {
+		s.Climb += alt - a.ref
+		a.ref = alt
+	} else if a.ref-alt >= ClimbThreshold {
+		s.Descent += a.ref - alt
+		a.ref = alt
+	}
+}
+
+// stopAt starts a stop at gr if the car's stopped and one isn't going
+func (a *Accumulator) stopAt(gr gps.GPSRecord, speed float64) {
+	if speed < MovingSpeed && !a.stopping {
+		a.stopping = true
+		a.stop = Stop{Start: gr.Time(), Lat: gr.Lat, Long: gr.Long}
+	}
+}
+
+// endStop ends the stop going, if any, at end
+func (a *Accumulator) endStop(end time.Time) {
+	if !a.stopping {
+		return
+	}
+	a.stopping = false
+	a.stop.Duration = end.Sub(a.stop.Start)
+	if a.stop.Duration > a.s.LongestStop.Duration {
+		a.s.LongestStop = a.stop
+	}
+}
+
+// Close ends a log, the next record written starts another
+func (a *Accumulator) Close() error {
+	if a.inLog {
+		a.endStop(a.prev.Time())
+	}
+	a.inLog = false
+	// climbing doesn't carry across logs either
+	a.haveAlt = false
+	return nil
+}
+
+// Stats is the totals so far, a stop still going counts to the last record
+func (a *Accumulator) Stats() Stats {
+	s := a.s
+	if a.stopping {
+		if d := a.prev.Time().Sub(a.stop.Start); d > s.LongestStop.Duration {
+			s.LongestStop = a.stop
+			s.LongestStop.Duration = d
+		}
+	}
+	s.Bands = append([]Band(nil), a.s.Bands...)
+	s.Fix = make(map[gps.FixQuality]int, len(a.s.Fix))
+	for k, v := range a.s.Fix {
+		s.Fix[k] = v
+	}
+	s.Sats = make(map[int64]int, len(a.s.Sats))
+	for k, v := range a.s.Sats {
+		s.Sats[k] = v
+	}
+	return s
+}
+
+// Compute sums the stats of one log's records in time order
+func Compute(records []gps.GPSRecord, bands []float64, loc *time.Location) Stats {
+	a := NewAccumulator(bands, loc)
+	for _, gr := range records {
+		a.Write(gr)
+	}
+	a.Close()
+	return a.Stats()
+}
@@ -0,0 +1,154 @@
+package tripstats
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+var start = time.Date(2022, 5, 20, 8, 59, 0, 0, time.UTC)
+
+// drive is 2 minutes at 10 m/s, 3 stopped and 1 at 20 m/s, a record a
+// second from at. The fix goes from GPS to DGPS at 100s and a satellite
+// comes in at 200s. It climbs a meter every 10s moving, with a meter of
+// noise
+func drive(at time.Time) []gps.GPSRecord {
+	var records []gps.GPSRecord
+	lat, alt := 38.6, 100.0
+	for i := 0; i <= 360; i++ {
+		speed := 0.0
+		switch {
+		case i >= 1 && i <= 120:
+			speed = 10
+		case i > 300:
+			speed = 20
+		}
+		lat += speed / 110996
+		if speed > 0 {
+			alt += 0.1
+		}
+		gr := gps.GPSRecord{
+			GPSTime: at.Add(time.Duration(i) * time.Second),
+			Lat:     lat, Long: -90.2, Speed: speed * 3.6 / 1.852,
+			Alt:        (alt + float64(i%2)) * 3.28084,
+			FixQuality: gps.FixGPS, NumSats: 8,
+		}
+		if i >= 100 {
+			gr.FixQuality = gps.FixDGPS
+		}
+		if i >= 200 {
+			gr.NumSats = 9
+		}
+		records = append(records, gr)
+	}
+	return records
+}
+
+func TestCompute(t *testing.T) {
+	s := Compute(drive(start), []float64{5, 15}, time.UTC)
+	if s.Logs != 1 || s.Records != 361 || s.Duration() != 6*time.Minute || s.Logged != 6*time.Minute {
+		t.Errorf("%d logs of %d records over %s, %s logged", s.Logs, s.Records, s.Duration(), s.Logged)
+	}
+	if math.Abs(s.Distance-2400) > 1 || s.Moving != 3*time.Minute || math.Abs(s.MaxSpeed-20) > 1e-9 {
+		t.Errorf("Distance %.1f, Moving %s, MaxSpeed %.2f, want 2400, 3m, 20", s.Distance, s.Moving, s.MaxSpeed)
+	}
+	if math.Abs(s.AvgSpeed()-2400.0/180) > 0.01 {
+		t.Errorf("AvgSpeed = %.3f", s.AvgSpeed())
+	}
+	wantBands := []time.Duration{3 * time.Minute, 2 * time.Minute, time.Minute}
+	for i, b := range s.Bands {
+		if b.Time != wantBands[i] {
+			t.Errorf("band %g-%g spent %s, want %s", b.From, b.To, b.Time, wantBands[i])
+		}
+	}
+	if len(s.Bands) != 3 || !math.IsInf(s.Bands[2].To, 1) || math.Abs(s.Bands[2].Distance-1200) > 1 {
+		t.Errorf("Bands = %+v", s.Bands)
+	}
+	stop := s.LongestStop
+	if !stop.Start.Equal(start.Add(121*time.Second)) || stop.Duration != 3*time.Minute || stop.Lat == 0 {
+		t.Errorf("LongestStop = %+v, want 3m from 121s", stop)
+	}
+	if s.Fix[gps.FixGPS] != 100 || s.Fix[gps.FixDGPS] != 261 || len(s.Fix) != 2 {
+		t.Errorf("Fix = %v", s.Fix)
+	}
+	if s.Sats[8] != 200 || s.Sats[9] != 161 || len(s.Sats) != 2 {
+		t.Errorf("Sats = %v", s.Sats)
+	}
+	if s.Hours[8].Moving != time.Minute || s.Hours[9].Moving != 2*time.Minute || math.Abs(s.Hours[8].Distance-600) > 1 {
+		t.Errorf("Hours 8 and 9 = %+v, %+v", s.Hours[8], s.Hours[9])
+	}
+	// 18m climbed, the noise doesn't count and the last bit may not
+	if !s.HasAltitude || s.Climb > 18.01 || s.Climb < 18-ClimbThreshold || s.Descent != 0 {
+		t.Errorf("Climb %.2f, Descent %.2f, want about 18, 0", s.Climb, s.Descent)
+	}
+	if s.MinAlt != 100 || math.Abs(s.MaxAlt-118.9) > 0.01 {
+		t.Errorf("altitudes %.2f to %.2f, want 100 to 118.9", s.MinAlt, s.MaxAlt)
+	}
+
+	if s := Compute(nil, nil, nil); s.Records != 0 || s.Bands != nil || s.AvgSpeed() != 0 {
+		t.Errorf("Compute(nil) = %+v", s)
+	}
+
+	// a kilometer covered while the receiver was off isn't driven distance
+	gap := []gps.GPSRecord{
+		{GPSTime: start, Lat: 38.6, Long: -90.2},
+		{GPSTime: start.Add(MaxGap + time.Second), Lat: 38.609, Long: -90.2},
+	}
+	if s := Compute(gap, nil, time.UTC); s.Distance != 0 || s.Logged != 0 {
+		t.Errorf("across a gap Distance = %.1f, Logged = %s, want 0", s.Distance, s.Logged)
+	}
+}
+
+func TestAccumulator(t *testing.T) {
+	a := NewAccumulator(nil, time.UTC)
+	first := drive(start)
+	for _, gr := range first {
+		a.Write(gr)
+	}
+	a.Close()
+	// the next day, with a gap in the middle of the stop
+	second := drive(start.Add(24 * time.Hour))
+	for i, gr := range second {
+		if i > 150 && i < 250 {
+			continue
+		}
+		a.Write(gr)
+	}
+	// still going, parked at the end
+	third := drive(start.Add(48 * time.Hour))[:200]
+	for i := range third {
+		third[i].Lat += 1
+	}
+	a.Close()
+	for _, gr := range third {
+		a.Write(gr)
+	}
+
+	s := a.Stats()
+	if s.Logs != 3 || s.Records != 361+262+200 {
+		t.Errorf("%d logs of %d records", s.Logs, s.Records)
+	}
+	if !s.Start.Equal(start) || !s.End.Equal(third[199].Time()) {
+		t.Errorf("from %s to %s", s.Start, s.End)
+	}
+	// the logs aren't joined up
+	if math.Abs(s.Distance-(2400+2400+1200)) > 3 {
+		t.Errorf("Distance = %.1f, want 6000", s.Distance)
+	}
+	if want := 6*time.Minute + 6*time.Minute - 100*time.Second + 199*time.Second; s.Logged != want {
+		t.Errorf("Logged = %s, want %s", s.Logged, want)
+	}
+	if s.LongestStop.Duration != 3*time.Minute || !s.LongestStop.Start.Equal(start.Add(121*time.Second)) {
+		t.Errorf("LongestStop = %+v, want the first day's", s.LongestStop)
+	}
+	// the third log's stop, still going at its end, is shorter
+	if s.MinAlt != 100 || s.Bands != nil {
+		t.Errorf("MinAlt %.2f, Bands %v", s.MinAlt, s.Bands)
+	}
+	a.Close()
+	if again := a.Stats(); again.LongestStop != s.LongestStop || again.Records != s.Records {
+		t.Errorf("Close changed the stats: %+v", again)
+	}
+}
@@ -0,0 +1,90 @@
+package waypoint
+
+import (
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// DefaultMaxGap is the longest gap in the track a press is placed in, the
+// same as the logger's GPX segments
+const DefaultMaxGap = 30 * time.Second
+
+// Locator places waypoints marked without a fix on the track, between the
+// records either side of the press. It's fed the track a record at a time,
+// so it can sit at the end of a pipeline and never hold the track
+type Locator struct {
+	// MaxGap is the furthest apart the records either side can be, a press
+	// in a longer gap in the track isn't placed
+	MaxGap time.Duration
+
+	waypoints []Waypoint
+	pending   []int // indexes of the ones still without a position
+	prev      gps.GPSRecord
+	started   bool
+}
+
+// NewLocator returns a locator for waypoints, which it copies
+func NewLocator(waypoints []Waypoint, maxGap time.Duration) *Locator {
+	l := &Locator{MaxGap: maxGap, waypoints: append([]Waypoint(nil), waypoints...)}
+	for i, w := range waypoints {
+		if !w.HasFix() {
+			l.pending = append(l.pending, i)
+		}
+	}
+	return l
+}
+
+// Pending reports whether any waypoint still needs placing
+func (l *Locator) Pending() bool {
+	return len(l.pending) > 0
+}
+
+// Write takes the next record of the track
+func (l *Locator) Write(gr gps.GPSRecord) error {
+	prev := l.prev
+	l.prev = gr
+	if !l.started {
+		l.started = true
+		return nil
+	}
+	from, to := prev.Time(), gr.Time()
+	span := to.Sub(from)
+	if span < 0 || span > l.MaxGap {
+		return nil
+	}
+	left := l.pending[:0]
+	for _, i := range l.pending {
+		w := &l.waypoints[i]
+		if w.Time.Before(from) || w.Time.After(to) {
+			left = append(left, i)
+			continue
+		}
+		f := 0.0
+		if span > 0 {
+			f = float64(w.Time.Sub(from)) / float64(span)
+		}
+		w.Lat = prev.Lat + f*(gr.Lat-prev.Lat)
+		w.Long = prev.Long + f*(gr.Long-prev.Long)
+		w.Interpolated = true
+	}
+	l.pending = left
+	return nil
+}
+
+// Close does nothing, it's there so a Locator can be a pipeline's sink
+func (l *Locator) Close() error {
+	return nil
+}
+
+// Waypoints returns the ones with a position, placed or not, in their
+// original order, and how many couldn't be placed
+func (l *Locator) Waypoints() ([]Waypoint, int) {
+	placed := make([]Waypoint, 0, len(l.waypoints))
+	for _, w := range l.waypoints {
+		if w.HasFix() {
+			placed = append(placed, w)
+		}
+	}
+	return placed, len(l.waypoints) - len(placed)
+}
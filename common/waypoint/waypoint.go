@@ -0,0 +1,109 @@
+package waypoint
+
+// waypoint files: what cmd/waypoint prints, one line per button press as
+//  unixmicro,lat,long,number with the -coords text on the end when it isn't
+//	decimal degrees. A press without a fix is logged at 0,0 and placed on the
+//	track later by a Locator. Blank lines and # comments are skipped so a file
+//	can be annotated by hand
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+// Waypoint is one marked spot
+type Waypoint struct {
+	Time   time.Time
+	Lat    float64
+	Long   float64
+	Number int
+	// Coords is the extra coordinate column, empty for decimal degrees
+	Coords string
+	// Interpolated is set when the position came from the track, not a fix
+	Interpolated bool
+	// Note says what's there for waypoints that aren't button presses, like
+	// the road a matched track turns onto. It isn't in waypoint files
+	Note string
+}
+
+// Parse reads one line
+func Parse(line string) (Waypoint, error) {
+	fields := strings.SplitN(strings.TrimSpace(line), ",", 5)
+	if len(fields) < 4 {
+		return Waypoint{}, fmt.Errorf("waypoint '%s' has %d fields, want at least 4", line, len(fields))
+	}
+	micro, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return Waypoint{}, fmt.Errorf("bad time in waypoint '%s': %w", line, err)
+	}
+	w := Waypoint{Time: time.UnixMicro(micro).UTC()}
+	if w.Lat, err = strconv.ParseFloat(fields[1], 64); err != nil || w.Lat < -90 || w.Lat > 90 {
+		return Waypoint{}, fmt.Errorf("bad latitude in waypoint '%s'", line)
+	}
+	if w.Long, err = strconv.ParseFloat(fields[2], 64); err != nil || w.Long < -180 || w.Long > 180 {
+		return Waypoint{}, fmt.Errorf("bad longitude in waypoint '%s'", line)
+	}
+	if w.Number, err = strconv.Atoi(fields[3]); err != nil {
+		return Waypoint{}, fmt.Errorf("bad number in waypoint '%s': %w", line, err)
+	}
+	if len(fields) == 5 {
+		w.Coords = fields[4]
+	}
+	return w, nil
+}
+
+// Name is the waypoint's label, WP and its number
+func (w Waypoint) Name() string {
+	return "WP" + strconv.Itoa(w.Number)
+}
+
+// HasFix reports whether the waypoint has a position, cmd/waypoint logs
+// 0,0 for a press without a fix
+func (w Waypoint) HasFix() bool {
+	return w.Lat != 0 || w.Long != 0
+}
+
+// Record is the waypoint as a track record, for filters made for tracks
+func (w Waypoint) Record() gps.GPSRecord {
+	return gps.GPSRecord{
+		UnixMicro: uint64(w.Time.UnixMicro()),
+		Lat:       w.Lat,
+		Long:      w.Long,
+		GPSTime:   w.Time,
+	}
+}
+
+// Read reads every waypoint, the error names the line that failed
+func Read(r io.Reader) ([]Waypoint, error) {
+	var waypoints []Waypoint
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		w, err := Parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		waypoints = append(waypoints, w)
+	}
+	return waypoints, sc.Err()
+}
+
+// ReadFile reads the waypoints in the file at path
+func ReadFile(path string) ([]Waypoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
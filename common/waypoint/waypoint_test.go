@@ -0,0 +1,97 @@
+package waypoint
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samiam2013/raspigogps/common/gps"
+)
+
+func TestParse(t *testing.T) {
+	at := time.UnixMicro(1653048000123456).UTC()
+	tests := []struct {
+		line    string
+		want    Waypoint
+		wantErr bool
+	}{
+		{"1653048000123456,38.627003,-90.199404,1", Waypoint{at, 38.627003, -90.199404, 1, "", false, ""}, false},
+		{"1653048000123456,38.627003,-90.199404,2,15S 744580E 4279364N",
+			Waypoint{at, 38.627003, -90.199404, 2, "15S 744580E 4279364N", false, ""}, false},
+		{"1653048000123456,38.627003,-90.199404,3,N38 37.6202, W090 11.9642",
+			Waypoint{at, 38.627003, -90.199404, 3, "N38 37.6202, W090 11.9642", false, ""}, false},
+		{"1653048000123456,38.627003,-90.199404", Waypoint{}, true},
+		{"1653048000123456,98.6,-90.2,1", Waypoint{}, true},
+		{"yesterday,38.6,-90.2,1", Waypoint{}, true},
+		{"1653048000123456,38.6,-90.2,first", Waypoint{}, true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.line)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%s) = %+v, %v, want %+v", tt.line, got, err, tt.want)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	in := "# drive to the arch\n1653048000000000,38.6,-90.2,1\n\n1653048010000000,38.7,-90.3,2\n"
+	got, err := Read(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(got) != 2 || got[1].Name() != "WP2" {
+		t.Errorf("Read() = %+v, want WP1 and WP2", got)
+	}
+
+	_, err = Read(strings.NewReader("1653048000000000,38.6,-90.2,1\nnot a waypoint\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Read() error = %v, want one naming line 2", err)
+	}
+}
+
+func TestLocator(t *testing.T) {
+	start := time.Date(2022, time.May, 20, 12, 0, 0, 0, time.UTC)
+	// a record every 2 seconds going north, then a minute's gap
+	var track []gps.GPSRecord
+	for _, sec := range []int{0, 2, 4, 64, 66} {
+		tm := start.Add(time.Duration(sec) * time.Second)
+		track = append(track, gps.GPSRecord{UnixMicro: uint64(tm.UnixMicro()), Lat: 38.6 + float64(sec)*1e-4, Long: -90.2, GPSTime: tm})
+	}
+	at := func(sec float64) time.Time { return start.Add(time.Duration(sec * float64(time.Second))) }
+	waypoints := []Waypoint{
+		{Time: at(1), Number: 1},
+		{Time: at(3), Lat: 40, Long: -91, Number: 2}, // had a fix
+		{Time: at(4), Number: 3},                     // on a record
+		{Time: at(30), Number: 4},                    // in the gap
+		{Time: at(65.5), Number: 5},
+		{Time: at(100), Number: 6}, // after the track
+	}
+	l := NewLocator(waypoints, 10*time.Second)
+	for _, gr := range track {
+		if err := l.Write(gr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !l.Pending() {
+		t.Error("Pending() = false with waypoints unplaced")
+	}
+	got, unplaced := l.Waypoints()
+	if unplaced != 2 || len(got) != 4 {
+		t.Fatalf("Waypoints() = %+v, %d unplaced, want 4 and 2", got, unplaced)
+	}
+	want := []struct {
+		number       int
+		lat          float64
+		interpolated bool
+	}{{1, 38.6001, true}, {2, 40, false}, {3, 38.6004, true}, {5, 38.60655, true}}
+	for i, w := range want {
+		g := got[i]
+		if g.Number != w.number || g.Interpolated != w.interpolated || g.Lat-w.lat > 1e-9 || w.lat-g.Lat > 1e-9 {
+			t.Errorf("waypoint %d = %+v, want number %d at %v interpolated %v", i, g, w.number, w.lat, w.interpolated)
+		}
+	}
+	if waypoints[0].HasFix() {
+		t.Error("NewLocator() changed the caller's waypoints")
+	}
+}
@@ -0,0 +1,287 @@
+package wmm
+
+// World Magnetic Model: computes the earth's main magnetic field (and from
+//  that the declination between true and magnetic north) for a position and
+//	date from a set of spherical harmonic coefficients
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+//go:embed WMM.COF
+var defaultCOF string
+
+const (
+	maxDegree = 12
+	// geomagnetic reference radius in km
+	refRadius = 6371.2
+	// WGS84 ellipsoid
+	wgs84A = 6378.137
+	wgs84F = 1 / 298.257223563
+	// a model is considered accurate for this many years past its epoch
+	validYears = 5.0
+)
+
+// Model is a set of Gauss coefficients (and their secular variation) valid
+// from Epoch for five years
+type Model struct {
+	Name  string
+	Epoch float64
+	g     [maxDegree + 1][maxDegree + 1]float64
+	h     [maxDegree + 1][maxDegree + 1]float64
+	gDot  [maxDegree + 1][maxDegree + 1]float64
+	hDot  [maxDegree + 1][maxDegree + 1]float64
+	// expired warns once that the model is being used past its lifespan
+	expired sync.Once
+}
+
+// Field is the magnetic field at a point in nanotesla and degrees
+type Field struct {
+	X, Y, Z     float64 // north, east and down components
+	H, F        float64 // horizontal and total intensity
+	Declination float64 // positive east of true north
+	Inclination float64 // positive down
+}
+
+var (
+	mu           sync.RWMutex
+	defaultModel *Model
+	builtinModel *Model
+)
+
+func init() {
+	m, err := Load(strings.NewReader(defaultCOF))
+	if err != nil {
+		panic(fmt.Sprintf("embedded WMM coefficients are invalid: %s", err.Error()))
+	}
+	builtinModel = m
+}
+
+// SetDefault sets the model used by Declination instead of the embedded one,
+// nil goes back to it
+func SetDefault(m *Model) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultModel = m
+}
+
+// Default returns the model set with SetDefault or else the one built from
+// the embedded coefficient file
+func Default() *Model {
+	mu.RLock()
+	defer mu.RUnlock()
+	if defaultModel != nil {
+		return defaultModel
+	}
+	return builtinModel
+}
+
+// Open loads a model from a WMM.COF file
+func Open(path string) (*Model, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Load reads a model in the NOAA WMM.COF format so that newer coefficients
+// can be used without a rebuild
+func Load(r io.Reader) (*Model, error) {
+	m := &Model{}
+	s := bufio.NewScanner(r)
+	if !s.Scan() {
+		return nil, fmt.Errorf("empty coefficient file")
+	}
+	header := strings.Fields(s.Text())
+	if len(header) < 2 {
+		return nil, fmt.Errorf("malformed header line '%s'", s.Text())
+	}
+	epoch, err := strconv.ParseFloat(header[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse epoch: %w", err)
+	}
+	m.Epoch = epoch
+	m.Name = header[1]
+
+	rows := 0
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "9999") {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("expected 6 fields in '%s'", line)
+		}
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse degree: %w", err)
+		}
+		mo, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse order: %w", err)
+		}
+		if n < 1 || n > maxDegree || mo < 0 || mo > n {
+			return nil, fmt.Errorf("degree/order %d/%d out of range", n, mo)
+		}
+		var vals [4]float64
+		for i := range vals {
+			vals[i], err = strconv.ParseFloat(fields[i+2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse coefficient: %w", err)
+			}
+		}
+		m.g[n][mo], m.h[n][mo], m.gDot[n][mo], m.hDot[n][mo] = vals[0], vals[1], vals[2], vals[3]
+		rows++
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, fmt.Errorf("no coefficients found")
+	}
+	return m, nil
+}
+
+// Valid reports whether t falls inside the model's five year lifespan,
+// outside of it the secular variation is extrapolated and accuracy degrades
+func (m *Model) Valid(t time.Time) bool {
+	y := decimalYear(t)
+	return y >= m.Epoch && y < m.Epoch+validYears
+}
+
+// Field computes the magnetic field at a geodetic lat/long (degrees),
+// altitude above the ellipsoid (km) and time
+func (m *Model) Field(lat, long, altKm float64, t time.Time) Field {
+	dt := decimalYear(t) - m.Epoch
+
+	// geodetic to geocentric spherical coordinates
+	phi := lat * math.Pi / 180
+	lambda := long * math.Pi / 180
+	e2 := wgs84F * (2 - wgs84F)
+	rc := wgs84A / math.Sqrt(1-e2*math.Sin(phi)*math.Sin(phi))
+	p := (rc + altKm) * math.Cos(phi)
+	z := (rc*(1-e2) + altKm) * math.Sin(phi)
+	r := math.Sqrt(p*p + z*z)
+	phiPrime := math.Asin(z / r)
+
+	// Schmidt semi-normalized associated legendre functions of cos(colatitude)
+	// and their derivatives with respect to colatitude
+	x := math.Sin(phiPrime)
+	s := math.Cos(phiPrime)
+	if s < 1e-10 {
+		// the east component is undefined at the poles
+		s = 1e-10
+	}
+	var pnm, dpnm [maxDegree + 1][maxDegree + 1]float64
+	pnm[0][0] = 1
+	for n := 1; n <= maxDegree; n++ {
+		for mo := 0; mo <= n; mo++ {
+			if n == mo {
+				pnm[n][n] = s * pnm[n-1][n-1]
+				dpnm[n][n] = s*dpnm[n-1][n-1] + x*pnm[n-1][n-1]
+			} else if n == 1 {
+				pnm[1][0] = x * pnm[0][0]
+				dpnm[1][0] = x*dpnm[0][0] - s*pnm[0][0]
+			} else {
+				k := float64((n-1)*(n-1)-mo*mo) / float64((2*n-1)*(2*n-3))
+				pnm[n][mo] = x*pnm[n-1][mo] - k*pnm[n-2][mo]
+				dpnm[n][mo] = x*dpnm[n-1][mo] - s*pnm[n-1][mo] - k*dpnm[n-2][mo]
+			}
+		}
+	}
+	var schmidt [maxDegree + 1][maxDegree + 1]float64
+	schmidt[0][0] = 1
+	for n := 1; n <= maxDegree; n++ {
+		schmidt[n][0] = schmidt[n-1][0] * float64(2*n-1) / float64(n)
+		for mo := 1; mo <= n; mo++ {
+			j := 1.0
+			if mo == 1 {
+				j = 2.0
+			}
+			schmidt[n][mo] = schmidt[n][mo-1] * math.Sqrt(float64(n-mo+1)*j/float64(n+mo))
+		}
+	}
+
+	var br, bt, bp float64
+	ratio := refRadius / r
+	for n := 1; n <= maxDegree; n++ {
+		rn := math.Pow(ratio, float64(n+2))
+		for mo := 0; mo <= n; mo++ {
+			g := m.g[n][mo] + dt*m.gDot[n][mo]
+			h := m.h[n][mo] + dt*m.hDot[n][mo]
+			cosML := math.Cos(float64(mo) * lambda)
+			sinML := math.Sin(float64(mo) * lambda)
+			pp := schmidt[n][mo] * pnm[n][mo]
+			dp := schmidt[n][mo] * dpnm[n][mo]
+			br += rn * float64(n+1) * (g*cosML + h*sinML) * pp
+			bt -= rn * (g*cosML + h*sinML) * dp
+			bp -= rn * float64(mo) * (-g*sinML + h*cosML) * pp / s
+		}
+	}
+
+	// rotate from geocentric back to the geodetic frame
+	xPrime, yPrime, zPrime := -bt, bp, -br
+	psi := phiPrime - phi
+	f := Field{
+		X: xPrime*math.Cos(psi) - zPrime*math.Sin(psi),
+		Y: yPrime,
+		Z: xPrime*math.Sin(psi) + zPrime*math.Cos(psi),
+	}
+	f.H = math.Hypot(f.X, f.Y)
+	f.F = math.Hypot(f.H, f.Z)
+	f.Declination = math.Atan2(f.Y, f.X) * 180 / math.Pi
+	f.Inclination = math.Atan2(f.Z, f.H) * 180 / math.Pi
+	return f
+}
+
+// Declination returns the angle in degrees (positive east) between true and
+// magnetic north using the default model, warning once if t is outside of
+// the model's lifespan
+func Declination(lat, long, altKm float64, t time.Time) float64 {
+	m := Default()
+	if !m.Valid(t) {
+		m.expired.Do(func() {
+			logrus.Warnf("%s is only valid from %.1f to %.1f, declination for %s is extrapolated; "+
+				"load newer coefficients from NOAA's WMM.COF", m.Name, m.Epoch, m.Epoch+validYears, t.Format("2006-01-02"))
+		})
+	}
+	return m.Field(lat, long, altKm, t).Declination
+}
+
+// MagneticHeading converts a true heading to a magnetic one given the
+// declination, keeping the result in [0, 360)
+func MagneticHeading(trueHeading, declination float64) float64 {
+	h := math.Mod(trueHeading-declination, 360.0)
+	if h < 0 {
+		h += 360.0
+	}
+	return h
+}
+
+func decimalYear(t time.Time) float64 {
+	t = t.UTC()
+	start := time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, 0)
+	return float64(t.Year()) + float64(t.Sub(start))/float64(end.Sub(start))
+}
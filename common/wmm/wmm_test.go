@@ -0,0 +1,121 @@
+package wmm
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestField(t *testing.T) {
+	// test values published alongside the WMM2020 coefficients
+	type args struct {
+		lat   float64
+		long  float64
+		altKm float64
+		t     time.Time
+	}
+	epoch := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	midway := time.Date(2022, time.July, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		args     args
+		wantX    float64
+		wantY    float64
+		wantZ    float64
+		wantDecl float64
+	}{
+		{
+			name:     "arctic at epoch",
+			args:     args{lat: 80, long: 0, altKm: 0, t: epoch},
+			wantX:    6570.4,
+			wantY:    -146.3,
+			wantZ:    54606.0,
+			wantDecl: -1.28,
+		},
+		{
+			name:     "equator at epoch",
+			args:     args{lat: 0, long: 120, altKm: 0, t: epoch},
+			wantX:    39624.3,
+			wantY:    109.9,
+			wantZ:    -10932.5,
+			wantDecl: 0.16,
+		},
+		{
+			name:     "antarctic at 100km",
+			args:     args{lat: -80, long: 240, altKm: 100, t: epoch},
+			wantX:    5744.9,
+			wantY:    14799.5,
+			wantZ:    -49969.4,
+			wantDecl: 68.78,
+		},
+		{
+			name:     "arctic half way through",
+			args:     args{lat: 80, long: 0, altKm: 0, t: midway},
+			wantX:    6529.9,
+			wantY:    1.1,
+			wantZ:    54713.4,
+			wantDecl: 0.01,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Default().Field(tt.args.lat, tt.args.long, tt.args.altKm, tt.args.t)
+			if math.Abs(got.X-tt.wantX) > 0.1 || math.Abs(got.Y-tt.wantY) > 0.1 ||
+				math.Abs(got.Z-tt.wantZ) > 0.1 {
+				t.Errorf("Field() = %+v, want X %v Y %v Z %v", got, tt.wantX, tt.wantY, tt.wantZ)
+			}
+			if math.Abs(got.Declination-tt.wantDecl) > 0.01 {
+				t.Errorf("Field().Declination = %v, want %v", got.Declination, tt.wantDecl)
+			}
+		})
+	}
+}
+
+func TestMagneticHeading(t *testing.T) {
+	tests := []struct {
+		name        string
+		heading     float64
+		declination float64
+		want        float64
+	}{
+		{name: "east declination", heading: 90, declination: 10, want: 80},
+		{name: "west declination", heading: 90, declination: -10, want: 100},
+		{name: "wraps below north", heading: 5, declination: 10, want: 355},
+		{name: "wraps past north", heading: 355, declination: -10, want: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MagneticHeading(tt.heading, tt.declination); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("MagneticHeading() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefault(t *testing.T) {
+	builtin := Default()
+	// the built in model should be NOAA's current one, WMM2025 until 2030
+	if !builtin.Valid(time.Date(2026, time.July, 2, 0, 0, 0, 0, time.UTC)) ||
+		builtin.Valid(time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("built in %s is valid from %v for five years, replace WMM.COF with NOAA's WMM2025 one", builtin.Name, builtin.Epoch)
+	}
+
+	// a made up dipole that puts magnetic north west of true north at 0,0
+	m, err := Load(strings.NewReader("2025.0 TEST 11/13/2024\n  1  0  -29000.0  0.0  0.0  0.0\n  1  1  0.0  5000.0  0.0  0.0\n999999999999\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	SetDefault(m)
+	defer SetDefault(nil)
+	if Default() != m {
+		t.Fatal("Default() isn't the model set")
+	}
+	if d := Declination(0, 0, 0, time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)); d >= 0 {
+		t.Errorf("Declination() = %v, want it west", d)
+	}
+	SetDefault(nil)
+	if Default() != builtin {
+		t.Error("SetDefault(nil) didn't go back to the built in model")
+	}
+}
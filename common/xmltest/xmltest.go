@@ -0,0 +1,148 @@
+package xmltest
+
+// helpers for the tests of packages that write XML: a parsed tree to check
+//  what the schema can't (counts, references between elements) and schema
+//	validation with xmllint against the real XSD, when both are on hand. The
+//	XSD's path comes from an environment variable, since the schemas aren't
+//	part of the repo and most of them import others from the network
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// Node is a parsed element. Name has the prefix Parse was given for its
+// namespace, like gx:Track, so it can be compared to the schema's names
+type Node struct {
+	Space, Name string
+	Attrs       []xml.Attr
+	Text        string
+	Children    []*Node
+}
+
+// Parse reads a document into a tree, failing the test if it isn't well
+// formed. prefixes maps namespaces to the prefix to put on their elements
+func Parse(t *testing.T, data []byte, prefixes map[string]string) *Node {
+	t.Helper()
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var stack []*Node
+	var root *Node
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("not well formed XML: %v", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &Node{Space: tok.Name.Space, Name: tok.Name.Local, Attrs: tok.Attr}
+			if p, ok := prefixes[n.Space]; ok {
+				n.Name = p + ":" + n.Name
+			}
+			if len(stack) == 0 {
+				root = n
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, n)
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].Text += string(tok)
+			}
+		}
+	}
+	if root == nil {
+		t.Fatal("no root element")
+	}
+	return root
+}
+
+// Attr is the value of the attribute with the local name, empty if there's
+// none
+func (n *Node) Attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// Child is the first child with the name, nil if there's none
+func (n *Node) Child(name string) *Node {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// All is every child with the name
+func (n *Node) All(name string) []*Node {
+	var found []*Node
+	for _, c := range n.Children {
+		if c.Name == name {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// CheckSequence checks children appear in the order listed, each at most
+// once unless repeatable
+func CheckSequence(n *Node, order []string, repeatable map[string]bool) error {
+	pos := 0
+	seen := map[string]int{}
+	for _, c := range n.Children {
+		i := pos
+		for i < len(order) && order[i] != c.Name {
+			i++
+		}
+		if i == len(order) {
+			return fmt.Errorf("%s: unexpected or out of order <%s>", n.Name, c.Name)
+		}
+		pos = i
+		seen[c.Name]++
+		if seen[c.Name] > 1 && !repeatable[c.Name] {
+			return fmt.Errorf("%s: more than one <%s>", n.Name, c.Name)
+		}
+	}
+	return nil
+}
+
+// Validate checks data with xmllint against the XSD the environment variable
+// names, or xsd when it's unset. The test is skipped with no schema either
+// way or no xmllint, except that a missing xmllint fails it when CI is set so
+// the check can't quietly stop running
+func Validate(t *testing.T, data []byte, env, xsd string) {
+	t.Helper()
+	if v := os.Getenv(env); v != "" {
+		xsd = v
+	}
+	if xsd == "" {
+		t.Skipf("set %s to the schema to validate against", env)
+	}
+	xmllint, err := exec.LookPath("xmllint")
+	if err != nil {
+		if os.Getenv("CI") != "" {
+			t.Fatalf("no xmllint to validate against %s: %v", xsd, err)
+		}
+		t.Skipf("no xmllint: %v", err)
+	}
+	cmd := exec.Command(xmllint, "--noout", "--schema", xsd, "-")
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("doesn't validate against %s: %v\n%s", xsd, err, out)
+	}
+}
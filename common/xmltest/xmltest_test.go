@@ -0,0 +1,72 @@
+package xmltest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	space = "http://example.com/ext"
+	doc   = `<?xml version="1.0"?><list><name>a</name><item id="1">x</item><ext:item xmlns:ext="` + space +
+		`">y</ext:item><item id="2">z</item></list>`
+)
+
+func TestParse(t *testing.T) {
+	root := Parse(t, []byte(doc), map[string]string{space: "ext"})
+	if root.Name != "list" || len(root.Children) != 4 {
+		t.Fatalf("root = %s with %d children", root.Name, len(root.Children))
+	}
+	if items := root.All("item"); len(items) != 2 || items[1].Attr("id") != "2" || items[1].Text != "z" {
+		t.Errorf("items = %+v", items)
+	}
+	if ext := root.Child("ext:item"); ext == nil || ext.Space != space || ext.Text != "y" {
+		t.Errorf("prefixed child = %+v", ext)
+	}
+	if root.Child("missing") != nil || root.Attr("missing") != "" {
+		t.Error("found something that isn't there")
+	}
+}
+
+func TestCheckSequence(t *testing.T) {
+	root := Parse(t, []byte(doc), map[string]string{space: "ext"})
+	tests := []struct {
+		name       string
+		order      []string
+		repeatable map[string]bool
+		wantErr    bool
+	}{
+		{"in order", []string{"name", "item", "ext:item", "item"}, map[string]bool{"item": true}, false},
+		{"not repeatable", []string{"name", "item", "ext:item", "item"}, nil, true},
+		{"out of order", []string{"name", "ext:item", "item"}, map[string]bool{"item": true}, true},
+		{"unexpected", []string{"name", "item"}, map[string]bool{"item": true}, true},
+	}
+	for _, tt := range tests {
+		if err := CheckSequence(root, tt.order, tt.repeatable); (err != nil) != tt.wantErr {
+			t.Errorf("%s: CheckSequence() error = %v", tt.name, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	xsd := filepath.Join(t.TempDir(), "list.xsd")
+	schema := `<?xml version="1.0"?>
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+ <xs:element name="list">
+  <xs:complexType>
+   <xs:sequence>
+    <xs:element name="name" type="xs:string"/>
+    <xs:any namespace="##any" processContents="skip" minOccurs="0" maxOccurs="unbounded"/>
+   </xs:sequence>
+  </xs:complexType>
+ </xs:element>
+</xs:schema>
+`
+	if err := os.WriteFile(xsd, []byte(schema), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	Validate(t, []byte(doc), "XMLTEST_XSD", xsd)
+	// the environment wins over the default
+	t.Setenv("XMLTEST_XSD", xsd)
+	Validate(t, []byte(doc), "XMLTEST_XSD", filepath.Join(t.TempDir(), "missing.xsd"))
+}
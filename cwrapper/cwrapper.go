@@ -72,3 +72,38 @@ func (l LCD) Close() error {
 	}
 	return nil
 }
+
+// SetContrast sets the display contrast (brightness on an OLED), 0 to 255
+func (l LCD) SetContrast(level uint8) error {
+	if C.lcd_contrast(C.uchar(level)) != 0 {
+		return fmt.Errorf("call to C.lcd_contrast returned non-zero code")
+	}
+	return nil
+}
+
+// SetInverted switches between lit text on black and dark text on a lit
+// background
+func (l LCD) SetInverted(inverted bool) error {
+	var inv C.int
+	if inverted {
+		inv = 1
+	}
+	if C.lcd_invert(inv) != 0 {
+		return fmt.Errorf("call to C.lcd_invert returned non-zero code")
+	}
+	return nil
+}
+
+// Palette is a contrast level and polarity for one lighting condition
+type Palette struct {
+	Contrast uint8
+	Inverted bool
+}
+
+// Dim applies a palette's contrast and polarity
+func (l LCD) Dim(p Palette) error {
+	if err := l.SetContrast(p.Contrast); err != nil {
+		return err
+	}
+	return l.SetInverted(p.Inverted)
+}
@@ -46,6 +46,16 @@ int lcd_close() {
     return close(file);
 }
 
+int lcd_contrast(unsigned char level) {
+    char cmd[3] = {0x0, 0x81, level}; // set contrast control, then the level
+    return I2C_Write(cmd, 3);
+}
+
+int lcd_invert(int inverted) {
+    char cmd[2] = {0x0, inverted ? 0xA7 : 0xA6}; // reverse or normal display
+    return I2C_Write(cmd, 2);
+}
+
 int lcd_move(uint8_t x, uint8_t y) {
     char ret[4] = {0};
     x <<= 3;
@@ -6,5 +6,7 @@ int lcd_move(int x, int y);
 int lcd_clear();
 int lcd_printc(const char chr, const int cursor);
 int lcd_printmap(const char map[64][16]);
+int lcd_contrast(unsigned char level);
+int lcd_invert(int inverted);
 #endif
 